@@ -0,0 +1,84 @@
+package cluster
+
+import (
+	"fastdb/common"
+	"fastdb/core"
+	"sync"
+)
+
+// Batch 收集一组写操作，Commit 时作为一条 raft 日志复制到集群
+type Batch struct {
+	node          *Node
+	pendingWrites map[string]*core.LogRecord
+	mu            sync.RWMutex
+	committed     bool
+}
+
+func (b *Batch) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return common.NewErr(&common.KeyIsEmptyErrNo, common.ErrKeyIsEmpty)
+	}
+
+	b.mu.Lock()
+	b.pendingWrites[string(key)] = &core.LogRecord{
+		Key:   key,
+		Value: value,
+		Type:  core.LogRecordNormal,
+	}
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *Batch) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, common.NewErr(&common.KeyIsEmptyErrNo, common.ErrKeyIsEmpty)
+	}
+
+	b.mu.RLock()
+	record := b.pendingWrites[string(key)]
+	b.mu.RUnlock()
+	if record != nil {
+		if record.Type == core.LogRecordDeleted {
+			return nil, common.NewErr(&common.KeyNotFoundErrNo, common.ErrKeyNotFound)
+		}
+		return record.Value, nil
+	}
+	return b.node.Get(key)
+}
+
+func (b *Batch) Delete(key []byte) error {
+	if len(key) == 0 {
+		return common.NewErr(&common.KeyIsEmptyErrNo, common.ErrKeyIsEmpty)
+	}
+
+	b.mu.Lock()
+	b.pendingWrites[string(key)] = &core.LogRecord{
+		Key:  key,
+		Type: core.LogRecordDeleted,
+	}
+	b.mu.Unlock()
+	return nil
+}
+
+// Commit 提交到 raft 日志，并等待多数派确认且应用到本地状态机后返回
+func (b *Batch) Commit() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.committed {
+		return common.NewErr(&common.BatchCommittedErrNo, common.ErrBatchCommitted)
+	}
+	if len(b.pendingWrites) == 0 {
+		return nil
+	}
+
+	records := make([]*core.LogRecord, 0, len(b.pendingWrites))
+	for _, record := range b.pendingWrites {
+		records = append(records, record)
+	}
+	if err := b.node.apply(records); err != nil {
+		return err
+	}
+	b.committed = true
+	return nil
+}
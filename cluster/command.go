@@ -0,0 +1,65 @@
+package cluster
+
+import (
+	"encoding/binary"
+	"errors"
+	"fastdb/core"
+)
+
+var errInvalidCommand = errors.New("invalid raft command")
+
+// encodeCommand 将一个 batch 中的所有写操作编码为一条 raft 日志
+// 格式: 操作数量 + [type(1) + keySize + key + valueSize + value]...
+func encodeCommand(records []*core.LogRecord) []byte {
+	size := binary.MaxVarintLen64
+	for _, record := range records {
+		size += 1 + binary.MaxVarintLen32*2 + len(record.Key) + len(record.Value)
+	}
+	buf := make([]byte, size)
+
+	index := binary.PutUvarint(buf, uint64(len(records)))
+	for _, record := range records {
+		buf[index] = record.Type
+		index++
+		index += binary.PutUvarint(buf[index:], uint64(len(record.Key)))
+		index += copy(buf[index:], record.Key)
+		index += binary.PutUvarint(buf[index:], uint64(len(record.Value)))
+		index += copy(buf[index:], record.Value)
+	}
+	return buf[:index]
+}
+
+func decodeCommand(buf []byte) ([]*core.LogRecord, error) {
+	count, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, errInvalidCommand
+	}
+	index := n
+	records := make([]*core.LogRecord, 0, count)
+	for i := uint64(0); i < count; i++ {
+		if index >= len(buf) {
+			return nil, errInvalidCommand
+		}
+		record := &core.LogRecord{Type: buf[index]}
+		index++
+
+		keySize, n := binary.Uvarint(buf[index:])
+		if n <= 0 || index+n+int(keySize) > len(buf) {
+			return nil, errInvalidCommand
+		}
+		index += n
+		record.Key = buf[index : index+int(keySize)]
+		index += int(keySize)
+
+		valueSize, n := binary.Uvarint(buf[index:])
+		if n <= 0 || index+n+int(valueSize) > len(buf) {
+			return nil, errInvalidCommand
+		}
+		index += n
+		record.Value = buf[index : index+int(valueSize)]
+		index += int(valueSize)
+
+		records = append(records, record)
+	}
+	return records, nil
+}
@@ -0,0 +1,66 @@
+package cluster
+
+import (
+	"fastdb/config"
+	"fastdb/core"
+	"github.com/hashicorp/raft"
+	"io"
+)
+
+// fsm 将多数派提交的 raft 日志应用到本地的 core.DB 上
+type fsm struct {
+	db           *core.DB
+	batchOptions config.BatchOptions
+}
+
+func (f *fsm) Apply(log *raft.Log) interface{} {
+	records, err := decodeCommand(log.Data)
+	if err != nil {
+		return err
+	}
+
+	batch := f.db.NewBatch(f.batchOptions)
+	for _, record := range records {
+		switch record.Type {
+		case core.LogRecordNormal:
+			err = batch.Put(record.Key, record.Value)
+		case core.LogRecordDeleted:
+			err = batch.Delete(record.Key)
+		default:
+			err = errInvalidCommand
+		}
+		if err != nil {
+			batch.Close()
+			return err
+		}
+	}
+	return batch.Commit()
+}
+
+// Snapshot 复用 core.DB 的备份输出，只记录当前数据文件的边界，真正的拷贝在 Persist 中完成
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	backup, err := f.db.NewBackup()
+	if err != nil {
+		return nil, err
+	}
+	return &fsmSnapshot{backup: backup}, nil
+}
+
+func (f *fsm) Restore(snapshot io.ReadCloser) error {
+	defer snapshot.Close()
+	return f.db.Restore(snapshot)
+}
+
+type fsmSnapshot struct {
+	backup *core.Backup
+}
+
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := s.backup.WriteTo(sink); err != nil {
+		_ = sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *fsmSnapshot) Release() {}
@@ -0,0 +1,229 @@
+package cluster
+
+import (
+	"errors"
+	"fastdb/common"
+	"fastdb/config"
+	"fastdb/core"
+	"github.com/hashicorp/raft"
+	"sync"
+	"sync/atomic"
+)
+
+// Node 是 raft 集群中的一个节点，每个 Batch.Commit 都会成为一条 raft 日志，
+// 只有在多数派确认之后才会被应用到本地的 core.DB
+type Node struct {
+	raft    *raft.Raft
+	db      *core.DB
+	options Options
+	// leaderReady 代表本节点成为 leader 后，之前任期的日志都已应用到状态机，可以处理线性一致读
+	leaderReady atomic.Bool
+	notifyCh    chan bool
+	closeCh     chan struct{}
+	closeOnce   sync.Once
+	closeErr    error
+}
+
+func Open(options Options) (*Node, error) {
+	if options.NodeId == "" {
+		return nil, common.NewErr(&common.InnerErrNo, errors.New("cluster node id is empty"))
+	}
+	if options.Transport == nil {
+		return nil, common.NewErr(&common.InnerErrNo, errors.New("cluster transport is nil"))
+	}
+
+	db, err := core.Open(options.DbOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	raftConfig := raft.DefaultConfig()
+	if options.Raft != nil {
+		c := *options.Raft
+		raftConfig = &c
+	}
+	notifyCh := make(chan bool, 8)
+	raftConfig.LocalID = raft.ServerID(options.NodeId)
+	raftConfig.NotifyCh = notifyCh
+	if options.SnapshotThreshold > 0 {
+		raftConfig.SnapshotThreshold = options.SnapshotThreshold
+	}
+
+	if options.LogStore == nil || options.StableStore == nil {
+		store := raft.NewInmemStore()
+		if options.LogStore == nil {
+			options.LogStore = store
+		}
+		if options.StableStore == nil {
+			options.StableStore = store
+		}
+	}
+	if options.SnapshotStore == nil {
+		options.SnapshotStore = raft.NewInmemSnapshotStore()
+	}
+
+	batchOptions := config.DefaultBatchOptions
+	batchOptions.Sync = options.DbOptions.Sync
+	stateMachine := &fsm{db: db, batchOptions: batchOptions}
+
+	r, err := raft.NewRaft(raftConfig, stateMachine, options.LogStore, options.StableStore,
+		options.SnapshotStore, options.Transport)
+	if err != nil {
+		_ = db.Close()
+		return nil, common.NewErr(&common.InnerErrNo, err)
+	}
+
+	node := &Node{
+		raft:     r,
+		db:       db,
+		options:  options,
+		notifyCh: notifyCh,
+		closeCh:  make(chan struct{}),
+	}
+	go node.watchLeadership()
+	return node, nil
+}
+
+// watchLeadership 在本节点当选 leader 后提交一个 barrier，
+// 确保之前任期已提交的日志全部应用到状态机后，才开始处理线性一致读
+func (n *Node) watchLeadership() {
+	for {
+		select {
+		case isLeader := <-n.notifyCh:
+			n.leaderReady.Store(false)
+			if !isLeader {
+				continue
+			}
+			if err := n.raft.Barrier(n.options.ApplyTimeout).Error(); err != nil {
+				continue
+			}
+			n.leaderReady.Store(n.raft.State() == raft.Leader)
+		case <-n.closeCh:
+			return
+		}
+	}
+}
+
+// Bootstrap 使用给定的成员初始化一个新集群，servers 为空时只包含本节点
+func (n *Node) Bootstrap(servers ...raft.Server) error {
+	if len(servers) == 0 {
+		servers = []raft.Server{{
+			ID:      raft.ServerID(n.options.NodeId),
+			Address: n.options.Transport.LocalAddr(),
+		}}
+	}
+	return n.raft.BootstrapCluster(raft.Configuration{Servers: servers}).Error()
+}
+
+// Join 将一个新节点作为投票成员加入集群，只能在 leader 上调用
+func (n *Node) Join(nodeId string, addr raft.ServerAddress) error {
+	future := n.raft.AddVoter(raft.ServerID(nodeId), addr, 0, n.options.ApplyTimeout)
+	return n.wrapRaftErr(future.Error())
+}
+
+// Leave 将一个节点从集群中移除，只能在 leader 上调用
+func (n *Node) Leave(nodeId string) error {
+	future := n.raft.RemoveServer(raft.ServerID(nodeId), 0, n.options.ApplyTimeout)
+	return n.wrapRaftErr(future.Error())
+}
+
+func (n *Node) Id() string {
+	return n.options.NodeId
+}
+
+func (n *Node) Address() raft.ServerAddress {
+	return n.options.Transport.LocalAddr()
+}
+
+func (n *Node) IsLeader() bool {
+	return n.raft.State() == raft.Leader
+}
+
+// Leader 返回当前 leader 的地址和 id，未知时为空
+func (n *Node) Leader() (raft.ServerAddress, string) {
+	addr, id := n.raft.LeaderWithID()
+	return addr, string(id)
+}
+
+// Snapshot 立即生成一个快照，并截断已包含在快照中的日志
+func (n *Node) Snapshot() error {
+	return n.raft.Snapshot().Error()
+}
+
+func (n *Node) NewBatch() *Batch {
+	return &Batch{
+		node:          n,
+		pendingWrites: make(map[string]*core.LogRecord),
+	}
+}
+
+func (n *Node) Put(key []byte, value []byte) error {
+	batch := n.NewBatch()
+	if err := batch.Put(key, value); err != nil {
+		return err
+	}
+	return batch.Commit()
+}
+
+// Get 线性一致读，只能在 leader 上执行
+func (n *Node) Get(key []byte) ([]byte, error) {
+	if err := n.readIndex(); err != nil {
+		return nil, err
+	}
+	return n.db.Get(key)
+}
+
+// StaleGet 直接读取本地状态机，可以在 follower 上执行，但可能读到旧数据
+func (n *Node) StaleGet(key []byte) ([]byte, error) {
+	return n.db.Get(key)
+}
+
+func (n *Node) Delete(key []byte) error {
+	batch := n.NewBatch()
+	if err := batch.Delete(key); err != nil {
+		return err
+	}
+	return batch.Commit()
+}
+
+// readIndex 确认本节点在读开始时仍然是多数派认可的 leader。
+// leader 上的写入只有在应用到状态机后才会返回给客户端，而之前任期的日志已经由
+// watchLeadership 的 barrier 应用完毕，因此确认领导权后直接读取本地状态机即可
+func (n *Node) readIndex() error {
+	if !n.leaderReady.Load() {
+		return common.NewErr(&common.NotLeaderErrNo, common.ErrNotLeader)
+	}
+	return n.wrapRaftErr(n.raft.VerifyLeader().Error())
+}
+
+func (n *Node) apply(records []*core.LogRecord) error {
+	future := n.raft.Apply(encodeCommand(records), n.options.ApplyTimeout)
+	if err := future.Error(); err != nil {
+		return n.wrapRaftErr(err)
+	}
+	if err, ok := future.Response().(error); ok && err != nil {
+		return err
+	}
+	return nil
+}
+
+func (n *Node) wrapRaftErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+		return common.NewErr(&common.NotLeaderErrNo, err)
+	}
+	return common.NewErr(&common.InnerErrNo, err)
+}
+
+// Close 关闭 raft 与本地的数据库，可以重复调用，之后的调用返回第一次关闭的结果
+func (n *Node) Close() error {
+	n.closeOnce.Do(func() {
+		close(n.closeCh)
+		// raft 关闭失败时依然需要关闭数据库，否则数据目录的 FLOCK 不会被释放
+		raftErr := n.raft.Shutdown().Error()
+		n.closeErr = errors.Join(raftErr, n.db.Close())
+	})
+	return n.closeErr
+}
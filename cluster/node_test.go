@@ -0,0 +1,220 @@
+package cluster
+
+import (
+	"fastdb/common"
+	"fastdb/config"
+	"fastdb/core"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+type testCluster struct {
+	nodes      []*Node
+	transports []*raft.InmemTransport
+}
+
+func testRaftConfig() *raft.Config {
+	c := raft.DefaultConfig()
+	c.HeartbeatTimeout = 50 * time.Millisecond
+	c.ElectionTimeout = 50 * time.Millisecond
+	c.LeaderLeaseTimeout = 50 * time.Millisecond
+	c.CommitTimeout = 5 * time.Millisecond
+	c.TrailingLogs = 4
+	c.LogLevel = "ERROR"
+	return c
+}
+
+func newTestNode(t *testing.T, id string) (*Node, *raft.InmemTransport) {
+	dir, err := os.MkdirTemp("", "fastdb-cluster")
+	assert.Nil(t, err)
+
+	options := DefaultOptions
+	options.NodeId = id
+	options.DbOptions = config.DefaultOptions
+	options.DbOptions.DirPath = dir
+	options.Raft = testRaftConfig()
+	_, transport := raft.NewInmemTransport(raft.ServerAddress(id))
+	options.Transport = transport
+
+	node, err := Open(options)
+	assert.Nil(t, err)
+	return node, transport
+}
+
+func newTestCluster(t *testing.T, size int) *testCluster {
+	c := &testCluster{}
+	var servers []raft.Server
+	for i := 0; i < size; i++ {
+		node, transport := newTestNode(t, string(rune('a'+i)))
+		c.nodes = append(c.nodes, node)
+		c.transports = append(c.transports, transport)
+		servers = append(servers, raft.Server{ID: raft.ServerID(node.Id()), Address: node.Address()})
+	}
+	c.connectAll()
+	assert.Nil(t, c.nodes[0].Bootstrap(servers...))
+	return c
+}
+
+func (c *testCluster) connectAll() {
+	for i, t1 := range c.transports {
+		for j, t2 := range c.transports {
+			if i != j {
+				t1.Connect(t2.LocalAddr(), t2)
+			}
+		}
+	}
+}
+
+func (c *testCluster) add(t *testing.T, id string) *Node {
+	node, transport := newTestNode(t, id)
+	c.nodes = append(c.nodes, node)
+	c.transports = append(c.transports, transport)
+	c.connectAll()
+	return node
+}
+
+// leader 等待集群选出一个可以处理线性一致读的 leader
+func (c *testCluster) leader(t *testing.T) *Node {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, node := range c.nodes {
+			if node.IsLeader() && node.leaderReady.Load() {
+				return node
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no leader elected")
+	return nil
+}
+
+func (c *testCluster) destroy() {
+	for _, node := range c.nodes {
+		_ = node.Close()
+		_ = os.RemoveAll(node.options.DbOptions.DirPath)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition not satisfied in time")
+}
+
+func TestNode_Replication(t *testing.T) {
+	c := newTestCluster(t, 3)
+	defer c.destroy()
+	leader := c.leader(t)
+
+	batch := leader.NewBatch()
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, batch.Put(common.GetTestKey(i), common.GetTestKey(i)))
+	}
+	assert.Nil(t, batch.Delete(common.GetTestKey(9)))
+	assert.Nil(t, batch.Commit())
+
+	val, err := leader.Get(common.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, common.GetTestKey(1), val)
+	_, err = leader.Get(common.GetTestKey(9))
+	assert.Equal(t, common.KeyNotFoundErrNo.Code, common.ExtractErrCode(err))
+
+	for _, node := range c.nodes {
+		node := node
+		waitFor(t, func() bool {
+			val, err := node.StaleGet(common.GetTestKey(5))
+			return err == nil && string(val) == string(common.GetTestKey(5))
+		})
+	}
+}
+
+func TestNode_FollowerRejects(t *testing.T) {
+	c := newTestCluster(t, 3)
+	defer c.destroy()
+	leader := c.leader(t)
+
+	for _, node := range c.nodes {
+		if node == leader {
+			continue
+		}
+		err := node.Put(common.GetTestKey(1), common.RandomValue(16))
+		assert.Equal(t, common.NotLeaderErrNo.Code, common.ExtractErrCode(err))
+		_, err = node.Get(common.GetTestKey(1))
+		assert.Equal(t, common.NotLeaderErrNo.Code, common.ExtractErrCode(err))
+	}
+}
+
+func TestNode_LeaderFailover(t *testing.T) {
+	c := newTestCluster(t, 3)
+	defer c.destroy()
+	leader := c.leader(t)
+	assert.Nil(t, leader.Put(common.GetTestKey(1), []byte("v1")))
+
+	// 断开旧 leader 的网络，剩余两个节点仍然构成多数派
+	for i, transport := range c.transports {
+		if c.nodes[i] == leader {
+			transport.DisconnectAll()
+		} else {
+			transport.Disconnect(leader.Address())
+		}
+	}
+
+	var newLeader *Node
+	waitFor(t, func() bool {
+		for _, node := range c.nodes {
+			if node != leader && node.IsLeader() && node.leaderReady.Load() {
+				newLeader = node
+				return true
+			}
+		}
+		return false
+	})
+
+	val, err := newLeader.Get(common.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+	assert.Nil(t, newLeader.Put(common.GetTestKey(2), []byte("v2")))
+}
+
+func TestNode_SnapshotInstall(t *testing.T) {
+	c := newTestCluster(t, 3)
+	defer c.destroy()
+	leader := c.leader(t)
+
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, leader.Put(common.GetTestKey(i), common.GetTestKey(i)))
+	}
+	assert.Nil(t, leader.Snapshot())
+
+	// 新节点落后于日志截断点，只能通过安装快照追上集群
+	node := c.add(t, "d")
+	assert.Nil(t, leader.Join(node.Id(), node.Address()))
+	waitFor(t, func() bool {
+		val, err := node.StaleGet(common.GetTestKey(49))
+		return err == nil && string(val) == string(common.GetTestKey(49))
+	})
+	val, err := node.StaleGet(common.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, common.GetTestKey(0), val)
+}
+
+func TestNode_CloseTwice(t *testing.T) {
+	node, _ := newTestNode(t, "a")
+	defer os.RemoveAll(node.options.DbOptions.DirPath)
+
+	assert.Nil(t, node.Close())
+	assert.Nil(t, node.Close())
+
+	// 数据库已经关闭，数据目录可以被再次打开
+	db, err := core.Open(node.options.DbOptions)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,38 @@
+package cluster
+
+import (
+	"fastdb/config"
+	"github.com/hashicorp/raft"
+	"time"
+)
+
+type Options struct {
+	// NodeId 节点在集群中的唯一标识
+	NodeId string
+
+	// DbOptions 本节点底层 core.DB 的配置
+	DbOptions config.DbOptions
+
+	// Transport 节点之间的通信方式，单进程测试时可以使用 raft.NewInmemTransport
+	Transport raft.Transport
+
+	// LogStore、StableStore、SnapshotStore 为 nil 时使用内存实现
+	LogStore      raft.LogStore
+	StableStore   raft.StableStore
+	SnapshotStore raft.SnapshotStore
+
+	// ApplyTimeout 等待一个 batch 被多数派提交并应用的最长时间
+	ApplyTimeout time.Duration
+
+	// SnapshotThreshold 距离上次快照累积多少条日志后生成新的快照
+	SnapshotThreshold uint64
+
+	// Raft 可以覆盖默认的 raft 配置，LocalID 与 NotifyCh 会被忽略
+	Raft *raft.Config
+}
+
+var DefaultOptions = Options{
+	DbOptions:         config.DefaultOptions,
+	ApplyTimeout:      5 * time.Second,
+	SnapshotThreshold: 8192,
+}
@@ -0,0 +1,265 @@
+// fastdb-cli 维护数据目录的命令行工具，执行时数据库不能处于打开状态
+package main
+
+import (
+	"fastdb/config"
+	"fastdb/core"
+	"fastdb/lib/encrypt"
+	"fastdb/tools/rdbimport"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+type command struct {
+	usage string
+	run   func(args []string, w io.Writer) error
+}
+
+var commands = map[string]command{
+	"repair":     {usage: "repair -dir <data dir> 从损坏的数据文件中重建数据库，原来的文件会被移动到备份目录", run: runRepair},
+	"export":     {usage: "export -dir <data dir> [-format jsonl|csv|native] [-out file] 导出所有 bucket 中的数据", run: runExport},
+	"rdb-import": {usage: "rdb-import -dir <data dir> -rdb <dump.rdb> [-db 0] [-skip-volatile] 导入 redis 的 RDB 文件", run: runRDBImport},
+	"import":     {usage: "import -dir <data dir> [-format jsonl|csv|native] [-in file] 导入 export 写出的数据", run: runImport},
+	"reencrypt":  {usage: "reencrypt -dir <data dir> -key-file|-key-env 使用第一个密钥重新加密所有数据文件，之后可以移除旧密钥", run: runReencrypt},
+}
+
+func main() {
+	if len(os.Args) < 2 || commands[os.Args[1]].run == nil {
+		printUsage()
+		os.Exit(2)
+	}
+	if err := commands[os.Args[1]].run(os.Args[2:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "fastdb-cli:", err)
+		os.Exit(1)
+	}
+}
+
+func printUsage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "usage: fastdb-cli <command> [flags]")
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "  "+commands[name].usage)
+	}
+}
+
+// dbFlags 是各个命令共用的打开数据库所需的参数
+type dbFlags struct {
+	dir     *string
+	keyFile *string
+	keyEnv  *string
+}
+
+func newDBFlags(fs *flag.FlagSet) *dbFlags {
+	return &dbFlags{
+		dir:     fs.String("dir", "", "数据目录"),
+		keyFile: fs.String("key-file", "", "加密密钥文件，每行一个十六进制编码的密钥"),
+		keyEnv:  fs.String("key-env", "", "保存加密密钥的环境变量，多个密钥使用逗号分隔"),
+	}
+}
+
+func (f *dbFlags) options() (config.DbOptions, error) {
+	if *f.dir == "" {
+		return config.DbOptions{}, fmt.Errorf("-dir is required")
+	}
+	options := config.DefaultOptions
+	options.DirPath = *f.dir
+	if *f.keyFile != "" {
+		options.KeyProvider = encrypt.NewFileKeyProvider(*f.keyFile)
+	} else if *f.keyEnv != "" {
+		options.KeyProvider = encrypt.NewEnvKeyProvider(*f.keyEnv)
+	}
+	return options, nil
+}
+
+func runRepair(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("repair", flag.ContinueOnError)
+	dbFlags := newDBFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	options, err := dbFlags.options()
+	if err != nil {
+		return err
+	}
+
+	report, err := core.Repair(options)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "repaired %d segment(s): kept %d records in %d batches\n",
+		report.Segments, report.Records, report.Batches)
+	for _, r := range report.Skipped {
+		fmt.Fprintf(w, "skipped segment=%d offset=%d size=%d error=%q\n", r.SegmentId, r.Offset, r.Size, r.Error)
+	}
+	for _, batch := range report.LostBatches {
+		fmt.Fprintf(w, "lost batch=%d keys=%d\n", batch.BatchId, len(batch.Keys))
+		for _, key := range batch.Keys {
+			op := "put"
+			if key.Deleted {
+				op = "delete"
+			}
+			fmt.Fprintf(w, "  %s bucket=%d key=%q\n", op, key.BucketId, key.Key)
+		}
+	}
+	fmt.Fprintf(w, "the damaged segments were moved to %s\n", report.BackupDir)
+	if len(report.Skipped) > 0 {
+		fmt.Fprintln(w, "keys written in the skipped ranges can't be identified, verify the data before removing the backup")
+	}
+	return nil
+}
+
+func runReencrypt(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("reencrypt", flag.ContinueOnError)
+	dbFlags := newDBFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	options, err := dbFlags.options()
+	if err != nil {
+		return err
+	}
+	if options.KeyProvider == nil {
+		return fmt.Errorf("-key-file or -key-env is required")
+	}
+
+	if err := core.Reencrypt(options); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "all segments are encrypted with the first key, the other keys can be removed")
+	return nil
+}
+
+func runExport(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	dbFlags := newDBFlags(fs)
+	formatName := fs.String("format", "jsonl", "导出格式: jsonl、csv 或 native")
+	out := fs.String("out", "", "输出文件，默认输出到标准输出")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	format, err := core.ParseExportFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	options, err := dbFlags.options()
+	if err != nil {
+		return err
+	}
+
+	db, err := core.Open(options)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	dst, summary := w, io.Writer(os.Stderr)
+	if *out != "" {
+		fd, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		dst, summary = fd, w
+	}
+	n, err := db.Export(dst, format)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(summary, "exported %d records\n", n)
+	return nil
+}
+
+func runImport(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	dbFlags := newDBFlags(fs)
+	formatName := fs.String("format", "jsonl", "数据格式: jsonl、csv 或 native")
+	in := fs.String("in", "", "输入文件，默认从标准输入读取")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	format, err := core.ParseExportFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	options, err := dbFlags.options()
+	if err != nil {
+		return err
+	}
+
+	src := io.Reader(os.Stdin)
+	if *in != "" {
+		fd, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		src = fd
+	}
+
+	db, err := core.Open(options)
+	if err != nil {
+		return err
+	}
+	n, err := db.Import(src, format)
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("imported %d records before the error: %w", n, err)
+	}
+	fmt.Fprintf(w, "imported %d records\n", n)
+	return nil
+}
+
+func runRDBImport(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("rdb-import", flag.ContinueOnError)
+	dbFlags := newDBFlags(fs)
+	path := fs.String("rdb", "", "RDB 文件")
+	database := fs.Int("db", -1, "只导入该编号的 redis 数据库，默认导入所有数据库")
+	skipVolatile := fs.Bool("skip-volatile", false, "跳过设置了过期时间的 key")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return fmt.Errorf("-rdb is required")
+	}
+	options, err := dbFlags.options()
+	if err != nil {
+		return err
+	}
+
+	importOptions := rdbimport.DefaultOptions
+	importOptions.SkipVolatile = *skipVolatile
+	if *database >= 0 {
+		importOptions.Databases = []int{*database}
+	}
+	db, err := core.Open(options)
+	if err != nil {
+		return err
+	}
+	report, err := rdbimport.ImportFile(db, *path, importOptions)
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "imported %d keys (%d elements): strings=%d hashes=%d lists=%d sets=%d zsets=%d\n",
+		report.Keys(), report.Elements, report.Strings, report.Hashes, report.Lists, report.Sets, report.ZSets)
+	fmt.Fprintf(w, "expired=%d skipped_volatile=%d\n", report.Expired, report.SkippedVolatile)
+	if report.Volatile > 0 {
+		fmt.Fprintf(w, "%d keys had an expiration and will not expire in fastdb\n", report.Volatile)
+	}
+	for dataType, n := range report.Unsupported {
+		fmt.Fprintf(w, "skipped %d keys of unsupported type %s\n", n, dataType)
+	}
+	return nil
+}
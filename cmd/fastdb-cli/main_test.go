@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fastdb/config"
+	"fastdb/core"
+	"fastdb/lib/encrypt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRepairCommand(t *testing.T) {
+	options := config.DefaultOptions
+	options.DirPath = t.TempDir()
+	db, err := core.Open(options)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	assert.Nil(t, db.Close())
+
+	var out bytes.Buffer
+	assert.Nil(t, runRepair([]string{"-dir", options.DirPath}, &out))
+	assert.Contains(t, out.String(), "repaired 1 segment(s): kept 1 records in 1 batches")
+
+	db, err = core.Open(options)
+	assert.Nil(t, err)
+	defer db.Close()
+	value, err := db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	// 数据库打开时不能修复
+	assert.NotNil(t, runRepair([]string{"-dir", options.DirPath}, &out))
+	assert.NotNil(t, runRepair(nil, &out))
+}
+
+func TestReencryptCommand(t *testing.T) {
+	options := config.DefaultOptions
+	options.DirPath = t.TempDir()
+	options.KeyProvider = encrypt.NewStaticKeyProvider(bytes.Repeat([]byte{1}, 16))
+	db, err := core.Open(options)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	assert.Nil(t, db.Close())
+
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	assert.Nil(t, os.WriteFile(keyFile, []byte(strings.Repeat("02", 16)+"\n"+strings.Repeat("01", 16)+"\n"), 0600))
+	var out bytes.Buffer
+	assert.NotNil(t, runReencrypt([]string{"-dir", options.DirPath}, &out))
+	assert.Nil(t, runReencrypt([]string{"-dir", options.DirPath, "-key-file", keyFile}, &out))
+
+	options.KeyProvider = encrypt.NewStaticKeyProvider(bytes.Repeat([]byte{2}, 16))
+	db, err = core.Open(options)
+	assert.Nil(t, err)
+	defer db.Close()
+	value, err := db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+}
+
+func TestExportImportCommand(t *testing.T) {
+	options := config.DefaultOptions
+	options.DirPath = t.TempDir()
+	db, err := core.Open(options)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	assert.Nil(t, db.Close())
+
+	file := filepath.Join(t.TempDir(), "export.csv")
+	var out bytes.Buffer
+	assert.Nil(t, runExport([]string{"-dir", options.DirPath, "-format", "csv", "-out", file}, &out))
+	assert.Equal(t, "exported 1 records\n", out.String())
+
+	target := t.TempDir()
+	out.Reset()
+	assert.Nil(t, runImport([]string{"-dir", target, "-format", "csv", "-in", file}, &out))
+	assert.Equal(t, "imported 1 records\n", out.String())
+
+	options.DirPath = target
+	db, err = core.Open(options)
+	assert.Nil(t, err)
+	defer db.Close()
+	value, err := db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	assert.NotNil(t, runExport([]string{"-dir", target, "-format", "xml"}, &out))
+}
@@ -0,0 +1,317 @@
+// fastdb-inspect 离线检查数据目录中的 segment 文件，数据库无法打开时用于排查问题。
+// 只以只读方式打开 segment 文件，不会获取数据目录的 FLOCK，也不会修改任何文件
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fastdb/core"
+	"fastdb/lib/encrypt"
+	"fastdb/wal"
+	"flag"
+	"fmt"
+	"github.com/bwmarrin/snowflake"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var chunkTypeNames = map[wal.ChunkType]string{
+	wal.ChunkTypeFull:   "Full",
+	wal.ChunkTypeFirst:  "First",
+	wal.ChunkTypeMiddle: "Middle",
+	wal.ChunkTypeLast:   "Last",
+}
+
+var recordTypeNames = map[core.LogRecordType]string{
+	core.LogRecordNormal:        "normal",
+	core.LogRecordDeleted:       "deleted",
+	core.LogRecordBatchFinished: "batch-finished",
+}
+
+type chunkLine struct {
+	Type   string `json:"type"`
+	Block  uint32 `json:"block"`
+	Offset int64  `json:"offset"`
+	Length uint16 `json:"length"`
+	CRC    string `json:"crc"`
+}
+
+// segmentLine 每个 segment 的头部信息
+type segmentLine struct {
+	Kind      string   `json:"kind"`
+	Segment   uint32   `json:"segment"`
+	Size      int64    `json:"size"`
+	Legacy    bool     `json:"legacy,omitempty"`
+	Version   uint16   `json:"version,omitempty"`
+	Flags     []string `json:"flags,omitempty"`
+	CreatedAt string   `json:"created_at,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// recordLine 一条完整的数据，以及组成它的物理 chunk
+type recordLine struct {
+	Kind      string      `json:"kind"`
+	Segment   uint32      `json:"segment"`
+	Block     uint32      `json:"block"`
+	Offset    int64       `json:"offset"`
+	Size      uint32      `json:"size,omitempty"`
+	Chunks    []chunkLine `json:"chunks"`
+	CRC       string      `json:"crc"`
+	Type      string      `json:"type,omitempty"`
+	BatchId   uint64      `json:"batch_id,omitempty"`
+	BucketId  uint32      `json:"bucket_id,omitempty"`
+	Key       string      `json:"key,omitempty"`
+	KeyHex    string      `json:"key_hex,omitempty"`
+	ValueSize int         `json:"value_size,omitempty"`
+	// Finished 结束标记所属的 batch
+	Finished uint64 `json:"finished,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// batchLine 没有结束标记的 batch，加载索引时会被丢弃
+type batchLine struct {
+	Kind    string `json:"kind"`
+	BatchId uint64 `json:"batch_id"`
+	Records int    `json:"records"`
+	Segment uint32 `json:"segment"`
+	Block   uint32 `json:"block"`
+	Offset  int64  `json:"offset"`
+}
+
+type printer struct {
+	w        io.Writer
+	jsonLine bool
+	quiet    bool
+}
+
+func (p *printer) print(v any, text string) {
+	if p.jsonLine {
+		data, _ := json.Marshal(v)
+		_, _ = fmt.Fprintln(p.w, string(data))
+		return
+	}
+	_, _ = fmt.Fprintln(p.w, text)
+}
+
+func main() {
+	dir := flag.String("dir", "", "数据目录")
+	ext := flag.String("ext", ".SEG", "segment 文件的扩展名")
+	jsonLine := flag.Bool("json", false, "以 JSON lines 格式输出")
+	out := flag.String("out", "", "输出文件，默认输出到标准输出")
+	unfinished := flag.Bool("unfinished", false, "只输出没有结束标记的 batch")
+	keyFile := flag.String("key-file", "", "加密密钥文件，每行一个十六进制编码的密钥")
+	keyEnv := flag.String("key-env", "", "保存加密密钥的环境变量，多个密钥使用逗号分隔")
+	flag.Parse()
+
+	if *dir == "" {
+		fmt.Fprintln(os.Stderr, "usage: fastdb-inspect -dir <data dir> [-json] [-out file] [-unfinished]")
+		os.Exit(2)
+	}
+
+	options := wal.Options{
+		DirPath:        *dir,
+		SegmentSize:    wal.DefaultOptions.SegmentSize,
+		SegmentFileExt: *ext,
+		ReadOnly:       true,
+	}
+	if *keyFile != "" {
+		options.KeyProvider = encrypt.NewFileKeyProvider(*keyFile)
+	} else if *keyEnv != "" {
+		options.KeyProvider = encrypt.NewEnvKeyProvider(*keyEnv)
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		fd, err := os.Create(*out)
+		if err != nil {
+			fail(err)
+		}
+		defer fd.Close()
+		w = fd
+	}
+
+	if err := inspect(options, &printer{w: w, jsonLine: *jsonLine, quiet: *unfinished}); err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "fastdb-inspect:", err)
+	os.Exit(1)
+}
+
+func inspect(options wal.Options, p *printer) error {
+	walFiles, err := wal.Open(options)
+	if err != nil {
+		return err
+	}
+	defer walFiles.Close()
+
+	sizes := walFiles.SegmentSizes()
+	type pendingBatch struct {
+		records  int
+		position *wal.ChunkPosition
+	}
+	pending := make(map[uint64]*pendingBatch)
+
+	var current wal.SegmentID
+	reader := walFiles.NewReader()
+	for {
+		data, position, chunks, err := reader.NextChunks()
+		if err == io.EOF {
+			break
+		}
+		if position != nil && position.SegmentId != current {
+			current = position.SegmentId
+			if !p.quiet {
+				printSegment(p, options, current, sizes[current])
+			}
+		}
+
+		line := &recordLine{Kind: "record", CRC: "ok"}
+		if position != nil {
+			line.Segment, line.Block, line.Offset, line.Size =
+				position.SegmentId, position.BlockNumber, position.ChunkOffset, position.ChunkSize
+		}
+		for _, chunk := range chunks {
+			crc := "ok"
+			if !chunk.Valid {
+				crc = "invalid"
+			}
+			line.Chunks = append(line.Chunks, chunkLine{Type: chunkTypeNames[chunk.Type],
+				Block: chunk.BlockNumber, Offset: chunk.ChunkOffset, Length: chunk.Length, CRC: crc})
+		}
+
+		if err != nil {
+			// 当前 segment 之后的数据无法定位，跳到下一个 segment 继续检查
+			line.Error = err.Error()
+			if errors.Is(err, wal.ErrInvalidCRC) {
+				line.CRC = "invalid"
+			}
+			if !p.quiet {
+				p.print(line, formatRecord(line))
+			}
+			if position == nil {
+				return err
+			}
+			reader.SkipSegment()
+			continue
+		}
+
+		record, err := core.DecodeLogRecord(data)
+		if err != nil {
+			line.Error = err.Error()
+		} else {
+			fillRecord(line, record)
+			if record.Type == core.LogRecordBatchFinished {
+				delete(pending, line.Finished)
+			} else if batch := pending[record.BatchId]; batch != nil {
+				batch.records++
+			} else {
+				pending[record.BatchId] = &pendingBatch{records: 1, position: position}
+			}
+		}
+		if !p.quiet {
+			p.print(line, formatRecord(line))
+		}
+	}
+
+	batchIds := make([]uint64, 0, len(pending))
+	for id := range pending {
+		batchIds = append(batchIds, id)
+	}
+	sort.Slice(batchIds, func(i, j int) bool {
+		return batchIds[i] < batchIds[j]
+	})
+	for _, id := range batchIds {
+		batch := pending[id]
+		line := &batchLine{Kind: "unfinished_batch", BatchId: id, Records: batch.records,
+			Segment: batch.position.SegmentId, Block: batch.position.BlockNumber, Offset: batch.position.ChunkOffset}
+		p.print(line, fmt.Sprintf("unfinished batch=%d records=%d segment=%d block=%d offset=%d",
+			line.BatchId, line.Records, line.Segment, line.Block, line.Offset))
+	}
+	return nil
+}
+
+func printSegment(p *printer, options wal.Options, id wal.SegmentID, size int64) {
+	line := &segmentLine{Kind: "segment", Segment: id, Size: size}
+	header, err := wal.ReadSegmentHeader(wal.SegmentFileName(options.DirPath, options.SegmentFileExt, id))
+	switch {
+	case err != nil:
+		line.Error = err.Error()
+	case header == nil:
+		line.Legacy = true
+	default:
+		line.Version = header.Version
+		line.CreatedAt = header.CreatedAt.Format("2006-01-02T15:04:05.000Z07:00")
+		if header.Flags&wal.SegmentFlagCompressed != 0 {
+			line.Flags = append(line.Flags, "compressed")
+		}
+		if header.Encrypted() {
+			line.Flags = append(line.Flags, "encrypted")
+		}
+	}
+
+	text := fmt.Sprintf("segment %09d%s size=%d", id, options.SegmentFileExt, size)
+	switch {
+	case line.Error != "":
+		text += " error=" + strconv.Quote(line.Error)
+	case line.Legacy:
+		text += " legacy (no header)"
+	default:
+		flags := strings.Join(line.Flags, ",")
+		if flags == "" {
+			flags = "none"
+		}
+		text += fmt.Sprintf(" version=%d flags=%s created=%s", line.Version, flags, line.CreatedAt)
+	}
+	p.print(line, text)
+}
+
+func fillRecord(line *recordLine, record *core.LogRecord) {
+	line.Type = recordTypeNames[record.Type]
+	if line.Type == "" {
+		line.Type = strconv.Itoa(int(record.Type))
+	}
+	line.BatchId = record.BatchId
+	line.BucketId = record.BucketId
+	line.ValueSize = len(record.Value)
+	if utf8.Valid(record.Key) {
+		line.Key = string(record.Key)
+	} else {
+		line.KeyHex = fmt.Sprintf("%x", record.Key)
+	}
+	if record.Type == core.LogRecordBatchFinished {
+		// 结束标记的 key 是 batch id
+		if id, err := snowflake.ParseBytes(record.Key); err == nil {
+			line.Finished = uint64(id)
+		}
+	}
+}
+
+func formatRecord(line *recordLine) string {
+	types := make([]string, 0, len(line.Chunks))
+	for _, chunk := range line.Chunks {
+		types = append(types, chunk.Type)
+	}
+	text := fmt.Sprintf("  block=%d offset=%d size=%d chunks=%s crc=%s",
+		line.Block, line.Offset, line.Size, strings.Join(types, ","), line.CRC)
+	if line.Finished != 0 {
+		text += fmt.Sprintf(" type=%s finished=%d", line.Type, line.Finished)
+	} else if line.Type != "" {
+		text += fmt.Sprintf(" type=%s batch=%d bucket=%d", line.Type, line.BatchId, line.BucketId)
+		if line.KeyHex != "" {
+			text += fmt.Sprintf(" key=0x%s value_size=%d", line.KeyHex, line.ValueSize)
+		} else {
+			text += fmt.Sprintf(" key=%q value_size=%d", line.Key, line.ValueSize)
+		}
+	}
+	if line.Error != "" {
+		text += " error=" + strconv.Quote(line.Error)
+	}
+	return text
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fastdb/config"
+	"fastdb/core"
+	"fastdb/wal"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	options := config.DefaultOptions
+	options.DirPath = t.TempDir()
+	db, err := core.Open(options)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("a"), []byte("1")))
+	assert.Nil(t, db.Put([]byte("big"), make([]byte, 70000)))
+	assert.Nil(t, db.Delete([]byte("a")))
+
+	// 数据库打开时依然可以检查，不需要获取 FLOCK
+	var buf bytes.Buffer
+	walOptions := wal.Options{DirPath: options.DirPath, SegmentSize: options.SegmentSize,
+		SegmentFileExt: ".SEG", ReadOnly: true}
+	assert.Nil(t, inspect(walOptions, &printer{w: &buf, jsonLine: true}))
+	assert.Nil(t, db.Close())
+
+	var kinds, types []string
+	var chunks int
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var line map[string]any
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &line))
+		kinds = append(kinds, line["kind"].(string))
+		if line["kind"] == "record" {
+			types = append(types, line["type"].(string))
+			chunks += len(line["chunks"].([]any))
+			assert.Equal(t, "ok", line["crc"])
+		}
+	}
+	assert.Equal(t, []string{"segment", "record", "record", "record", "record", "record", "record"}, kinds)
+	assert.Equal(t, []string{"normal", "batch-finished", "normal", "batch-finished", "deleted", "batch-finished"}, types)
+	// 70000 字节的 value 跨越三个 block
+	assert.Equal(t, 8, chunks)
+
+	// 损坏最后一个结束标记，对应的 batch 没有完成
+	path := wal.SegmentFileName(options.DirPath, ".SEG", 1)
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	data[len(data)-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(path, data, 0644))
+
+	buf.Reset()
+	assert.Nil(t, inspect(walOptions, &printer{w: &buf, jsonLine: true, quiet: true}))
+	var batch batchLine
+	assert.Nil(t, json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &batch))
+	assert.Equal(t, "unfinished_batch", batch.Kind)
+	assert.Equal(t, 1, batch.Records)
+}
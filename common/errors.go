@@ -24,6 +24,10 @@ func (err *Err) Error() string {
 	return fmt.Sprintf("Err - code: %d, message: %s, error: %s", err.Code, err.Message, err.Err)
 }
 
+func (err *Err) Unwrap() error {
+	return err.Err
+}
+
 func NewErr(errno *ErrNo, err error) *Err {
 	return &Err{
 		Code:    errno.Code,
@@ -51,6 +55,13 @@ var (
 	DBClosedErrNo        = ErrNo{Code: 10007, Message: "the database is closed"}
 	MergeRunningErrNo    = ErrNo{Code: 10008, Message: "the merge operation is running"}
 	UnknownActionErrNo   = ErrNo{Code: 10009, Message: "未知行为无法处理"}
+	NotLeaderErrNo       = ErrNo{Code: 10010, Message: "the node is not the raft leader"}
+	NoAvailableNodeErrNo = ErrNo{Code: 10011, Message: "no available backend node"}
+	BucketNotFoundErrNo  = ErrNo{Code: 10012, Message: "the bucket does not exist"}
+	WrongTypeErrNo       = ErrNo{Code: 10013, Message: "operation against a key holding the wrong kind of value"}
+	NotIntegerErrNo      = ErrNo{Code: 10014, Message: "the value is not an integer or out of range"}
+	NotFloatErrNo        = ErrNo{Code: 10015, Message: "the value is not a valid float"}
+	ConditionFailedErrNo = ErrNo{Code: 10016, Message: "the write condition is not satisfied"}
 )
 
 var (
@@ -62,4 +73,11 @@ var (
 	ErrDBClosed        = errors.New("the database is closed")
 	ErrMergeRunning    = errors.New("the merge operation is running")
 	ErrUnknownAction   = errors.New("未知行为无法处理")
+	ErrNotLeader       = errors.New("the node is not the raft leader")
+	ErrNoAvailableNode = errors.New("no available backend node")
+	ErrBucketNotFound  = errors.New("the bucket does not exist")
+	ErrWrongType       = errors.New("operation against a key holding the wrong kind of value")
+	ErrNotInteger      = errors.New("the value is not an integer or out of range")
+	ErrNotFloat        = errors.New("the value is not a valid float")
+	ErrConditionFailed = errors.New("the write condition is not satisfied")
 )
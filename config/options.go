@@ -1,12 +1,43 @@
 package config
 
-import "os"
+import (
+	"fastdb/lib/encrypt"
+	"os"
+	"time"
+)
 
 type BatchOptions struct {
 	Sync     bool
 	ReadOnly bool
 }
 
+// CompressionType 代表 value 的压缩算法，会被写入记录中，修改配置后旧的记录依然可以读取
+type CompressionType = byte
+
+const (
+	NoCompression CompressionType = iota
+	// SnappyCompression 压缩与解压速度快，压缩率一般
+	SnappyCompression
+	// ZstdCompression 压缩率高，速度比 snappy 慢
+	ZstdCompression
+)
+
+// IndexType 代表每个 bucket 所使用的索引
+type IndexType = byte
+
+const (
+	// RadixTreeIndex 不可变的基数树，迭代器创建时不需要复制数据，适合前缀查询
+	RadixTreeIndex IndexType = iota
+	// BTreeIndex 有序的 B 树，写入时原地修改，内存占用比基数树少
+	BTreeIndex
+	// HashMapIndex 分片的哈希表，点查询最快，但迭代时需要复制并排序所有的 key
+	HashMapIndex
+	// DiskBTreeIndex 保存在数据目录中的 B+ 树，适合无法全部放入内存的 keyspace，
+	// 索引修改与 WAL 中的位置一起原子地提交，打开数据库时只需要重放之后的记录。
+	// 索引文件以明文保存 key，不能与 KeyProvider 同时使用
+	DiskBTreeIndex
+)
+
 type DbOptions struct {
 	DirPath string
 	// SegmentSize specifies the maximum size of each segment file in bytes.
@@ -17,11 +48,51 @@ type DbOptions struct {
 	Sync bool
 
 	BytesPerSync uint32
+
+	// IndexType 索引的类型
+	IndexType IndexType
+
+	// Compression 写入 value 时使用的压缩算法
+	Compression CompressionType
+	// CompressionMinSize 小于该大小的 value 不进行压缩
+	CompressionMinSize int
+
+	// HistoryVersions 每个 key 最多保留的版本数量(包括当前版本)，为 0 时不限制数量
+	HistoryVersions int
+	// HistoryRetention 被覆盖的版本的保留时长，为 0 时不限制时长。
+	// HistoryVersions 与 HistoryRetention 都为 0 时不保留历史版本。
+	// 版本链保存在内存中，打开时从 WAL 重建；保留策略只限制可读的版本，不会清理数据文件中的旧记录
+	HistoryRetention time.Duration
+
+	// KeyProvider 不为 nil 时数据文件使用 AES-GCM 加密，为 nil 时不加密
+	KeyProvider encrypt.KeyProvider
+
+	// ScrubInterval 后台校验数据文件的间隔，为 0 时不在后台校验
+	ScrubInterval time.Duration
+	// ScrubBytesPerSecond 校验时每秒最多读取的字节数，避免影响前台的读写，为 0 时不限制
+	ScrubBytesPerSecond int64
+
+	// BloomFilter 为每个 bucket 维护一个布隆过滤器，读取不存在的 key 时不需要查询索引
+	BloomFilter bool
+	// BloomFalsePositiveRate 布隆过滤器的目标误判率
+	BloomFalsePositiveRate float64
+	// BloomExpectedKeys 每个 bucket 的布隆过滤器的初始容量，key 的数量超过容量时会追加一个两倍容量的过滤器
+	BloomExpectedKeys int
+}
+
+type ProxyOptions struct {
+	// Nodes 后端 fastdb 节点的地址，例如 127.0.0.1:6666
+	Nodes []string
+	// VirtualNodes 每个后端节点在一致性哈希环上的虚拟节点数量
+	VirtualNodes int
+	// HealthCheckInterval 后端节点健康检查的间隔，为 0 时不做健康检查
+	HealthCheckInterval time.Duration
 }
 
 type ServerOptions struct {
 	BatchOptions BatchOptions
 	DbOptions    DbOptions
+	ProxyOptions ProxyOptions
 	Port         uint16
 }
 
@@ -33,11 +104,17 @@ const (
 )
 
 var DefaultOptions = DbOptions{
-	DirPath:      tempDBDir(),
-	SegmentSize:  1 * GB,
-	BlockCache:   64 * MB,
-	Sync:         false,
-	BytesPerSync: 0,
+	DirPath:            tempDBDir(),
+	SegmentSize:        1 * GB,
+	BlockCache:         64 * MB,
+	Sync:               false,
+	BytesPerSync:       0,
+	IndexType:          RadixTreeIndex,
+	Compression:        NoCompression,
+	CompressionMinSize: 256,
+
+	BloomFalsePositiveRate: 0.01,
+	BloomExpectedKeys:      64 * 1024,
 }
 
 var DefaultBatchOptions = BatchOptions{
@@ -45,6 +122,11 @@ var DefaultBatchOptions = BatchOptions{
 	ReadOnly: false,
 }
 
+var DefaultProxyOptions = ProxyOptions{
+	VirtualNodes:        160,
+	HealthCheckInterval: time.Second,
+}
+
 func tempDBDir() string {
 	dir, _ := os.MkdirTemp("", "rosedb-temp")
 	return dir
@@ -0,0 +1,204 @@
+package core
+
+import (
+	"encoding/binary"
+	"fastdb/common"
+	"fastdb/wal"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Backup 代表数据库在某一时间点的数据文件视图，可用于备份以及 raft 快照
+type Backup struct {
+	db       *DB
+	segments []wal.SegmentID
+	sizes    map[wal.SegmentID]int64
+}
+
+// NewBackup 记录下当前所有 segment 文件的大小。
+// 由于 WAL 只会追加写入，这些文件前缀构成了一个时间点一致的快照，
+// 之后的写入不会影响 Backup 的内容
+func (db *DB) NewBackup() (*Backup, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, common.NewErr(&common.DBClosedErrNo, common.ErrDBClosed)
+	}
+
+	sizes := db.dataFiles.SegmentSizes()
+	segments := make([]wal.SegmentID, 0, len(sizes))
+	for id := range sizes {
+		segments = append(segments, id)
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i] < segments[j]
+	})
+	return &Backup{db: db, segments: segments, sizes: sizes}, nil
+}
+
+// WriteTo 将快照写入 w
+// 格式: segment 数量(4) + [segment id(4) + 字节数(8) + 数据]...
+func (bk *Backup) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	header := make([]byte, 12)
+	binary.LittleEndian.PutUint32(header[:4], uint32(len(bk.segments)))
+	n, err := w.Write(header[:4])
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	for _, id := range bk.segments {
+		size := bk.sizes[id]
+		binary.LittleEndian.PutUint32(header[:4], id)
+		binary.LittleEndian.PutUint64(header[4:], uint64(size))
+		n, err = w.Write(header)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+
+		fd, err := os.Open(wal.SegmentFileName(bk.db.options.DirPath, dataFileNameSuffix, id))
+		if err != nil {
+			return written, err
+		}
+		copied, err := io.CopyN(w, fd, size)
+		written += copied
+		_ = fd.Close()
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+const (
+	// restoreTempDir 恢复快照时先将快照中的 segment 文件写入该目录，全部写完并校验之后再替换原来的文件
+	restoreTempDir = "restore-tmp"
+	// restoreBackupDir 替换期间原来的 segment 文件所在的目录，恢复成功后删除
+	restoreBackupDir = "restore-old"
+)
+
+// Restore 使用 Backup 写出的快照替换数据库当前的全部数据，并重建索引。
+// 快照先被完整地写入临时目录并校验，读取或者校验失败时数据库中的数据保持不变
+func (db *DB) Restore(r io.Reader) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return common.NewErr(&common.DBClosedErrNo, common.ErrDBClosed)
+	}
+
+	tempDir := filepath.Join(db.options.DirPath, restoreTempDir)
+	if err := os.RemoveAll(tempDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tempDir, os.ModePerm); err != nil {
+		return err
+	}
+	if err := readSnapshot(tempDir, r); err != nil {
+		_ = os.RemoveAll(tempDir)
+		return err
+	}
+
+	if err := db.dataFiles.Close(); err != nil {
+		_ = os.RemoveAll(tempDir)
+		return err
+	}
+	backupDir := filepath.Join(db.options.DirPath, restoreBackupDir)
+	swapErr := os.RemoveAll(backupDir)
+	if swapErr == nil {
+		swapErr = replaceDataFiles(db.options.DirPath, tempDir, backupDir)
+	}
+	// 替换失败时 replaceDataFiles 已经回滚，重新打开的是原来的文件
+	walFiles, err := openDataFiles(db.options)
+	if err != nil {
+		return err
+	}
+	db.dataFiles = walFiles
+	if swapErr != nil {
+		_ = os.RemoveAll(tempDir)
+		return swapErr
+	}
+	if err := os.RemoveAll(backupDir); err != nil {
+		return err
+	}
+
+	// 快照中的 bucket 会重新加载，之前获取的 bucket 句柄全部失效
+	for _, bucket := range db.buckets {
+		bucket.dropped.Store(true)
+	}
+	return db.rebuildIndex()
+}
+
+// readSnapshot 将快照中的 segment 文件写入 dirPath，并校验写入的文件数量与大小
+func readSnapshot(dirPath string, r io.Reader) error {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header[:4]); err != nil {
+		return fmt.Errorf("read snapshot header failed: %v", err)
+	}
+	count := binary.LittleEndian.Uint32(header[:4])
+	sizes := make(map[wal.SegmentID]int64, count)
+	for i := uint32(0); i < count; i++ {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return fmt.Errorf("read snapshot segment header failed: %v", err)
+		}
+		id := binary.LittleEndian.Uint32(header[:4])
+		size := int64(binary.LittleEndian.Uint64(header[4:]))
+		if _, ok := sizes[id]; ok || size < 0 {
+			return fmt.Errorf("invalid snapshot segment %d", id)
+		}
+		if err := restoreSegmentFile(dirPath, id, size, r); err != nil {
+			return err
+		}
+		sizes[id] = size
+	}
+
+	expected := make(map[string]int64, len(sizes))
+	for id, size := range sizes {
+		expected[filepath.Base(wal.SegmentFileName(dirPath, dataFileNameSuffix, id))] = size
+	}
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return err
+	}
+	restored := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), dataFileNameSuffix) {
+			continue
+		}
+		restored++
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		size, ok := expected[entry.Name()]
+		if !ok || info.Size() != size {
+			return fmt.Errorf("restored segment file %s does not match the snapshot", entry.Name())
+		}
+	}
+	if restored != len(sizes) {
+		return fmt.Errorf("restored %d segment files, expected %d", restored, len(sizes))
+	}
+	return syncDir(dirPath)
+}
+
+func restoreSegmentFile(dirPath string, id wal.SegmentID, size int64, r io.Reader) error {
+	fd, err := os.OpenFile(wal.SegmentFileName(dirPath, dataFileNameSuffix, id),
+		os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.CopyN(fd, r, size); err != nil {
+		_ = fd.Close()
+		return fmt.Errorf("restore segment file %d failed: %v", id, err)
+	}
+	if err = fd.Sync(); err != nil {
+		_ = fd.Close()
+		return err
+	}
+	return fd.Close()
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"bytes"
+	"fastdb/common"
+	"fastdb/config"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDB_Restore(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(common.GetTestKey(i), []byte("backup")))
+	}
+	backup, err := db.NewBackup()
+	assert.Nil(t, err)
+	var buf bytes.Buffer
+	_, err = backup.WriteTo(&buf)
+	assert.Nil(t, err)
+	snapshot := buf.Bytes()
+
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(common.GetTestKey(i), []byte("latest")))
+	}
+
+	// 不完整的快照不会影响原来的数据，数据库依然可以读写
+	for _, size := range []int{2, 10, len(snapshot) - 1} {
+		assert.NotNil(t, db.Restore(bytes.NewReader(snapshot[:size])))
+		val, err := db.Get(common.GetTestKey(150))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("latest"), val)
+	}
+	assert.Nil(t, db.Put([]byte("after-failed-restore"), []byte("1")))
+	_, err = os.Stat(filepath.Join(db.options.DirPath, restoreTempDir))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Nil(t, db.Restore(bytes.NewReader(snapshot)))
+	assert.Equal(t, 100, db.defaultBucket.Stats().KeyCount)
+	val, err := db.Get(common.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("backup"), val)
+	_, err = db.Get(common.GetTestKey(150))
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+	for _, dir := range []string{restoreTempDir, restoreBackupDir, swapMarkerName} {
+		_, err = os.Stat(filepath.Join(db.options.DirPath, dir))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+func removeDataFiles(dirPath string) error {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), dataFileNameSuffix) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dirPath, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
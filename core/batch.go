@@ -1,10 +1,11 @@
 package core
 
 import (
+	"encoding/binary"
 	"fastdb/common"
 	"fastdb/config"
+	"fastdb/index"
 	"fastdb/wal"
-	"fmt"
 	"github.com/bwmarrin/snowflake"
 	"sync"
 )
@@ -12,32 +13,52 @@ import (
 // Batch 是个对数据库的批量操作
 
 type Batch struct {
-	db            *DB
+	db *DB
+	// bucket Put/Get/Delete 默认操作的 bucket
+	bucket        *Bucket
 	pendingWrites map[string]*LogRecord
 	options       config.BatchOptions
 	mu            sync.RWMutex
 	committed     bool
-	batchId       *snowflake.Node
+	// released 代表 batch 持有的数据库锁是否已经释放
+	released bool
+	batchId  *snowflake.Node
 }
 
 func (db *DB) NewBatch(options config.BatchOptions) *Batch {
+	return db.newBatch(db.defaultBucket, options)
+}
+
+func (db *DB) newBatch(bucket *Bucket, options config.BatchOptions) *Batch {
 	batch := &Batch{
 		db:        db,
+		bucket:    bucket,
 		options:   options,
 		committed: false,
 	}
 	if !options.ReadOnly {
 		batch.pendingWrites = make(map[string]*LogRecord)
-		node, err := snowflake.NewNode(1)
-		if err != nil {
-			panic(fmt.Sprintf("snowflake.NewNode(1) failed: %v", err))
-		}
-		batch.batchId = node
+		batch.batchId = db.batchIdNode
 	}
 	batch.lock()
 	return batch
 }
 
+// asyncBatchOptions 单个命令所使用的 batch 选项，是否刷盘由 DbOptions.Sync 决定
+func asyncBatchOptions() config.BatchOptions {
+	options := config.DefaultBatchOptions
+	options.Sync = false
+	return options
+}
+
+// pendingKey 不同 bucket 中相同的 key 在 pendingWrites 中需要区分开
+func pendingKey(bucketId uint32, key []byte) string {
+	buf := make([]byte, 4+len(key))
+	binary.BigEndian.PutUint32(buf, bucketId)
+	copy(buf[4:], key)
+	return string(buf)
+}
+
 func (b *Batch) lock() {
 	if b.options.ReadOnly {
 		b.db.mu.RLock()
@@ -47,6 +68,10 @@ func (b *Batch) lock() {
 }
 
 func (b *Batch) unlock() {
+	if b.released {
+		return
+	}
+	b.released = true
 	if b.options.ReadOnly {
 		b.db.mu.RUnlock()
 	} else {
@@ -59,6 +84,10 @@ func (b *Batch) Close() {
 }
 
 func (b *Batch) Put(key []byte, value []byte) error {
+	return b.put(b.bucket, key, value)
+}
+
+func (b *Batch) put(bucket *Bucket, key []byte, value []byte) error {
 	if len(key) == 0 {
 		return common.NewErr(&common.KeyIsEmptyErrNo, common.ErrKeyIsEmpty)
 	}
@@ -68,54 +97,86 @@ func (b *Batch) Put(key []byte, value []byte) error {
 	if b.options.ReadOnly {
 		return common.NewErr(&common.ReadOnlyBatchErrNo, common.ErrReadOnlyBatch)
 	}
+	if err := bucket.checkDropped(); err != nil {
+		return err
+	}
+	if b.db.indexStore != nil && len(key) > index.MaxDiskKeySize {
+		return common.NewErr(&common.InnerErrNo, index.ErrDiskKeyTooLarge)
+	}
+	if len(value) > MaxValueSize {
+		return common.NewErr(&common.InnerErrNo, ErrValueTooLarge)
+	}
 
 	b.mu.Lock()
-	b.pendingWrites[string(key)] = &LogRecord{
-		Key:   key,
-		Value: value,
-		Type:  LogRecordNormal,
+	b.pendingWrites[pendingKey(bucket.id, key)] = &LogRecord{
+		Key:      key,
+		Value:    value,
+		Type:     LogRecordNormal,
+		BucketId: bucket.id,
 	}
 	b.mu.Unlock()
 	return nil
 }
 
 func (b *Batch) Get(key []byte) ([]byte, error) {
+	return b.get(b.bucket, key)
+}
+
+func (b *Batch) get(bucket *Bucket, key []byte) ([]byte, error) {
+	record, err := b.getRecord(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return record.Value, nil
+}
+
+// getRecord 返回 key 最新的记录，batch 中尚未提交的记录优先，其 BatchId 为 0
+func (b *Batch) getRecord(bucket *Bucket, key []byte) (*LogRecord, error) {
 	if len(key) == 0 {
 		return nil, common.NewErr(&common.KeyIsEmptyErrNo, common.ErrKeyIsEmpty)
 	}
 	if b.db.closed {
 		return nil, common.NewErr(&common.DBClosedErrNo, common.ErrDBClosed)
 	}
+	if err := bucket.checkDropped(); err != nil {
+		return nil, err
+	}
 
 	if b.pendingWrites != nil {
 		b.mu.RLock()
-		if record := b.pendingWrites[string(key)]; record != nil {
+		record := b.pendingWrites[pendingKey(bucket.id, key)]
+		b.mu.RUnlock()
+		if record != nil {
 			if record.Type == LogRecordDeleted {
-				b.mu.RUnlock()
 				return nil, common.NewErr(&common.KeyNotFoundErrNo, common.ErrKeyNotFound)
 			}
-			b.mu.RUnlock()
-			return record.Value, nil
+			return record, nil
 		}
 	}
 
-	chunkPosition := b.db.index.Get(key)
+	if !bucket.checkBloom(key) {
+		return nil, common.NewErr(&common.KeyNotFoundErrNo, common.ErrKeyNotFound)
+	}
+	chunkPosition := bucket.index.Get(key)
 	if chunkPosition == nil {
+		bucket.bloomMiss()
 		return nil, common.NewErr(&common.KeyNotFoundErrNo, common.ErrKeyNotFound)
 	}
-	chunk, err := b.db.dataFiles.Read(chunkPosition)
+	record, err := bucket.readRecord(chunkPosition)
 	if err != nil {
-		return nil, common.NewErr(&common.InnerErrNo, err)
+		return nil, err
 	}
-
-	record := decodeLogRecord(chunk)
 	if record.Type == LogRecordDeleted {
 		return nil, common.NewErr(&common.KeyNotFoundErrNo, common.ErrKeyNotFound)
 	}
-	return record.Value, nil
+	return record, nil
 }
 
 func (b *Batch) Delete(key []byte) error {
+	return b.delete(b.bucket, key)
+}
+
+func (b *Batch) delete(bucket *Bucket, key []byte) error {
 	if len(key) == 0 {
 		return common.NewErr(&common.KeyIsEmptyErrNo, common.ErrKeyIsEmpty)
 	}
@@ -126,15 +187,20 @@ func (b *Batch) Delete(key []byte) error {
 		return common.NewErr(&common.ReadOnlyBatchErrNo, common.ErrReadOnlyBatch)
 	}
 
+	if err := bucket.checkDropped(); err != nil {
+		return err
+	}
+
 	b.mu.Lock()
-	if position := b.db.index.Get(key); position != nil {
+	if position := bucket.index.Get(key); position != nil {
 		// write to pendingWrites if the key exists
-		b.pendingWrites[string(key)] = &LogRecord{
-			Key:  key,
-			Type: LogRecordDeleted,
+		b.pendingWrites[pendingKey(bucket.id, key)] = &LogRecord{
+			Key:      key,
+			Type:     LogRecordDeleted,
+			BucketId: bucket.id,
 		}
 	} else {
-		delete(b.pendingWrites, string(key))
+		delete(b.pendingWrites, pendingKey(bucket.id, key))
 	}
 	b.mu.Unlock()
 
@@ -142,6 +208,7 @@ func (b *Batch) Delete(key []byte) error {
 }
 
 func (b *Batch) Commit() error {
+	defer b.unlock()
 	if b.db.closed {
 		return common.ErrDBClosed
 	}
@@ -157,24 +224,30 @@ func (b *Batch) Commit() error {
 		return common.ErrBatchCommitted
 	}
 
+	// 磁盘索引之前的提交失败时拒绝写入，写入 WAL 之后的 batch 不会因为索引而失败
+	if err := b.db.beginIndex(); err != nil {
+		return err
+	}
+
 	batchId := b.batchId.Generate()
 	positions := make(map[string]*wal.ChunkPosition)
 
 	for _, record := range b.pendingWrites {
 		record.BatchId = uint64(batchId)
-		encRecord := encodeLogRecord(record)
+		encRecord := encodeLogRecord(record, b.db.options.Compression, b.db.options.CompressionMinSize)
 		pos, err := b.db.dataFiles.Write(encRecord)
 		if err != nil {
 			return err
 		}
-		positions[string(record.Key)] = pos
+		positions[pendingKey(record.BucketId, record.Key)] = pos
 	}
 
 	endRecord := encodeLogRecord(&LogRecord{
 		Key:  batchId.Bytes(),
 		Type: LogRecordBatchFinished,
-	})
-	if _, err := b.db.dataFiles.Write(endRecord); err != nil {
+	}, config.NoCompression, 0)
+	endPosition, err := b.db.dataFiles.Write(endRecord)
+	if err != nil {
 		return err
 	}
 
@@ -185,15 +258,50 @@ func (b *Batch) Commit() error {
 		}
 	}
 
-	// 最后更新索引
+	// 最后更新索引，磁盘索引的修改与 checkpoint 在同一个事务中提交
+	mutations := make(indexMutations)
 	for key, record := range b.pendingWrites {
-		if record.Type == LogRecordDeleted {
-			b.db.index.Delete(record.Key)
-		} else {
-			b.db.index.Put(record.Key, positions[key])
+		bucket := b.db.bucketIds[record.BucketId]
+		if bucket == nil {
+			continue
+		}
+		bucket.addVersion(record.Key, record.BatchId, positions[key], record.Type == LogRecordDeleted)
+		mutations.add(bucket, record.Key, positions[key], record.Type == LogRecordDeleted)
+	}
+	// 每个 bucket 的修改在一次 Apply 中完成，读取方不会看到只应用了一部分的 batch
+	mutations.apply()
+	if !b.db.watcher.empty() {
+		for key, record := range b.pendingWrites {
+			if record.Type != LogRecordDeleted {
+				b.db.watcher.notify(key)
+			}
 		}
 	}
+	b.db.deferIndexCommit(&indexCheckpoint{batchId: uint64(batchId), position: endPosition}, len(b.pendingWrites))
 
 	b.committed = true
 	return nil
 }
+
+// BucketBatch 是 Batch 在某个 bucket 上的视图，
+// 通过同一个 Batch 的多个视图可以原子地修改多个 bucket
+type BucketBatch struct {
+	batch  *Batch
+	bucket *Bucket
+}
+
+func (b *Batch) Bucket(bucket *Bucket) *BucketBatch {
+	return &BucketBatch{batch: b, bucket: bucket}
+}
+
+func (bb *BucketBatch) Put(key []byte, value []byte) error {
+	return bb.batch.put(bb.bucket, key, value)
+}
+
+func (bb *BucketBatch) Get(key []byte) ([]byte, error) {
+	return bb.batch.get(bb.bucket, key)
+}
+
+func (bb *BucketBatch) Delete(key []byte) error {
+	return bb.batch.delete(bb.bucket, key)
+}
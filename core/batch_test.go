@@ -33,7 +33,7 @@ func TestBatch_GET_Normal(t *testing.T) {
 	assert.Nil(t, err)
 	val, err := batch2.Get(common.GetTestKey(450))
 	assert.Nil(t, val)
-	assert.Equal(t, common.ErrKeyNotFound, err)
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
 	_ = batch2.Commit()
 
 }
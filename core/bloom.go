@@ -0,0 +1,224 @@
+package core
+
+import (
+	"encoding/binary"
+	"fastdb/common"
+	"fastdb/index"
+	"fastdb/lib/bloom"
+	"math"
+	"sync/atomic"
+)
+
+const (
+	defaultBloomExpectedKeys = 64 * 1024
+	defaultBloomRate         = 0.01
+)
+
+// bloomFilter 一个 bucket 的布隆过滤器以及它的统计。
+// filters 只在持有写锁时修改，读取时只需要读锁
+type bloomFilter struct {
+	// filters 按照创建顺序排列，只有最后一个接受新的 key。最后一个满了之后追加一个容量翻倍、
+	// 误判率减半的过滤器，不需要遍历索引重建，所有过滤器合起来的误判率不超过 rate
+	filters []*bloom.Filter
+	rate    float64
+	// capacity 第一个过滤器的容量
+	capacity int
+	// checks 读取时查询过滤器的次数
+	checks atomic.Uint64
+	// negatives 过滤器确认 key 不存在的次数
+	negatives atomic.Uint64
+	// falsePositives 过滤器认为 key 可能存在，但索引中并不存在的次数
+	falsePositives atomic.Uint64
+}
+
+type BloomStats struct {
+	Enabled bool
+	// Keys 加入过滤器的 key 数量，被删除的 key 在重建之前依然留在过滤器中
+	Keys int
+	// Capacity 所有过滤器的容量之和
+	Capacity int
+	// Filters 过滤器的数量，key 的数量超过容量时会追加新的过滤器
+	Filters int
+	Bits    uint64
+	// HashFunctions 最新的过滤器中每个 key 设置的位数
+	HashFunctions uint64
+	Checks        uint64
+	Negatives     uint64
+	// FalsePositives 读取时过滤器返回 true 但 key 不存在的次数
+	FalsePositives uint64
+	// FalsePositiveRate 读取不存在的 key 时实际观测到的误判率
+	FalsePositiveRate float64
+	// EstimatedFalsePositiveRate 根据位数组中已经设置的位估算的误判率
+	EstimatedFalsePositiveRate float64
+}
+
+func newBloomFilter(capacity int, rate float64) *bloomFilter {
+	if capacity <= 0 {
+		capacity = defaultBloomExpectedKeys
+	}
+	if rate <= 0 || rate >= 1 {
+		rate = defaultBloomRate
+	}
+	bf := &bloomFilter{rate: rate, capacity: capacity}
+	bf.reset(capacity)
+	return bf
+}
+
+// reset 清空过滤器，只保留一个容量为 capacity 的过滤器
+func (bf *bloomFilter) reset(capacity int) {
+	bf.filters = []*bloom.Filter{bloom.New(capacity, bf.rate/2)}
+}
+
+func (bf *bloomFilter) add(key []byte) {
+	last := bf.filters[len(bf.filters)-1]
+	if last.Count() >= last.Capacity() {
+		last = bloom.New(last.Capacity()*2, bf.rate/math.Pow(2, float64(len(bf.filters)+1)))
+		bf.filters = append(bf.filters, last)
+	}
+	last.Add(key)
+}
+
+func (bf *bloomFilter) mayContain(key []byte) bool {
+	for _, filter := range bf.filters {
+		if filter.MayContain(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// encode 编码所有的过滤器: count [size filter]...
+func (bf *bloomFilter) encode(buf []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(bf.filters)))
+	for _, filter := range bf.filters {
+		data, _ := filter.MarshalBinary()
+		buf = binary.AppendUvarint(buf, uint64(len(data)))
+		buf = append(buf, data...)
+	}
+	return buf
+}
+
+// decode 从 buf 中解码 encode 编码的过滤器，返回剩余的数据
+func (bf *bloomFilter) decode(buf []byte) ([]byte, error) {
+	count, n := binary.Uvarint(buf)
+	if n <= 0 || count == 0 {
+		return nil, bloom.ErrInvalidEncoding
+	}
+	buf = buf[n:]
+	filters := make([]*bloom.Filter, 0, count)
+	for i := uint64(0); i < count; i++ {
+		size, n := binary.Uvarint(buf)
+		if n <= 0 || size > uint64(len(buf)-n) {
+			return nil, bloom.ErrInvalidEncoding
+		}
+		filter := &bloom.Filter{}
+		if err := filter.UnmarshalBinary(buf[n : n+int(size)]); err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+		buf = buf[n+int(size):]
+	}
+	bf.filters = filters
+	return buf, nil
+}
+
+// addToBloom 将新加入索引的 key 加入过滤器，调用方需要持有写锁
+func (bk *Bucket) addToBloom(key []byte) {
+	bk.bloom.add(key)
+}
+
+// rebuildBloom 按照索引中当前的 key 重建过滤器，清除已经被删除的 key，需要遍历整个索引，调用方需要持有写锁
+func (bk *Bucket) rebuildBloom() {
+	if bk.bloom == nil {
+		return
+	}
+	capacity := bk.bloom.capacity
+	if size := bk.index.Size() * 2; size > capacity {
+		capacity = size
+	}
+	bk.bloom.reset(capacity)
+	iter := bk.index.Iterator(index.IteratorOptions{})
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		bk.bloom.add(iter.Key())
+	}
+}
+
+// checkBloom 读取 key 之前查询过滤器，返回 false 时 key 一定不存在，调用方需要持有数据库的锁
+func (bk *Bucket) checkBloom(key []byte) bool {
+	if bk.bloom == nil {
+		return true
+	}
+	bk.bloom.checks.Add(1)
+	if bk.bloom.mayContain(key) {
+		return true
+	}
+	bk.bloom.negatives.Add(1)
+	return false
+}
+
+// bloomMiss 过滤器返回 true 但索引中不存在该 key
+func (bk *Bucket) bloomMiss() {
+	if bk.bloom != nil {
+		bk.bloom.falsePositives.Add(1)
+	}
+}
+
+// MayContain 返回 false 时 key 一定不在 bucket 中，返回 true 时 key 可能存在。
+// 开启布隆过滤器时只查询过滤器，不会访问索引与数据文件；未开启时查询索引，结果是准确的
+func (bk *Bucket) MayContain(key []byte) (bool, error) {
+	db := bk.db
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return false, common.NewErr(&common.DBClosedErrNo, common.ErrDBClosed)
+	}
+	if err := bk.checkDropped(); err != nil {
+		return false, err
+	}
+	if bk.bloom == nil {
+		return bk.index.Get(key) != nil, nil
+	}
+	return bk.bloom.mayContain(key), nil
+}
+
+func (bk *Bucket) BloomStats() BloomStats {
+	db := bk.db
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if bk.bloom == nil {
+		return BloomStats{}
+	}
+
+	stats := BloomStats{
+		Enabled:        true,
+		Filters:        len(bk.bloom.filters),
+		Checks:         bk.bloom.checks.Load(),
+		Negatives:      bk.bloom.negatives.Load(),
+		FalsePositives: bk.bloom.falsePositives.Load(),
+	}
+	// 任意一个过滤器误判都会返回 true
+	negative := 1.0
+	for _, filter := range bk.bloom.filters {
+		stats.Keys += filter.Count()
+		stats.Capacity += filter.Capacity()
+		stats.Bits += filter.Bits()
+		stats.HashFunctions = filter.HashFunctions()
+		negative *= 1 - filter.FalsePositiveRate()
+	}
+	stats.EstimatedFalsePositiveRate = 1 - negative
+	if misses := stats.Negatives + stats.FalsePositives; misses > 0 {
+		stats.FalsePositiveRate = float64(stats.FalsePositives) / float64(misses)
+	}
+	return stats
+}
+
+// MayContain 查询默认 bucket，见 Bucket.MayContain
+func (db *DB) MayContain(key []byte) (bool, error) {
+	return db.defaultBucket.MayContain(key)
+}
+
+// BloomStats 返回默认 bucket 的布隆过滤器统计
+func (db *DB) BloomStats() BloomStats {
+	return db.defaultBucket.BloomStats()
+}
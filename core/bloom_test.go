@@ -0,0 +1,121 @@
+package core
+
+import (
+	"fastdb/common"
+	"fastdb/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDB_MayContain(t *testing.T) {
+	options := config.DefaultOptions
+	options.BloomFilter = true
+	options.BloomExpectedKeys = 100
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// 超过初始容量后追加新的过滤器
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(common.GetTestKey(i), common.RandomValue(10)))
+	}
+	for i := 0; i < 1000; i++ {
+		ok, err := db.MayContain(common.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}
+	stats := db.BloomStats()
+	assert.True(t, stats.Enabled)
+	assert.Equal(t, 1000, stats.Keys)
+	// 超过容量时追加新的过滤器: 100 + 200 + 400 + 800
+	assert.Equal(t, 1500, stats.Capacity)
+	assert.Equal(t, 4, stats.Filters)
+
+	for i := 1000; i < 11000; i++ {
+		_, err := db.Get(common.GetTestKey(i))
+		assert.ErrorIs(t, err, common.ErrKeyNotFound)
+	}
+	stats = db.BloomStats()
+	assert.Equal(t, uint64(10000), stats.Checks)
+	assert.Equal(t, uint64(10000), stats.Negatives+stats.FalsePositives)
+	assert.Less(t, stats.FalsePositiveRate, 0.05)
+	assert.Greater(t, stats.EstimatedFalsePositiveRate, float64(0))
+
+	// 重新打开时按照索引重建，被删除的 key 不再留在过滤器中
+	for i := 0; i < 900; i++ {
+		assert.Nil(t, db.Delete(common.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	stats = db.BloomStats()
+	assert.Equal(t, 100, stats.Keys)
+	assert.Equal(t, 200, stats.Capacity)
+	assert.Equal(t, 1, stats.Filters)
+	ok, err := db.MayContain(common.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = db.Get(common.GetTestKey(999))
+	assert.Nil(t, err)
+}
+
+func TestDB_MayContainDisabled(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	ok, err := db.MayContain([]byte("key"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = db.MayContain([]byte("missing"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.False(t, db.BloomStats().Enabled)
+}
+
+func TestDB_MayContainDiskIndex(t *testing.T) {
+	options := diskIndexOptions(t)
+	options.BloomFilter = true
+	options.BloomExpectedKeys = 100
+	db, err := Open(options)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(common.GetTestKey(i), common.RandomValue(10)))
+	}
+	for i := 0; i < 900; i++ {
+		assert.Nil(t, db.Delete(common.GetTestKey(i)))
+	}
+	stats := db.BloomStats()
+	assert.Nil(t, db.Close())
+
+	// 正常关闭后过滤器与磁盘索引一起保存，重新打开时不会重建，被删除的 key 依然计算在内
+	db, err = Open(options)
+	assert.Nil(t, err)
+	restored := db.BloomStats()
+	assert.Equal(t, stats.Keys, restored.Keys)
+	assert.Equal(t, stats.Filters, restored.Filters)
+	for i := 900; i < 1000; i++ {
+		ok, err := db.MayContain(common.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}
+
+	// 之后的提交使保存的过滤器失效，没有正常关闭时按照索引重建
+	assert.Nil(t, db.Put([]byte("new"), []byte("value")))
+	db.mu.Lock()
+	assert.Nil(t, db.flushIndex())
+	assert.Nil(t, db.indexStore.Close())
+	assert.Nil(t, db.dataFiles.Close())
+	assert.Nil(t, db.fileLock.Unlock())
+	db.closed = true
+	db.mu.Unlock()
+
+	db, err = Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assert.Equal(t, 101, db.BloomStats().Keys)
+	ok, err := db.MayContain([]byte("new"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
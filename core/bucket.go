@@ -0,0 +1,397 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fastdb/common"
+	"fastdb/config"
+	"fastdb/index"
+	"fastdb/wal"
+	"math"
+	"sort"
+	"sync/atomic"
+)
+
+const (
+	// defaultBucketId 是数据库默认的 bucket，DB.Put/Get/Delete 都作用在它上面
+	defaultBucketId uint32 = 0
+	// metaBucketId 保存 bucket 名称到 id 的映射，不对用户开放
+	metaBucketId uint32 = 1
+	// firstUserBucketId 第一个用户 bucket 的 id
+	firstUserBucketId uint32 = 2
+	// typesBucketId 保存 hash、set 等数据结构的内部 bucket
+	typesBucketId uint32 = math.MaxUint32
+)
+
+// Bucket 是数据库中的一个命名空间，每个 bucket 拥有独立的索引，
+// 不同 bucket 中相同的 key 互不影响
+type Bucket struct {
+	db    *DB
+	id    uint32
+	name  string
+	index index.Indexer
+	// diskSize 该 bucket 中有效数据在数据文件中占用的字节数
+	diskSize atomic.Int64
+	dropped  atomic.Bool
+	// history 每个 key 的版本链，按 batch id 升序排列，未开启历史版本时为 nil
+	history map[string][]keyVersion
+	// bloom 索引中所有 key 的布隆过滤器，未开启时为 nil
+	bloom *bloomFilter
+}
+
+type BucketStats struct {
+	Name string
+	Id   uint32
+	// KeyCount 有效 key 的数量
+	KeyCount int
+	// DiskSize 有效数据在数据文件中占用的字节数
+	DiskSize int64
+}
+
+func newBucket(db *DB, id uint32, name string) *Bucket {
+	bucket := &Bucket{
+		db:    db,
+		id:    id,
+		name:  name,
+		index: db.newIndexer(id),
+	}
+	// 数据结构所使用的内部 bucket 不保留历史版本
+	if db.historyEnabled() && id != typesBucketId {
+		bucket.history = make(map[string][]keyVersion)
+	}
+	if db.options.BloomFilter {
+		bucket.bloom = newBloomFilter(db.options.BloomExpectedKeys, db.options.BloomFalsePositiveRate)
+	}
+	return bucket
+}
+
+// resetBuckets 清空所有 bucket 的索引，只保留默认 bucket 与内部 bucket
+func (db *DB) resetBuckets() {
+	db.defaultBucket = newBucket(db, defaultBucketId, "")
+	db.typesBucket = newBucket(db, typesBucketId, "")
+	db.buckets = make(map[string]*Bucket)
+	db.bucketIds = map[uint32]*Bucket{
+		defaultBucketId: db.defaultBucket,
+		typesBucketId:   db.typesBucket,
+	}
+	db.nextBucketId = firstUserBucketId
+}
+
+// Bucket 返回名为 name 的 bucket，不存在时创建
+func (db *DB) Bucket(name string) (*Bucket, error) {
+	if name == "" {
+		return nil, common.NewErr(&common.InnerErrNo, errors.New("bucket name is empty"))
+	}
+	db.mu.RLock()
+	bucket := db.buckets[name]
+	closed := db.closed
+	db.mu.RUnlock()
+	if closed {
+		return nil, common.NewErr(&common.DBClosedErrNo, common.ErrDBClosed)
+	}
+	if bucket != nil {
+		return bucket, nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if bucket = db.buckets[name]; bucket != nil {
+		return bucket, nil
+	}
+
+	id := db.nextBucketId
+	value := make([]byte, binary.MaxVarintLen32)
+	n := binary.PutUvarint(value, uint64(id))
+	checkpoint, err := db.writeMetaRecord(&LogRecord{
+		Key:      []byte(name),
+		Value:    value[:n],
+		Type:     LogRecordNormal,
+		BucketId: metaBucketId,
+	})
+	if err != nil {
+		return nil, common.NewErr(&common.InnerErrNo, err)
+	}
+
+	bucket = newBucket(db, id, name)
+	db.buckets[name] = bucket
+	db.bucketIds[id] = bucket
+	db.nextBucketId++
+	if err = db.beginIndex(); err == nil {
+		err = db.commitIndex(checkpoint)
+	}
+	if err != nil {
+		return nil, common.NewErr(&common.InnerErrNo, err)
+	}
+	return bucket, nil
+}
+
+// LookupBucket 返回名为 name 的 bucket，不存在时不会创建，返回 false
+func (db *DB) LookupBucket(name string) (*Bucket, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	bucket := db.buckets[name]
+	return bucket, bucket != nil
+}
+
+// DropBucket 删除整个 bucket，只需要写入一条元数据记录并丢弃索引，与 bucket 中的数据量无关
+// bucket id 不会被复用，数据文件中残留的旧记录在重新加载时会被忽略
+func (db *DB) DropBucket(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return common.NewErr(&common.DBClosedErrNo, common.ErrDBClosed)
+	}
+	bucket := db.buckets[name]
+	if bucket == nil {
+		return common.NewErr(&common.BucketNotFoundErrNo, common.ErrBucketNotFound)
+	}
+
+	checkpoint, err := db.writeMetaRecord(&LogRecord{
+		Key:      []byte(name),
+		Type:     LogRecordDeleted,
+		BucketId: metaBucketId,
+	})
+	if err != nil {
+		return common.NewErr(&common.InnerErrNo, err)
+	}
+
+	bucket.dropped.Store(true)
+	delete(db.buckets, name)
+	delete(db.bucketIds, bucket.id)
+	if err = db.beginIndex(); err == nil {
+		db.dropIndex(bucket)
+		err = db.commitIndex(checkpoint)
+	}
+	if err != nil {
+		return common.NewErr(&common.InnerErrNo, err)
+	}
+	return nil
+}
+
+// Buckets 返回所有用户 bucket 的名称
+func (db *DB) Buckets() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	names := make([]string, 0, len(db.buckets))
+	for name := range db.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeMetaRecord 将一条元数据记录作为单独的 batch 写入，返回该 batch 的 checkpoint，调用方需要持有写锁
+func (db *DB) writeMetaRecord(record *LogRecord) (*indexCheckpoint, error) {
+	batchId := db.batchIdNode.Generate()
+	record.BatchId = uint64(batchId)
+	if _, err := db.dataFiles.Write(encodeLogRecord(record, config.NoCompression, 0)); err != nil {
+		return nil, err
+	}
+	endRecord := encodeLogRecord(&LogRecord{
+		Key:  batchId.Bytes(),
+		Type: LogRecordBatchFinished,
+	}, config.NoCompression, 0)
+	position, err := db.dataFiles.Write(endRecord)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.dataFiles.Sync(); err != nil {
+		return nil, err
+	}
+	return &indexCheckpoint{batchId: uint64(batchId), position: position}, nil
+}
+
+// applyMetaRecord 在加载索引时重放 bucket 的创建与删除
+func (db *DB) applyMetaRecord(recordType LogRecordType, name []byte, value []byte) {
+	if recordType == LogRecordDeleted {
+		if bucket := db.buckets[string(name)]; bucket != nil {
+			delete(db.buckets, string(name))
+			delete(db.bucketIds, bucket.id)
+			db.dropIndex(bucket)
+		}
+		return
+	}
+
+	id, _ := binary.Uvarint(value)
+	bucket := newBucket(db, uint32(id), string(name))
+	db.buckets[bucket.name] = bucket
+	db.bucketIds[bucket.id] = bucket
+	if bucket.id >= db.nextBucketId {
+		db.nextBucketId = bucket.id + 1
+	}
+}
+
+func (bk *Bucket) Name() string {
+	return bk.name
+}
+
+func (bk *Bucket) Stats() BucketStats {
+	return BucketStats{
+		Name:     bk.name,
+		Id:       bk.id,
+		KeyCount: bk.index.Size(),
+		DiskSize: bk.diskSize.Load(),
+	}
+}
+
+// indexApply 将一个 batch 在 bucket 中的所有修改一次应用到索引中，并更新统计与布隆过滤器
+func (bk *Bucket) indexApply(mutations []index.Mutation) {
+	olds := bk.index.Apply(mutations)
+	for i, mutation := range mutations {
+		if old := olds[i]; old != nil {
+			bk.diskSize.Add(-int64(old.ChunkSize))
+		} else if !mutation.Delete && bk.bloom != nil {
+			bk.addToBloom(mutation.Key)
+		}
+		if !mutation.Delete {
+			bk.diskSize.Add(int64(mutation.Position.ChunkSize))
+		}
+	}
+}
+
+// indexMutations 一个 batch 对每个 bucket 的索引修改
+type indexMutations map[*Bucket][]index.Mutation
+
+func (m indexMutations) add(bucket *Bucket, key []byte, position *wal.ChunkPosition, deleted bool) {
+	m[bucket] = append(m[bucket], index.Mutation{Key: key, Position: position, Delete: deleted})
+}
+
+func (m indexMutations) apply() {
+	for bucket, mutations := range m {
+		bucket.indexApply(mutations)
+	}
+}
+
+// readRecord 读取并解码 position 处的记录
+func (bk *Bucket) readRecord(position *wal.ChunkPosition) (*LogRecord, error) {
+	chunk, err := bk.db.dataFiles.Read(position)
+	if err != nil {
+		return nil, common.NewErr(&common.InnerErrNo, err)
+	}
+	record, err := decodeLogRecord(chunk)
+	if err != nil {
+		return nil, common.NewErr(&common.InnerErrNo, err)
+	}
+	return record, nil
+}
+
+func (bk *Bucket) checkDropped() error {
+	if bk.dropped.Load() {
+		return common.NewErr(&common.BucketNotFoundErrNo, common.ErrBucketNotFound)
+	}
+	return nil
+}
+
+func (bk *Bucket) NewBatch(options config.BatchOptions) *Batch {
+	return bk.db.newBatch(bk, options)
+}
+
+func (bk *Bucket) Put(key []byte, value []byte) error {
+	options := config.DefaultBatchOptions
+	options.Sync = false
+	batch := bk.NewBatch(options)
+	if err := batch.Put(key, value); err != nil {
+		batch.Close()
+		return err
+	}
+	return batch.Commit()
+}
+
+func (bk *Bucket) Get(key []byte) ([]byte, error) {
+	options := config.DefaultBatchOptions
+	options.ReadOnly = true
+	batch := bk.NewBatch(options)
+	defer func() {
+		_ = batch.Commit()
+	}()
+	return batch.Get(key)
+}
+
+func (bk *Bucket) Delete(key []byte) error {
+	options := config.DefaultBatchOptions
+	options.Sync = false
+	batch := bk.NewBatch(options)
+	if err := batch.Delete(key); err != nil {
+		batch.Close()
+		return err
+	}
+	return batch.Commit()
+}
+
+// DeletePrefix 在一个 batch 中删除 bucket 中所有以 prefix 为前缀的 key，为每个 key 写入一条删除记录，
+// 返回删除的 key 的数量。prefix 为空时删除 bucket 中所有的 key
+func (bk *Bucket) DeletePrefix(prefix []byte) (int, error) {
+	batch := bk.NewBatch(asyncBatchOptions())
+	var keys [][]byte
+	err := bk.iterateLocked(index.IteratorOptions{Prefix: prefix}, nil, false, func(key []byte, _ []byte) (bool, error) {
+		keys = append(keys, key)
+		return true, nil
+	})
+	if err != nil {
+		batch.Close()
+		return 0, err
+	}
+	for _, key := range keys {
+		if err := batch.Delete(key); err != nil {
+			batch.Close()
+			return 0, err
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// Scan 按照 key 的升序遍历 bucket 中所有以 prefix 为前缀的数据，handleFn 返回 false 时停止遍历
+// 遍历期间持有数据库的读锁，handleFn 中不能再对数据库进行写操作
+func (bk *Bucket) Scan(prefix []byte, handleFn func(key []byte, value []byte) (bool, error)) error {
+	db := bk.db
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return common.NewErr(&common.DBClosedErrNo, common.ErrDBClosed)
+	}
+	if err := bk.checkDropped(); err != nil {
+		return err
+	}
+	return bk.scanLocked(prefix, handleFn)
+}
+
+// scanLocked 与 Scan 相同，但需要调用方持有数据库的锁
+func (bk *Bucket) scanLocked(prefix []byte, handleFn func(key []byte, value []byte) (bool, error)) error {
+	return bk.iterateLocked(index.IteratorOptions{Prefix: prefix}, nil, true, handleFn)
+}
+
+// iterateLocked 按照 options 遍历索引，seek 不为空时从 seek 的位置开始，
+// withValue 为 false 时不读取数据文件，handleFn 收到的 value 为 nil，调用方需要持有数据库的锁
+func (bk *Bucket) iterateLocked(options index.IteratorOptions, seek []byte, withValue bool,
+	handleFn func(key []byte, value []byte) (bool, error)) error {
+	iter := bk.index.Iterator(options)
+	defer iter.Close()
+	if seek != nil {
+		iter.Seek(seek)
+	}
+
+	for ; iter.Valid(); iter.Next() {
+		var value []byte
+		if withValue {
+			record, err := bk.readRecord(iter.Value())
+			if err != nil {
+				return err
+			}
+			if record.Type == LogRecordDeleted {
+				continue
+			}
+			value = record.Value
+		}
+		next, err := handleFn(iter.Key(), value)
+		if err != nil {
+			return err
+		}
+		if !next {
+			break
+		}
+	}
+	return nil
+}
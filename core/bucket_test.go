@@ -0,0 +1,226 @@
+package core
+
+import (
+	"fastdb/common"
+	"fastdb/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBucket_Isolation(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	users, err := db.Bucket("users")
+	assert.Nil(t, err)
+	orders, err := db.Bucket("orders")
+	assert.Nil(t, err)
+
+	key := common.GetTestKey(1)
+	assert.Nil(t, db.Put(key, []byte("default")))
+	assert.Nil(t, users.Put(key, []byte("users")))
+	assert.Nil(t, orders.Put(key, []byte("orders")))
+
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+	val, err = users.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("users"), val)
+
+	assert.Nil(t, orders.Delete(key))
+	_, err = orders.Get(key)
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+	val, err = users.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("users"), val)
+
+	assert.Equal(t, []string{"orders", "users"}, db.Buckets())
+	stats := users.Stats()
+	assert.Equal(t, "users", stats.Name)
+	assert.Equal(t, 1, stats.KeyCount)
+	assert.Greater(t, stats.DiskSize, int64(0))
+	assert.Equal(t, 0, orders.Stats().KeyCount)
+	assert.Equal(t, int64(0), orders.Stats().DiskSize)
+}
+
+func TestBucket_BatchAcrossBuckets(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	users, err := db.Bucket("users")
+	assert.Nil(t, err)
+	orders, err := db.Bucket("orders")
+	assert.Nil(t, err)
+
+	batch := users.NewBatch(config.DefaultBatchOptions)
+	assert.Nil(t, batch.Put([]byte("alice"), []byte("1")))
+	assert.Nil(t, batch.Bucket(orders).Put([]byte("alice"), []byte("order-1")))
+	val, err := batch.Bucket(orders).Get([]byte("alice"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("order-1"), val)
+	assert.Nil(t, batch.Commit())
+
+	// 重新打开后 bucket 与其中的数据都能恢复
+	assert.Nil(t, db.Close())
+	db, err = Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	users, err = db.Bucket("users")
+	assert.Nil(t, err)
+	orders, err = db.Bucket("orders")
+	assert.Nil(t, err)
+	val, err = users.Get([]byte("alice"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), val)
+	val, err = orders.Get([]byte("alice"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("order-1"), val)
+	_, err = db.Get([]byte("alice"))
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+}
+
+func TestDB_LookupBucket(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	_, ok := db.LookupBucket("users")
+	assert.False(t, ok)
+	// 查找不会创建 bucket
+	_, ok = db.LookupBucket("users")
+	assert.False(t, ok)
+
+	users, err := db.Bucket("users")
+	assert.Nil(t, err)
+	found, ok := db.LookupBucket("users")
+	assert.True(t, ok)
+	assert.Same(t, users, found)
+
+	assert.Nil(t, db.DropBucket("users"))
+	_, ok = db.LookupBucket("users")
+	assert.False(t, ok)
+}
+
+func TestBucket_Drop(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	users, err := db.Bucket("users")
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, users.Put(common.GetTestKey(i), common.RandomValue(16)))
+	}
+	assert.Nil(t, db.DropBucket("users"))
+	assert.ErrorIs(t, db.DropBucket("users"), common.ErrBucketNotFound)
+
+	// 旧的句柄不能再使用
+	_, err = users.Get(common.GetTestKey(1))
+	assert.ErrorIs(t, err, common.ErrBucketNotFound)
+	assert.ErrorIs(t, users.Put(common.GetTestKey(1), nil), common.ErrBucketNotFound)
+
+	// 同名的新 bucket 不会看到被删除的数据
+	users, err = db.Bucket("users")
+	assert.Nil(t, err)
+	assert.Nil(t, users.Put(common.GetTestKey(1000), []byte("new")))
+	assert.Equal(t, 1, users.Stats().KeyCount)
+
+	assert.Nil(t, db.Close())
+	db, err = Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	users, err = db.Bucket("users")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, users.Stats().KeyCount)
+	_, err = users.Get(common.GetTestKey(1))
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+	val, err := users.Get(common.GetTestKey(1000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), val)
+}
+
+func TestBucket_IndexTypes(t *testing.T) {
+	for name, indexType := range map[string]config.IndexType{
+		"btree":   config.BTreeIndex,
+		"hashmap": config.HashMapIndex,
+		"disk":    config.DiskBTreeIndex,
+	} {
+		t.Run(name, func(t *testing.T) {
+			options := config.DefaultOptions
+			options.DirPath = t.TempDir()
+			options.IndexType = indexType
+			db, err := Open(options)
+			assert.Nil(t, err)
+			defer destroyDB(db)
+
+			for i := 0; i < 100; i++ {
+				assert.Nil(t, db.Put(common.GetTestKey(i), []byte{byte(i)}))
+			}
+			assert.Nil(t, db.Delete(common.GetTestKey(0)))
+			_, err = db.HSet([]byte("hash"), []byte("field"), []byte("value"))
+			assert.Nil(t, err)
+
+			var keys int
+			assert.Nil(t, db.Scan(nil, func(key []byte, value []byte) (bool, error) {
+				keys++
+				return true, nil
+			}))
+			assert.Equal(t, 99, keys)
+
+			// 重新打开后索引从数据文件中重建
+			assert.Nil(t, db.Close())
+			db, err = Open(options)
+			assert.Nil(t, err)
+			val, err := db.Get(common.GetTestKey(42))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte{42}, val)
+			val, err = db.HGet([]byte("hash"), []byte("field"))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("value"), val)
+			_, err = db.Get(common.GetTestKey(0))
+			assert.ErrorIs(t, err, common.ErrKeyNotFound)
+		})
+	}
+}
+
+func TestBucket_DeletePrefix(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	users, err := db.Bucket("users")
+	assert.Nil(t, err)
+	for _, key := range []string{"user:1", "user:2", "user:10", "users", "order:1"} {
+		assert.Nil(t, db.Put([]byte(key), []byte(key)))
+		assert.Nil(t, users.Put([]byte(key), []byte(key)))
+	}
+
+	n, err := db.DeletePrefix([]byte("user:"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	n, err = db.DeletePrefix([]byte("missing"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 2, db.defaultBucket.Stats().KeyCount)
+	// 其他 bucket 中相同前缀的 key 不受影响
+	assert.Equal(t, 5, users.Stats().KeyCount)
+
+	assert.Nil(t, db.Close())
+	db, err = Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	_, err = db.Get([]byte("user:10"))
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+	val, err := db.Get([]byte("users"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("users"), val)
+	users, err = db.Bucket("users")
+	assert.Nil(t, err)
+
+	// 空前缀删除 bucket 中所有的 key
+	n, err = users.DeletePrefix(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, 0, users.Stats().KeyCount)
+	assert.Equal(t, 2, db.defaultBucket.Stats().KeyCount)
+}
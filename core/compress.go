@@ -0,0 +1,96 @@
+package core
+
+import (
+	"errors"
+	"fastdb/config"
+	"fmt"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"sync"
+)
+
+// codec 对 value 进行压缩与解压，实现需要是并发安全的。
+// decode 解压后的长度超过 maxSize 时返回 ErrValueTooLarge，并且不会分配超过 maxSize 的内存
+type codec interface {
+	encode(src []byte) []byte
+	decode(src []byte, maxSize int) ([]byte, error)
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) encode(src []byte) []byte {
+	return snappy.Encode(nil, src)
+}
+
+func (snappyCodec) decode(src []byte, maxSize int) ([]byte, error) {
+	size, err := snappy.DecodedLen(src)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxSize {
+		return nil, ErrValueTooLarge
+	}
+	return snappy.Decode(nil, src)
+}
+
+// zstdCodec 的 EncodeAll/DecodeAll 是并发安全的，整个进程共用一对编码器与解码器
+type zstdCodec struct {
+	once    sync.Once
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func (c *zstdCodec) init() {
+	c.once.Do(func() {
+		c.encoder, _ = zstd.NewWriter(nil)
+		// 没有记录原始长度的 frame 解压时同样不会超过 MaxValueSize
+		c.decoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(MaxValueSize))
+	})
+}
+
+func (c *zstdCodec) encode(src []byte) []byte {
+	c.init()
+	return c.encoder.EncodeAll(src, nil)
+}
+
+func (c *zstdCodec) decode(src []byte, maxSize int) ([]byte, error) {
+	c.init()
+	var header zstd.Header
+	if err := header.Decode(src); err != nil {
+		return nil, err
+	}
+	if header.HasFCS && header.FrameContentSize > uint64(maxSize) {
+		return nil, ErrValueTooLarge
+	}
+	value, err := c.decoder.DecodeAll(src, nil)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || len(value) > maxSize {
+		return nil, ErrValueTooLarge
+	}
+	return value, err
+}
+
+var codecs = map[config.CompressionType]codec{
+	config.SnappyCompression: snappyCodec{},
+	config.ZstdCompression:   &zstdCodec{},
+}
+
+// compressValue 按配置压缩 value，压缩后没有变小时返回 nil，调用方应该保存原始的 value
+func compressValue(value []byte, compression config.CompressionType, minSize int) []byte {
+	c := codecs[compression]
+	if c == nil || len(value) == 0 || len(value) < minSize {
+		return nil
+	}
+	compressed := c.encode(value)
+	if len(compressed) >= len(value) {
+		return nil
+	}
+	return compressed
+}
+
+func decompressValue(value []byte, compression config.CompressionType) ([]byte, error) {
+	c := codecs[compression]
+	if c == nil {
+		return nil, fmt.Errorf("unknown compression type %d", compression)
+	}
+	return c.decode(value, MaxValueSize)
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"bytes"
+	"fastdb/common"
+	"fastdb/config"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLogRecord_Compression(t *testing.T) {
+	compressible := bytes.Repeat([]byte(`{"name":"fastdb","tags":["kv","wal"]}`), 64)
+	tests := []struct {
+		name        string
+		compression config.CompressionType
+		value       []byte
+		compressed  bool
+	}{
+		{"none", config.NoCompression, compressible, false},
+		{"snappy", config.SnappyCompression, compressible, true},
+		{"zstd", config.ZstdCompression, compressible, true},
+		{"below min size", config.ZstdCompression, []byte("small"), false},
+		{"incompressible", config.SnappyCompression, common.RandomValue(1024), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := &LogRecord{Key: []byte("key"), Value: tt.value, BatchId: 42, BucketId: 7}
+			buf := encodeLogRecord(record, tt.compression, 64)
+			assert.Equal(t, tt.compressed, buf[0]&recordCompressedFlag != 0)
+			if tt.compressed {
+				assert.Less(t, len(buf), len(tt.value))
+			}
+
+			decoded, err := decodeLogRecord(buf)
+			assert.Nil(t, err)
+			assert.Equal(t, record.Key, decoded.Key)
+			assert.Equal(t, record.Value, decoded.Value)
+			assert.Equal(t, record.BatchId, decoded.BatchId)
+			assert.Equal(t, record.BucketId, decoded.BucketId)
+			assert.Equal(t, LogRecordNormal, decoded.Type)
+		})
+	}
+}
+
+func TestDB_CompressionChange(t *testing.T) {
+	options := config.DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	value := bytes.Repeat([]byte("fastdb "), 100)
+	assert.Nil(t, db.Put([]byte("plain"), value))
+
+	// 修改压缩算法后，之前写入的记录依然可以读取
+	for _, compression := range []config.CompressionType{config.SnappyCompression, config.ZstdCompression} {
+		assert.Nil(t, db.Close())
+		options.Compression = compression
+		db, err = Open(options)
+		assert.Nil(t, err)
+		assert.Nil(t, db.Put([]byte{compression}, value))
+	}
+
+	options.Compression = config.NoCompression
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	for _, key := range [][]byte{[]byte("plain"), {config.SnappyCompression}, {config.ZstdCompression}} {
+		got, err := db.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, value, got)
+	}
+}
+
+func TestCodec_DecodeMaxSize(t *testing.T) {
+	value := bytes.Repeat([]byte("fastdb "), 1024)
+	for compression, c := range codecs {
+		encoded := c.encode(value)
+		decoded, err := c.decode(encoded, len(value))
+		assert.Nil(t, err)
+		assert.Equal(t, value, decoded)
+
+		// 解压后的长度超过上限时拒绝，不会分配完整的内存
+		_, err = c.decode(encoded, len(value)-1)
+		assert.ErrorIs(t, err, ErrValueTooLarge, "compression %d", compression)
+	}
+
+	// 流式写入的 zstd frame 不记录原始长度，解压后同样检查长度
+	var buf bytes.Buffer
+	w, err := zstd.NewWriter(&buf)
+	assert.Nil(t, err)
+	_, err = w.Write(value)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+	c := codecs[config.ZstdCompression]
+	_, err = c.decode(buf.Bytes(), len(value)-1)
+	assert.ErrorIs(t, err, ErrValueTooLarge)
+	decoded, err := c.decode(buf.Bytes(), len(value))
+	assert.Nil(t, err)
+	assert.Equal(t, value, decoded)
+}
+
+func TestDB_PutValueTooLarge(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	err = db.Put([]byte("key"), make([]byte, MaxValueSize+1))
+	assert.ErrorIs(t, err, ErrValueTooLarge)
+	_, err = db.Get([]byte("key"))
+	assert.NotNil(t, err)
+}
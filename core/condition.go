@@ -0,0 +1,205 @@
+package core
+
+import (
+	"bytes"
+	"fastdb/common"
+)
+
+// 条件写入只有在 key 的当前状态满足条件时才会执行，否则返回 ErrConditionFailed。
+// key 的版本号为最后一次写入它的 batch id，batch 中尚未提交的写入没有版本号(为 0)，
+// 版本号为 0 的条件代表 key 不存在
+
+func (db *DB) PutIfAbsent(key []byte, value []byte) error {
+	return db.defaultBucket.PutIfAbsent(key, value)
+}
+
+func (db *DB) PutIfExists(key []byte, value []byte) error {
+	return db.defaultBucket.PutIfExists(key, value)
+}
+
+// CompareAndSwap 只有当 key 存在且值等于 expected 时才写入 value
+func (db *DB) CompareAndSwap(key []byte, expected []byte, value []byte) error {
+	return db.defaultBucket.CompareAndSwap(key, expected, value)
+}
+
+func (db *DB) DeleteIfEquals(key []byte, expected []byte) error {
+	return db.defaultBucket.DeleteIfEquals(key, expected)
+}
+
+// GetWithVersion 返回 key 的值以及版本号
+func (db *DB) GetWithVersion(key []byte) ([]byte, uint64, error) {
+	return db.defaultBucket.GetWithVersion(key)
+}
+
+// PutIfVersion 只有当 key 的版本号等于 version 时才写入 value，version 为 0 时要求 key 不存在
+func (db *DB) PutIfVersion(key []byte, value []byte, version uint64) error {
+	return db.defaultBucket.PutIfVersion(key, value, version)
+}
+
+func (db *DB) DeleteIfVersion(key []byte, version uint64) error {
+	return db.defaultBucket.DeleteIfVersion(key, version)
+}
+
+func (bk *Bucket) PutIfAbsent(key []byte, value []byte) error {
+	return bk.conditional(func(op *BucketBatch) error {
+		return op.PutIfAbsent(key, value)
+	})
+}
+
+func (bk *Bucket) PutIfExists(key []byte, value []byte) error {
+	return bk.conditional(func(op *BucketBatch) error {
+		return op.PutIfExists(key, value)
+	})
+}
+
+func (bk *Bucket) CompareAndSwap(key []byte, expected []byte, value []byte) error {
+	return bk.conditional(func(op *BucketBatch) error {
+		return op.CompareAndSwap(key, expected, value)
+	})
+}
+
+func (bk *Bucket) DeleteIfEquals(key []byte, expected []byte) error {
+	return bk.conditional(func(op *BucketBatch) error {
+		return op.DeleteIfEquals(key, expected)
+	})
+}
+
+func (bk *Bucket) GetWithVersion(key []byte) ([]byte, uint64, error) {
+	options := asyncBatchOptions()
+	options.ReadOnly = true
+	batch := bk.NewBatch(options)
+	defer func() {
+		_ = batch.Commit()
+	}()
+	return batch.GetWithVersion(key)
+}
+
+func (bk *Bucket) PutIfVersion(key []byte, value []byte, version uint64) error {
+	return bk.conditional(func(op *BucketBatch) error {
+		return op.PutIfVersion(key, value, version)
+	})
+}
+
+func (bk *Bucket) DeleteIfVersion(key []byte, version uint64) error {
+	return bk.conditional(func(op *BucketBatch) error {
+		return op.DeleteIfVersion(key, version)
+	})
+}
+
+// conditional 在一个 batch 中完成条件检查与写入
+func (bk *Bucket) conditional(fn func(op *BucketBatch) error) error {
+	batch := bk.NewBatch(asyncBatchOptions())
+	return commitOrClose(batch, fn(batch.Bucket(bk)))
+}
+
+func (b *Batch) PutIfAbsent(key []byte, value []byte) error {
+	return b.Bucket(b.bucket).PutIfAbsent(key, value)
+}
+
+func (b *Batch) PutIfExists(key []byte, value []byte) error {
+	return b.Bucket(b.bucket).PutIfExists(key, value)
+}
+
+func (b *Batch) CompareAndSwap(key []byte, expected []byte, value []byte) error {
+	return b.Bucket(b.bucket).CompareAndSwap(key, expected, value)
+}
+
+func (b *Batch) DeleteIfEquals(key []byte, expected []byte) error {
+	return b.Bucket(b.bucket).DeleteIfEquals(key, expected)
+}
+
+func (b *Batch) GetWithVersion(key []byte) ([]byte, uint64, error) {
+	return b.Bucket(b.bucket).GetWithVersion(key)
+}
+
+func (b *Batch) PutIfVersion(key []byte, value []byte, version uint64) error {
+	return b.Bucket(b.bucket).PutIfVersion(key, value, version)
+}
+
+func (b *Batch) DeleteIfVersion(key []byte, version uint64) error {
+	return b.Bucket(b.bucket).DeleteIfVersion(key, version)
+}
+
+func (bb *BucketBatch) PutIfAbsent(key []byte, value []byte) error {
+	if err := bb.check(key, func(record *LogRecord) bool {
+		return record == nil
+	}); err != nil {
+		return err
+	}
+	return bb.Put(key, value)
+}
+
+func (bb *BucketBatch) PutIfExists(key []byte, value []byte) error {
+	if err := bb.check(key, func(record *LogRecord) bool {
+		return record != nil
+	}); err != nil {
+		return err
+	}
+	return bb.Put(key, value)
+}
+
+func (bb *BucketBatch) CompareAndSwap(key []byte, expected []byte, value []byte) error {
+	if err := bb.check(key, valueEquals(expected)); err != nil {
+		return err
+	}
+	return bb.Put(key, value)
+}
+
+func (bb *BucketBatch) DeleteIfEquals(key []byte, expected []byte) error {
+	if err := bb.check(key, valueEquals(expected)); err != nil {
+		return err
+	}
+	return bb.Delete(key)
+}
+
+func (bb *BucketBatch) GetWithVersion(key []byte) ([]byte, uint64, error) {
+	record, err := bb.batch.getRecord(bb.bucket, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return record.Value, record.BatchId, nil
+}
+
+func (bb *BucketBatch) PutIfVersion(key []byte, value []byte, version uint64) error {
+	if err := bb.check(key, versionEquals(version)); err != nil {
+		return err
+	}
+	return bb.Put(key, value)
+}
+
+func (bb *BucketBatch) DeleteIfVersion(key []byte, version uint64) error {
+	if version == 0 {
+		return common.NewErr(&common.ConditionFailedErrNo, common.ErrConditionFailed)
+	}
+	if err := bb.check(key, versionEquals(version)); err != nil {
+		return err
+	}
+	return bb.Delete(key)
+}
+
+// check 读取 key 当前的记录并检查条件，key 不存在时 record 为 nil
+func (bb *BucketBatch) check(key []byte, cond func(record *LogRecord) bool) error {
+	record, err := bb.batch.getRecord(bb.bucket, key)
+	if err != nil && !isKeyNotFound(err) {
+		return err
+	}
+	if !cond(record) {
+		return common.NewErr(&common.ConditionFailedErrNo, common.ErrConditionFailed)
+	}
+	return nil
+}
+
+func valueEquals(expected []byte) func(record *LogRecord) bool {
+	return func(record *LogRecord) bool {
+		return record != nil && bytes.Equal(record.Value, expected)
+	}
+}
+
+func versionEquals(version uint64) func(record *LogRecord) bool {
+	return func(record *LogRecord) bool {
+		if version == 0 {
+			return record == nil
+		}
+		return record != nil && record.BatchId == version
+	}
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"fastdb/common"
+	"fastdb/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDB_ConditionalWrites(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("lock")
+	assert.ErrorIs(t, db.PutIfExists(key, []byte("v0")), common.ErrConditionFailed)
+	assert.Nil(t, db.PutIfAbsent(key, []byte("owner-1")))
+	assert.ErrorIs(t, db.PutIfAbsent(key, []byte("owner-2")), common.ErrConditionFailed)
+	assert.Nil(t, db.PutIfExists(key, []byte("owner-1")))
+
+	assert.ErrorIs(t, db.CompareAndSwap(key, []byte("owner-2"), []byte("owner-3")), common.ErrConditionFailed)
+	assert.Nil(t, db.CompareAndSwap(key, []byte("owner-1"), []byte("owner-3")))
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("owner-3"), val)
+
+	assert.ErrorIs(t, db.DeleteIfEquals(key, []byte("owner-1")), common.ErrConditionFailed)
+	assert.Nil(t, db.DeleteIfEquals(key, []byte("owner-3")))
+	_, err = db.Get(key)
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+	assert.ErrorIs(t, db.CompareAndSwap(key, nil, []byte("v")), common.ErrConditionFailed)
+}
+
+func TestDB_VersionedWrites(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("doc")
+	assert.Nil(t, db.PutIfVersion(key, []byte("v1"), 0))
+	assert.ErrorIs(t, db.PutIfVersion(key, []byte("v1"), 0), common.ErrConditionFailed)
+	val, v1, err := db.GetWithVersion(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+	assert.NotZero(t, v1)
+
+	assert.Nil(t, db.PutIfVersion(key, []byte("v2"), v1))
+	assert.ErrorIs(t, db.PutIfVersion(key, []byte("v3"), v1), common.ErrConditionFailed)
+	_, v2, err := db.GetWithVersion(key)
+	assert.Nil(t, err)
+	assert.Greater(t, v2, v1)
+
+	// 版本号保存在记录中，重启后不变
+	assert.Nil(t, db.Close())
+	db, err = Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	_, version, err := db.GetWithVersion(key)
+	assert.Nil(t, err)
+	assert.Equal(t, v2, version)
+	assert.ErrorIs(t, db.DeleteIfVersion(key, v1), common.ErrConditionFailed)
+	assert.Nil(t, db.DeleteIfVersion(key, v2))
+	_, _, err = db.GetWithVersion(key)
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+}
+
+func TestBatch_ConditionalWrites(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.Put([]byte("a"), []byte("1")))
+	batch := db.NewBatch(config.DefaultBatchOptions)
+	assert.Nil(t, batch.PutIfAbsent([]byte("b"), []byte("2")))
+	// batch 中尚未提交的写入对条件可见
+	assert.ErrorIs(t, batch.PutIfAbsent([]byte("b"), []byte("3")), common.ErrConditionFailed)
+	assert.Nil(t, batch.CompareAndSwap([]byte("b"), []byte("2"), []byte("4")))
+	assert.Nil(t, batch.DeleteIfEquals([]byte("a"), []byte("1")))
+	_, version, err := batch.GetWithVersion([]byte("b"))
+	assert.Nil(t, err)
+	assert.Zero(t, version)
+	assert.Nil(t, batch.Commit())
+
+	_, err = db.Get([]byte("a"))
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+	val, err := db.Get([]byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("4"), val)
+}
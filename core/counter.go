@@ -0,0 +1,155 @@
+package core
+
+import (
+	"fastdb/common"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"sync"
+)
+
+// counterStripes 计数器的锁按 key 的哈希分成的条带数量
+const counterStripes = 256
+
+// IncrBy 将 key 的值按十进制整数加上 delta 并返回新的值，key 不存在时视为 0。
+// 读取与计算只持有数据库的读锁，不同 key 上的计数器可以并发执行，
+// 写入时检查 key 的版本号没有变化，与其他写入冲突时重新读取，因此是原子的
+func (db *DB) IncrBy(key []byte, delta int64) (int64, error) {
+	return db.defaultBucket.IncrBy(key, delta)
+}
+
+func (db *DB) DecrBy(key []byte, delta int64) (int64, error) {
+	return db.defaultBucket.DecrBy(key, delta)
+}
+
+// IncrByFloat 将 key 的值按十进制浮点数加上 delta 并返回新的值，key 不存在时视为 0
+func (db *DB) IncrByFloat(key []byte, delta float64) (float64, error) {
+	return db.defaultBucket.IncrByFloat(key, delta)
+}
+
+func (bk *Bucket) IncrBy(key []byte, delta int64) (int64, error) {
+	var current int64
+	err := bk.update(key, func(value []byte, exists bool) (newValue []byte, err error) {
+		current, newValue, err = addInt(value, exists, delta)
+		return newValue, err
+	})
+	return current, err
+}
+
+func (bk *Bucket) DecrBy(key []byte, delta int64) (int64, error) {
+	if delta == math.MinInt64 {
+		return 0, common.NewErr(&common.NotIntegerErrNo, common.ErrNotInteger)
+	}
+	return bk.IncrBy(key, -delta)
+}
+
+func (bk *Bucket) IncrByFloat(key []byte, delta float64) (float64, error) {
+	var current float64
+	err := bk.update(key, func(value []byte, exists bool) (newValue []byte, err error) {
+		current, newValue, err = addFloat(value, exists, delta)
+		return newValue, err
+	})
+	return current, err
+}
+
+// update 读取 key 当前的值，由 fn 计算出新的值后，只有当 key 的版本号没有变化时才写入。
+// 同一个 key 上的 update 通过条带锁串行执行，只有与其他写入冲突时才需要重试
+func (bk *Bucket) update(key []byte, fn func(value []byte, exists bool) ([]byte, error)) error {
+	mu := bk.db.counterLock(bk, key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	for {
+		value, version, err := bk.GetWithVersion(key)
+		if err != nil && !isKeyNotFound(err) {
+			return err
+		}
+		newValue, err := fn(value, err == nil)
+		if err != nil {
+			return err
+		}
+		err = bk.PutIfVersion(key, newValue, version)
+		if common.ExtractErrCode(err) != common.ConditionFailedErrNo.Code {
+			return err
+		}
+	}
+}
+
+func (db *DB) counterLock(bucket *Bucket, key []byte) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	return &db.counterLocks[(h.Sum32()^bucket.id)%counterStripes]
+}
+
+func (b *Batch) IncrBy(key []byte, delta int64) (int64, error) {
+	return incrBy(b.Bucket(b.bucket), key, delta)
+}
+
+func (b *Batch) IncrByFloat(key []byte, delta float64) (float64, error) {
+	return incrByFloat(b.Bucket(b.bucket), key, delta)
+}
+
+func (bb *BucketBatch) IncrBy(key []byte, delta int64) (int64, error) {
+	return incrBy(bb, key, delta)
+}
+
+func (bb *BucketBatch) IncrByFloat(key []byte, delta float64) (float64, error) {
+	return incrByFloat(bb, key, delta)
+}
+
+func incrBy(op *BucketBatch, key []byte, delta int64) (int64, error) {
+	value, err := op.Get(key)
+	if err != nil && !isKeyNotFound(err) {
+		return 0, err
+	}
+	current, newValue, err := addInt(value, err == nil, delta)
+	if err != nil {
+		return 0, err
+	}
+	return current, op.Put(key, newValue)
+}
+
+func incrByFloat(op *BucketBatch, key []byte, delta float64) (float64, error) {
+	value, err := op.Get(key)
+	if err != nil && !isKeyNotFound(err) {
+		return 0, err
+	}
+	current, newValue, err := addFloat(value, err == nil, delta)
+	if err != nil {
+		return 0, err
+	}
+	return current, op.Put(key, newValue)
+}
+
+// addInt 将 value 按十进制整数加上 delta，返回新的值及其编码，exists 为 false 时 value 视为 0
+func addInt(value []byte, exists bool, delta int64) (int64, []byte, error) {
+	var current int64
+	if exists {
+		var err error
+		if current, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+			return 0, nil, common.NewErr(&common.NotIntegerErrNo, common.ErrNotInteger)
+		}
+	}
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, nil, common.NewErr(&common.NotIntegerErrNo, common.ErrNotInteger)
+	}
+
+	current += delta
+	return current, []byte(strconv.FormatInt(current, 10)), nil
+}
+
+func addFloat(value []byte, exists bool, delta float64) (float64, []byte, error) {
+	var current float64
+	if exists {
+		var err error
+		if current, err = strconv.ParseFloat(string(value), 64); err != nil {
+			return 0, nil, common.NewErr(&common.NotFloatErrNo, common.ErrNotFloat)
+		}
+	}
+
+	current += delta
+	if math.IsNaN(current) || math.IsInf(current, 0) {
+		return 0, nil, common.NewErr(&common.NotFloatErrNo, common.ErrNotFloat)
+	}
+	return current, []byte(strconv.FormatFloat(current, 'f', -1, 64)), nil
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"fastdb/common"
+	"fastdb/config"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestDB_IncrBy(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("counter")
+	val, err := db.IncrBy(key, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), val)
+	val, err = db.DecrBy(key, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), val)
+	got, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("7"), got)
+
+	_, err = db.IncrBy(key, math.MaxInt64)
+	assert.ErrorIs(t, err, common.ErrNotInteger)
+	_, err = db.DecrBy(key, math.MinInt64)
+	assert.ErrorIs(t, err, common.ErrNotInteger)
+
+	assert.Nil(t, db.Put([]byte("name"), []byte("alice")))
+	_, err = db.IncrBy([]byte("name"), 1)
+	assert.ErrorIs(t, err, common.ErrNotInteger)
+	_, err = db.IncrByFloat([]byte("name"), 1)
+	assert.ErrorIs(t, err, common.ErrNotFloat)
+
+	f, err := db.IncrByFloat(key, 0.25)
+	assert.Nil(t, err)
+	assert.Equal(t, 7.25, f)
+	_, err = db.IncrBy(key, 1)
+	assert.ErrorIs(t, err, common.ErrNotInteger)
+	_, err = db.IncrByFloat(key, math.Inf(1))
+	assert.ErrorIs(t, err, common.ErrNotFloat)
+
+	// 同一个 bucket 中的计数器与默认 bucket 互不影响
+	users, err := db.Bucket("users")
+	assert.Nil(t, err)
+	val, err = users.IncrBy(key, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), val)
+}
+
+func TestDB_IncrByConcurrent(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("counter")
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := db.IncrBy(key, 1)
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	got, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("800"), got)
+}
+
+func TestDB_IncrByConflict(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// batch 中的计数器不经过条带锁，与 DB.IncrBy 的写入冲突时后者重新读取，结果不会丢失
+	key := []byte("counter")
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if i%2 == 0 {
+					_, err := db.IncrBy(key, 1)
+					assert.Nil(t, err)
+					continue
+				}
+				batch := db.NewBatch(asyncBatchOptions())
+				_, err := batch.IncrBy(key, 1)
+				assert.Nil(t, err)
+				assert.Nil(t, batch.Commit())
+			}
+		}(i)
+	}
+	wg.Wait()
+	got, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("800"), got)
+
+	// 一个 key 上的计数器被阻塞时，其他 key 上的计数器不受影响
+	mu := db.counterLock(db.defaultBucket, key)
+	other := []byte("other")
+	assert.NotSame(t, mu, db.counterLock(db.defaultBucket, other))
+	mu.Lock()
+	val, err := db.IncrBy(other, 1)
+	mu.Unlock()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), val)
+}
@@ -12,6 +12,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 )
 
 const (
@@ -25,13 +26,40 @@ const (
 type DB struct {
 	dataFiles *wal.WAL
 	hintFile  *wal.WAL
-	index     index.Indexer
 	options   config.DbOptions
 	fileLock  *flock.Flock
 	mu        sync.RWMutex
 	closed    bool
 	// mergeRunning 代表数据库正在被合并
 	mergeRunning uint32
+	// batchIdNode 为所有 batch 生成唯一且递增的 batch id
+	batchIdNode *snowflake.Node
+	// defaultBucket DB.Put/Get/Delete 所使用的 bucket
+	defaultBucket *Bucket
+	// typesBucket hash、set 等数据结构所使用的内部 bucket
+	typesBucket *Bucket
+	// buckets 用户 bucket 的名称到 bucket 的映射
+	buckets map[string]*Bucket
+	// bucketIds 所有有效 bucket (包括默认 bucket) 的 id 到 bucket 的映射
+	bucketIds    map[uint32]*Bucket
+	nextBucketId uint32
+	// watcher 阻塞命令在此等待 key 被提交
+	watcher *watcher
+	// scrubber 记录数据文件的校验状态
+	scrubber *scrubber
+	// indexStore 磁盘索引，IndexType 不是 DiskBTreeIndex 时为 nil
+	indexStore *index.DiskStore
+	// indexTxOpen 存在尚未提交的磁盘索引事务
+	indexTxOpen bool
+	// indexPending 磁盘索引事务中最后一个已经应用但尚未提交的 batch
+	indexPending        *indexCheckpoint
+	indexPendingRecords int
+	// indexTimer 有尚未提交的 batch 时，等待 diskIndexCommitInterval 之后提交
+	indexTimer *time.Timer
+	// indexCommitted 磁盘索引中最后一次提交的 checkpoint
+	indexCommitted *indexCheckpoint
+	// counterLocks 计数器按 key 的哈希加锁，同一个 key 上的读取、修改、写入串行执行
+	counterLocks [counterStripes]sync.Mutex
 }
 
 func Open(options config.DbOptions) (*DB, error) {
@@ -56,31 +84,67 @@ func Open(options config.DbOptions) (*DB, error) {
 		return nil, common.NewErr(&common.DatabaseIsUsingErrNo, common.ErrDatabaseIsUsing)
 	}
 
-	walFiles, err := wal.Open(wal.Options{
-		DirPath:        options.DirPath,
-		SegmentSize:    options.SegmentSize,
-		SegmentFileExt: dataFileNameSuffix,
-		BlockCache:     options.BlockCache,
-		Sync:           options.Sync,
-		BytesPerSync:   options.BytesPerSync,
-	})
+	// 上一次 Repair 或 Restore 替换数据文件时崩溃的话，先回滚或者完成替换
+	if err = recoverDataFiles(options.DirPath); err != nil {
+		_ = fileLock.Unlock()
+		return nil, common.NewErr(&common.InnerErrNo, err)
+	}
+
+	walFiles, err := openDataFiles(options)
+	if err != nil {
+		_ = fileLock.Unlock()
+		return nil, common.NewErr(&common.InnerErrNo, err)
+	}
+
+	batchIdNode, err := snowflake.NewNode(1)
 	if err != nil {
+		_ = walFiles.Close()
+		_ = fileLock.Unlock()
 		return nil, common.NewErr(&common.InnerErrNo, err)
 	}
 
 	db := &DB{
-		dataFiles: walFiles,
-		index:     index.NewIndexer(),
-		options:   options,
-		fileLock:  fileLock,
+		dataFiles:   walFiles,
+		options:     options,
+		fileLock:    fileLock,
+		batchIdNode: batchIdNode,
+		watcher:     newWatcher(),
+		scrubber:    newScrubber(),
 	}
-	if err = db.loadIndexFromWAL(); err != nil {
+	if err = db.openDiskIndex(); err != nil {
+		_ = walFiles.Close()
+		_ = fileLock.Unlock()
 		return nil, common.NewErr(&common.InnerErrNo, err)
 	}
+	db.resetBuckets()
+	if err = db.loadIndex(); err != nil {
+		if db.indexStore != nil {
+			_ = db.indexStore.Close()
+		}
+		_ = walFiles.Close()
+		_ = fileLock.Unlock()
+		return nil, common.NewErr(&common.InnerErrNo, err)
+	}
+	if options.ScrubInterval > 0 {
+		db.startScrubber(options.ScrubInterval)
+	}
 
 	return db, nil
 }
 
+func openDataFiles(options config.DbOptions) (*wal.WAL, error) {
+	return wal.Open(wal.Options{
+		DirPath:        options.DirPath,
+		SegmentSize:    options.SegmentSize,
+		SegmentFileExt: dataFileNameSuffix,
+		BlockCache:     options.BlockCache,
+		Sync:           options.Sync,
+		BytesPerSync:   options.BytesPerSync,
+		Compressed:     options.Compression != config.NoCompression,
+		KeyProvider:    options.KeyProvider,
+	})
+}
+
 func checkOptions(options config.DbOptions) error {
 	if options.DirPath == "" {
 		return errors.New("database dir path is empty")
@@ -88,14 +152,36 @@ func checkOptions(options config.DbOptions) error {
 	if options.SegmentSize <= 0 {
 		return errors.New("database data file size must be greater than 0")
 	}
+	// 历史版本只保存在内存中，打开数据库时需要重放整个 WAL 才能恢复
+	if options.IndexType == config.DiskBTreeIndex && (options.HistoryVersions > 0 || options.HistoryRetention > 0) {
+		return errors.New("history versions are not supported by the disk index")
+	}
+	// 磁盘索引文件中以明文保存所有的 key，开启加密时不能使用
+	if options.IndexType == config.DiskBTreeIndex && options.KeyProvider != nil {
+		return errors.New("the disk index stores keys in plaintext and can not be used with encryption")
+	}
 	return nil
 }
 
 // loadIndexFromWAL 从 WAL 文件中，重新加载索引
 func (db *DB) loadIndexFromWAL() error {
+	if err := db.replayWAL(db.dataFiles.NewReader()); err != nil {
+		return err
+	}
+	// 加载过程中被删除的 key 依然留在布隆过滤器中，按照加载后的索引重建
+	db.rebuildBlooms()
+	return nil
+}
+
+// replayWAL 将 reader 之后的所有已经完成的 batch 应用到索引中。
+// 使用磁盘索引时每应用 diskIndexReplayRecords 条记录提交一次，重放中断后下次可以从最后一次提交的位置继续
+func (db *DB) replayWAL(reader *wal.Reader) error {
 	indexRecords := make(map[uint64][]*IndexRecord)
+	var (
+		checkpoint *indexCheckpoint
+		applied    int
+	)
 
-	reader := db.dataFiles.NewReader()
 	for {
 		chunk, position, err := reader.Next()
 		if err != nil {
@@ -104,48 +190,98 @@ func (db *DB) loadIndexFromWAL() error {
 			}
 			return err
 		}
-		record := decodeLogRecord(chunk)
+		record, err := decodeLogRecord(chunk)
+		if err != nil {
+			return err
+		}
 
 		if record.Type == LogRecordBatchFinished {
 			batchId, err := snowflake.ParseBytes(record.Key)
 			if err != nil {
 				return err
 			}
+			if db.indexStore != nil && checkpoint == nil {
+				if err := db.beginIndex(); err != nil {
+					return err
+				}
+			}
+			mutations := make(indexMutations)
 			for _, idxRecord := range indexRecords[uint64(batchId)] {
-				if idxRecord.recordType == LogRecordNormal {
-					db.index.Put(idxRecord.key, idxRecord.position)
+				if idxRecord.bucketId == metaBucketId {
+					db.applyMetaRecord(idxRecord.recordType, idxRecord.key, idxRecord.value)
+					continue
 				}
-				if idxRecord.recordType == LogRecordDeleted {
-					db.index.Delete(idxRecord.key)
+				// 已经被删除的 bucket 中的数据直接忽略
+				bucket := db.bucketIds[idxRecord.bucketId]
+				if bucket == nil {
+					continue
 				}
+				deleted := idxRecord.recordType == LogRecordDeleted
+				bucket.addVersion(idxRecord.key, uint64(batchId), idxRecord.position, deleted)
+				mutations.add(bucket, idxRecord.key, idxRecord.position, deleted)
 			}
+			mutations.apply()
 
+			applied += len(indexRecords[uint64(batchId)])
 			delete(indexRecords, uint64(batchId))
+			if db.indexStore != nil {
+				checkpoint = &indexCheckpoint{batchId: uint64(batchId), position: position}
+				if applied >= diskIndexReplayRecords {
+					if err := db.commitIndex(checkpoint); err != nil {
+						return err
+					}
+					checkpoint, applied = nil, 0
+				}
+			}
 		} else {
-			indexRecords[record.BatchId] = append(indexRecords[record.BatchId],
-				&IndexRecord{
-					key:        record.Key,
-					recordType: record.Type,
-					position:   position,
-				})
+			idxRecord := &IndexRecord{
+				bucketId:   record.BucketId,
+				key:        record.Key,
+				recordType: record.Type,
+				position:   position,
+			}
+			// bucket 元数据需要保留 value 中的 bucket id
+			if record.BucketId == metaBucketId {
+				idxRecord.value = record.Value
+			}
+			indexRecords[record.BatchId] = append(indexRecords[record.BatchId], idxRecord)
+		}
+	}
+	if checkpoint != nil {
+		if err := db.commitIndex(checkpoint); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
 func (db *DB) Close() error {
+	// 后台校验需要获取读锁，必须在获取写锁之前停止
+	db.stopScrubber()
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	// 提交失败时磁盘索引停留在之前的 checkpoint，下次打开时从 WAL 中重放，
+	// 布隆过滤器保存失败时下次打开会按照索引重建
+	if db.flushIndex() == nil {
+		_ = db.saveBloom()
+	}
 	if err := db.dataFiles.Close(); err != nil {
 		return err
 	}
+	if db.indexStore != nil {
+		if err := db.indexStore.Close(); err != nil {
+			return err
+		}
+	}
 
 	if err := db.fileLock.Unlock(); err != nil {
 		return err
 	}
 
 	db.closed = true
+	// 阻塞中的命令醒来后会发现数据库已经关闭
+	db.watcher.notifyAll()
 	return nil
 }
 
@@ -154,6 +290,7 @@ func (db *DB) Put(key []byte, value []byte) error {
 	options.Sync = false
 	batch := db.NewBatch(options)
 	if err := batch.Put(key, value); err != nil {
+		batch.Close()
 		return err
 	}
 	return batch.Commit()
@@ -170,12 +307,24 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	return batch.Get(key)
 }
 
+// Scan 按照 key 的升序遍历默认 bucket 中所有以 prefix 为前缀的数据，handleFn 返回 false 时停止遍历
+// 遍历期间持有数据库的读锁，handleFn 中不能再对数据库进行写操作
+func (db *DB) Scan(prefix []byte, handleFn func(key []byte, value []byte) (bool, error)) error {
+	return db.defaultBucket.Scan(prefix, handleFn)
+}
+
 func (db *DB) Delete(key []byte) error {
 	options := config.DefaultBatchOptions
 	options.Sync = false
 	batch := db.NewBatch(options)
 	if err := batch.Delete(key); err != nil {
+		batch.Close()
 		return err
 	}
 	return batch.Commit()
 }
+
+// DeletePrefix 在一个 batch 中删除默认 bucket 中所有以 prefix 为前缀的 key，见 Bucket.DeletePrefix
+func (db *DB) DeletePrefix(prefix []byte) (int, error) {
+	return db.defaultBucket.DeletePrefix(prefix)
+}
@@ -0,0 +1,343 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fastdb/config"
+	"fastdb/index"
+	"fastdb/wal"
+	"github.com/bwmarrin/snowflake"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// diskIndexFileName 磁盘索引在数据目录中的文件名
+	diskIndexFileName = "INDEX"
+	// diskIndexStateVersion 磁盘索引中保存的数据库状态的编码版本
+	diskIndexStateVersion byte = 1
+	// diskIndexReplayRecords 重放 WAL 时每应用这么多条记录提交一次磁盘索引
+	diskIndexReplayRecords = 10000
+	// diskIndexCommitRecords 写入时每应用这么多条记录提交一次磁盘索引，
+	// 提交需要 fsync，未提交的修改在重新打开时从 WAL 中重放
+	diskIndexCommitRecords = 1000
+	// diskIndexCommitInterval 有未提交的修改时，最多经过这么长时间提交一次磁盘索引
+	diskIndexCommitInterval = time.Second
+	// diskIndexBloomName 关闭数据库时保存在磁盘索引中的布隆过滤器
+	diskIndexBloomName = "bloom"
+)
+
+var errInvalidIndexState = errors.New("invalid disk index state")
+
+// indexCheckpoint 磁盘索引中最后一个已经应用的 batch，position 是该 batch 的结束标记在 WAL 中的位置
+type indexCheckpoint struct {
+	batchId  uint64
+	position *wal.ChunkPosition
+}
+
+// matches 检查 WAL 中 checkpoint 位置处的数据是否依然是该 batch 的结束标记
+func (cp *indexCheckpoint) matches(data []byte) bool {
+	record, err := decodeLogRecord(data)
+	if err != nil {
+		return false
+	}
+	return record.Type == LogRecordBatchFinished && bytes.Equal(record.Key, snowflake.ID(cp.batchId).Bytes())
+}
+
+func (db *DB) openDiskIndex() error {
+	if db.options.IndexType != config.DiskBTreeIndex {
+		return nil
+	}
+	store, err := index.OpenDiskStore(filepath.Join(db.options.DirPath, diskIndexFileName))
+	if err != nil {
+		return err
+	}
+	db.indexStore = store
+	return nil
+}
+
+func (db *DB) newIndexer(bucketId uint32) index.Indexer {
+	if db.indexStore != nil {
+		return db.indexStore.Indexer(bucketId)
+	}
+	return index.NewIndexer(db.options.IndexType)
+}
+
+// loadIndex 打开数据库时加载索引。
+// 磁盘索引保存了最后一个已经应用的 batch，只需要重放 WAL 中它之后的记录；
+// 在 WAL 中找不到该 batch 的结束标记时(例如数据文件被替换)，清空磁盘索引并重放整个 WAL
+func (db *DB) loadIndex() error {
+	if db.indexStore == nil {
+		return db.loadIndexFromWAL()
+	}
+	checkpoint, err := db.loadIndexState()
+	if err != nil && !errors.Is(err, errInvalidIndexState) {
+		return err
+	}
+	if checkpoint != nil {
+		reader, data, err := db.dataFiles.NewReaderAfter(checkpoint.position)
+		if err == nil && checkpoint.matches(data) {
+			db.indexCommitted = checkpoint
+			// 正常关闭时保存的布隆过滤器与 checkpoint 一致，不需要遍历整个磁盘索引重建
+			restored := db.loadBloom(checkpoint)
+			if err := db.replayWAL(reader); err != nil {
+				return err
+			}
+			if !restored {
+				db.rebuildBlooms()
+			}
+			return nil
+		}
+	}
+	return db.rebuildIndex()
+}
+
+// rebuildIndex 清空所有 bucket 的索引，并重放整个 WAL
+func (db *DB) rebuildIndex() error {
+	if db.indexStore != nil {
+		db.rollbackIndex()
+		db.indexCommitted = nil
+		if err := db.indexStore.Reset(); err != nil {
+			return err
+		}
+	}
+	db.resetBuckets()
+	return db.loadIndexFromWAL()
+}
+
+// beginIndex 确保存在一个磁盘索引事务，之后对索引的修改在 commitIndex 时与 checkpoint 一起原子地提交。
+// 事务会跨越多个 batch，之前的提交失败时返回该错误。未使用磁盘索引时什么都不做
+func (db *DB) beginIndex() error {
+	if db.indexStore == nil || db.indexTxOpen {
+		return nil
+	}
+	if err := db.indexStore.Begin(); err != nil {
+		return err
+	}
+	db.indexTxOpen = true
+	return nil
+}
+
+// commitIndex 立即提交磁盘索引事务，同时保存 bucket 的元数据与 checkpoint，调用方需要持有写锁
+func (db *DB) commitIndex(checkpoint *indexCheckpoint) error {
+	if db.indexStore == nil {
+		return nil
+	}
+	db.indexTxOpen = false
+	db.indexPending, db.indexPendingRecords = nil, 0
+	if db.indexTimer != nil {
+		db.indexTimer.Stop()
+		db.indexTimer = nil
+	}
+	if err := db.indexStore.Commit(db.encodeIndexState(checkpoint)); err != nil {
+		return err
+	}
+	db.indexCommitted = checkpoint
+	return nil
+}
+
+// deferIndexCommit 记录 batch 已经应用到磁盘索引事务中，每 diskIndexCommitRecords 条记录
+// 或者每 diskIndexCommitInterval 提交一次，避免每个 batch 都 fsync 一次索引文件。
+// batch 已经写入 WAL，提交失败不影响它的结果，错误在下一个 batch 调用 beginIndex 时返回，
+// 重新打开数据库时从最后一次成功提交的 checkpoint 开始重放，调用方需要持有写锁
+func (db *DB) deferIndexCommit(checkpoint *indexCheckpoint, records int) {
+	if db.indexStore == nil {
+		return
+	}
+	db.indexPending = checkpoint
+	db.indexPendingRecords += records
+	if db.indexPendingRecords >= diskIndexCommitRecords {
+		_ = db.commitIndex(checkpoint)
+		return
+	}
+	if db.indexTimer == nil {
+		db.indexTimer = time.AfterFunc(diskIndexCommitInterval, func() {
+			db.mu.Lock()
+			defer db.mu.Unlock()
+			_ = db.flushIndex()
+		})
+	}
+}
+
+// flushIndex 提交尚未提交的 batch，调用方需要持有写锁
+func (db *DB) flushIndex() error {
+	if db.closed || db.indexPending == nil {
+		return nil
+	}
+	return db.commitIndex(db.indexPending)
+}
+
+// saveBloom 将所有 bucket 的布隆过滤器与最后提交的 checkpoint 一起保存，关闭数据库时调用，
+// 调用方需要持有写锁。之后再有提交时 checkpoint 会变化，保存的过滤器随之失效
+func (db *DB) saveBloom() error {
+	if db.indexStore == nil || !db.options.BloomFilter || db.indexCommitted == nil {
+		return nil
+	}
+	buf := binary.AppendUvarint(nil, db.indexCommitted.batchId)
+	buf = binary.AppendUvarint(buf, uint64(len(db.bucketIds)))
+	for id, bucket := range db.bucketIds {
+		buf = binary.AppendUvarint(buf, uint64(id))
+		buf = bucket.bloom.encode(buf)
+	}
+	if err := db.beginIndex(); err != nil {
+		return err
+	}
+	if err := db.indexStore.PutExtra(diskIndexBloomName, buf); err != nil {
+		db.rollbackIndex()
+		return err
+	}
+	return db.commitIndex(db.indexCommitted)
+}
+
+// loadBloom 恢复 saveBloom 保存的布隆过滤器，只有保存时的 checkpoint 与 checkpoint 相同时才有效，
+// 返回是否恢复了所有 bucket 的过滤器
+func (db *DB) loadBloom(checkpoint *indexCheckpoint) bool {
+	if !db.options.BloomFilter {
+		return true
+	}
+	buf, err := db.indexStore.Extra(diskIndexBloomName)
+	if err != nil || buf == nil {
+		return false
+	}
+	batchId, n := binary.Uvarint(buf)
+	if n <= 0 || batchId != checkpoint.batchId {
+		return false
+	}
+	buf = buf[n:]
+	count, n := binary.Uvarint(buf)
+	if n <= 0 || count != uint64(len(db.bucketIds)) {
+		return false
+	}
+	buf = buf[n:]
+	filters := make(map[uint32]*bloomFilter, count)
+	for i := uint64(0); i < count; i++ {
+		id, n := binary.Uvarint(buf)
+		bucket := db.bucketIds[uint32(id)]
+		if n <= 0 || bucket == nil {
+			return false
+		}
+		filter := newBloomFilter(bucket.bloom.capacity, bucket.bloom.rate)
+		if buf, err = filter.decode(buf[n:]); err != nil {
+			return false
+		}
+		filters[uint32(id)] = filter
+	}
+	for id, filter := range filters {
+		db.bucketIds[id].bloom = filter
+	}
+	return true
+}
+
+// rebuildBlooms 按照索引重建所有 bucket 的布隆过滤器
+func (db *DB) rebuildBlooms() {
+	for _, bucket := range db.bucketIds {
+		bucket.rebuildBloom()
+	}
+}
+
+// rollbackIndex 放弃尚未提交的磁盘索引事务
+func (db *DB) rollbackIndex() {
+	db.indexStore.Rollback()
+	db.indexTxOpen = false
+	db.indexPending, db.indexPendingRecords = nil, 0
+	if db.indexTimer != nil {
+		db.indexTimer.Stop()
+		db.indexTimer = nil
+	}
+}
+
+// dropIndex 删除磁盘索引中 bucket 的数据，出错时错误在 commitIndex 时返回
+func (db *DB) dropIndex(bucket *Bucket) {
+	if db.indexStore != nil {
+		_ = db.indexStore.Drop(bucket.id)
+	}
+}
+
+// encodeIndexState 编码 checkpoint 以及重新打开时需要恢复的 bucket 元数据:
+// version batchId position nextBucketId count [id diskSize nameSize name]...
+func (db *DB) encodeIndexState(checkpoint *indexCheckpoint) []byte {
+	buf := []byte{diskIndexStateVersion}
+	buf = binary.AppendUvarint(buf, checkpoint.batchId)
+	position := checkpoint.position.Encode()
+	buf = binary.AppendUvarint(buf, uint64(len(position)))
+	buf = append(buf, position...)
+	buf = binary.AppendUvarint(buf, uint64(db.nextBucketId))
+	buf = binary.AppendUvarint(buf, uint64(len(db.bucketIds)))
+	for id, bucket := range db.bucketIds {
+		buf = binary.AppendUvarint(buf, uint64(id))
+		buf = binary.AppendVarint(buf, bucket.diskSize.Load())
+		buf = binary.AppendUvarint(buf, uint64(len(bucket.name)))
+		buf = append(buf, bucket.name...)
+	}
+	return buf
+}
+
+// loadIndexState 从磁盘索引中恢复 bucket 的元数据，返回保存的 checkpoint，从未提交过时返回 nil
+func (db *DB) loadIndexState() (*indexCheckpoint, error) {
+	state, err := db.indexStore.State()
+	if err != nil || state == nil {
+		return nil, err
+	}
+	if state[0] != diskIndexStateVersion {
+		return nil, errInvalidIndexState
+	}
+	buf := state[1:]
+	next := func() uint64 {
+		value, n := binary.Uvarint(buf)
+		if n <= 0 {
+			err = errInvalidIndexState
+			return 0
+		}
+		buf = buf[n:]
+		return value
+	}
+	bytesOf := func(size uint64) []byte {
+		if err != nil || size > uint64(len(buf)) {
+			err = errInvalidIndexState
+			return nil
+		}
+		value := buf[:size]
+		buf = buf[size:]
+		return value
+	}
+
+	checkpoint := &indexCheckpoint{batchId: next()}
+	position := bytesOf(next())
+	nextBucketId := uint32(next())
+	count := next()
+	var buckets []*Bucket
+	for i := uint64(0); i < count && err == nil; i++ {
+		id := uint32(next())
+		diskSize, n := binary.Varint(buf)
+		if n <= 0 {
+			return nil, errInvalidIndexState
+		}
+		buf = buf[n:]
+		name := bytesOf(next())
+		bucket := newBucket(db, id, string(name))
+		bucket.diskSize.Store(diskSize)
+		buckets = append(buckets, bucket)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint.position, err = wal.DecodeChunkPosition(position); err != nil {
+		return nil, err
+	}
+
+	db.resetBuckets()
+	for _, bucket := range buckets {
+		switch bucket.id {
+		case defaultBucketId:
+			db.defaultBucket = bucket
+		case typesBucketId:
+			db.typesBucket = bucket
+		default:
+			db.buckets[bucket.name] = bucket
+		}
+		db.bucketIds[bucket.id] = bucket
+	}
+	db.nextBucketId = nextBucketId
+	return checkpoint, nil
+}
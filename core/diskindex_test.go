@@ -0,0 +1,250 @@
+package core
+
+import (
+	"bytes"
+	"fastdb/common"
+	"fastdb/config"
+	"fastdb/lib/encrypt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func diskIndexOptions(t *testing.T) config.DbOptions {
+	options := config.DefaultOptions
+	options.DirPath = t.TempDir()
+	options.IndexType = config.DiskBTreeIndex
+	return options
+}
+
+func TestDiskIndex_Reopen(t *testing.T) {
+	options := diskIndexOptions(t)
+	db, err := Open(options)
+	assert.Nil(t, err)
+
+	users, err := db.Bucket("users")
+	assert.Nil(t, err)
+	dropped, err := db.Bucket("dropped")
+	assert.Nil(t, err)
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(common.GetTestKey(i), common.RandomValue(64)))
+		assert.Nil(t, users.Put(common.GetTestKey(i), []byte("user")))
+		assert.Nil(t, dropped.Put(common.GetTestKey(i), []byte("dropped")))
+	}
+	for i := 0; i < 500; i += 5 {
+		assert.Nil(t, db.Delete(common.GetTestKey(i)))
+	}
+	_, err = db.HSet([]byte("hash"), []byte("field"), []byte("value"))
+	assert.Nil(t, err)
+	assert.Nil(t, db.DropBucket("dropped"))
+	stats := db.defaultBucket.Stats()
+	userStats := users.Stats()
+	assert.Equal(t, 400, stats.KeyCount)
+	assert.Nil(t, db.Close())
+
+	// 重新打开时 bucket 与统计从磁盘索引中恢复，不需要重放 WAL
+	db, err = Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assert.Equal(t, stats, db.defaultBucket.Stats())
+	assert.Equal(t, []string{"users"}, db.Buckets())
+	users, err = db.Bucket("users")
+	assert.Nil(t, err)
+	assert.Equal(t, userStats, users.Stats())
+	val, err := users.Get(common.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("user"), val)
+	_, err = db.Get(common.GetTestKey(5))
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+	val, err = db.HGet([]byte("hash"), []byte("field"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+
+	// 被删除的 bucket 的 id 不会被复用
+	recreated, err := db.Bucket("dropped")
+	assert.Nil(t, err)
+	assert.Greater(t, recreated.id, users.id+1)
+	assert.Equal(t, 0, recreated.Stats().KeyCount)
+}
+
+func TestDiskIndex_ReplayAfterCheckpoint(t *testing.T) {
+	options := diskIndexOptions(t)
+	db, err := Open(options)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(common.GetTestKey(i), []byte("old")))
+	}
+	assert.Nil(t, db.Close())
+
+	// 使用内存索引写入的数据不会进入磁盘索引，磁盘索引的 checkpoint 落后于 WAL
+	memOptions := options
+	memOptions.IndexType = config.RadixTreeIndex
+	db, err = Open(memOptions)
+	assert.Nil(t, err)
+	for i := 50; i < 150; i++ {
+		assert.Nil(t, db.Put(common.GetTestKey(i), []byte("new")))
+	}
+	assert.Nil(t, db.Delete(common.GetTestKey(0)))
+	orders, err := db.Bucket("orders")
+	assert.Nil(t, err)
+	assert.Nil(t, orders.Put([]byte("order"), []byte("1")))
+	expected := db.defaultBucket.Stats()
+	assert.Nil(t, db.Close())
+
+	db, err = Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assert.Equal(t, expected, db.defaultBucket.Stats())
+	_, err = db.Get(common.GetTestKey(0))
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+	val, err := db.Get(common.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("old"), val)
+	val, err = db.Get(common.GetTestKey(100))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), val)
+	orders, err = db.Bucket("orders")
+	assert.Nil(t, err)
+	val, err = orders.Get([]byte("order"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), val)
+}
+
+func TestDiskIndex_DeferredCommit(t *testing.T) {
+	options := diskIndexOptions(t)
+	db, err := Open(options)
+	assert.Nil(t, err)
+
+	// 每个 batch 不会单独提交磁盘索引，未提交的修改依然可以读到
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(common.GetTestKey(i), []byte("v1")))
+	}
+	state, err := db.indexStore.State()
+	assert.Nil(t, err)
+	assert.Nil(t, state)
+	val, err := db.Get(common.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+
+	for i := 10; i < diskIndexCommitRecords; i++ {
+		assert.Nil(t, db.Put(common.GetTestKey(i), []byte("v1")))
+	}
+	state, err = db.indexStore.State()
+	assert.Nil(t, err)
+	assert.NotNil(t, state)
+
+	// 写入较少时由定时器提交
+	assert.Nil(t, db.Put([]byte("timer"), []byte("v1")))
+	assert.Eventually(t, func() bool {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		return db.indexPending == nil
+	}, 3*diskIndexCommitInterval, 10*time.Millisecond)
+
+	// 模拟崩溃，尚未提交的 batch 在重新打开时从 WAL 中重放
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(common.GetTestKey(i), []byte("v2")))
+	}
+	db.mu.Lock()
+	db.rollbackIndex()
+	assert.Nil(t, db.indexStore.Close())
+	assert.Nil(t, db.dataFiles.Close())
+	assert.Nil(t, db.fileLock.Unlock())
+	db.closed = true
+	db.mu.Unlock()
+
+	db, err = Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assert.Equal(t, diskIndexCommitRecords+1, db.defaultBucket.Stats().KeyCount)
+	for i := 0; i < 20; i++ {
+		expected := []byte("v1")
+		if i < 10 {
+			expected = []byte("v2")
+		}
+		val, err = db.Get(common.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, val)
+	}
+}
+
+func TestDiskIndex_DataFilesReplaced(t *testing.T) {
+	options := diskIndexOptions(t)
+	db, err := Open(options)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(common.GetTestKey(i), []byte("old")))
+	}
+	assert.Nil(t, db.Close())
+
+	// 数据文件被替换之后 checkpoint 处不再是原来的 batch，磁盘索引需要重建
+	assert.Nil(t, removeDataFiles(options.DirPath))
+	memOptions := options
+	memOptions.IndexType = config.RadixTreeIndex
+	db, err = Open(memOptions)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("replaced"), []byte("1")))
+	assert.Nil(t, db.Close())
+
+	db, err = Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assert.Equal(t, 1, db.defaultBucket.Stats().KeyCount)
+	_, err = db.Get(common.GetTestKey(1))
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+	val, err := db.Get([]byte("replaced"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), val)
+}
+
+func TestDiskIndex_Restore(t *testing.T) {
+	db, err := Open(diskIndexOptions(t))
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(common.GetTestKey(i), []byte("backup")))
+	}
+	backup, err := db.NewBackup()
+	assert.Nil(t, err)
+	var buf bytes.Buffer
+	_, err = backup.WriteTo(&buf)
+	assert.Nil(t, err)
+
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(common.GetTestKey(i), []byte("latest")))
+	}
+	assert.Nil(t, db.Restore(&buf))
+	assert.Equal(t, 100, db.defaultBucket.Stats().KeyCount)
+	val, err := db.Get(common.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("backup"), val)
+	_, err = db.Get(common.GetTestKey(150))
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+}
+
+func TestDiskIndex_Options(t *testing.T) {
+	options := diskIndexOptions(t)
+	options.HistoryVersions = 3
+	_, err := Open(options)
+	assert.NotNil(t, err)
+
+	// 磁盘索引以明文保存 key，不能与加密同时使用
+	options = diskIndexOptions(t)
+	options.KeyProvider = encrypt.NewStaticKeyProvider(bytes.Repeat([]byte{1}, 32))
+	_, err = Open(options)
+	assert.NotNil(t, err)
+	_, err = os.Stat(filepath.Join(options.DirPath, diskIndexFileName))
+	assert.True(t, os.IsNotExist(err))
+
+	options = diskIndexOptions(t)
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	_, err = os.Stat(filepath.Join(options.DirPath, diskIndexFileName))
+	assert.Nil(t, err)
+	err = db.Put(make([]byte, 64*1024), []byte("value"))
+	assert.NotNil(t, err)
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+}
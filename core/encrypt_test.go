@@ -0,0 +1,130 @@
+package core
+
+import (
+	"bytes"
+	"fastdb/common"
+	"fastdb/config"
+	"fastdb/lib/encrypt"
+	"fastdb/wal"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDB_Encryption(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	options := config.DefaultOptions
+	options.KeyProvider = encrypt.NewStaticKeyProvider(key)
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	plain := bytes.Repeat([]byte("plaintext "), 10)
+	large := common.RandomValue(100 * config.KB)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(common.GetTestKey(i), plain))
+	}
+	assert.Nil(t, db.Put([]byte("large"), large))
+	assert.Nil(t, db.Close())
+
+	// 数据文件中不包含明文
+	data, err := os.ReadFile(wal.SegmentFileName(options.DirPath, dataFileNameSuffix, 1))
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(data, plain))
+
+	// 错误的密钥在打开时失败
+	options.KeyProvider = encrypt.NewStaticKeyProvider(bytes.Repeat([]byte{2}, 32))
+	_, err = Open(options)
+	assert.ErrorIs(t, err, encrypt.ErrUnknownKey)
+
+	options.KeyProvider = encrypt.NewStaticKeyProvider(key)
+	db, err = Open(options)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		value, err := db.Get(common.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, plain, value)
+	}
+	value, err := db.Get([]byte("large"))
+	assert.Nil(t, err)
+	assert.Equal(t, large, value)
+}
+
+func TestDB_EncryptionKeyRotation(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 16)
+	newKey := bytes.Repeat([]byte{2}, 16)
+	options := config.DefaultOptions
+	options.KeyProvider = encrypt.NewStaticKeyProvider(oldKey)
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assert.Nil(t, db.Put([]byte("old"), []byte("value1")))
+	assert.Nil(t, db.Close())
+
+	// 新密钥加密新写入的数据，旧密钥只用于读取之前的数据
+	options.KeyProvider = encrypt.NewStaticKeyProvider(newKey, oldKey)
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("new"), []byte("value2")))
+	assert.Nil(t, db.Close())
+
+	db, err = Open(options)
+	assert.Nil(t, err)
+	value, err := db.Get([]byte("old"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value1"), value)
+	value, err = db.Get([]byte("new"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value2"), value)
+}
+
+func TestDB_Reencrypt(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 16)
+	newKey := bytes.Repeat([]byte{2}, 16)
+	options := config.DefaultOptions
+	options.SegmentSize = 64 * config.KB
+	options.KeyProvider = encrypt.NewStaticKeyProvider(oldKey)
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("key-%03d", i)), bytes.Repeat([]byte{byte(i)}, 1024)))
+	}
+	assert.Nil(t, db.Delete([]byte("key-000")))
+	assert.Nil(t, db.Close())
+
+	// 没有重新加密之前，移除旧密钥后无法打开
+	options.KeyProvider = encrypt.NewStaticKeyProvider(newKey)
+	_, err = Open(options)
+	assert.ErrorIs(t, err, encrypt.ErrUnknownKey)
+
+	options.KeyProvider = encrypt.NewStaticKeyProvider(newKey, oldKey)
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, Reencrypt(options), common.ErrDatabaseIsUsing)
+	assert.Nil(t, db.Close())
+	assert.Nil(t, Reencrypt(options))
+
+	entries, err := os.ReadDir(options.DirPath)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		assert.False(t, strings.HasPrefix(entry.Name(), reencryptBackupPrefix), entry.Name())
+	}
+
+	options.KeyProvider = encrypt.NewStaticKeyProvider(newKey)
+	db, err = Open(options)
+	assert.Nil(t, err)
+	_, err = db.Get([]byte("key-000"))
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+	for i := 1; i < 100; i++ {
+		value, err := db.Get([]byte(fmt.Sprintf("key-%03d", i)))
+		assert.Nil(t, err)
+		assert.Equal(t, bytes.Repeat([]byte{byte(i)}, 1024), value)
+	}
+	assert.Nil(t, db.Close())
+
+	options.KeyProvider = nil
+	assert.ErrorIs(t, Reencrypt(options), encrypt.ErrNoKey)
+}
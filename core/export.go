@@ -0,0 +1,645 @@
+package core
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fastdb/common"
+	"fastdb/index"
+	"fastdb/wal"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ExportFormat 导出数据所使用的格式
+type ExportFormat = byte
+
+const (
+	// FormatJSONLines 每行一个 JSON 对象，key 与 value 不是合法的 UTF-8 时使用 base64 编码
+	FormatJSONLines ExportFormat = iota
+	// FormatCSV 每行依次为 bucket、key、value、types 四列，第一行为表头
+	FormatCSV
+	// FormatNative 紧凑的二进制格式，末尾带有 crc 校验
+	FormatNative
+)
+
+var exportFormatNames = map[string]ExportFormat{
+	"jsonl":  FormatJSONLines,
+	"csv":    FormatCSV,
+	"native": FormatNative,
+}
+
+const (
+	// importBatchRecords 导入时每个 batch 最多包含的记录数
+	importBatchRecords = 1000
+	// importBatchBytes 导入时每个 batch 最多包含的 key 与 value 的字节数
+	importBatchBytes = 4 * 1024 * 1024
+
+	// csvBase64Prefix CSV 中以该前缀开头的字段是 base64 编码的二进制数据
+	csvBase64Prefix = "base64:"
+
+	// nativeMagic 与 nativeVersion 位于 native 格式的开头
+	nativeMagic   = "FDBX"
+	nativeVersion = 1
+	// native 格式中每一项的类型
+	nativeTagEnd    byte = 0
+	nativeTagBucket byte = 1
+	nativeTagEntry  byte = 2
+	// nativeMaxLength native 格式中 bucket 名称、key 与 value 的最大长度，超过时认为数据已经损坏
+	nativeMaxLength = 1 << 30
+)
+
+var (
+	ErrUnknownExportFormat = errors.New("unknown export format")
+	ErrInvalidImportData   = errors.New("invalid import data")
+	// errDataFilesReplaced 导出期间数据文件被 Restore 替换，快照中的位置不再有效
+	errDataFilesReplaced = errors.New("the data files were replaced during export")
+)
+
+// ParseExportFormat 将 jsonl、csv、native 转换为对应的格式
+func ParseExportFormat(name string) (ExportFormat, error) {
+	format, ok := exportFormatNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownExportFormat, name)
+	}
+	return format, nil
+}
+
+// ExportRecord 导出的一条数据。Types 为 true 时属于 hash、set 等数据结构的内部 bucket，
+// Key 是内部编码之后的 key，导入后这些数据结构可以原样恢复
+type ExportRecord struct {
+	Bucket string
+	Key    []byte
+	Value  []byte
+	Types  bool
+}
+
+// exportBucket 导出时某个 bucket 的索引快照
+type exportBucket struct {
+	bucket *Bucket
+	iter   index.Iterator
+}
+
+// Export 将所有 bucket 中的数据按格式 format 写入 w，返回写入的记录数。
+// 开始时在读锁下获取所有 bucket 的索引快照，之后的写入对导出不可见；
+// 读取每条记录时只短暂地持有读锁，导出期间不会阻塞写入
+func (db *DB) Export(w io.Writer, format ExportFormat) (int, error) {
+	encoder, err := newExportEncoder(w, format)
+	if err != nil {
+		return 0, common.NewErr(&common.InnerErrNo, err)
+	}
+
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return 0, common.NewErr(&common.DBClosedErrNo, common.ErrDBClosed)
+	}
+	files := db.dataFiles
+	names := make([]string, 0, len(db.buckets))
+	for name := range db.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buckets := []*Bucket{db.defaultBucket}
+	for _, name := range names {
+		buckets = append(buckets, db.buckets[name])
+	}
+	buckets = append(buckets, db.typesBucket)
+	snapshots := make([]exportBucket, len(buckets))
+	for i, bucket := range buckets {
+		snapshots[i] = exportBucket{bucket: bucket, iter: bucket.index.Iterator(index.IteratorOptions{})}
+	}
+	db.mu.RUnlock()
+	defer func() {
+		for _, snapshot := range snapshots {
+			snapshot.iter.Close()
+		}
+	}()
+
+	var count int
+	for _, snapshot := range snapshots {
+		for iter := snapshot.iter; iter.Valid(); iter.Next() {
+			record, err := db.readExportRecord(files, iter.Value())
+			if err != nil {
+				return count, err
+			}
+			if record.Type == LogRecordDeleted {
+				continue
+			}
+			err = encoder.encode(&ExportRecord{
+				Bucket: snapshot.bucket.name,
+				Key:    iter.Key(),
+				Value:  record.Value,
+				Types:  snapshot.bucket.id == typesBucketId,
+			})
+			if err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	return count, encoder.close()
+}
+
+// readExportRecord 在读锁下读取快照中的一条记录
+func (db *DB) readExportRecord(files *wal.WAL, position *wal.ChunkPosition) (*LogRecord, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, common.NewErr(&common.DBClosedErrNo, common.ErrDBClosed)
+	}
+	if db.dataFiles != files {
+		return nil, common.NewErr(&common.InnerErrNo, errDataFilesReplaced)
+	}
+	chunk, err := files.Read(position)
+	if err != nil {
+		return nil, common.NewErr(&common.InnerErrNo, err)
+	}
+	record, err := decodeLogRecord(chunk)
+	if err != nil {
+		return nil, common.NewErr(&common.InnerErrNo, err)
+	}
+	return record, nil
+}
+
+// Import 读取 Export 以格式 format 写出的数据并写入数据库，返回导入的记录数。
+// 数据被分成多个 batch 提交，每个 batch 是原子的，但整个导入不是，
+// 出错时已经提交的 batch 会保留，全部导入完成后才会刷盘
+func (db *DB) Import(r io.Reader, format ExportFormat) (int, error) {
+	decoder, err := newExportDecoder(r, format)
+	if err != nil {
+		return 0, common.NewErr(&common.InnerErrNo, err)
+	}
+
+	var count int
+	var pending []*importRecord
+	var pendingBytes int
+	// buckets 缓存 bucket 名称到 bucket 的映射，bucket 需要在 batch 之外获取
+	buckets := make(map[string]*Bucket)
+	for {
+		record, err := decoder.decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, common.NewErr(&common.InnerErrNo, err)
+		}
+
+		bucket, err := db.importBucket(record, buckets)
+		if err != nil {
+			return count, err
+		}
+		pending = append(pending, &importRecord{bucket: bucket, key: record.Key, value: record.Value})
+		pendingBytes += len(record.Key) + len(record.Value)
+		if len(pending) >= importBatchRecords || pendingBytes >= importBatchBytes {
+			if err := db.commitImport(pending); err != nil {
+				return count, err
+			}
+			count += len(pending)
+			pending, pendingBytes = pending[:0], 0
+		}
+	}
+	if err := db.commitImport(pending); err != nil {
+		return count, err
+	}
+	count += len(pending)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return count, common.NewErr(&common.DBClosedErrNo, common.ErrDBClosed)
+	}
+	if err := db.dataFiles.Sync(); err != nil {
+		return count, common.NewErr(&common.InnerErrNo, err)
+	}
+	return count, nil
+}
+
+type importRecord struct {
+	bucket *Bucket
+	key    []byte
+	value  []byte
+}
+
+// importBucket 返回记录所属的 bucket，用户 bucket 不存在时创建
+func (db *DB) importBucket(record *ExportRecord, buckets map[string]*Bucket) (*Bucket, error) {
+	if record.Types {
+		return db.typesBucket, nil
+	}
+	if record.Bucket == "" {
+		return db.defaultBucket, nil
+	}
+	if bucket := buckets[record.Bucket]; bucket != nil {
+		return bucket, nil
+	}
+	bucket, err := db.Bucket(record.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	buckets[record.Bucket] = bucket
+	return bucket, nil
+}
+
+func (db *DB) commitImport(records []*importRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	batch := db.NewBatch(asyncBatchOptions())
+	for _, record := range records {
+		if err := batch.Bucket(record.bucket).Put(record.key, record.value); err != nil {
+			batch.Close()
+			return err
+		}
+	}
+	return batch.Commit()
+}
+
+type exportEncoder interface {
+	encode(record *ExportRecord) error
+	// close 写入格式要求的结尾并刷新缓冲区，不会关闭底层的 writer
+	close() error
+}
+
+type exportDecoder interface {
+	// decode 返回下一条记录，全部读取完成时返回 io.EOF
+	decode() (*ExportRecord, error)
+}
+
+func newExportEncoder(w io.Writer, format ExportFormat) (exportEncoder, error) {
+	switch format {
+	case FormatJSONLines:
+		bw := bufio.NewWriter(w)
+		return &jsonEncoder{w: bw, encoder: json.NewEncoder(bw)}, nil
+	case FormatCSV:
+		encoder := &csvEncoder{w: csv.NewWriter(w)}
+		return encoder, encoder.w.Write([]string{"bucket", "key", "value", "types"})
+	case FormatNative:
+		encoder := &nativeEncoder{w: bufio.NewWriter(w), crc: crc32.NewIEEE()}
+		return encoder, encoder.write(append([]byte(nativeMagic), nativeVersion))
+	default:
+		return nil, ErrUnknownExportFormat
+	}
+}
+
+func newExportDecoder(r io.Reader, format ExportFormat) (exportDecoder, error) {
+	switch format {
+	case FormatJSONLines:
+		return &jsonDecoder{decoder: json.NewDecoder(r)}, nil
+	case FormatCSV:
+		decoder := &csvDecoder{r: csv.NewReader(r)}
+		decoder.r.FieldsPerRecord = 4
+		decoder.r.ReuseRecord = true
+		return decoder, decoder.readHeader()
+	case FormatNative:
+		decoder := &nativeDecoder{r: &crcReader{r: bufio.NewReader(r), crc: crc32.NewIEEE()}}
+		return decoder, decoder.readHeader()
+	default:
+		return nil, ErrUnknownExportFormat
+	}
+}
+
+// jsonLine 是 JSON lines 格式中的一行，key 或 value 不是合法的 UTF-8 时
+// 使用 KeyBase64 或 ValueBase64 代替 Key 或 Value
+type jsonLine struct {
+	Bucket      string  `json:"bucket,omitempty"`
+	Key         *string `json:"key,omitempty"`
+	KeyBase64   []byte  `json:"key_base64,omitempty"`
+	Value       *string `json:"value,omitempty"`
+	ValueBase64 []byte  `json:"value_base64,omitempty"`
+	Types       bool    `json:"types,omitempty"`
+}
+
+type jsonEncoder struct {
+	w       *bufio.Writer
+	encoder *json.Encoder
+}
+
+func (e *jsonEncoder) encode(record *ExportRecord) error {
+	line := &jsonLine{Bucket: record.Bucket, Types: record.Types}
+	if utf8.Valid(record.Key) {
+		key := string(record.Key)
+		line.Key = &key
+	} else {
+		line.KeyBase64 = record.Key
+	}
+	if utf8.Valid(record.Value) {
+		value := string(record.Value)
+		line.Value = &value
+	} else {
+		line.ValueBase64 = record.Value
+	}
+	return e.encoder.Encode(line)
+}
+
+func (e *jsonEncoder) close() error {
+	return e.w.Flush()
+}
+
+type jsonDecoder struct {
+	decoder *json.Decoder
+}
+
+func (d *jsonDecoder) decode() (*ExportRecord, error) {
+	var line jsonLine
+	if err := d.decoder.Decode(&line); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImportData, err)
+	}
+	record := &ExportRecord{Bucket: line.Bucket, Key: line.KeyBase64, Value: line.ValueBase64, Types: line.Types}
+	if line.Key != nil {
+		record.Key = []byte(*line.Key)
+	}
+	if line.Value != nil {
+		record.Value = []byte(*line.Value)
+	}
+	if len(record.Key) == 0 {
+		return nil, fmt.Errorf("%w: the key is empty", ErrInvalidImportData)
+	}
+	return record, nil
+}
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvEncoder) encode(record *ExportRecord) error {
+	types := ""
+	if record.Types {
+		types = "1"
+	}
+	return e.w.Write([]string{record.Bucket, encodeCSVField(record.Key), encodeCSVField(record.Value), types})
+}
+
+func (e *csvEncoder) close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// encodeCSVField 不是合法 UTF-8 的数据，以及恰好以 csvBase64Prefix 开头的文本都使用 base64 编码
+func encodeCSVField(data []byte) string {
+	if utf8.Valid(data) && !strings.HasPrefix(string(data), csvBase64Prefix) {
+		return string(data)
+	}
+	return csvBase64Prefix + base64.StdEncoding.EncodeToString(data)
+}
+
+func decodeCSVField(field string) ([]byte, error) {
+	if !strings.HasPrefix(field, csvBase64Prefix) {
+		return []byte(field), nil
+	}
+	return base64.StdEncoding.DecodeString(field[len(csvBase64Prefix):])
+}
+
+type csvDecoder struct {
+	r *csv.Reader
+}
+
+func (d *csvDecoder) readHeader() error {
+	header, err := d.r.Read()
+	if err == io.EOF {
+		// 空的输入等同于没有数据
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidImportData, err)
+	}
+	if strings.Join(header, ",") != "bucket,key,value,types" {
+		return fmt.Errorf("%w: unexpected csv header %q", ErrInvalidImportData, header)
+	}
+	return nil
+}
+
+func (d *csvDecoder) decode() (*ExportRecord, error) {
+	fields, err := d.r.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImportData, err)
+	}
+	key, err := decodeCSVField(fields[1])
+	if err != nil || len(key) == 0 {
+		line, _ := d.r.FieldPos(1)
+		return nil, fmt.Errorf("%w: invalid key at line %d", ErrInvalidImportData, line)
+	}
+	value, err := decodeCSVField(fields[2])
+	if err != nil {
+		line, _ := d.r.FieldPos(2)
+		return nil, fmt.Errorf("%w: invalid value at line %d", ErrInvalidImportData, line)
+	}
+	return &ExportRecord{Bucket: fields[0], Key: key, Value: value, Types: fields[3] == "1"}, nil
+}
+
+// nativeEncoder native 格式:
+// magic(4) + version(1) + [bucket 或 entry]... + end(1) + 记录数(uvarint) + crc(4)
+// bucket: tag(1) + types(1) + 名称长度(uvarint) + 名称，之后的 entry 都属于该 bucket
+// entry: tag(1) + key 长度(uvarint) + key + value 长度(uvarint) + value
+// crc 覆盖 crc 之前的所有字节
+type nativeEncoder struct {
+	w     *bufio.Writer
+	crc   hash.Hash32
+	count uint64
+	// bucket 与 types 为最近一次写入的 bucket
+	bucket  string
+	types   bool
+	started bool
+	buf     [1 + 2*binary.MaxVarintLen64]byte
+}
+
+func (e *nativeEncoder) write(data []byte) error {
+	_, _ = e.crc.Write(data)
+	_, err := e.w.Write(data)
+	return err
+}
+
+func (e *nativeEncoder) writeBytes(tag byte, data []byte) error {
+	buf := e.buf[:0]
+	if tag != 0 {
+		buf = append(buf, tag)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	if err := e.write(buf); err != nil {
+		return err
+	}
+	return e.write(data)
+}
+
+func (e *nativeEncoder) encode(record *ExportRecord) error {
+	if !e.started || record.Bucket != e.bucket || record.Types != e.types {
+		types := byte(0)
+		if record.Types {
+			types = 1
+		}
+		if err := e.write([]byte{nativeTagBucket, types}); err != nil {
+			return err
+		}
+		if err := e.writeBytes(0, []byte(record.Bucket)); err != nil {
+			return err
+		}
+		e.bucket, e.types, e.started = record.Bucket, record.Types, true
+	}
+	if err := e.writeBytes(nativeTagEntry, record.Key); err != nil {
+		return err
+	}
+	if err := e.writeBytes(0, record.Value); err != nil {
+		return err
+	}
+	e.count++
+	return nil
+}
+
+func (e *nativeEncoder) close() error {
+	buf := binary.AppendUvarint([]byte{nativeTagEnd}, e.count)
+	if err := e.write(buf); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(binary.LittleEndian.AppendUint32(nil, e.crc.Sum32())); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// crcReader 计算已经读取的字节的 crc
+type crcReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+func (c *crcReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	_, _ = c.crc.Write(p[:n])
+	return n, err
+}
+
+func (c *crcReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		_, _ = c.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+type nativeDecoder struct {
+	r     *crcReader
+	count uint64
+	// bucket 与 types 为最近一次读取的 bucket
+	bucket  string
+	types   bool
+	started bool
+	done    bool
+}
+
+func (d *nativeDecoder) readHeader() error {
+	header := make([]byte, len(nativeMagic)+1)
+	if _, err := io.ReadFull(d.r, header); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidImportData, err)
+	}
+	if string(header[:len(nativeMagic)]) != nativeMagic {
+		return fmt.Errorf("%w: bad magic", ErrInvalidImportData)
+	}
+	if header[len(nativeMagic)] != nativeVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidImportData, header[len(nativeMagic)])
+	}
+	return nil
+}
+
+func (d *nativeDecoder) readBytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return nil, err
+	}
+	if n > nativeMaxLength {
+		return nil, fmt.Errorf("length %d out of range", n)
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(d.r, data)
+	return data, err
+}
+
+func (d *nativeDecoder) decode() (*ExportRecord, error) {
+	if d.done {
+		return nil, io.EOF
+	}
+	record, err := d.next()
+	if err != nil && err != io.EOF {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			err = errors.New("unexpected end of data")
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImportData, err)
+	}
+	return record, err
+}
+
+func (d *nativeDecoder) next() (*ExportRecord, error) {
+	for {
+		tag, err := d.r.ReadByte()
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		switch tag {
+		case nativeTagBucket:
+			types, err := d.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			name, err := d.readBytes()
+			if err != nil {
+				return nil, err
+			}
+			d.bucket, d.types, d.started = string(name), types == 1, true
+		case nativeTagEntry:
+			if !d.started {
+				return nil, errors.New("entry before bucket")
+			}
+			key, err := d.readBytes()
+			if err != nil {
+				return nil, err
+			}
+			if len(key) == 0 {
+				return nil, errors.New("the key is empty")
+			}
+			value, err := d.readBytes()
+			if err != nil {
+				return nil, err
+			}
+			d.count++
+			return &ExportRecord{Bucket: d.bucket, Key: key, Value: value, Types: d.types}, nil
+		case nativeTagEnd:
+			return nil, d.readEnd()
+		default:
+			return nil, fmt.Errorf("unknown tag %d", tag)
+		}
+	}
+}
+
+// readEnd 校验结尾的记录数与 crc，成功时返回 io.EOF
+func (d *nativeDecoder) readEnd() error {
+	count, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return err
+	}
+	// crc 本身不计入校验
+	sum := d.r.crc.Sum32()
+	expected := make([]byte, 4)
+	if _, err := io.ReadFull(d.r.r, expected); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(expected) != sum {
+		return errors.New("crc mismatch")
+	}
+	if count != d.count {
+		return fmt.Errorf("record count mismatch, expected %d but read %d", count, d.count)
+	}
+	d.done = true
+	return io.EOF
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"bytes"
+	"fastdb/common"
+	"fastdb/config"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+// collectKeyspace 以 JSON lines 导出整个数据库，用于比较两个数据库的内容
+func collectKeyspace(t *testing.T, db *DB) string {
+	var buf bytes.Buffer
+	_, err := db.Export(&buf, FormatJSONLines)
+	assert.Nil(t, err)
+	return buf.String()
+}
+
+func TestDB_ExportImport(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 2500; i++ {
+		assert.Nil(t, db.Put(common.GetTestKey(i), common.RandomValue(64)))
+	}
+	users, err := db.Bucket("users")
+	assert.Nil(t, err)
+	assert.Nil(t, users.Put([]byte{0xff, 0x00, 0x01}, []byte{0xfe, 0xfd}))
+	assert.Nil(t, users.Put([]byte("name"), []byte("base64:not really")))
+	assert.Nil(t, users.Put([]byte("empty"), nil))
+	_, err = db.HSet([]byte("hash"), []byte("field"), []byte("value"))
+	assert.Nil(t, err)
+	expected := collectKeyspace(t, db)
+
+	for _, name := range []string{"jsonl", "csv", "native"} {
+		t.Run(name, func(t *testing.T) {
+			format, err := ParseExportFormat(name)
+			assert.Nil(t, err)
+			var buf bytes.Buffer
+			n, err := db.Export(&buf, format)
+			assert.Nil(t, err)
+			// hash 包含元数据与 field 两条内部记录
+			assert.Equal(t, 2505, n)
+
+			options := config.DefaultOptions
+			options.DirPath = t.TempDir()
+			target, err := Open(options)
+			assert.Nil(t, err)
+			defer destroyDB(target)
+			n, err = target.Import(&buf, format)
+			assert.Nil(t, err)
+			assert.Equal(t, 2505, n)
+
+			assert.Equal(t, expected, collectKeyspace(t, target))
+			assert.Equal(t, []string{"users"}, target.Buckets())
+			value, err := target.HGet([]byte("hash"), []byte("field"))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("value"), value)
+		})
+	}
+
+	_, err = ParseExportFormat("xml")
+	assert.ErrorIs(t, err, ErrUnknownExportFormat)
+}
+
+// hookWriter 第一次写入时调用 hook
+type hookWriter struct {
+	bytes.Buffer
+	hook func()
+}
+
+func (w *hookWriter) Write(p []byte) (int, error) {
+	if w.hook != nil {
+		w.hook()
+		w.hook = nil
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestDB_ExportSnapshot(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(common.GetTestKey(i), common.RandomValue(100)))
+	}
+	users, err := db.Bucket("users")
+	assert.Nil(t, err)
+	assert.Nil(t, users.Put([]byte("user"), []byte("old")))
+
+	// 缓冲区写满时导出已经开始，这时的写入不应该出现在导出的数据中
+	w := &hookWriter{hook: func() {
+		assert.Nil(t, users.Put([]byte("user"), []byte("new")))
+		assert.Nil(t, users.Put([]byte("added"), []byte("new")))
+	}}
+	n, err := db.Export(w, FormatJSONLines)
+	assert.Nil(t, err)
+	assert.Equal(t, 101, n)
+	assert.Contains(t, w.String(), `"key":"user","value":"old"`)
+	assert.NotContains(t, w.String(), "added")
+}
+
+func TestDB_ImportInvalid(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+
+	var buf bytes.Buffer
+	_, err = db.Export(&buf, FormatNative)
+	assert.Nil(t, err)
+	data := buf.Bytes()
+
+	// 结尾被截断或者内容被修改
+	_, err = db.Import(bytes.NewReader(data[:len(data)-1]), FormatNative)
+	assert.ErrorIs(t, err, ErrInvalidImportData)
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)-8] ^= 0xff
+	_, err = db.Import(bytes.NewReader(corrupted), FormatNative)
+	assert.ErrorIs(t, err, ErrInvalidImportData)
+
+	_, err = db.Import(strings.NewReader("bucket,key,value\n"), FormatCSV)
+	assert.ErrorIs(t, err, ErrInvalidImportData)
+	_, err = db.Import(strings.NewReader(`{"key":""}`), FormatJSONLines)
+	assert.ErrorIs(t, err, ErrInvalidImportData)
+}
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"bytes"
+	"fastdb/config"
+	"fastdb/lib/encrypt"
+	"fastdb/wal"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func segmentPath(options config.DbOptions, id wal.SegmentID) string {
+	return wal.SegmentFileName(options.DirPath, dataFileNameSuffix, id)
+}
+
+func TestDB_SegmentHeader(t *testing.T) {
+	options := config.DefaultOptions
+	options.Compression = config.SnappyCompression
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	assert.Nil(t, db.Close())
+
+	header, err := wal.ReadSegmentHeader(segmentPath(options, 1))
+	assert.Nil(t, err)
+	assert.NotNil(t, header)
+	assert.Equal(t, wal.FormatVersion, header.Version)
+	assert.Equal(t, wal.SegmentID(1), header.SegmentId)
+	assert.Equal(t, wal.SegmentFlagCompressed, header.Flags)
+
+	// segment 文件的 id 与头部记录的不一致时拒绝打开
+	assert.Nil(t, os.Rename(segmentPath(options, 1), segmentPath(options, 7)))
+	_, err = Open(options)
+	assert.ErrorIs(t, err, wal.ErrInvalidHeader)
+	assert.Nil(t, os.Rename(segmentPath(options, 7), segmentPath(options, 1)))
+
+	db, err = Open(options)
+	assert.Nil(t, err)
+	value, err := db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+}
+
+func TestDB_LegacySegment(t *testing.T) {
+	options := config.DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}, bytes.Repeat([]byte{byte(i)}, 100)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 去掉头部得到旧版本的 segment 文件，数据只占用第一个 block，chunk 的位置不受影响
+	data, err := os.ReadFile(segmentPath(options, 1))
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(segmentPath(options, 1), data[24:], 0644))
+	header, err := wal.ReadSegmentHeader(segmentPath(options, 1))
+	assert.Nil(t, err)
+	assert.Nil(t, header)
+
+	// 旧版本的文件只读，新的数据写入带有头部的新 segment
+	db, err = Open(options)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		value, err := db.Get([]byte{byte(i)})
+		assert.Nil(t, err)
+		assert.Equal(t, bytes.Repeat([]byte{byte(i)}, 100), value)
+	}
+	assert.Nil(t, db.Put([]byte("new"), []byte("value")))
+	assert.Nil(t, db.Close())
+
+	header, err = wal.ReadSegmentHeader(segmentPath(options, 2))
+	assert.Nil(t, err)
+	assert.NotNil(t, header)
+
+	db, err = Open(options)
+	assert.Nil(t, err)
+	value, err := db.Get([]byte("new"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+}
+
+func TestDB_LegacySegmentWithKeyProvider(t *testing.T) {
+	options := config.DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assert.Nil(t, db.Put([]byte("plain"), []byte("value1")))
+	assert.Nil(t, db.Close())
+
+	data, err := os.ReadFile(segmentPath(options, 1))
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(segmentPath(options, 1), data[24:], 0644))
+
+	// 没有头部的旧版本文件没有加密，开启加密后依然按照明文读取，只有新的 segment 会被加密
+	options.KeyProvider = encrypt.NewStaticKeyProvider(bytes.Repeat([]byte{1}, 32))
+	db, err = Open(options)
+	assert.Nil(t, err)
+	value, err := db.Get([]byte("plain"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value1"), value)
+	assert.Nil(t, db.Put([]byte("secret"), []byte("value2")))
+	assert.Nil(t, db.Close())
+
+	header, err := wal.ReadSegmentHeader(segmentPath(options, 2))
+	assert.Nil(t, err)
+	assert.True(t, header.Encrypted())
+
+	db, err = Open(options)
+	assert.Nil(t, err)
+	for key, expected := range map[string]string{"plain": "value1", "secret": "value2"} {
+		value, err = db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(expected), value)
+	}
+}
+
+func TestDB_SegmentEncryptionFlag(t *testing.T) {
+	options := config.DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assert.Nil(t, db.Put([]byte("plain"), []byte("value1")))
+	assert.Nil(t, db.Close())
+
+	// 开启加密后，未加密的 segment 依然可读，新的数据写入加密的 segment
+	options.KeyProvider = encrypt.NewStaticKeyProvider(bytes.Repeat([]byte{1}, 32))
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("secret"), []byte("value2")))
+	assert.Nil(t, db.Close())
+
+	header, err := wal.ReadSegmentHeader(segmentPath(options, 2))
+	assert.Nil(t, err)
+	assert.True(t, header.Encrypted())
+
+	options.KeyProvider = nil
+	_, err = Open(options)
+	assert.ErrorIs(t, err, wal.ErrNoKeyProvider)
+
+	options.KeyProvider = encrypt.NewStaticKeyProvider(bytes.Repeat([]byte{1}, 32))
+	db, err = Open(options)
+	assert.Nil(t, err)
+	value, err := db.Get([]byte("plain"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value1"), value)
+	value, err = db.Get([]byte("secret"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value2"), value)
+}
@@ -0,0 +1,162 @@
+package core
+
+import (
+	"fastdb/common"
+	"math"
+	"strconv"
+)
+
+type FieldValue struct {
+	Field []byte
+	Value []byte
+}
+
+// HSet 设置 hash 中 field 的值，返回 field 是否为新建的
+func (db *DB) HSet(key []byte, field []byte, value []byte) (bool, error) {
+	batch, op := db.newTypesBatch(false)
+	created, err := hset(op, key, field, value)
+	return created, commitOrClose(batch, err)
+}
+
+// HSet 与 DB.HSet 相同，修改在 batch 提交时生效
+func (b *Batch) HSet(key []byte, field []byte, value []byte) (bool, error) {
+	return hset(b.types(), key, field, value)
+}
+
+func hset(op *BucketBatch, key []byte, field []byte, value []byte) (bool, error) {
+	meta, err := getTypeMeta(op, key, Hash)
+	if err != nil {
+		return false, err
+	}
+	if meta == nil {
+		meta = &typeMeta{dataType: Hash}
+	}
+
+	fieldKey := encodeMemberKey(hashFieldTag, key, field)
+	created, err := notExist(op, fieldKey)
+	if err != nil {
+		return false, err
+	}
+	if created {
+		meta.size++
+		if err = putTypeMeta(op, key, meta); err != nil {
+			return false, err
+		}
+	}
+	return created, op.Put(fieldKey, value)
+}
+
+func (db *DB) HGet(key []byte, field []byte) ([]byte, error) {
+	batch, op := db.newTypesBatch(true)
+	defer func() {
+		_ = batch.Commit()
+	}()
+
+	meta, err := getTypeMeta(op, key, Hash)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, common.NewErr(&common.KeyNotFoundErrNo, common.ErrKeyNotFound)
+	}
+	return op.Get(encodeMemberKey(hashFieldTag, key, field))
+}
+
+// HDel 删除 hash 中的 fields，返回实际删除的数量
+func (db *DB) HDel(key []byte, fields ...[]byte) (int, error) {
+	batch, op := db.newTypesBatch(false)
+	deleted, err := hdel(op, key, fields)
+	return deleted, commitOrClose(batch, err)
+}
+
+func hdel(op *BucketBatch, key []byte, fields [][]byte) (int, error) {
+	meta, err := getTypeMeta(op, key, Hash)
+	if err != nil || meta == nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, field := range fields {
+		fieldKey := encodeMemberKey(hashFieldTag, key, field)
+		absent, err := notExist(op, fieldKey)
+		if err != nil {
+			return 0, err
+		}
+		if absent {
+			continue
+		}
+		if err = op.Delete(fieldKey); err != nil {
+			return 0, err
+		}
+		deleted++
+	}
+	meta.size -= int64(deleted)
+	return deleted, putTypeMeta(op, key, meta)
+}
+
+// HGetAll 按 field 的升序返回 hash 中的所有 field 与 value
+func (db *DB) HGetAll(key []byte) ([]FieldValue, error) {
+	batch, op := db.newTypesBatch(true)
+	defer func() {
+		_ = batch.Commit()
+	}()
+
+	meta, err := getTypeMeta(op, key, Hash)
+	if err != nil || meta == nil {
+		return nil, err
+	}
+
+	prefix := encodeMemberPrefix(hashFieldTag, key)
+	result := make([]FieldValue, 0, meta.size)
+	err = db.typesBucket.scanLocked(prefix, func(k []byte, v []byte) (bool, error) {
+		field := make([]byte, len(k)-len(prefix))
+		copy(field, k[len(prefix):])
+		result = append(result, FieldValue{Field: field, Value: v})
+		return true, nil
+	})
+	return result, err
+}
+
+// HLen 返回 hash 中 field 的数量，只需要读取元数据
+func (db *DB) HLen(key []byte) (int64, error) {
+	batch, op := db.newTypesBatch(true)
+	defer func() {
+		_ = batch.Commit()
+	}()
+
+	meta, err := getTypeMeta(op, key, Hash)
+	if err != nil || meta == nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// HIncrBy 将 field 的值按十进制整数加上 delta，field 不存在时视为 0，返回新的值
+func (db *DB) HIncrBy(key []byte, field []byte, delta int64) (int64, error) {
+	batch, op := db.newTypesBatch(false)
+	value, err := hincrBy(op, key, field, delta)
+	return value, commitOrClose(batch, err)
+}
+
+func hincrBy(op *BucketBatch, key []byte, field []byte, delta int64) (int64, error) {
+	var current int64
+	if _, err := getTypeMeta(op, key, Hash); err != nil {
+		return 0, err
+	}
+	value, err := op.Get(encodeMemberKey(hashFieldTag, key, field))
+	if err != nil && !isKeyNotFound(err) {
+		return 0, err
+	}
+	if err == nil {
+		if current, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+			return 0, common.NewErr(&common.NotIntegerErrNo, common.ErrNotInteger)
+		}
+	}
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, common.NewErr(&common.NotIntegerErrNo, common.ErrNotInteger)
+	}
+
+	current += delta
+	_, err = hset(op, key, field, []byte(strconv.FormatInt(current, 10)))
+	return current, err
+}
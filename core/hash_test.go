@@ -0,0 +1,92 @@
+package core
+
+import (
+	"fastdb/common"
+	"fastdb/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDB_Hash(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("user:1")
+	created, err := db.HSet(key, []byte("name"), []byte("alice"))
+	assert.Nil(t, err)
+	assert.True(t, created)
+	created, err = db.HSet(key, []byte("name"), []byte("bob"))
+	assert.Nil(t, err)
+	assert.False(t, created)
+	_, err = db.HSet(key, []byte("age"), []byte("20"))
+	assert.Nil(t, err)
+
+	val, err := db.HGet(key, []byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bob"), val)
+	_, err = db.HGet(key, []byte("email"))
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+	_, err = db.HGet([]byte("user:2"), []byte("name"))
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+
+	length, err := db.HLen(key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), length)
+
+	// 前缀相同的 key 之间互不影响
+	_, err = db.HSet([]byte("user:10"), []byte("name"), []byte("carol"))
+	assert.Nil(t, err)
+	all, err := db.HGetAll(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []FieldValue{
+		{Field: []byte("age"), Value: []byte("20")},
+		{Field: []byte("name"), Value: []byte("bob")},
+	}, all)
+
+	deleted, err := db.HDel(key, []byte("age"), []byte("missing"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, deleted)
+	length, _ = db.HLen(key)
+	assert.Equal(t, int64(1), length)
+
+	deleted, err = db.HDel(key, []byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, deleted)
+	length, _ = db.HLen(key)
+	assert.Equal(t, int64(0), length)
+	all, err = db.HGetAll(key)
+	assert.Nil(t, err)
+	assert.Empty(t, all)
+}
+
+func TestDB_HIncrBy(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("counter")
+	val, err := db.HIncrBy(key, []byte("hits"), 5)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), val)
+	val, err = db.HIncrBy(key, []byte("hits"), -7)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-2), val)
+	length, _ := db.HLen(key)
+	assert.Equal(t, int64(1), length)
+
+	_, err = db.HSet(key, []byte("name"), []byte("abc"))
+	assert.Nil(t, err)
+	_, err = db.HIncrBy(key, []byte("name"), 1)
+	assert.ErrorIs(t, err, common.ErrNotInteger)
+
+	// 重启后元数据与 field 都能恢复
+	assert.Nil(t, db.Close())
+	db, err = Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	length, _ = db.HLen(key)
+	assert.Equal(t, int64(2), length)
+	got, err := db.HGet(key, []byte("hits"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("-2"), got)
+}
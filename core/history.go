@@ -0,0 +1,165 @@
+package core
+
+import (
+	"fastdb/common"
+	"fastdb/config"
+	"fastdb/wal"
+	"github.com/bwmarrin/snowflake"
+	"time"
+)
+
+// 版本链只保存在内存中，打开数据库时通过重放整个 WAL 重建。
+// FastDB 不会合并或清理数据文件，Repair 与 Reencrypt 重写数据文件时也会保留所有的记录，
+// 因此重启之后保留策略内的旧版本依然可读。保留策略只限制可以读到的版本，
+// 不会从磁盘上删除旧的数据；历史版本也不是审计级别的保证，
+// Repair 丢弃的 batch 与被移出数据目录的文件中的版本都无法再读取
+
+// keyVersion 版本链中的一个版本，batch id 即为版本号，
+// batch id 由 snowflake 生成，其中包含了写入的时间
+type keyVersion struct {
+	batchId  uint64
+	position *wal.ChunkPosition
+	deleted  bool
+}
+
+// KeyVersion 是 History 返回的一个历史版本
+type KeyVersion struct {
+	BatchId uint64
+	// Time 该版本被写入的时间
+	Time    time.Time
+	Value   []byte
+	Deleted bool
+}
+
+func (db *DB) historyEnabled() bool {
+	return db.options.HistoryVersions > 0 || db.options.HistoryRetention > 0
+}
+
+// batchTime 返回 batch id 中记录的写入时间
+func batchTime(batchId uint64) time.Time {
+	return time.UnixMilli(snowflake.ID(batchId).Time())
+}
+
+// addVersion 将 key 新写入的版本加入版本链，并按保留策略淘汰旧的版本，调用方需要持有写锁
+func (bk *Bucket) addVersion(key []byte, batchId uint64, position *wal.ChunkPosition, deleted bool) {
+	if bk.history == nil {
+		return
+	}
+	chain := append(bk.history[string(key)], keyVersion{batchId: batchId, position: position, deleted: deleted})
+	chain = chain[retainedFrom(chain, bk.db.options, time.Now()):]
+	if len(chain) == 1 && deleted {
+		// 只剩下删除记录时不再需要版本链
+		delete(bk.history, string(key))
+		return
+	}
+	bk.history[string(key)] = chain
+}
+
+// retainedFrom 返回版本链中需要保留的第一个版本的下标。
+// 一个版本被之后的版本覆盖的时间早于保留时长时即可淘汰，当前版本始终保留。
+// 淘汰只是从内存中的版本链移除，数据文件中的旧记录不会因此被清理
+func retainedFrom(chain []keyVersion, options config.DbOptions, now time.Time) int {
+	start := 0
+	if n := options.HistoryVersions; n > 0 && len(chain) > n {
+		start = len(chain) - n
+	}
+	if options.HistoryRetention > 0 {
+		cutoff := now.Add(-options.HistoryRetention)
+		for start < len(chain)-1 && batchTime(chain[start+1].batchId).Before(cutoff) {
+			start++
+		}
+	}
+	return start
+}
+
+// visibleVersions 返回 key 当前可读的版本，未开启历史版本时只包含当前版本
+func (bk *Bucket) visibleVersions(key []byte) ([]keyVersion, error) {
+	if bk.history != nil {
+		chain := bk.history[string(key)]
+		return chain[retainedFrom(chain, bk.db.options, time.Now()):], nil
+	}
+
+	position := bk.index.Get(key)
+	if position == nil {
+		return nil, nil
+	}
+	record, err := bk.readRecord(position)
+	if err != nil {
+		return nil, err
+	}
+	return []keyVersion{{batchId: record.BatchId, position: position}}, nil
+}
+
+// GetAt 返回 key 在 batchId 提交之后的值，即版本号小于等于 batchId 的最新版本
+func (db *DB) GetAt(key []byte, batchId uint64) ([]byte, error) {
+	return db.defaultBucket.GetAt(key, batchId)
+}
+
+// History 按版本号升序返回 key 所有保留的版本
+func (db *DB) History(key []byte) ([]KeyVersion, error) {
+	return db.defaultBucket.History(key)
+}
+
+func (bk *Bucket) GetAt(key []byte, batchId uint64) ([]byte, error) {
+	var value []byte
+	err := bk.readHistory(key, func(versions []keyVersion) error {
+		for i := len(versions) - 1; i >= 0; i-- {
+			if versions[i].batchId > batchId {
+				continue
+			}
+			if versions[i].deleted {
+				break
+			}
+			record, err := bk.readRecord(versions[i].position)
+			if err != nil {
+				return err
+			}
+			value = record.Value
+			return nil
+		}
+		return common.NewErr(&common.KeyNotFoundErrNo, common.ErrKeyNotFound)
+	})
+	return value, err
+}
+
+func (bk *Bucket) History(key []byte) ([]KeyVersion, error) {
+	var history []KeyVersion
+	err := bk.readHistory(key, func(versions []keyVersion) error {
+		history = make([]KeyVersion, 0, len(versions))
+		for _, v := range versions {
+			kv := KeyVersion{BatchId: v.batchId, Time: batchTime(v.batchId), Deleted: v.deleted}
+			if !v.deleted {
+				record, err := bk.readRecord(v.position)
+				if err != nil {
+					return err
+				}
+				kv.Value = record.Value
+			}
+			history = append(history, kv)
+		}
+		return nil
+	})
+	return history, err
+}
+
+// readHistory 在数据库读锁下读取 key 的版本
+func (bk *Bucket) readHistory(key []byte, fn func(versions []keyVersion) error) error {
+	if len(key) == 0 {
+		return common.NewErr(&common.KeyIsEmptyErrNo, common.ErrKeyIsEmpty)
+	}
+	db := bk.db
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return common.NewErr(&common.DBClosedErrNo, common.ErrDBClosed)
+	}
+	if err := bk.checkDropped(); err != nil {
+		return err
+	}
+
+	versions, err := bk.visibleVersions(key)
+	if err != nil {
+		return err
+	}
+	return fn(versions)
+}
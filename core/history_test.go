@@ -0,0 +1,128 @@
+package core
+
+import (
+	"fastdb/common"
+	"fastdb/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDB_History(t *testing.T) {
+	options := config.DefaultOptions
+	options.HistoryVersions = 3
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("doc")
+	versions := make([]uint64, 4)
+	for i := range versions {
+		assert.Nil(t, db.Put(key, []byte{byte('a' + i)}))
+		_, versions[i], err = db.GetWithVersion(key)
+		assert.Nil(t, err)
+	}
+
+	// 只保留最新的 3 个版本
+	history, err := db.History(key)
+	assert.Nil(t, err)
+	assert.Len(t, history, 3)
+	assert.Equal(t, versions[1], history[0].BatchId)
+	assert.Equal(t, []byte("b"), history[0].Value)
+	assert.Equal(t, []byte("d"), history[2].Value)
+
+	val, err := db.GetAt(key, versions[2])
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), val)
+	val, err = db.GetAt(key, versions[3]+1000)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("d"), val)
+	_, err = db.GetAt(key, versions[0])
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+
+	// 删除也是一个版本，删除之前的版本依然可读
+	assert.Nil(t, db.Delete(key))
+	_, err = db.Get(key)
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+	val, err = db.GetAt(key, versions[3])
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("d"), val)
+
+	// 重启后从数据文件中重建版本链
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	history, err = db.History(key)
+	assert.Nil(t, err)
+	assert.Len(t, history, 3)
+	assert.Equal(t, []byte("c"), history[0].Value)
+	assert.True(t, history[2].Deleted)
+	val, err = db.GetAt(key, versions[2])
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), val)
+}
+
+func TestDB_HistoryRetention(t *testing.T) {
+	options := config.DefaultOptions
+	options.HistoryRetention = 50 * time.Millisecond
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("doc")
+	assert.Nil(t, db.Put(key, []byte("old")))
+	assert.Nil(t, db.Put(key, []byte("new")))
+	history, err := db.History(key)
+	assert.Nil(t, err)
+	assert.Len(t, history, 2)
+
+	// 被覆盖超过保留时长的版本不再可读，当前版本始终保留
+	time.Sleep(100 * time.Millisecond)
+	history, err = db.History(key)
+	assert.Nil(t, err)
+	assert.Len(t, history, 1)
+	assert.Equal(t, []byte("new"), history[0].Value)
+}
+
+func TestDB_HistoryDisabled(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("doc")
+	assert.Nil(t, db.Put(key, []byte("v1")))
+	_, v1, _ := db.GetWithVersion(key)
+	assert.Nil(t, db.Put(key, []byte("v2")))
+
+	history, err := db.History(key)
+	assert.Nil(t, err)
+	assert.Len(t, history, 1)
+	assert.Equal(t, []byte("v2"), history[0].Value)
+	_, err = db.GetAt(key, v1)
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+}
+
+func TestDB_HistoryAfterRepair(t *testing.T) {
+	options := config.DefaultOptions
+	options.HistoryVersions = 2
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("doc")
+	for _, value := range []string{"a", "b", "c"} {
+		assert.Nil(t, db.Put(key, []byte(value)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 重写数据文件时保留所有的记录，重新打开后从中重建版本链
+	_, err = Repair(options)
+	assert.Nil(t, err)
+	db, err = Open(options)
+	assert.Nil(t, err)
+	history, err := db.History(key)
+	assert.Nil(t, err)
+	assert.Len(t, history, 2)
+	assert.Equal(t, []byte("b"), history[0].Value)
+	assert.Equal(t, []byte("c"), history[1].Value)
+}
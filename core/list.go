@@ -0,0 +1,225 @@
+package core
+
+import (
+	"encoding/binary"
+	"fastdb/common"
+	"fastdb/index"
+	"time"
+)
+
+// encodeSeq 将列表元素的序号编码为保持大小顺序的 8 字节，翻转符号位后负数排在正数之前
+func encodeSeq(seq int64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(seq)^(1<<63))
+	return buf
+}
+
+func encodeElementKey(key []byte, seq int64) []byte {
+	return encodeMemberKey(listElementTag, key, encodeSeq(seq))
+}
+
+// LPush 依次将 values 插入到列表头部，返回插入后列表的长度
+func (db *DB) LPush(key []byte, values ...[]byte) (int64, error) {
+	batch, op := db.newTypesBatch(false)
+	length, err := push(op, key, values, true)
+	return length, commitOrClose(batch, err)
+}
+
+// RPush 依次将 values 插入到列表尾部，返回插入后列表的长度
+func (db *DB) RPush(key []byte, values ...[]byte) (int64, error) {
+	batch, op := db.newTypesBatch(false)
+	length, err := push(op, key, values, false)
+	return length, commitOrClose(batch, err)
+}
+
+// RPush 与 DB.RPush 相同，修改在 batch 提交时生效
+func (b *Batch) RPush(key []byte, values ...[]byte) (int64, error) {
+	return push(b.types(), key, values, false)
+}
+
+func push(op *BucketBatch, key []byte, values [][]byte, left bool) (int64, error) {
+	meta, err := getTypeMeta(op, key, List)
+	if err != nil {
+		return 0, err
+	}
+	if meta == nil {
+		meta = &typeMeta{dataType: List}
+	}
+
+	for _, value := range values {
+		var seq int64
+		if left {
+			meta.head--
+			seq = meta.head
+		} else {
+			seq = meta.tail
+			meta.tail++
+		}
+		if err = op.Put(encodeElementKey(key, seq), value); err != nil {
+			return 0, err
+		}
+	}
+	meta.size = meta.tail - meta.head
+	return meta.size, putTypeMeta(op, key, meta)
+}
+
+// LPop 删除并返回列表头部的元素，列表为空时返回 ErrKeyNotFound
+func (db *DB) LPop(key []byte) ([]byte, error) {
+	batch, op := db.newTypesBatch(false)
+	value, err := pop(op, key, true)
+	return value, commitOrClose(batch, err)
+}
+
+// RPop 删除并返回列表尾部的元素，列表为空时返回 ErrKeyNotFound
+func (db *DB) RPop(key []byte) ([]byte, error) {
+	batch, op := db.newTypesBatch(false)
+	value, err := pop(op, key, false)
+	return value, commitOrClose(batch, err)
+}
+
+func pop(op *BucketBatch, key []byte, left bool) ([]byte, error) {
+	meta, err := getTypeMeta(op, key, List)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, common.NewErr(&common.KeyNotFoundErrNo, common.ErrKeyNotFound)
+	}
+
+	var seq int64
+	if left {
+		seq = meta.head
+		meta.head++
+	} else {
+		meta.tail--
+		seq = meta.tail
+	}
+	elementKey := encodeElementKey(key, seq)
+	value, err := op.Get(elementKey)
+	if err != nil {
+		return nil, err
+	}
+	if err = op.Delete(elementKey); err != nil {
+		return nil, err
+	}
+	meta.size = meta.tail - meta.head
+	return value, putTypeMeta(op, key, meta)
+}
+
+// BLPop 从第一个非空的列表头部弹出元素，所有列表都为空时阻塞，
+// 直到某个列表被 Batch.Commit 写入或者超时，timeout 为 0 时一直等待。
+// 返回弹出元素所在的 key 以及元素，超时返回 ErrKeyNotFound
+func (db *DB) BLPop(timeout time.Duration, keys ...[]byte) ([]byte, []byte, error) {
+	var popKey, value []byte
+	ok, err := db.waitFor(keys, timeout, func() (bool, error) {
+		for _, key := range keys {
+			v, err := db.LPop(key)
+			if err == nil {
+				popKey, value = key, v
+				return true, nil
+			}
+			if !isKeyNotFound(err) {
+				return false, err
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, nil, common.NewErr(&common.KeyNotFoundErrNo, common.ErrKeyNotFound)
+	}
+	return popKey, value, nil
+}
+
+// LRange 返回列表中下标在 [start, stop] 之间的元素，负数表示从末尾开始计算
+func (db *DB) LRange(key []byte, start, stop int64) ([][]byte, error) {
+	batch, op := db.newTypesBatch(true)
+	defer func() {
+		_ = batch.Commit()
+	}()
+
+	meta, err := getTypeMeta(op, key, List)
+	if err != nil || meta == nil {
+		return nil, err
+	}
+	start, stop, ok := normalizeRange(start, stop, meta.size)
+	if !ok {
+		return nil, nil
+	}
+
+	count := stop - start + 1
+	result := make([][]byte, 0, count)
+	options := index.IteratorOptions{Prefix: encodeMemberPrefix(listElementTag, key)}
+	err = db.typesBucket.iterateLocked(options, encodeElementKey(key, meta.head+start), true,
+		func(_ []byte, v []byte) (bool, error) {
+			result = append(result, v)
+			return int64(len(result)) < count, nil
+		})
+	return result, err
+}
+
+// LIndex 返回列表中下标为 idx 的元素，负数表示从末尾开始计算
+func (db *DB) LIndex(key []byte, idx int64) ([]byte, error) {
+	batch, op := db.newTypesBatch(true)
+	defer func() {
+		_ = batch.Commit()
+	}()
+
+	meta, err := getTypeMeta(op, key, List)
+	if err != nil {
+		return nil, err
+	}
+	if idx < 0 && meta != nil {
+		idx += meta.size
+	}
+	if meta == nil || idx < 0 || idx >= meta.size {
+		return nil, common.NewErr(&common.KeyNotFoundErrNo, common.ErrKeyNotFound)
+	}
+	return op.Get(encodeElementKey(key, meta.head+idx))
+}
+
+func (db *DB) LLen(key []byte) (int64, error) {
+	batch, op := db.newTypesBatch(true)
+	defer func() {
+		_ = batch.Commit()
+	}()
+
+	meta, err := getTypeMeta(op, key, List)
+	if err != nil || meta == nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// LTrim 只保留列表中下标在 [start, stop] 之间的元素，范围为空时删除整个列表
+func (db *DB) LTrim(key []byte, start, stop int64) error {
+	batch, op := db.newTypesBatch(false)
+	return commitOrClose(batch, ltrim(op, key, start, stop))
+}
+
+func ltrim(op *BucketBatch, key []byte, start, stop int64) error {
+	meta, err := getTypeMeta(op, key, List)
+	if err != nil || meta == nil {
+		return err
+	}
+
+	start, stop, ok := normalizeRange(start, stop, meta.size)
+	head, tail := meta.head+start, meta.head+stop+1
+	if !ok {
+		head, tail = meta.tail, meta.tail
+	}
+	for seq := meta.head; seq < meta.tail; seq++ {
+		if seq >= head && seq < tail {
+			continue
+		}
+		if err = op.Delete(encodeElementKey(key, seq)); err != nil {
+			return err
+		}
+	}
+
+	meta.head, meta.tail = head, tail
+	meta.size = tail - head
+	return putTypeMeta(op, key, meta)
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"fastdb/common"
+	"fastdb/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDB_List(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("jobs")
+	length, err := db.RPush(key, []byte("c"), []byte("d"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), length)
+	length, err = db.LPush(key, []byte("b"), []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), length)
+
+	all, err := db.LRange(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}, all)
+	part, err := db.LRange(key, -3, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("b")}, part)
+
+	val, err := db.LIndex(key, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("d"), val)
+	_, err = db.LIndex(key, 4)
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+
+	val, err = db.LPop(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+	val, err = db.RPop(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("d"), val)
+	length, _ = db.LLen(key)
+	assert.Equal(t, int64(2), length)
+
+	// 重启后 head 与 tail 都能恢复
+	assert.Nil(t, db.Close())
+	db, err = Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	_, err = db.RPush(key, []byte("e"), []byte("f"))
+	assert.Nil(t, err)
+	assert.Nil(t, db.LTrim(key, 1, -2))
+	all, err = db.LRange(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("c"), []byte("e")}, all)
+
+	assert.Nil(t, db.LTrim(key, 5, 10))
+	length, _ = db.LLen(key)
+	assert.Equal(t, int64(0), length)
+	_, err = db.LPop(key)
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+
+	_, err = db.HSet([]byte("user:1"), []byte("name"), []byte("alice"))
+	assert.Nil(t, err)
+	_, err = db.LPush([]byte("user:1"), []byte("a"))
+	assert.ErrorIs(t, err, common.ErrWrongType)
+}
+
+func TestDB_BLPop(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// 列表非空时立即返回
+	_, err = db.RPush([]byte("q2"), []byte("ready"))
+	assert.Nil(t, err)
+	key, val, err := db.BLPop(time.Second, []byte("q1"), []byte("q2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("q2"), key)
+	assert.Equal(t, []byte("ready"), val)
+
+	_, _, err = db.BLPop(10*time.Millisecond, []byte("q1"))
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+
+	// 阻塞的调用方被其他 goroutine 的提交唤醒
+	type result struct {
+		key, val []byte
+		err      error
+	}
+	done := make(chan result)
+	go func() {
+		key, val, err := db.BLPop(0, []byte("q1"))
+		done <- result{key, val, err}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	_, err = db.RPush([]byte("q1"), []byte("job-1"))
+	assert.Nil(t, err)
+	select {
+	case res := <-done:
+		assert.Nil(t, res.err)
+		assert.Equal(t, []byte("q1"), res.key)
+		assert.Equal(t, []byte("job-1"), res.val)
+	case <-time.After(time.Second):
+		t.Fatal("BLPop was not woken by commit")
+	}
+
+	// 数据库关闭时阻塞的调用方返回错误
+	go func() {
+		_, _, err := db.BLPop(0, []byte("q1"))
+		done <- result{err: err}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, db.Close())
+	select {
+	case res := <-done:
+		assert.ErrorIs(t, res.err, common.ErrDBClosed)
+	case <-time.After(time.Second):
+		t.Fatal("BLPop was not woken by close")
+	}
+}
@@ -2,6 +2,8 @@ package core
 
 import (
 	"encoding/binary"
+	"errors"
+	"fastdb/config"
 	"fastdb/wal"
 )
 
@@ -16,31 +18,88 @@ const (
 	LogRecordBatchFinished
 )
 
-// type batchId keySize valueSize
+// recordBucketFlag 标记记录中带有 bucket id，默认 bucket 的记录不设置该标志，与旧的格式保持兼容
+const recordBucketFlag byte = 1 << 7
+
+// recordCompressedFlag 标记记录中的 value 经过压缩，type 之后紧跟一个字节的压缩算法
+const recordCompressedFlag byte = 1 << 6
+
+// type compression bucketId batchId keySize valueSize
 //
-//	1  +  10  +   5   +   5 = 21
-const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + binary.MaxVarintLen64 + 1
+//	1  +    1     +   5   +  10  +   5   +   5 = 27
+const maxLogRecordHeaderSize = binary.MaxVarintLen32*3 + binary.MaxVarintLen64 + 2
 
 type LogRecord struct {
-	Key     []byte
-	Value   []byte
-	Type    LogRecordType
-	BatchId uint64
+	Key      []byte
+	Value    []byte
+	Type     LogRecordType
+	BatchId  uint64
+	BucketId uint32
+}
+
+// ErrInvalidLogRecord 记录的编码不完整或者长度越界，数据文件可能已经损坏
+var ErrInvalidLogRecord = errors.New("invalid log record, the data may be corrupted")
+
+// ErrValueTooLarge value 的长度超过 MaxValueSize
+var ErrValueTooLarge = errors.New("the value is larger than the max value size")
+
+// MaxValueSize value 的最大长度，写入时超过该长度的 value 被拒绝，
+// 解压后超过该长度的记录被视为损坏，避免损坏的数据导致无限制的内存分配
+const MaxValueSize = 256 * config.MB
+
+// DecodeLogRecord 解码数据文件中的一条记录，供离线检查数据文件的工具使用
+func DecodeLogRecord(buf []byte) (*LogRecord, error) {
+	return decodeLogRecord(buf)
 }
 
 // 进行解码
-func decodeLogRecord(buf []byte) *LogRecord {
-	recordType := buf[0]
+func decodeLogRecord(buf []byte) (*LogRecord, error) {
+	if len(buf) == 0 {
+		return nil, ErrInvalidLogRecord
+	}
+	flags := buf[0]
+	recordType := flags &^ (recordBucketFlag | recordCompressedFlag)
 	var index uint32 = 1
+	// compression
+	compression := config.NoCompression
+	if flags&recordCompressedFlag != 0 {
+		if len(buf) < 2 {
+			return nil, ErrInvalidLogRecord
+		}
+		compression = buf[index]
+		index++
+	}
+	// bucket id
+	var bucketId uint64
+	if flags&recordBucketFlag != 0 {
+		var n int
+		bucketId, n = binary.Uvarint(buf[index:])
+		if n <= 0 {
+			return nil, ErrInvalidLogRecord
+		}
+		index += uint32(n)
+	}
 	// batch id
 	batchId, n := binary.Uvarint(buf[index:])
+	if n <= 0 {
+		return nil, ErrInvalidLogRecord
+	}
 	index += uint32(n)
 	// key size
 	keySize, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return nil, ErrInvalidLogRecord
+	}
 	index += uint32(n)
 	// value size
 	valueSize, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return nil, ErrInvalidLogRecord
+	}
 	index += uint32(n)
+	if keySize < 0 || valueSize < 0 || int64(index)+keySize+valueSize > int64(len(buf)) {
+		return nil, ErrInvalidLogRecord
+	}
 
 	// copy key
 	key := make([]byte, keySize)
@@ -48,26 +107,50 @@ func decodeLogRecord(buf []byte) *LogRecord {
 	index += uint32(keySize)
 
 	// copy value
-	value := make([]byte, valueSize)
-	copy(value[:], buf[index:index+uint32(valueSize)])
+	var value []byte
+	if compression != config.NoCompression {
+		var err error
+		if value, err = decompressValue(buf[index:index+uint32(valueSize)], compression); err != nil {
+			return nil, err
+		}
+	} else {
+		value = make([]byte, valueSize)
+		copy(value[:], buf[index:index+uint32(valueSize)])
+	}
 
 	return &LogRecord{Key: key, Value: value,
-		BatchId: batchId, Type: recordType}
+		BatchId: batchId, Type: recordType, BucketId: uint32(bucketId)}, nil
 }
 
-func encodeLogRecord(logRecord *LogRecord) []byte {
+// encodeLogRecord 编码记录，value 不小于 minSize 时使用 compression 压缩
+func encodeLogRecord(logRecord *LogRecord, compression config.CompressionType, minSize int) []byte {
 	header := make([]byte, maxLogRecordHeaderSize)
 
 	header[0] = logRecord.Type
 	var index = 1
 
+	// compression
+	value := logRecord.Value
+	if compressed := compressValue(value, compression, minSize); compressed != nil {
+		header[0] |= recordCompressedFlag
+		header[index] = compression
+		index++
+		value = compressed
+	}
+
+	// bucket id
+	if logRecord.BucketId != defaultBucketId {
+		header[0] |= recordBucketFlag
+		index += binary.PutUvarint(header[index:], uint64(logRecord.BucketId))
+	}
+
 	// batch id
 	index += binary.PutUvarint(header[index:], logRecord.BatchId)
 	// key size
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
 	// value size
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
-	var size = index + len(logRecord.Key) + len(logRecord.Value)
+	index += binary.PutVarint(header[index:], int64(len(value)))
+	var size = index + len(logRecord.Key) + len(value)
 	encBytes := make([]byte, size)
 
 	// copy header
@@ -75,13 +158,15 @@ func encodeLogRecord(logRecord *LogRecord) []byte {
 	// copy key
 	copy(encBytes[index:], logRecord.Key)
 	// copy value
-	copy(encBytes[index+len(logRecord.Key):], logRecord.Value)
+	copy(encBytes[index+len(logRecord.Key):], value)
 
 	return encBytes
 }
 
 type IndexRecord struct {
+	bucketId   uint32
 	key        []byte
+	value      []byte
 	recordType LogRecordType
 	position   *wal.ChunkPosition
 }
@@ -0,0 +1,452 @@
+package core
+
+import (
+	"errors"
+	"fastdb/common"
+	"fastdb/config"
+	"fastdb/lib/encrypt"
+	"fastdb/wal"
+	"fmt"
+	"github.com/bwmarrin/snowflake"
+	"github.com/gofrs/flock"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// repairTempDir 修复时新的 segment 文件先写入该目录，全部写完之后再替换原来的文件
+	repairTempDir = "repair-tmp"
+	// repairBackupPrefix 原来的 segment 文件会被移动到以该前缀命名的目录中，确认数据无误后可以手动删除
+	repairBackupPrefix = "repair-backup-"
+	// reencryptBackupPrefix 重新加密时原来的文件暂时移动到的目录，替换完成后被删除
+	reencryptBackupPrefix = "reencrypt-backup-"
+)
+
+// LostKey 被丢弃的 batch 中写入的一个 key
+type LostKey struct {
+	BucketId uint32
+	Key      []byte
+	Deleted  bool
+}
+
+// LostBatch 没有结束标记的 batch，其中的写入在修复后全部丢失
+type LostBatch struct {
+	BatchId uint64
+	Keys    []LostKey
+}
+
+type RepairReport struct {
+	Segments int
+	// Records 保留下来的记录数量，不包括结束标记
+	Records int
+	// Batches 保留下来的 batch 数量
+	Batches int
+	// Skipped 因为损坏而被跳过的区间，其中的记录无法解码，也就无法知道属于哪些 key
+	Skipped     []CorruptRange
+	LostBatches []LostBatch
+	// BackupDir 原来的 segment 文件被移动到的目录
+	BackupDir string
+}
+
+// Repair 从损坏的数据文件中重建一个可以正常打开的数据库。
+// 读取所有 segment 时跳过损坏的 chunk 与 block，只保留结束标记仍然存在的 batch，
+// 将它们写入一组新的 segment 文件替换原来的文件，原来的文件会被移动到 BackupDir 中。
+// 修复期间会持有数据目录的 FLOCK，数据库不能处于打开状态，替换文件时崩溃的话下次 Open 或 Repair 会继续完成替换。
+// 结束标记存在的 batch 中如果有记录位于损坏的区间，该 batch 只能被部分恢复
+func Repair(options config.DbOptions) (*RepairReport, error) {
+	report := &RepairReport{}
+	backupDir, err := rewriteDataFiles(options, repairBackupPrefix, func(src, dst *wal.WAL) error {
+		report.Segments = len(src.SegmentSizes())
+		finished, err := collectFinishedBatches(src, report)
+		if err != nil {
+			return err
+		}
+		return scanForRepair(src, nil, func(data []byte, record *LogRecord) error {
+			if record.Type == LogRecordBatchFinished {
+				batchId, _ := snowflake.ParseBytes(record.Key)
+				if !finished[uint64(batchId)] {
+					return nil
+				}
+				report.Batches++
+			} else if !finished[record.BatchId] {
+				return nil
+			} else {
+				report.Records++
+			}
+			_, err := dst.Write(data)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.BackupDir = backupDir
+	return report, nil
+}
+
+// Reencrypt 使用 KeyProvider 中的第一个密钥重新加密所有的数据文件，用于轮换密钥:
+// 将新密钥放在 KeyProvider 的最前面并保留旧密钥，执行 Reencrypt 之后旧密钥就可以被移除。
+// 与 Repair 一样需要在数据库关闭时执行，替换成功后使用旧密钥加密的文件会被删除。
+// 数据文件损坏时返回错误，不会丢弃任何记录，需要先执行 Repair
+func Reencrypt(options config.DbOptions) error {
+	if options.KeyProvider == nil {
+		return common.NewErr(&common.InnerErrNo, encrypt.ErrNoKey)
+	}
+	backupDir, err := rewriteDataFiles(options, reencryptBackupPrefix, func(src, dst *wal.WAL) error {
+		reader := src.NewUncachedReader()
+		for {
+			data, _, err := reader.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if _, err := dst.Write(data); err != nil {
+				return err
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(backupDir)
+}
+
+// rewriteDataFiles 持有数据目录的 FLOCK，通过 fn 将原来的记录写入 tempDir 中一组新的 segment 文件，
+// 新的文件使用 options 中的 KeyProvider 加密，写完之后替换原来的文件，返回原来的文件被移动到的目录
+func rewriteDataFiles(options config.DbOptions, backupPrefix string, fn func(src, dst *wal.WAL) error) (string, error) {
+	if err := checkOptions(options); err != nil {
+		return "", common.NewErr(&common.InnerErrNo, err)
+	}
+	fileLock := flock.New(filepath.Join(options.DirPath, fileLockName))
+	hold, err := fileLock.TryLock()
+	if err != nil {
+		return "", common.NewErr(&common.InnerErrNo, err)
+	}
+	if !hold {
+		return "", common.NewErr(&common.DatabaseIsUsingErrNo, common.ErrDatabaseIsUsing)
+	}
+	defer func() {
+		_ = fileLock.Unlock()
+	}()
+	if err := recoverDataFiles(options.DirPath); err != nil {
+		return "", err
+	}
+
+	src, err := wal.Open(wal.Options{
+		DirPath:        options.DirPath,
+		SegmentSize:    options.SegmentSize,
+		SegmentFileExt: dataFileNameSuffix,
+		ReadOnly:       true,
+		KeyProvider:    options.KeyProvider,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	tempDir := filepath.Join(options.DirPath, repairTempDir)
+	if err := os.RemoveAll(tempDir); err != nil {
+		return "", err
+	}
+	tempOptions := options
+	tempOptions.DirPath = tempDir
+	dst, err := openDataFiles(tempOptions)
+	if err != nil {
+		return "", err
+	}
+	err = fn(src, dst)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.RemoveAll(tempDir)
+		return "", err
+	}
+
+	// 磁盘索引中的位置指向原来的文件，下次打开时根据新的文件重建
+	if err := os.Remove(filepath.Join(options.DirPath, diskIndexFileName)); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	backupDir := filepath.Join(options.DirPath, fmt.Sprintf("%s%d", backupPrefix, time.Now().UnixNano()))
+	if err := replaceDataFiles(options.DirPath, tempDir, backupDir); err != nil {
+		return "", err
+	}
+	return backupDir, nil
+}
+
+// collectFinishedBatches 第一次扫描，找出结束标记存在的 batch，并记录丢失的 batch 与损坏的区间
+func collectFinishedBatches(src *wal.WAL, report *RepairReport) (map[uint64]bool, error) {
+	finished := make(map[uint64]bool)
+	pending := make(map[uint64][]LostKey)
+	err := scanForRepair(src, report, func(_ []byte, record *LogRecord) error {
+		if record.Type == LogRecordBatchFinished {
+			batchId, err := snowflake.ParseBytes(record.Key)
+			if err != nil {
+				return nil
+			}
+			finished[uint64(batchId)] = true
+			delete(pending, uint64(batchId))
+			return nil
+		}
+		pending[record.BatchId] = append(pending[record.BatchId], LostKey{
+			BucketId: record.BucketId,
+			Key:      record.Key,
+			Deleted:  record.Type == LogRecordDeleted,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for batchId, keys := range pending {
+		report.LostBatches = append(report.LostBatches, LostBatch{BatchId: batchId, Keys: keys})
+	}
+	sort.Slice(report.LostBatches, func(i, j int) bool {
+		return report.LostBatches[i].BatchId < report.LostBatches[j].BatchId
+	})
+	return finished, nil
+}
+
+// scanForRepair 按顺序读取所有可以读取的记录。crc 校验失败的 chunk 头部完好时只跳过该 chunk，
+// 否则从下一个 block 继续，并丢弃以 Middle 或 Last chunk 开始的残缺记录，report 不为 nil 时记录被跳过的区间
+func scanForRepair(src *wal.WAL, report *RepairReport, fn func(data []byte, record *LogRecord) error) error {
+	sizes := src.SegmentSizes()
+	// skipping 正在跳过的区间，读取到下一条完整的记录或者 segment 结束时确定区间的大小
+	var skipping *CorruptRange
+	finishSkipping := func(end int64) {
+		if skipping != nil && report != nil {
+			skipping.Size = end - skipping.Offset
+			report.Skipped = append(report.Skipped, *skipping)
+		}
+		skipping = nil
+	}
+	markSkipped := func(position *wal.ChunkPosition, err error) {
+		if skipping != nil && skipping.SegmentId != position.SegmentId {
+			finishSkipping(sizes[skipping.SegmentId])
+		}
+		if skipping == nil {
+			skipping = &CorruptRange{SegmentId: position.SegmentId, Offset: position.FileOffset(), Error: err.Error()}
+		}
+	}
+
+	reader := src.NewUncachedReader()
+	for {
+		data, position, chunks, err := reader.NextChunks()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if position == nil || errors.Is(err, wal.ErrClosed) {
+				return err
+			}
+			markSkipped(position, err)
+			if n := len(chunks); n > 0 && !chunks[n-1].Valid {
+				reader.SkipChunk(chunks[n-1])
+			} else {
+				reader.SkipBlock()
+			}
+			continue
+		}
+		if skipping != nil && skipping.SegmentId != position.SegmentId {
+			finishSkipping(sizes[skipping.SegmentId])
+		}
+		// 跳过一个 block 之后读到的是前一条记录的剩余部分
+		if first := chunks[0].Type; first == wal.ChunkTypeMiddle || first == wal.ChunkTypeLast {
+			markSkipped(position, errors.New("truncated record"))
+			continue
+		}
+
+		record, err := decodeLogRecord(data)
+		if err != nil {
+			markSkipped(position, err)
+			continue
+		}
+		finishSkipping(position.FileOffset())
+		if err := fn(data, record); err != nil {
+			return err
+		}
+	}
+	if skipping != nil {
+		finishSkipping(sizes[skipping.SegmentId])
+	}
+	return nil
+}
+
+// replaceDataFiles 将 dirPath 中原来的 segment 文件移动到 backupDir，再将 tempDir 中新的文件移动到 dirPath。
+// 替换期间 dirPath 中保存着记录了当前阶段的 SWAP 文件，进程在替换过程中崩溃时，
+// 下次 Open 或 Repair 根据它回滚或者完成这次替换，见 recoverDataFiles。
+// 替换出错时会尝试回滚，回滚成功后 dirPath 中依然是原来的文件
+func replaceDataFiles(dirPath, tempDir, backupDir string) error {
+	if err := os.MkdirAll(backupDir, os.ModePerm); err != nil {
+		return err
+	}
+	marker := &swapMarker{tempDir: filepath.Base(tempDir), backupDir: filepath.Base(backupDir)}
+	if err := marker.save(dirPath, swapPhaseBackup); err != nil {
+		return err
+	}
+	if err := moveDataFiles(dirPath, backupDir); err != nil {
+		return rollbackOnError(err, marker.rollback(dirPath))
+	}
+	if err := marker.save(dirPath, swapPhaseInstall); err != nil {
+		return rollbackOnError(err, marker.rollback(dirPath))
+	}
+	if err := moveDataFiles(tempDir, dirPath); err != nil {
+		// 已经移入的新文件先移回 tempDir，之后与第一阶段一样回滚
+		rollbackErr := moveDataFiles(dirPath, tempDir)
+		if rollbackErr == nil {
+			rollbackErr = marker.save(dirPath, swapPhaseBackup)
+		}
+		if rollbackErr == nil {
+			rollbackErr = marker.rollback(dirPath)
+		}
+		return rollbackOnError(err, rollbackErr)
+	}
+	return marker.finish(dirPath)
+}
+
+func rollbackOnError(err, rollbackErr error) error {
+	if rollbackErr != nil {
+		return fmt.Errorf("%v, rollback failed: %v", err, rollbackErr)
+	}
+	return err
+}
+
+func moveDataFiles(from, to string) error {
+	entries, err := os.ReadDir(from)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), dataFileNameSuffix) {
+			continue
+		}
+		if err := os.Rename(filepath.Join(from, entry.Name()), filepath.Join(to, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return syncDir(to)
+}
+
+// recoverDataFiles 检查上一次替换数据文件是否被中断，需要在持有 FLOCK 之后、打开数据文件之前调用。
+// 原来的文件还没有全部移出时回滚，否则将 tempDir 中剩余的新文件移入，完成这次替换。
+// 磁盘索引可能对应替换前或者替换后的文件，直接删除，打开时会根据数据文件重建
+func recoverDataFiles(dirPath string) error {
+	marker, phase, err := loadSwapMarker(dirPath)
+	if err != nil || marker == nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dirPath, diskIndexFileName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if phase == swapPhaseBackup {
+		return marker.rollback(dirPath)
+	}
+	if err := moveDataFiles(filepath.Join(dirPath, marker.tempDir), dirPath); err != nil {
+		return err
+	}
+	return marker.finish(dirPath)
+}
+
+const (
+	// swapMarkerName 替换数据文件期间存在的标记文件
+	swapMarkerName = "SWAP"
+	// swapPhaseBackup 原来的文件正在被移动到 backupDir，新的文件全部在 tempDir 中
+	swapPhaseBackup = "backup"
+	// swapPhaseInstall 原来的文件全部在 backupDir 中，新的文件正在被移动到数据目录
+	swapPhaseInstall = "install"
+)
+
+// swapMarker 记录替换数据文件时使用的两个目录，均为数据目录下的目录名
+type swapMarker struct {
+	tempDir   string
+	backupDir string
+}
+
+// save 原子地写入标记文件: phase tempDir backupDir，每个字段一行
+func (m *swapMarker) save(dirPath, phase string) error {
+	path := filepath.Join(dirPath, swapMarkerName)
+	content := strings.Join([]string{phase, m.tempDir, m.backupDir}, "\n") + "\n"
+	fd, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = fd.WriteString(content); err == nil {
+		err = fd.Sync()
+	}
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	return syncDir(dirPath)
+}
+
+// rollback 将 backupDir 中原来的文件移回数据目录，删除 backupDir、tempDir 以及标记文件
+func (m *swapMarker) rollback(dirPath string) error {
+	backupDir := filepath.Join(dirPath, m.backupDir)
+	if err := moveDataFiles(backupDir, dirPath); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(backupDir); err != nil {
+		return err
+	}
+	return m.finish(dirPath)
+}
+
+// finish 删除 tempDir 以及标记文件，替换结束
+func (m *swapMarker) finish(dirPath string) error {
+	if err := os.RemoveAll(filepath.Join(dirPath, m.tempDir)); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dirPath, swapMarkerName)); err != nil {
+		return err
+	}
+	return syncDir(dirPath)
+}
+
+// loadSwapMarker 读取标记文件，不存在时返回 nil
+func loadSwapMarker(dirPath string) (*swapMarker, string, error) {
+	content, err := os.ReadFile(filepath.Join(dirPath, swapMarkerName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+	fields := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(fields) != 3 || (fields[0] != swapPhaseBackup && fields[0] != swapPhaseInstall) ||
+		!isLocalDirName(fields[1]) || !isLocalDirName(fields[2]) {
+		return nil, "", fmt.Errorf("invalid swap marker %s", filepath.Join(dirPath, swapMarkerName))
+	}
+	return &swapMarker{tempDir: fields[1], backupDir: fields[2]}, fields[0], nil
+}
+
+func isLocalDirName(name string) bool {
+	return name != "" && name != "." && name != ".." && filepath.Base(name) == name
+}
+
+func syncDir(dirPath string) error {
+	fd, err := os.Open(dirPath)
+	if err != nil {
+		return err
+	}
+	err = fd.Sync()
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
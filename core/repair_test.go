@@ -0,0 +1,142 @@
+package core
+
+import (
+	"bytes"
+	"fastdb/common"
+	"fastdb/config"
+	"fastdb/wal"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepair(t *testing.T) {
+	options := config.DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(common.GetTestKey(i), common.RandomValue(128)))
+	}
+	// 只写入记录而没有结束标记，模拟写入 batch 时进程崩溃
+	for _, key := range []string{"lost-1", "lost-2"} {
+		_, err = db.dataFiles.Write(encodeLogRecord(&LogRecord{Key: []byte(key), Value: []byte("v"), BatchId: 42},
+			config.NoCompression, 0))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.Close())
+
+	path := wal.SegmentFileName(options.DirPath, dataFileNameSuffix, 1)
+	fd, err := os.OpenFile(path, os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = fd.WriteAt([]byte{0xff, 0xff}, 3*32*config.KB+100)
+	assert.Nil(t, err)
+	// 覆盖了 chunk 头部的损坏需要跳过剩余的 block
+	_, err = fd.WriteAt(bytes.Repeat([]byte{0xff}, 400), 5*32*config.KB+100)
+	assert.Nil(t, err)
+	assert.Nil(t, fd.Close())
+
+	// 数据文件损坏之后无法打开
+	_, err = Open(options)
+	assert.NotNil(t, err)
+
+	report, err := Repair(options)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Segments)
+	assert.Len(t, report.Skipped, 2)
+	assert.Equal(t, wal.SegmentID(1), report.Skipped[0].SegmentId)
+	// 损坏的 chunk 头部完好，只会跳过这一个 chunk
+	assert.Less(t, report.Skipped[0].Size, int64(1*config.KB))
+	// 下一个 block 开头可能是跨 block 记录的剩余部分，也会被跳过
+	assert.Less(t, report.Skipped[1].Offset+report.Skipped[1].Size, int64(6*32*config.KB+config.KB))
+	assert.Equal(t, []LostBatch{{BatchId: 42, Keys: []LostKey{{Key: []byte("lost-1")}, {Key: []byte("lost-2")}}}},
+		report.LostBatches)
+	assert.Greater(t, report.Records, 800)
+	assert.LessOrEqual(t, report.Records, report.Batches)
+	_, err = os.Stat(wal.SegmentFileName(report.BackupDir, dataFileNameSuffix, 1))
+	assert.Nil(t, err)
+
+	db, err = Open(options)
+	assert.Nil(t, err)
+	found := 0
+	for i := 0; i < 1000; i++ {
+		if _, err := db.Get(common.GetTestKey(i)); err == nil {
+			found++
+		}
+	}
+	assert.Equal(t, report.Records, found)
+	_, err = db.Get([]byte("lost-1"))
+	assert.Equal(t, common.KeyNotFoundErrNo.Code, common.ExtractErrCode(err))
+
+	verify, err := db.Verify()
+	assert.Nil(t, err)
+	assert.True(t, verify.OK())
+}
+
+func TestRepair_DatabaseInUse(t *testing.T) {
+	options := config.DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	_, err = Repair(options)
+	assert.Equal(t, common.DatabaseIsUsingErrNo.Code, common.ExtractErrCode(err))
+}
+
+func TestRecoverDataFiles(t *testing.T) {
+	options := config.DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assert.Nil(t, db.Put([]byte("key"), []byte("old")))
+	assert.Nil(t, db.Close())
+
+	other := options
+	other.DirPath = options.DirPath + "-other"
+	otherDB, err := Open(other)
+	assert.Nil(t, err)
+	defer destroyDB(otherDB)
+	assert.Nil(t, otherDB.Put([]byte("key"), []byte("new")))
+	assert.Nil(t, otherDB.Close())
+
+	tempDir := filepath.Join(options.DirPath, repairTempDir)
+	backupDir := filepath.Join(options.DirPath, repairBackupPrefix+"1")
+	marker := &swapMarker{tempDir: repairTempDir, backupDir: repairBackupPrefix + "1"}
+	prepare := func() {
+		assert.Nil(t, os.MkdirAll(tempDir, os.ModePerm))
+		assert.Nil(t, os.MkdirAll(backupDir, os.ModePerm))
+		data, err := os.ReadFile(wal.SegmentFileName(other.DirPath, dataFileNameSuffix, 1))
+		assert.Nil(t, err)
+		assert.Nil(t, os.WriteFile(wal.SegmentFileName(tempDir, dataFileNameSuffix, 1), data, 0644))
+	}
+	get := func() string {
+		db, err := Open(options)
+		assert.Nil(t, err)
+		defer db.Close()
+		value, err := db.Get([]byte("key"))
+		assert.Nil(t, err)
+		return string(value)
+	}
+
+	// 原来的文件已经移出一部分时崩溃，回滚到原来的文件
+	prepare()
+	assert.Nil(t, marker.save(options.DirPath, swapPhaseBackup))
+	assert.Nil(t, moveDataFiles(options.DirPath, backupDir))
+	assert.Equal(t, "old", get())
+	for _, dir := range []string{tempDir, backupDir, filepath.Join(options.DirPath, swapMarkerName)} {
+		_, err = os.Stat(dir)
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	// 原来的文件已经全部移出时崩溃，完成替换
+	prepare()
+	assert.Nil(t, moveDataFiles(options.DirPath, backupDir))
+	assert.Nil(t, marker.save(options.DirPath, swapPhaseInstall))
+	assert.Equal(t, "new", get())
+	_, err = os.Stat(tempDir)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(wal.SegmentFileName(backupDir, dataFileNameSuffix, 1))
+	assert.Nil(t, err)
+}
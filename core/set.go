@@ -0,0 +1,305 @@
+package core
+
+import (
+	"bytes"
+	"fastdb/common"
+	"fastdb/index"
+)
+
+// SAdd 将 members 加入集合，返回新加入的数量
+func (db *DB) SAdd(key []byte, members ...[]byte) (int, error) {
+	batch, op := db.newTypesBatch(false)
+	added, err := sadd(op, key, members)
+	return added, commitOrClose(batch, err)
+}
+
+// SAdd 与 DB.SAdd 相同，修改在 batch 提交时生效
+func (b *Batch) SAdd(key []byte, members ...[]byte) (int, error) {
+	return sadd(b.types(), key, members)
+}
+
+func sadd(op *BucketBatch, key []byte, members [][]byte) (int, error) {
+	meta, err := getTypeMeta(op, key, Set)
+	if err != nil {
+		return 0, err
+	}
+	if meta == nil {
+		meta = &typeMeta{dataType: Set}
+	}
+
+	added := 0
+	for _, member := range members {
+		memberKey := encodeMemberKey(setMemberTag, key, member)
+		absent, err := notExist(op, memberKey)
+		if err != nil {
+			return 0, err
+		}
+		if !absent {
+			continue
+		}
+		if err = op.Put(memberKey, nil); err != nil {
+			return 0, err
+		}
+		added++
+	}
+	meta.size += int64(added)
+	return added, putTypeMeta(op, key, meta)
+}
+
+// SRem 从集合中删除 members，返回实际删除的数量
+func (db *DB) SRem(key []byte, members ...[]byte) (int, error) {
+	batch, op := db.newTypesBatch(false)
+	removed, err := srem(op, key, members)
+	return removed, commitOrClose(batch, err)
+}
+
+func srem(op *BucketBatch, key []byte, members [][]byte) (int, error) {
+	meta, err := getTypeMeta(op, key, Set)
+	if err != nil || meta == nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, member := range members {
+		memberKey := encodeMemberKey(setMemberTag, key, member)
+		absent, err := notExist(op, memberKey)
+		if err != nil {
+			return 0, err
+		}
+		if absent {
+			continue
+		}
+		if err = op.Delete(memberKey); err != nil {
+			return 0, err
+		}
+		removed++
+	}
+	meta.size -= int64(removed)
+	return removed, putTypeMeta(op, key, meta)
+}
+
+func (db *DB) SIsMember(key []byte, member []byte) (bool, error) {
+	batch, op := db.newTypesBatch(true)
+	defer func() {
+		_ = batch.Commit()
+	}()
+
+	meta, err := getTypeMeta(op, key, Set)
+	if err != nil || meta == nil {
+		return false, err
+	}
+	absent, err := notExist(op, encodeMemberKey(setMemberTag, key, member))
+	return !absent, err
+}
+
+// SCard 返回集合中元素的数量，只需要读取元数据
+func (db *DB) SCard(key []byte) (int64, error) {
+	batch, op := db.newTypesBatch(true)
+	defer func() {
+		_ = batch.Commit()
+	}()
+
+	meta, err := getTypeMeta(op, key, Set)
+	if err != nil || meta == nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// 集合运算的各集合通过前缀迭代器按序归并，不会把输入的集合整体加载到内存。
+// SMembers、SInter、SUnion、SDiff 返回完整的结果，内存占用与结果的大小成正比；
+// 结果可能很大时使用对应的 Scan 版本，每个元素产生后立即交给 handleFn，只占用常数的内存。
+// Scan 版本在遍历期间持有数据库的读锁，handleFn 中不能再对数据库进行写操作，返回 false 时停止遍历
+
+// SMembers 按升序返回集合中的所有元素
+func (db *DB) SMembers(key []byte) ([][]byte, error) {
+	return collectMembers(func(handleFn func(member []byte) (bool, error)) error {
+		return db.SMembersScan(key, handleFn)
+	})
+}
+
+func (db *DB) SMembersScan(key []byte, handleFn func(member []byte) (bool, error)) error {
+	return db.setAlgebra(key, nil, handleFn, func(iters []*memberIterator, emit func([]byte) bool) {
+		for ; iters[0].valid(); iters[0].next() {
+			if !emit(iters[0].member()) {
+				return
+			}
+		}
+	})
+}
+
+// SInter 按升序返回所有集合的交集
+func (db *DB) SInter(key []byte, others ...[]byte) ([][]byte, error) {
+	return collectMembers(func(handleFn func(member []byte) (bool, error)) error {
+		return db.SInterScan(key, others, handleFn)
+	})
+}
+
+func (db *DB) SInterScan(key []byte, others [][]byte, handleFn func(member []byte) (bool, error)) error {
+	return db.setAlgebra(key, others, handleFn, func(iters []*memberIterator, emit func([]byte) bool) {
+		for {
+			// 所有迭代器都跳到当前最大的 member，全部相等时即为交集中的元素
+			var max []byte
+			for _, iter := range iters {
+				if !iter.valid() {
+					return
+				}
+				if member := iter.member(); max == nil || bytes.Compare(member, max) > 0 {
+					max = member
+				}
+			}
+			matched := true
+			for _, iter := range iters {
+				if !bytes.Equal(iter.member(), max) {
+					iter.seek(max)
+					matched = false
+				}
+			}
+			if matched {
+				if !emit(max) {
+					return
+				}
+				for _, iter := range iters {
+					iter.next()
+				}
+			}
+		}
+	})
+}
+
+// SUnion 按升序返回所有集合的并集
+func (db *DB) SUnion(key []byte, others ...[]byte) ([][]byte, error) {
+	return collectMembers(func(handleFn func(member []byte) (bool, error)) error {
+		return db.SUnionScan(key, others, handleFn)
+	})
+}
+
+func (db *DB) SUnionScan(key []byte, others [][]byte, handleFn func(member []byte) (bool, error)) error {
+	return db.setAlgebra(key, others, handleFn, func(iters []*memberIterator, emit func([]byte) bool) {
+		for {
+			var min []byte
+			for _, iter := range iters {
+				if !iter.valid() {
+					continue
+				}
+				if member := iter.member(); min == nil || bytes.Compare(member, min) < 0 {
+					min = member
+				}
+			}
+			if min == nil || !emit(min) {
+				return
+			}
+			for _, iter := range iters {
+				if iter.valid() && bytes.Equal(iter.member(), min) {
+					iter.next()
+				}
+			}
+		}
+	})
+}
+
+// SDiff 按升序返回在第一个集合中但不在其他集合中的元素
+func (db *DB) SDiff(key []byte, others ...[]byte) ([][]byte, error) {
+	return collectMembers(func(handleFn func(member []byte) (bool, error)) error {
+		return db.SDiffScan(key, others, handleFn)
+	})
+}
+
+func (db *DB) SDiffScan(key []byte, others [][]byte, handleFn func(member []byte) (bool, error)) error {
+	return db.setAlgebra(key, others, handleFn, func(iters []*memberIterator, emit func([]byte) bool) {
+		for ; iters[0].valid(); iters[0].next() {
+			member := iters[0].member()
+			found := false
+			for _, iter := range iters[1:] {
+				iter.seek(member)
+				if iter.valid() && bytes.Equal(iter.member(), member) {
+					found = true
+					break
+				}
+			}
+			if !found && !emit(member) {
+				return
+			}
+		}
+	})
+}
+
+// setAlgebra 在同一个只读 batch 中为每个集合创建前缀迭代器，再由 mergeFn 归并，
+// 归并出的元素依次交给 handleFn，emit 返回 false 时 mergeFn 需要停止归并
+func (db *DB) setAlgebra(key []byte, others [][]byte, handleFn func(member []byte) (bool, error),
+	mergeFn func(iters []*memberIterator, emit func([]byte) bool)) error {
+	batch, op := db.newTypesBatch(true)
+	defer func() {
+		_ = batch.Commit()
+	}()
+
+	keys := append([][]byte{key}, others...)
+	iters := make([]*memberIterator, len(keys))
+	for i, k := range keys {
+		// 不存在的集合视为空集
+		if _, err := getTypeMeta(op, k, Set); err != nil {
+			return err
+		}
+		iters[i] = db.newMemberIterator(k)
+		defer iters[i].close()
+	}
+
+	var err error
+	mergeFn(iters, func(member []byte) bool {
+		var ok bool
+		ok, err = handleFn(member)
+		return ok && err == nil
+	})
+	return err
+}
+
+// collectMembers 通过 scan 收集所有的元素
+func collectMembers(scan func(handleFn func(member []byte) (bool, error)) error) ([][]byte, error) {
+	var result [][]byte
+	err := scan(func(member []byte) (bool, error) {
+		result = append(result, member)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// memberIterator 按升序遍历一个集合中的 member
+type memberIterator struct {
+	iter   index.Iterator
+	prefix []byte
+}
+
+func (db *DB) newMemberIterator(key []byte) *memberIterator {
+	prefix := encodeMemberPrefix(setMemberTag, key)
+	return &memberIterator{
+		iter:   db.typesBucket.index.Iterator(index.IteratorOptions{Prefix: prefix}),
+		prefix: prefix,
+	}
+}
+
+func (mi *memberIterator) valid() bool {
+	return mi.iter.Valid()
+}
+
+func (mi *memberIterator) member() []byte {
+	key := mi.iter.Key()
+	member := make([]byte, len(key)-len(mi.prefix))
+	copy(member, key[len(mi.prefix):])
+	return member
+}
+
+func (mi *memberIterator) next() {
+	mi.iter.Next()
+}
+
+// seek 跳到第一个大于等于 member 的位置
+func (mi *memberIterator) seek(member []byte) {
+	mi.iter.Seek(common.Concat(mi.prefix, member))
+}
+
+func (mi *memberIterator) close() {
+	mi.iter.Close()
+}
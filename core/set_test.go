@@ -0,0 +1,153 @@
+package core
+
+import (
+	"errors"
+	"fastdb/common"
+	"fastdb/config"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func members(values ...string) [][]byte {
+	result := make([][]byte, len(values))
+	for i, v := range values {
+		result[i] = []byte(v)
+	}
+	return result
+}
+
+func TestDB_Set(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("tags")
+	added, err := db.SAdd(key, members("go", "db", "go", "kv")...)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, added)
+	added, err = db.SAdd(key, members("db", "raft")...)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, added)
+
+	card, err := db.SCard(key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), card)
+	ok, err := db.SIsMember(key, []byte("kv"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = db.SIsMember([]byte("missing"), []byte("kv"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	removed, err := db.SRem(key, members("kv", "missing")...)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, removed)
+
+	assert.Nil(t, db.Close())
+	db, err = Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	all, err := db.SMembers(key)
+	assert.Nil(t, err)
+	assert.Equal(t, members("db", "go", "raft"), all)
+	card, _ = db.SCard(key)
+	assert.Equal(t, int64(3), card)
+
+	_, err = db.HSet([]byte("user:1"), []byte("name"), []byte("alice"))
+	assert.Nil(t, err)
+	_, err = db.SAdd([]byte("user:1"), []byte("a"))
+	assert.ErrorIs(t, err, common.ErrWrongType)
+	_, err = db.SInter(key, []byte("user:1"))
+	assert.ErrorIs(t, err, common.ErrWrongType)
+}
+
+func TestDB_SetAlgebra(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	_, err = db.SAdd([]byte("a"), members("1", "2", "3", "5", "8")...)
+	assert.Nil(t, err)
+	_, err = db.SAdd([]byte("b"), members("2", "3", "4", "8", "9")...)
+	assert.Nil(t, err)
+	_, err = db.SAdd([]byte("c"), members("0", "3", "8")...)
+	assert.Nil(t, err)
+	// 前缀相同的 key 不会混入结果
+	_, err = db.SAdd([]byte("ab"), members("1", "2", "3", "4")...)
+	assert.Nil(t, err)
+
+	inter, err := db.SInter([]byte("a"), []byte("b"), []byte("c"))
+	assert.Nil(t, err)
+	assert.Equal(t, members("3", "8"), inter)
+	inter, err = db.SInter([]byte("a"), []byte("missing"))
+	assert.Nil(t, err)
+	assert.Empty(t, inter)
+
+	union, err := db.SUnion([]byte("a"), []byte("b"), []byte("missing"))
+	assert.Nil(t, err)
+	assert.Equal(t, members("1", "2", "3", "4", "5", "8", "9"), union)
+
+	diff, err := db.SDiff([]byte("a"), []byte("b"), []byte("c"))
+	assert.Nil(t, err)
+	assert.Equal(t, members("1", "5"), diff)
+	diff, err = db.SDiff([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, members("1", "2", "3", "5", "8"), diff)
+}
+
+func TestDB_SetAlgebraScan(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	var a, b [][]byte
+	for i := 0; i < 1000; i++ {
+		member := []byte(fmt.Sprintf("%04d", i))
+		if i%2 == 0 {
+			a = append(a, member)
+		}
+		if i%3 == 0 {
+			b = append(b, member)
+		}
+	}
+	_, err = db.SAdd([]byte("a"), a...)
+	assert.Nil(t, err)
+	_, err = db.SAdd([]byte("b"), b...)
+	assert.Nil(t, err)
+
+	// 元素在归并时逐个交给 handleFn，返回 false 后不再继续
+	collect := func(scan func(handleFn func(member []byte) (bool, error)) error, limit int) [][]byte {
+		var result [][]byte
+		assert.Nil(t, scan(func(member []byte) (bool, error) {
+			result = append(result, member)
+			return len(result) < limit, nil
+		}))
+		return result
+	}
+	inter := collect(func(handleFn func(member []byte) (bool, error)) error {
+		return db.SInterScan([]byte("a"), [][]byte{[]byte("b")}, handleFn)
+	}, 3)
+	assert.Equal(t, members("0000", "0006", "0012"), inter)
+	union := collect(func(handleFn func(member []byte) (bool, error)) error {
+		return db.SUnionScan([]byte("a"), [][]byte{[]byte("b")}, handleFn)
+	}, 4)
+	assert.Equal(t, members("0000", "0002", "0003", "0004"), union)
+	diff := collect(func(handleFn func(member []byte) (bool, error)) error {
+		return db.SDiffScan([]byte("a"), [][]byte{[]byte("b")}, handleFn)
+	}, 3)
+	assert.Equal(t, members("0002", "0004", "0008"), diff)
+	all := collect(func(handleFn func(member []byte) (bool, error)) error {
+		return db.SMembersScan([]byte("a"), handleFn)
+	}, 1000)
+	assert.Equal(t, a, all)
+
+	// handleFn 返回的错误会停止遍历并原样返回
+	calls := 0
+	stop := errors.New("stop")
+	err = db.SUnionScan([]byte("a"), [][]byte{[]byte("b")}, func(member []byte) (bool, error) {
+		calls++
+		return true, stop
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, calls)
+}
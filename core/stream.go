@@ -0,0 +1,331 @@
+package core
+
+import (
+	"encoding/binary"
+	"fastdb/common"
+	"fastdb/index"
+	"math"
+	"time"
+)
+
+// StreamNewEntries 创建消费组时使用，代表消费组只接收之后新加入的消息
+const StreamNewEntries int64 = -1
+
+type StreamEntry struct {
+	Id     int64
+	Fields []FieldValue
+}
+
+// PendingEntry 已投递给消费者但还没有被确认的消息
+type PendingEntry struct {
+	Id          int64
+	Consumer    []byte
+	DeliveredAt time.Time
+}
+
+func encodeStreamId(id int64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(id))
+	return buf
+}
+
+func encodeEntryKey(key []byte, id int64) []byte {
+	return encodeMemberKey(streamEntryTag, key, encodeStreamId(id))
+}
+
+// encodePendingPrefix 消费组中所有未确认消息的前缀: groupSize + group
+func encodePendingPrefix(key []byte, group []byte) []byte {
+	buf := make([]byte, binary.MaxVarintLen32+len(group))
+	n := binary.PutUvarint(buf, uint64(len(group)))
+	n += copy(buf[n:], group)
+	return encodeMemberKey(streamPendingTag, key, buf[:n])
+}
+
+// encodeFields 消息的编码: fieldCount + (fieldSize + field + valueSize + value)*
+func encodeFields(fields []FieldValue) []byte {
+	size := binary.MaxVarintLen32
+	for _, fv := range fields {
+		size += binary.MaxVarintLen32*2 + len(fv.Field) + len(fv.Value)
+	}
+	buf := make([]byte, size)
+	n := binary.PutUvarint(buf, uint64(len(fields)))
+	for _, fv := range fields {
+		n += binary.PutUvarint(buf[n:], uint64(len(fv.Field)))
+		n += copy(buf[n:], fv.Field)
+		n += binary.PutUvarint(buf[n:], uint64(len(fv.Value)))
+		n += copy(buf[n:], fv.Value)
+	}
+	return buf[:n]
+}
+
+func decodeFields(buf []byte) []FieldValue {
+	count, n := binary.Uvarint(buf)
+	fields := make([]FieldValue, count)
+	for i := range fields {
+		size, m := binary.Uvarint(buf[n:])
+		n += m
+		fields[i].Field = buf[n : n+int(size)]
+		n += int(size)
+		size, m = binary.Uvarint(buf[n:])
+		n += m
+		fields[i].Value = buf[n : n+int(size)]
+		n += int(size)
+	}
+	return fields
+}
+
+// encodePending 未确认消息的编码: deliveredAt(毫秒) + consumer
+func encodePending(consumer []byte, deliveredAt time.Time) []byte {
+	buf := make([]byte, binary.MaxVarintLen64+len(consumer))
+	n := binary.PutVarint(buf, deliveredAt.UnixMilli())
+	n += copy(buf[n:], consumer)
+	return buf[:n]
+}
+
+func decodePending(buf []byte) ([]byte, time.Time) {
+	deliveredAt, n := binary.Varint(buf)
+	return buf[n:], time.UnixMilli(deliveredAt)
+}
+
+// XAdd 向流的末尾追加一条消息，返回自动生成的消息 id。
+// id 由 snowflake 生成，并保证大于流中最后一条消息的 id，即使时钟回拨也是单调递增的
+func (db *DB) XAdd(key []byte, fields ...FieldValue) (int64, error) {
+	batch, op := db.newTypesBatch(false)
+	id, err := xadd(db, op, key, fields)
+	return id, commitOrClose(batch, err)
+}
+
+func xadd(db *DB, op *BucketBatch, key []byte, fields []FieldValue) (int64, error) {
+	meta, err := getTypeMeta(op, key, Stream)
+	if err != nil {
+		return 0, err
+	}
+	if meta == nil {
+		meta = &typeMeta{dataType: Stream}
+	}
+
+	id := db.batchIdNode.Generate().Int64()
+	if id <= meta.tail {
+		id = meta.tail + 1
+	}
+	if err = op.Put(encodeEntryKey(key, id), encodeFields(fields)); err != nil {
+		return 0, err
+	}
+	meta.tail = id
+	meta.size++
+	return id, putTypeMeta(op, key, meta)
+}
+
+// XLen 返回流中消息的数量
+func (db *DB) XLen(key []byte) (int64, error) {
+	batch, op := db.newTypesBatch(true)
+	defer func() {
+		_ = batch.Commit()
+	}()
+
+	meta, err := getTypeMeta(op, key, Stream)
+	if err != nil || meta == nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// XRange 按 id 升序返回 id 在 [start, end] 之间的消息，count 小于等于 0 时不限制数量
+func (db *DB) XRange(key []byte, start, end int64, count int) ([]StreamEntry, error) {
+	return db.xrange(key, start, end, count, false)
+}
+
+// XRevRange 按 id 降序返回 id 在 [start, end] 之间的消息，count 小于等于 0 时不限制数量
+func (db *DB) XRevRange(key []byte, end, start int64, count int) ([]StreamEntry, error) {
+	return db.xrange(key, start, end, count, true)
+}
+
+func (db *DB) xrange(key []byte, start, end int64, count int, reverse bool) ([]StreamEntry, error) {
+	batch, op := db.newTypesBatch(true)
+	defer func() {
+		_ = batch.Commit()
+	}()
+
+	meta, err := getTypeMeta(op, key, Stream)
+	if err != nil || meta == nil || start > end {
+		return nil, err
+	}
+
+	seek := start
+	if reverse {
+		seek = end
+	}
+	options := index.IteratorOptions{Prefix: encodeMemberPrefix(streamEntryTag, key), Reverse: reverse}
+	return db.scanEntries(options, encodeEntryKey(key, seek), count, func(id int64) bool {
+		return id >= start && id <= end
+	})
+}
+
+// scanEntries 从 seek 开始遍历流中的消息，直到 inRange 返回 false 或者达到 count 条
+func (db *DB) scanEntries(options index.IteratorOptions, seek []byte, count int, inRange func(id int64) bool) ([]StreamEntry, error) {
+	var entries []StreamEntry
+	err := db.typesBucket.iterateLocked(options, seek, true, func(k []byte, v []byte) (bool, error) {
+		id := int64(binary.BigEndian.Uint64(k[len(options.Prefix):]))
+		if !inRange(id) {
+			return false, nil
+		}
+		entries = append(entries, StreamEntry{Id: id, Fields: decodeFields(v)})
+		return count <= 0 || len(entries) < count, nil
+	})
+	return entries, err
+}
+
+// XRead 返回流中 id 大于 afterId 的消息，count 小于等于 0 时不限制数量
+func (db *DB) XRead(key []byte, afterId int64, count int) ([]StreamEntry, error) {
+	if afterId == math.MaxInt64 {
+		return nil, nil
+	}
+	return db.XRange(key, afterId+1, math.MaxInt64, count)
+}
+
+// XReadBlock 与 XRead 相同，但没有新消息时阻塞，直到流被 Batch.Commit 写入或者超时，
+// timeout 为 0 时一直等待，超时返回空的结果
+func (db *DB) XReadBlock(key []byte, afterId int64, count int, timeout time.Duration) ([]StreamEntry, error) {
+	var entries []StreamEntry
+	_, err := db.waitFor([][]byte{key}, timeout, func() (bool, error) {
+		var err error
+		entries, err = db.XRead(key, afterId, count)
+		return len(entries) > 0, err
+	})
+	return entries, err
+}
+
+// XGroupCreate 为流创建消费组，消费组从 id 大于 lastId 的消息开始投递，
+// lastId 为 StreamNewEntries 时只投递之后新加入的消息，消费组已存在时不做修改
+func (db *DB) XGroupCreate(key []byte, group []byte, lastId int64) error {
+	batch, op := db.newTypesBatch(false)
+	return commitOrClose(batch, xgroupCreate(op, key, group, lastId))
+}
+
+func xgroupCreate(op *BucketBatch, key []byte, group []byte, lastId int64) error {
+	meta, err := getTypeMeta(op, key, Stream)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return common.NewErr(&common.KeyNotFoundErrNo, common.ErrKeyNotFound)
+	}
+
+	groupKey := encodeMemberKey(streamGroupTag, key, group)
+	absent, err := notExist(op, groupKey)
+	if err != nil || !absent {
+		return err
+	}
+	if lastId == StreamNewEntries {
+		lastId = meta.tail
+	}
+	return op.Put(groupKey, encodeStreamId(lastId))
+}
+
+// XReadGroup 将消费组中还未投递的消息投递给 consumer，并记录到消费组的未确认列表中，
+// 消费组不存在时返回 ErrKeyNotFound
+func (db *DB) XReadGroup(key []byte, group []byte, consumer []byte, count int) ([]StreamEntry, error) {
+	batch, op := db.newTypesBatch(false)
+	entries, err := xreadGroup(db, op, key, group, consumer, count)
+	return entries, commitOrClose(batch, err)
+}
+
+// XReadGroupBlock 与 XReadGroup 相同，但没有新消息时阻塞，timeout 为 0 时一直等待，超时返回空的结果
+func (db *DB) XReadGroupBlock(key []byte, group []byte, consumer []byte, count int, timeout time.Duration) ([]StreamEntry, error) {
+	var entries []StreamEntry
+	_, err := db.waitFor([][]byte{key}, timeout, func() (bool, error) {
+		var err error
+		entries, err = db.XReadGroup(key, group, consumer, count)
+		return len(entries) > 0, err
+	})
+	return entries, err
+}
+
+func xreadGroup(db *DB, op *BucketBatch, key []byte, group []byte, consumer []byte, count int) ([]StreamEntry, error) {
+	if _, err := getTypeMeta(op, key, Stream); err != nil {
+		return nil, err
+	}
+	groupKey := encodeMemberKey(streamGroupTag, key, group)
+	value, err := op.Get(groupKey)
+	if err != nil {
+		return nil, err
+	}
+	lastId := int64(binary.BigEndian.Uint64(value))
+
+	options := index.IteratorOptions{Prefix: encodeMemberPrefix(streamEntryTag, key)}
+	entries, err := db.scanEntries(options, encodeEntryKey(key, lastId+1), count, func(int64) bool {
+		return true
+	})
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+
+	pendingPrefix := encodePendingPrefix(key, group)
+	now := time.Now()
+	for _, entry := range entries {
+		pendingEntryKey := common.Concat(pendingPrefix, encodeStreamId(entry.Id))
+		if err = op.Put(pendingEntryKey, encodePending(consumer, now)); err != nil {
+			return nil, err
+		}
+	}
+	return entries, op.Put(groupKey, encodeStreamId(entries[len(entries)-1].Id))
+}
+
+// XPending 按 id 升序返回消费组中所有未确认的消息
+func (db *DB) XPending(key []byte, group []byte) ([]PendingEntry, error) {
+	batch, op := db.newTypesBatch(true)
+	defer func() {
+		_ = batch.Commit()
+	}()
+
+	if _, err := getTypeMeta(op, key, Stream); err != nil {
+		return nil, err
+	}
+	if _, err := op.Get(encodeMemberKey(streamGroupTag, key, group)); err != nil {
+		return nil, err
+	}
+
+	prefix := encodePendingPrefix(key, group)
+	var pending []PendingEntry
+	err := db.typesBucket.scanLocked(prefix, func(k []byte, v []byte) (bool, error) {
+		consumer, deliveredAt := decodePending(v)
+		pending = append(pending, PendingEntry{
+			Id:          int64(binary.BigEndian.Uint64(k[len(prefix):])),
+			Consumer:    consumer,
+			DeliveredAt: deliveredAt,
+		})
+		return true, nil
+	})
+	return pending, err
+}
+
+// XAck 确认消费组中的消息，将其从未确认列表中删除，返回实际确认的数量
+func (db *DB) XAck(key []byte, group []byte, ids ...int64) (int, error) {
+	batch, op := db.newTypesBatch(false)
+	acked, err := xack(op, key, group, ids)
+	return acked, commitOrClose(batch, err)
+}
+
+func xack(op *BucketBatch, key []byte, group []byte, ids []int64) (int, error) {
+	if _, err := getTypeMeta(op, key, Stream); err != nil {
+		return 0, err
+	}
+
+	pendingPrefix := encodePendingPrefix(key, group)
+	acked := 0
+	for _, id := range ids {
+		pendingEntryKey := common.Concat(pendingPrefix, encodeStreamId(id))
+		absent, err := notExist(op, pendingEntryKey)
+		if err != nil {
+			return 0, err
+		}
+		if absent {
+			continue
+		}
+		if err = op.Delete(pendingEntryKey); err != nil {
+			return 0, err
+		}
+		acked++
+	}
+	return acked, nil
+}
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"fastdb/common"
+	"fastdb/config"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDB_Stream(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("events")
+	ids := make([]int64, 5)
+	for i := range ids {
+		ids[i], err = db.XAdd(key, FieldValue{Field: []byte("seq"), Value: []byte{byte('0' + i)}})
+		assert.Nil(t, err)
+		if i > 0 {
+			assert.Greater(t, ids[i], ids[i-1])
+		}
+	}
+	length, err := db.XLen(key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), length)
+
+	entries, err := db.XRange(key, ids[1], ids[3], 0)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, ids[1], entries[0].Id)
+	assert.Equal(t, []FieldValue{{Field: []byte("seq"), Value: []byte("1")}}, entries[0].Fields)
+
+	entries, err = db.XRevRange(key, math.MaxInt64, 0, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{ids[4], ids[3]}, []int64{entries[0].Id, entries[1].Id})
+
+	entries, err = db.XRead(key, ids[2], 0)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, ids[3], entries[0].Id)
+
+	// 重启后新的 id 依然大于已有的 id
+	assert.Nil(t, db.Close())
+	db, err = Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	id, err := db.XAdd(key)
+	assert.Nil(t, err)
+	assert.Greater(t, id, ids[4])
+	entries, err = db.XRead(key, ids[4], 0)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Empty(t, entries[0].Fields)
+
+	_, err = db.HSet([]byte("user:1"), []byte("name"), []byte("alice"))
+	assert.Nil(t, err)
+	_, err = db.XAdd([]byte("user:1"))
+	assert.ErrorIs(t, err, common.ErrWrongType)
+}
+
+func TestDB_StreamBlockingRead(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("events")
+	entries, err := db.XReadBlock(key, 0, 0, 10*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+
+	done := make(chan []StreamEntry)
+	go func() {
+		entries, err := db.XReadBlock(key, 0, 0, 0)
+		assert.Nil(t, err)
+		done <- entries
+	}()
+	time.Sleep(20 * time.Millisecond)
+	id, err := db.XAdd(key, FieldValue{Field: []byte("k"), Value: []byte("v")})
+	assert.Nil(t, err)
+	select {
+	case entries := <-done:
+		assert.Len(t, entries, 1)
+		assert.Equal(t, id, entries[0].Id)
+	case <-time.After(time.Second):
+		t.Fatal("XReadBlock was not woken by commit")
+	}
+}
+
+func TestDB_StreamConsumerGroup(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("events")
+	group := []byte("workers")
+	assert.ErrorIs(t, db.XGroupCreate(key, group, 0), common.ErrKeyNotFound)
+
+	first, err := db.XAdd(key)
+	assert.Nil(t, err)
+	assert.Nil(t, db.XGroupCreate(key, group, 0))
+	assert.Nil(t, db.XGroupCreate(key, []byte("late"), StreamNewEntries))
+	second, err := db.XAdd(key)
+	assert.Nil(t, err)
+	third, err := db.XAdd(key)
+	assert.Nil(t, err)
+
+	entries, err := db.XReadGroup(key, group, []byte("c1"), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{first, second}, []int64{entries[0].Id, entries[1].Id})
+	entries, err = db.XReadGroup(key, group, []byte("c2"), 0)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, third, entries[0].Id)
+	entries, err = db.XReadGroup(key, group, []byte("c2"), 0)
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+
+	// 以 StreamNewEntries 创建的消费组看不到之前的消息
+	entries, err = db.XReadGroup(key, []byte("late"), []byte("c1"), 0)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+	_, err = db.XReadGroup(key, []byte("missing"), []byte("c1"), 0)
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+
+	acked, err := db.XAck(key, group, first, third, third+1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, acked)
+
+	// 未确认列表与消费进度在重启后都能恢复
+	assert.Nil(t, db.Close())
+	db, err = Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	pending, err := db.XPending(key, group)
+	assert.Nil(t, err)
+	assert.Len(t, pending, 1)
+	assert.Equal(t, second, pending[0].Id)
+	assert.Equal(t, []byte("c1"), pending[0].Consumer)
+	assert.False(t, pending[0].DeliveredAt.IsZero())
+
+	entries, err = db.XReadGroup(key, group, []byte("c1"), 0)
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,159 @@
+package core
+
+import (
+	"encoding/binary"
+	"fastdb/common"
+	"fastdb/config"
+)
+
+// DataType 代表 key 所保存的数据结构类型
+type DataType = byte
+
+const (
+	Hash DataType = iota + 1
+	ZSet
+	List
+	Set
+	Stream
+)
+
+const (
+	// metaKeyTag 数据结构元数据的 key 前缀
+	metaKeyTag byte = 'M'
+	// hashFieldTag hash 中 field 的 key 前缀
+	hashFieldTag byte = 'H'
+	// zsetMemberTag 有序集合中 member 到 score 的映射
+	zsetMemberTag byte = 'Z'
+	// zsetScoreTag 有序集合中按 score 排序的索引: score + member
+	zsetScoreTag byte = 'S'
+	// listElementTag 列表中元素的 key 前缀，其后是元素的序号
+	listElementTag byte = 'L'
+	// setMemberTag 集合中 member 的 key 前缀，value 为空
+	setMemberTag byte = 'E'
+	// streamEntryTag 流中的消息，其后是消息 id
+	streamEntryTag byte = 'X'
+	// streamGroupTag 流的消费组，value 为消费组最后投递的消息 id
+	streamGroupTag byte = 'G'
+	// streamPendingTag 消费组中已投递但未确认的消息
+	streamPendingTag byte = 'P'
+)
+
+// typeMeta 每个数据结构都有一条元数据记录，保存其类型以及元素数量
+type typeMeta struct {
+	dataType DataType
+	size     int64
+	// head tail 只有列表与流使用，列表元素的序号范围为 [head, tail)，流的 tail 为最后一条消息的 id
+	head int64
+	tail int64
+}
+
+func encodeTypeMeta(meta *typeMeta) []byte {
+	buf := make([]byte, 1+binary.MaxVarintLen64*3)
+	buf[0] = meta.dataType
+	n := 1 + binary.PutVarint(buf[1:], meta.size)
+	if meta.dataType == List || meta.dataType == Stream {
+		n += binary.PutVarint(buf[n:], meta.head)
+		n += binary.PutVarint(buf[n:], meta.tail)
+	}
+	return buf[:n]
+}
+
+func decodeTypeMeta(buf []byte) *typeMeta {
+	meta := &typeMeta{dataType: buf[0]}
+	size, n := binary.Varint(buf[1:])
+	meta.size = size
+	if meta.dataType == List || meta.dataType == Stream {
+		index := 1 + n
+		meta.head, n = binary.Varint(buf[index:])
+		index += n
+		meta.tail, _ = binary.Varint(buf[index:])
+	}
+	return meta
+}
+
+func encodeMetaKey(key []byte) []byte {
+	buf := make([]byte, 1+len(key))
+	buf[0] = metaKeyTag
+	copy(buf[1:], key)
+	return buf
+}
+
+// encodeMemberPrefix 同一个数据结构中所有成员共同的前缀: tag + keySize + key
+// key 的长度保证了不同 key 的成员前缀不会互相包含
+func encodeMemberPrefix(tag byte, key []byte) []byte {
+	buf := make([]byte, 1+binary.MaxVarintLen32+len(key))
+	buf[0] = tag
+	n := 1 + binary.PutUvarint(buf[1:], uint64(len(key)))
+	n += copy(buf[n:], key)
+	return buf[:n]
+}
+
+func encodeMemberKey(tag byte, key []byte, member []byte) []byte {
+	return common.Concat(encodeMemberPrefix(tag, key), member)
+}
+
+// newTypesBatch 为一次数据结构命令创建 batch，命令中的所有修改会被原子地提交
+func (db *DB) newTypesBatch(readOnly bool) (*Batch, *BucketBatch) {
+	options := config.DefaultBatchOptions
+	options.Sync = false
+	options.ReadOnly = readOnly
+	batch := db.NewBatch(options)
+	return batch, batch.Bucket(db.typesBucket)
+}
+
+// types 返回 batch 在数据结构内部 bucket 上的视图
+func (b *Batch) types() *BucketBatch {
+	return b.Bucket(b.db.typesBucket)
+}
+
+// getTypeMeta 读取 key 的元数据，key 不存在时返回 nil，类型不一致时返回错误
+func getTypeMeta(op *BucketBatch, key []byte, dataType DataType) (*typeMeta, error) {
+	if len(key) == 0 {
+		return nil, common.NewErr(&common.KeyIsEmptyErrNo, common.ErrKeyIsEmpty)
+	}
+	value, err := op.Get(encodeMetaKey(key))
+	if err != nil {
+		if isKeyNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	meta := decodeTypeMeta(value)
+	if meta.dataType != dataType {
+		return nil, common.NewErr(&common.WrongTypeErrNo, common.ErrWrongType)
+	}
+	return meta, nil
+}
+
+// putTypeMeta 写入 key 的元数据，元素数量为 0 时删除该数据结构
+func putTypeMeta(op *BucketBatch, key []byte, meta *typeMeta) error {
+	if meta.size <= 0 {
+		return op.Delete(encodeMetaKey(key))
+	}
+	return op.Put(encodeMetaKey(key), encodeTypeMeta(meta))
+}
+
+// commitOrClose 提交 batch，出错时只释放锁
+func commitOrClose(batch *Batch, err error) error {
+	if err != nil {
+		batch.Close()
+		return err
+	}
+	return batch.Commit()
+}
+
+func isKeyNotFound(err error) bool {
+	return common.ExtractErrCode(err) == common.KeyNotFoundErrNo.Code
+}
+
+// notExist 判断 key 在 batch 中是否不存在
+func notExist(op *BucketBatch, key []byte) (bool, error) {
+	_, err := op.Get(key)
+	if err == nil {
+		return false, nil
+	}
+	if isKeyNotFound(err) {
+		return true, nil
+	}
+	return false, err
+}
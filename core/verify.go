@@ -0,0 +1,323 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fastdb/common"
+	"fastdb/index"
+	"fastdb/wal"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// verifyPageSize 校验索引时每次持有读锁检查的 key 数量
+const verifyPageSize = 256
+
+var errVerifyStopped = errors.New("verify stopped")
+
+// CorruptRange 数据文件中无法通过校验的区间
+type CorruptRange struct {
+	SegmentId wal.SegmentID
+	// Offset 区间在 segment 文件中的偏移量
+	Offset int64
+	// Size 区间的字节数，chunk 无法读取时之后的数据无法定位，区间会一直持续到 segment 的结尾
+	Size  int64
+	Error string
+}
+
+// IndexError 索引中指向的记录无法读取，或者与索引中的 key 不一致
+type IndexError struct {
+	Bucket   string
+	Key      []byte
+	Position wal.ChunkPosition
+	Error    string
+}
+
+// VerifyReport 一次校验的结果
+type VerifyReport struct {
+	StartedAt time.Time
+	Duration  time.Duration
+	Segments  int
+	// Records 通过 crc 校验的记录数量
+	Records int
+	// Bytes 校验过程中读取的字节数
+	Bytes       int64
+	Corrupt     []CorruptRange
+	IndexErrors []IndexError
+}
+
+// OK 数据文件与索引都没有发现问题
+func (r *VerifyReport) OK() bool {
+	return len(r.Corrupt) == 0 && len(r.IndexErrors) == 0
+}
+
+type VerifyStats struct {
+	// Runs 已经完成的校验次数
+	Runs    uint64
+	Running bool
+	// Last 最近一次完成的校验结果，从未校验时为 nil
+	Last *VerifyReport
+}
+
+// scrubber 记录校验的状态，并在开启 ScrubInterval 时在后台定期校验
+type scrubber struct {
+	// mu 同一时间只运行一次校验
+	mu       sync.Mutex
+	runs     atomic.Uint64
+	running  atomic.Bool
+	last     atomic.Pointer[VerifyReport]
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     sync.WaitGroup
+}
+
+func newScrubber() *scrubber {
+	return &scrubber{stop: make(chan struct{})}
+}
+
+// startScrubber 开启后台校验，每次校验完成后等待 interval 再开始下一次
+func (db *DB) startScrubber(interval time.Duration) {
+	s := db.scrubber
+	s.done.Add(1)
+	go func() {
+		defer s.done.Done()
+		timer := time.NewTimer(interval)
+		defer timer.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-timer.C:
+				_, _ = db.Verify()
+				timer.Reset(interval)
+			}
+		}
+	}()
+}
+
+// stopScrubber 停止正在进行的校验并等待后台校验退出，调用时不能持有数据库的锁
+func (db *DB) stopScrubber() {
+	s := db.scrubber
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	s.done.Wait()
+}
+
+func (db *DB) VerifyStats() VerifyStats {
+	s := db.scrubber
+	return VerifyStats{Runs: s.runs.Load(), Running: s.running.Load(), Last: s.last.Load()}
+}
+
+// Verify 重新读取所有 segment 中的每个 chunk 并检查 crc，再确认索引中的每个位置都能解码出对应的 key。
+// 读取数据文件时不经过 block cache，读取速度受 ScrubBytesPerSecond 限制，
+// 校验期间只会短暂地持有读锁，不会长时间阻塞写入
+func (db *DB) Verify() (*VerifyReport, error) {
+	s := db.scrubber
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running.Store(true)
+	defer s.running.Store(false)
+
+	report := &VerifyReport{StartedAt: time.Now()}
+	t := &throttle{bytesPerSecond: db.options.ScrubBytesPerSecond, start: time.Now(), stop: s.stop}
+	err := db.verifySegments(report, t)
+	if err == nil {
+		err = db.verifyIndex(report, t)
+	}
+	if errors.Is(err, errVerifyStopped) {
+		return nil, common.NewErr(&common.DBClosedErrNo, common.ErrDBClosed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report.Duration = time.Since(report.StartedAt)
+	s.last.Store(report)
+	s.runs.Add(1)
+	return report, nil
+}
+
+// verifySegments 顺序读取所有 segment，读取每条数据时持有读锁，与写入互斥
+func (db *DB) verifySegments(report *VerifyReport, t *throttle) error {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return errVerifyStopped
+	}
+	reader := db.dataFiles.NewUncachedReader()
+	report.Segments = len(db.dataFiles.SegmentSizes())
+	db.mu.RUnlock()
+
+	for {
+		db.mu.RLock()
+		if db.closed {
+			db.mu.RUnlock()
+			return errVerifyStopped
+		}
+		data, position, err := reader.Next()
+		var segmentSize int64
+		if err != nil && err != io.EOF && position != nil {
+			segmentSize = db.dataFiles.SegmentSizes()[position.SegmentId]
+		}
+		db.mu.RUnlock()
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// 数据文件被 Restore 替换之后无法继续校验
+			if position == nil || errors.Is(err, wal.ErrClosed) {
+				return err
+			}
+			// 出错的 chunk 之后的数据无法定位，跳过当前 segment 剩余的部分
+			offset := position.FileOffset()
+			report.Corrupt = append(report.Corrupt, CorruptRange{
+				SegmentId: position.SegmentId,
+				Offset:    offset,
+				Size:      segmentSize - offset,
+				Error:     err.Error(),
+			})
+			reader.SkipSegment()
+			continue
+		}
+
+		report.Bytes += int64(position.ChunkSize)
+		if _, err := decodeLogRecord(data); err != nil {
+			report.Corrupt = append(report.Corrupt, CorruptRange{
+				SegmentId: position.SegmentId,
+				Offset:    position.FileOffset(),
+				Size:      int64(position.ChunkSize),
+				Error:     err.Error(),
+			})
+		} else {
+			report.Records++
+		}
+		if err := t.wait(int64(position.ChunkSize)); err != nil {
+			return err
+		}
+	}
+}
+
+// verifyIndex 分页检查每个 bucket 的索引，每一页检查完之后释放读锁
+func (db *DB) verifyIndex(report *VerifyReport, t *throttle) error {
+	db.mu.RLock()
+	buckets := []*Bucket{db.defaultBucket, db.typesBucket}
+	for _, bucket := range db.buckets {
+		buckets = append(buckets, bucket)
+	}
+	db.mu.RUnlock()
+
+	for _, bucket := range buckets {
+		var seek []byte
+		for {
+			db.mu.RLock()
+			if db.closed {
+				db.mu.RUnlock()
+				return errVerifyStopped
+			}
+			if bucket.dropped.Load() {
+				db.mu.RUnlock()
+				break
+			}
+			next, read := bucket.verifyIndexPage(seek, report)
+			db.mu.RUnlock()
+
+			report.Bytes += read
+			if err := t.wait(read); err != nil {
+				return err
+			}
+			if next == nil {
+				break
+			}
+			seek = next
+		}
+	}
+	return nil
+}
+
+// verifyIndexPage 从 seek 之后开始检查最多 verifyPageSize 个 key，
+// 返回最后检查的 key，已经检查到索引结尾时返回 nil，调用方需要持有数据库的锁
+func (bk *Bucket) verifyIndexPage(seek []byte, report *VerifyReport) ([]byte, int64) {
+	iter := bk.index.Iterator(index.IteratorOptions{})
+	defer iter.Close()
+	if seek != nil {
+		iter.Seek(seek)
+	}
+
+	var last []byte
+	var read int64
+	for n := 0; iter.Valid() && n < verifyPageSize; iter.Next() {
+		key, position := iter.Key(), iter.Value()
+		if seek != nil && bytes.Equal(key, seek) {
+			continue
+		}
+		read += int64(position.ChunkSize)
+		if err := bk.verifyEntry(key, position); err != nil {
+			report.IndexErrors = append(report.IndexErrors, IndexError{
+				Bucket:   bk.name,
+				Key:      append([]byte(nil), key...),
+				Position: *position,
+				Error:    err.Error(),
+			})
+		}
+		last = append(last[:0], key...)
+		n++
+	}
+	if !iter.Valid() {
+		return nil, read
+	}
+	return last, read
+}
+
+func (bk *Bucket) verifyEntry(key []byte, position *wal.ChunkPosition) error {
+	record, err := bk.readRecord(position)
+	if err != nil {
+		return err
+	}
+	if record.Type != LogRecordNormal {
+		return fmt.Errorf("the record has type %d", record.Type)
+	}
+	if record.BucketId != bk.id || !bytes.Equal(record.Key, key) {
+		return fmt.Errorf("the record belongs to key %q in bucket %d", record.Key, record.BucketId)
+	}
+	return nil
+}
+
+// throttle 限制校验读取数据的速度，避免与前台的读写争抢 I/O
+type throttle struct {
+	bytesPerSecond int64
+	start          time.Time
+	bytes          int64
+	stop           <-chan struct{}
+}
+
+// wait 记录读取了 n 个字节，读取速度超过限制时等待，数据库关闭时返回 errVerifyStopped
+func (t *throttle) wait(n int64) error {
+	t.bytes += n
+	var delay time.Duration
+	if t.bytesPerSecond > 0 {
+		expected := time.Duration(float64(t.bytes) / float64(t.bytesPerSecond) * float64(time.Second))
+		delay = expected - time.Since(t.start)
+	}
+	if delay <= 0 {
+		select {
+		case <-t.stop:
+			return errVerifyStopped
+		default:
+			return nil
+		}
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-t.stop:
+		return errVerifyStopped
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"fastdb/common"
+	"fastdb/config"
+	"fastdb/wal"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDB_Verify(t *testing.T) {
+	options := config.DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(common.GetTestKey(i), common.RandomValue(128)))
+	}
+	assert.Nil(t, db.Put([]byte("large"), common.RandomValue(100*config.KB)))
+	bucket, err := db.Bucket("users")
+	assert.Nil(t, err)
+	assert.Nil(t, bucket.Put([]byte("alice"), []byte("1")))
+	_, err = db.HSet([]byte("hash"), []byte("field"), []byte("value"))
+	assert.Nil(t, err)
+
+	report, err := db.Verify()
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, 1, report.Segments)
+	// 每次写入包括一条数据以及一个结束标记
+	assert.Greater(t, report.Records, 200)
+	assert.Equal(t, uint64(1), db.VerifyStats().Runs)
+	assert.Equal(t, report, db.VerifyStats().Last)
+
+	// 索引指向了另一个 key 的记录
+	position := db.defaultBucket.index.Get(common.GetTestKey(1))
+	db.defaultBucket.index.Put(common.GetTestKey(2), position)
+	report, err = db.Verify()
+	assert.Nil(t, err)
+	assert.Empty(t, report.Corrupt)
+	assert.Len(t, report.IndexErrors, 1)
+	assert.Equal(t, common.GetTestKey(2), report.IndexErrors[0].Key)
+}
+
+func TestDB_VerifyCorruption(t *testing.T) {
+	options := config.DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(common.GetTestKey(i), common.RandomValue(128)))
+	}
+	size := db.dataFiles.SegmentSizes()[1]
+
+	// 磁盘上的数据损坏，block cache 中的数据依然完好，校验时不经过缓存
+	fd, err := os.OpenFile(wal.SegmentFileName(options.DirPath, dataFileNameSuffix, 1), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = fd.WriteAt([]byte{0xff, 0xff}, 3*32*config.KB+100)
+	assert.Nil(t, err)
+	assert.Nil(t, fd.Close())
+
+	report, err := db.Verify()
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+	assert.Len(t, report.Corrupt, 1)
+	corrupt := report.Corrupt[0]
+	assert.Equal(t, wal.SegmentID(1), corrupt.SegmentId)
+	assert.LessOrEqual(t, corrupt.Offset, int64(3*32*config.KB+100))
+	assert.Greater(t, corrupt.Offset, int64(3*32*config.KB-200))
+	assert.Equal(t, size, corrupt.Offset+corrupt.Size)
+}
+
+func TestDB_VerifyThrottle(t *testing.T) {
+	options := config.DefaultOptions
+	options.ScrubBytesPerSecond = 1 * config.MB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assert.Nil(t, db.Put([]byte("large"), common.RandomValue(200*config.KB)))
+
+	// 数据文件与索引各读取一次，一共约 400KB
+	report, err := db.Verify()
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	assert.GreaterOrEqual(t, report.Duration, 300*time.Millisecond)
+}
+
+func TestDB_Scrubber(t *testing.T) {
+	options := config.DefaultOptions
+	options.ScrubInterval = 10 * time.Millisecond
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+
+	assert.Eventually(t, func() bool {
+		return db.VerifyStats().Runs >= 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, db.VerifyStats().Last.OK())
+
+	assert.Nil(t, db.Close())
+	_, err = db.Verify()
+	assert.Equal(t, common.DBClosedErrNo.Code, common.ExtractErrCode(err))
+}
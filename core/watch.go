@@ -0,0 +1,109 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// watcher 让阻塞命令(如 BLPop)等待某些 key 被修改，
+// Batch.Commit 在更新索引后通知等待这些 key 的调用方
+type watcher struct {
+	mu      sync.Mutex
+	waiters map[string]map[chan struct{}]struct{}
+}
+
+func newWatcher() *watcher {
+	return &watcher{waiters: make(map[string]map[chan struct{}]struct{})}
+}
+
+// watch 注册对 keys 的等待，任意一个 key 被修改时返回的 channel 会收到通知
+func (w *watcher) watch(keys []string) chan struct{} {
+	ch := make(chan struct{}, 1)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, key := range keys {
+		chs := w.waiters[key]
+		if chs == nil {
+			chs = make(map[chan struct{}]struct{})
+			w.waiters[key] = chs
+		}
+		chs[ch] = struct{}{}
+	}
+	return ch
+}
+
+func (w *watcher) unwatch(keys []string, ch chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, key := range keys {
+		if chs := w.waiters[key]; chs != nil {
+			delete(chs, ch)
+			if len(chs) == 0 {
+				delete(w.waiters, key)
+			}
+		}
+	}
+}
+
+// notify 唤醒所有等待 key 的调用方，通知不会阻塞
+func (w *watcher) notify(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch := range w.waiters[key] {
+		wake(ch)
+	}
+}
+
+// notifyAll 唤醒所有等待者，用于数据库关闭时
+func (w *watcher) notifyAll() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, chs := range w.waiters {
+		for ch := range chs {
+			wake(ch)
+		}
+	}
+}
+
+func (w *watcher) empty() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.waiters) == 0
+}
+
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// waitFor 反复调用 fn 直到其返回 true，数据结构 keys 被提交时才会重试，
+// 超时返回 false，timeout 为 0 时一直等待
+func (db *DB) waitFor(keys [][]byte, timeout time.Duration, fn func() (bool, error)) (bool, error) {
+	// 先注册等待再调用 fn，避免在两者之间提交的写入被错过
+	watchKeys := make([]string, len(keys))
+	for i, key := range keys {
+		watchKeys[i] = pendingKey(typesBucketId, encodeMetaKey(key))
+	}
+	ch := db.watcher.watch(watchKeys)
+	defer db.watcher.unwatch(watchKeys, ch)
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+		if ok, err := fn(); ok || err != nil {
+			return ok, err
+		}
+		select {
+		case <-ch:
+		case <-deadline:
+			return false, nil
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package core
+
+import (
+	"encoding/binary"
+	"fastdb/common"
+	"fastdb/index"
+	"math"
+)
+
+type ScoredMember struct {
+	Member []byte
+	Score  float64
+}
+
+// encodeScore 将 float64 编码为保持大小顺序的 8 字节:
+// 正数翻转符号位，负数翻转所有位，编码后按字节比较的顺序与数值顺序一致。
+// -0 与 +0 相等，统一编码为 +0，否则按照 0 查找时会错过 -0
+func encodeScore(score float64) []byte {
+	if score == 0 {
+		score = 0
+	}
+	bits := math.Float64bits(score)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, bits)
+	return buf
+}
+
+func decodeScore(buf []byte) float64 {
+	bits := binary.BigEndian.Uint64(buf)
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
+
+func encodeScoreKey(key []byte, score float64, member []byte) []byte {
+	return common.Concat(common.Concat(encodeMemberPrefix(zsetScoreTag, key), encodeScore(score)), member)
+}
+
+// decodeScoreKey 从 score 索引的 key 中解析出 score 与 member
+func decodeScoreKey(prefix []byte, scoreKey []byte) ScoredMember {
+	member := make([]byte, len(scoreKey)-len(prefix)-8)
+	copy(member, scoreKey[len(prefix)+8:])
+	return ScoredMember{
+		Member: member,
+		Score:  decodeScore(scoreKey[len(prefix) : len(prefix)+8]),
+	}
+}
+
+// ZAdd 将 member 以 score 加入有序集合，member 已存在时更新 score，返回 member 是否为新加入的
+func (db *DB) ZAdd(key []byte, score float64, member []byte) (bool, error) {
+	batch, op := db.newTypesBatch(false)
+	created, err := zadd(op, key, score, member)
+	return created, commitOrClose(batch, err)
+}
+
+// ZAdd 与 DB.ZAdd 相同，修改在 batch 提交时生效
+func (b *Batch) ZAdd(key []byte, score float64, member []byte) (bool, error) {
+	return zadd(b.types(), key, score, member)
+}
+
+func zadd(op *BucketBatch, key []byte, score float64, member []byte) (bool, error) {
+	if math.IsNaN(score) {
+		return false, common.NewErr(&common.NotFloatErrNo, common.ErrNotFloat)
+	}
+	meta, err := getTypeMeta(op, key, ZSet)
+	if err != nil {
+		return false, err
+	}
+	if meta == nil {
+		meta = &typeMeta{dataType: ZSet}
+	}
+
+	memberKey := encodeMemberKey(zsetMemberTag, key, member)
+	oldScore, err := op.Get(memberKey)
+	if err != nil && !isKeyNotFound(err) {
+		return false, err
+	}
+	created := err != nil
+	if created {
+		meta.size++
+		if err = putTypeMeta(op, key, meta); err != nil {
+			return false, err
+		}
+	} else {
+		if err = op.Delete(encodeScoreKey(key, decodeScore(oldScore), member)); err != nil {
+			return false, err
+		}
+	}
+
+	if err = op.Put(memberKey, encodeScore(score)); err != nil {
+		return false, err
+	}
+	return created, op.Put(encodeScoreKey(key, score, member), nil)
+}
+
+// ZRem 从有序集合中删除 members，返回实际删除的数量
+func (db *DB) ZRem(key []byte, members ...[]byte) (int, error) {
+	batch, op := db.newTypesBatch(false)
+	removed, err := zrem(op, key, members)
+	return removed, commitOrClose(batch, err)
+}
+
+func zrem(op *BucketBatch, key []byte, members [][]byte) (int, error) {
+	meta, err := getTypeMeta(op, key, ZSet)
+	if err != nil || meta == nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, member := range members {
+		memberKey := encodeMemberKey(zsetMemberTag, key, member)
+		score, err := op.Get(memberKey)
+		if err != nil {
+			if isKeyNotFound(err) {
+				continue
+			}
+			return 0, err
+		}
+		if err = op.Delete(memberKey); err != nil {
+			return 0, err
+		}
+		if err = op.Delete(encodeScoreKey(key, decodeScore(score), member)); err != nil {
+			return 0, err
+		}
+		removed++
+	}
+	meta.size -= int64(removed)
+	return removed, putTypeMeta(op, key, meta)
+}
+
+func (db *DB) ZScore(key []byte, member []byte) (float64, error) {
+	batch, op := db.newTypesBatch(true)
+	defer func() {
+		_ = batch.Commit()
+	}()
+
+	meta, err := getTypeMeta(op, key, ZSet)
+	if err != nil {
+		return 0, err
+	}
+	if meta == nil {
+		return 0, common.NewErr(&common.KeyNotFoundErrNo, common.ErrKeyNotFound)
+	}
+	score, err := op.Get(encodeMemberKey(zsetMemberTag, key, member))
+	if err != nil {
+		return 0, err
+	}
+	return decodeScore(score), nil
+}
+
+func (db *DB) ZCard(key []byte) (int64, error) {
+	batch, op := db.newTypesBatch(true)
+	defer func() {
+		_ = batch.Commit()
+	}()
+
+	meta, err := getTypeMeta(op, key, ZSet)
+	if err != nil || meta == nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// ZRank 返回 member 按 score 升序的排名(从 0 开始)，需要从头遍历 score 索引
+func (db *DB) ZRank(key []byte, member []byte) (int64, error) {
+	batch, op := db.newTypesBatch(true)
+	defer func() {
+		_ = batch.Commit()
+	}()
+
+	meta, err := getTypeMeta(op, key, ZSet)
+	if err != nil {
+		return 0, err
+	}
+	if meta == nil {
+		return 0, common.NewErr(&common.KeyNotFoundErrNo, common.ErrKeyNotFound)
+	}
+	score, err := op.Get(encodeMemberKey(zsetMemberTag, key, member))
+	if err != nil {
+		return 0, err
+	}
+
+	// 排在 member 之前的元素都小于它在 score 索引中的 key
+	target := encodeScoreKey(key, decodeScore(score), member)
+	var rank int64
+	err = db.typesBucket.iterateLocked(index.IteratorOptions{Prefix: encodeMemberPrefix(zsetScoreTag, key)}, nil, false,
+		func(k []byte, _ []byte) (bool, error) {
+			if string(k) == string(target) {
+				return false, nil
+			}
+			rank++
+			return true, nil
+		})
+	return rank, err
+}
+
+// ZRange 按 score 升序返回排名在 [start, stop] 之间的元素，负数表示从末尾开始计算
+func (db *DB) ZRange(key []byte, start, stop int64) ([]ScoredMember, error) {
+	batch, op := db.newTypesBatch(true)
+	defer func() {
+		_ = batch.Commit()
+	}()
+
+	meta, err := getTypeMeta(op, key, ZSet)
+	if err != nil || meta == nil {
+		return nil, err
+	}
+	start, stop, ok := normalizeRange(start, stop, meta.size)
+	if !ok {
+		return nil, nil
+	}
+
+	prefix := encodeMemberPrefix(zsetScoreTag, key)
+	result := make([]ScoredMember, 0, stop-start+1)
+	var rank int64
+	err = db.typesBucket.iterateLocked(index.IteratorOptions{Prefix: prefix}, nil, false,
+		func(k []byte, _ []byte) (bool, error) {
+			if rank >= start {
+				result = append(result, decodeScoreKey(prefix, k))
+			}
+			rank++
+			return rank <= stop, nil
+		})
+	return result, err
+}
+
+// ZRangeByScore 按 score 升序返回 score 在 [min, max] 之间的元素
+func (db *DB) ZRangeByScore(key []byte, min, max float64) ([]ScoredMember, error) {
+	batch, op := db.newTypesBatch(true)
+	defer func() {
+		_ = batch.Commit()
+	}()
+
+	meta, err := getTypeMeta(op, key, ZSet)
+	if err != nil || meta == nil || min > max {
+		return nil, err
+	}
+
+	prefix := encodeMemberPrefix(zsetScoreTag, key)
+	var result []ScoredMember
+	err = db.typesBucket.iterateLocked(index.IteratorOptions{Prefix: prefix}, common.Concat(prefix, encodeScore(min)), false,
+		func(k []byte, _ []byte) (bool, error) {
+			member := decodeScoreKey(prefix, k)
+			if member.Score > max {
+				return false, nil
+			}
+			result = append(result, member)
+			return true, nil
+		})
+	return result, err
+}
+
+// ZPopMin 删除并返回 score 最小的 count 个元素
+func (db *DB) ZPopMin(key []byte, count int) ([]ScoredMember, error) {
+	batch, op := db.newTypesBatch(false)
+	popped, err := zpopMin(db, op, key, count)
+	return popped, commitOrClose(batch, err)
+}
+
+func zpopMin(db *DB, op *BucketBatch, key []byte, count int) ([]ScoredMember, error) {
+	meta, err := getTypeMeta(op, key, ZSet)
+	if err != nil || meta == nil || count <= 0 {
+		return nil, err
+	}
+
+	prefix := encodeMemberPrefix(zsetScoreTag, key)
+	var popped []ScoredMember
+	err = db.typesBucket.iterateLocked(index.IteratorOptions{Prefix: prefix}, nil, false,
+		func(k []byte, _ []byte) (bool, error) {
+			popped = append(popped, decodeScoreKey(prefix, k))
+			return len(popped) < count, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([][]byte, len(popped))
+	for i, m := range popped {
+		members[i] = m.Member
+	}
+	if _, err = zrem(op, key, members); err != nil {
+		return nil, err
+	}
+	return popped, nil
+}
+
+// normalizeRange 将 redis 风格的 [start, stop] 转换为合法的下标范围
+func normalizeRange(start, stop, size int64) (int64, int64, bool) {
+	if start < 0 {
+		start += size
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	return start, stop, start <= stop
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"fastdb/common"
+	"fastdb/config"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"sort"
+	"testing"
+)
+
+func TestEncodeScore_Order(t *testing.T) {
+	scores := []float64{math.Inf(-1), -1e300, -2.5, -1, -math.SmallestNonzeroFloat64, 0, math.SmallestNonzeroFloat64, 1, 2.5, 1e300, math.Inf(1)}
+	encoded := make([]string, len(scores))
+	for i, score := range scores {
+		encoded[i] = string(encodeScore(score))
+		assert.Equal(t, score, decodeScore(encodeScore(score)))
+	}
+	assert.True(t, sort.StringsAreSorted(encoded))
+}
+
+func TestDB_ZSetNegativeZero(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Equal(t, encodeScore(0), encodeScore(math.Copysign(0, -1)))
+	key := []byte("zeros")
+	_, err = db.ZAdd(key, math.Copysign(0, -1), []byte("m"))
+	assert.Nil(t, err)
+	for _, bound := range []float64{0, math.Copysign(0, -1)} {
+		members, err := db.ZRangeByScore(key, bound, bound)
+		assert.Nil(t, err)
+		assert.Len(t, members, 1)
+		assert.Equal(t, []byte("m"), members[0].Member)
+	}
+
+	// 更新为 +0 不会留下重复的 member
+	_, err = db.ZAdd(key, 0, []byte("m"))
+	assert.Nil(t, err)
+	all, err := db.ZRange(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Len(t, all, 1)
+}
+
+func TestDB_ZSet(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("rank")
+	for _, m := range []ScoredMember{
+		{Member: []byte("a"), Score: 3},
+		{Member: []byte("b"), Score: -1.5},
+		{Member: []byte("c"), Score: 10},
+		{Member: []byte("d"), Score: 3},
+	} {
+		created, err := db.ZAdd(key, m.Score, m.Member)
+		assert.Nil(t, err)
+		assert.True(t, created)
+	}
+	// 更新已存在 member 的 score
+	created, err := db.ZAdd(key, 0, []byte("c"))
+	assert.Nil(t, err)
+	assert.False(t, created)
+
+	card, err := db.ZCard(key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), card)
+	score, err := db.ZScore(key, []byte("c"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(0), score)
+	_, err = db.ZScore(key, []byte("missing"))
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+
+	all, err := db.ZRange(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []ScoredMember{
+		{Member: []byte("b"), Score: -1.5},
+		{Member: []byte("c"), Score: 0},
+		{Member: []byte("a"), Score: 3},
+		{Member: []byte("d"), Score: 3},
+	}, all)
+	tail, err := db.ZRange(key, -2, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, all[2:], tail)
+	empty, err := db.ZRange(key, 3, 1)
+	assert.Nil(t, err)
+	assert.Empty(t, empty)
+
+	rank, err := db.ZRank(key, []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), rank)
+
+	byScore, err := db.ZRangeByScore(key, -1, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, all[1:], byScore)
+	byScore, err = db.ZRangeByScore(key, 5, math.Inf(1))
+	assert.Nil(t, err)
+	assert.Empty(t, byScore)
+
+	removed, err := db.ZRem(key, []byte("a"), []byte("missing"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, removed)
+
+	// 重启后 score 索引依然有序
+	assert.Nil(t, db.Close())
+	db, err = Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	popped, err := db.ZPopMin(key, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []ScoredMember{
+		{Member: []byte("b"), Score: -1.5},
+		{Member: []byte("c"), Score: 0},
+	}, popped)
+	all, err = db.ZRange(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []ScoredMember{{Member: []byte("d"), Score: 3}}, all)
+
+	popped, err = db.ZPopMin(key, 10)
+	assert.Nil(t, err)
+	assert.Len(t, popped, 1)
+	card, _ = db.ZCard(key)
+	assert.Equal(t, int64(0), card)
+}
+
+func TestDB_ZSetWrongType(t *testing.T) {
+	db, err := Open(config.DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("user:1")
+	_, err = db.HSet(key, []byte("name"), []byte("alice"))
+	assert.Nil(t, err)
+	_, err = db.ZAdd(key, 1, []byte("a"))
+	assert.ErrorIs(t, err, common.ErrWrongType)
+	_, err = db.ZRange(key, 0, -1)
+	assert.ErrorIs(t, err, common.ErrWrongType)
+	_, err = db.ZAdd([]byte("rank"), math.NaN(), []byte("a"))
+	assert.ErrorIs(t, err, common.ErrNotFloat)
+}
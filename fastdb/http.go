@@ -10,6 +10,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type httpServer struct {
@@ -17,6 +20,7 @@ type httpServer struct {
 	basePath string
 	db       *core.DB
 	options  config.ServerOptions
+	server   *http.Server
 }
 
 func MakeServer(options config.ServerOptions) (server.Server, error) {
@@ -27,21 +31,51 @@ func MakeServer(options config.ServerOptions) (server.Server, error) {
 
 func (s *httpServer) handleSingleRequest(writer http.ResponseWriter, request *http.Request) {
 	r, e := decodeRequest(request)
+	if e == nil {
+		e = conditionFromHeader(request, &r)
+	}
 	if e != nil {
 		encodeReply(writer, params.MakeErrReply(e))
 		return
 	}
-	batch := s.db.NewBatch(s.options.BatchOptions)
+	bucket, e := s.bucketOf(r.Bucket, createsBucket(r.Action))
+	if e != nil {
+		encodeReply(writer, params.MakeErrReply(e))
+		return
+	}
+	// 布隆过滤器只需要读锁，不能在 batch 持有锁之后查询
+	if r.Action == params.MayContainAction {
+		mayContain := s.db.MayContain
+		if bucket != nil {
+			mayContain = bucket.MayContain
+		}
+		ok, e := mayContain([]byte(r.Key))
+		if e != nil {
+			encodeReply(writer, params.MakeErrReply(e))
+			return
+		}
+		encodeReply(writer, params.MakeSuccessReply([]byte(strconv.FormatBool(ok))))
+		return
+	}
+	var batch *core.Batch
+	if bucket != nil {
+		batch = bucket.NewBatch(s.options.BatchOptions)
+	} else {
+		batch = s.db.NewBatch(s.options.BatchOptions)
+	}
 	defer batch.Close()
 
 	var val []byte
+	var version uint64
 	switch r.Action {
 	case params.GetAction:
-		val, e = batch.Get([]byte(r.Key))
+		val, version, e = batch.GetWithVersion([]byte(r.Key))
 	case params.PutAction:
-		e = batch.Put([]byte(r.Key), []byte(r.Value))
+		e = put(batch, r)
 	case params.DeleteAction:
-		e = batch.Delete([]byte(r.Key))
+		e = del(batch, r)
+	case params.IncrAction:
+		val, e = incr(batch, []byte(r.Key), r.Value)
 	default:
 		e = common.NewErr(&common.UnknownActionErrNo, common.ErrUnknownAction)
 	}
@@ -55,19 +89,261 @@ func (s *httpServer) handleSingleRequest(writer http.ResponseWriter, request *ht
 		encodeReply(writer, params.MakeErrReply(e))
 		return
 	}
-	encodeReply(writer, params.MakeSuccessReply(val))
+	reply := params.MakeSuccessReply(val)
+	if version != 0 {
+		reply.Version = version
+		writer.Header().Set("ETag", formatETag(version))
+	}
+	encodeReply(writer, reply)
+}
+
+func (s *httpServer) handleBatchRequest(writer http.ResponseWriter, request *http.Request) {
+	var r params.FastDbBatchRequest
+	if e := decodeBody(request, &r); e != nil {
+		encodeReply(writer, params.MakeErrReply(e))
+		return
+	}
+	// bucket 需要在获取 batch 之前准备好，batch 会持有数据库的锁
+	// 只有其中有写入的 bucket 才会在不存在时被创建
+	create := make(map[string]bool)
+	for _, req := range r.Requests {
+		create[req.Bucket] = create[req.Bucket] || createsBucket(req.Action)
+	}
+	buckets := make(map[string]*core.Bucket)
+	for name := range create {
+		bucket, e := s.bucketOf(name, create[name])
+		if e != nil {
+			encodeReply(writer, params.MakeErrReply(e))
+			return
+		}
+		buckets[name] = bucket
+	}
+	batch := s.db.NewBatch(s.options.BatchOptions)
+	defer batch.Close()
+
+	var items []params.KeyValue
+	for _, req := range r.Requests {
+		var op kvOperator = batch
+		if bucket := buckets[req.Bucket]; bucket != nil {
+			op = batch.Bucket(bucket)
+		}
+
+		var e error
+		switch req.Action {
+		case params.GetAction:
+			var val []byte
+			val, e = op.Get([]byte(req.Key))
+			items = append(items, params.KeyValue{Key: req.Key, Value: string(val)})
+		case params.PutAction:
+			e = put(op, req)
+		case params.DeleteAction:
+			e = del(op, req)
+		case params.IncrAction:
+			var val []byte
+			val, e = incr(op, []byte(req.Key), req.Value)
+			items = append(items, params.KeyValue{Key: req.Key, Value: string(val)})
+		default:
+			e = common.NewErr(&common.UnknownActionErrNo, common.ErrUnknownAction)
+		}
+		if e != nil {
+			encodeReply(writer, params.MakeErrReply(e))
+			return
+		}
+	}
+
+	if e := batch.Commit(); e != nil {
+		encodeReply(writer, params.MakeErrReply(e))
+		return
+	}
+	encodeReply(writer, params.MakeItemsReply(items))
+}
+
+func (s *httpServer) handleScanRequest(writer http.ResponseWriter, request *http.Request) {
+	var r params.FastDbScanRequest
+	if e := decodeBody(request, &r); e != nil {
+		encodeReply(writer, params.MakeErrReply(e))
+		return
+	}
+
+	bucket, e := s.bucketOf(r.Bucket, false)
+	if e != nil {
+		encodeReply(writer, params.MakeErrReply(e))
+		return
+	}
+	scan := s.db.Scan
+	if bucket != nil {
+		scan = bucket.Scan
+	}
+
+	var items []params.KeyValue
+	e = scan([]byte(r.Prefix), func(key []byte, value []byte) (bool, error) {
+		items = append(items, params.KeyValue{Key: string(key), Value: string(value)})
+		return r.Limit <= 0 || len(items) < r.Limit, nil
+	})
+	if e != nil {
+		encodeReply(writer, params.MakeErrReply(e))
+		return
+	}
+	encodeReply(writer, params.MakeItemsReply(items))
+}
+
+// kvOperator 是 core.Batch 与 core.BucketBatch 共同的操作
+type kvOperator interface {
+	Put(key []byte, value []byte) error
+	Get(key []byte) ([]byte, error)
+	Delete(key []byte) error
+	IncrBy(key []byte, delta int64) (int64, error)
+	IncrByFloat(key []byte, delta float64) (float64, error)
+	PutIfAbsent(key []byte, value []byte) error
+	PutIfExists(key []byte, value []byte) error
+	CompareAndSwap(key []byte, expected []byte, value []byte) error
+	DeleteIfEquals(key []byte, expected []byte) error
+	PutIfVersion(key []byte, value []byte, version uint64) error
+	DeleteIfVersion(key []byte, version uint64) error
+}
+
+// put 按请求中的条件写入
+func put(op kvOperator, r params.FastDbRequest) error {
+	key, value := []byte(r.Key), []byte(r.Value)
+	switch r.Condition {
+	case "":
+		return op.Put(key, value)
+	case params.ConditionAbsent:
+		return op.PutIfAbsent(key, value)
+	case params.ConditionExists:
+		return op.PutIfExists(key, value)
+	case params.ConditionEquals:
+		return op.CompareAndSwap(key, []byte(r.Expected), value)
+	case params.ConditionVersion:
+		return op.PutIfVersion(key, value, r.Version)
+	}
+	return common.NewErr(&common.UnknownActionErrNo, common.ErrUnknownAction)
+}
+
+// del 按请求中的条件删除，只支持 equals 与 version 条件
+func del(op kvOperator, r params.FastDbRequest) error {
+	key := []byte(r.Key)
+	switch r.Condition {
+	case "":
+		return op.Delete(key)
+	case params.ConditionEquals:
+		return op.DeleteIfEquals(key, []byte(r.Expected))
+	case params.ConditionVersion:
+		return op.DeleteIfVersion(key, r.Version)
+	}
+	return common.NewErr(&common.UnknownActionErrNo, common.ErrUnknownAction)
+}
+
+// conditionFromHeader 将 If-Match/If-None-Match 转换为请求中的条件:
+// If-None-Match: * 代表 key 不存在，If-Match: * 代表 key 存在，If-Match: "版本号" 代表版本号相等
+func conditionFromHeader(request *http.Request, r *params.FastDbRequest) error {
+	if v := request.Header.Get("If-None-Match"); v != "" {
+		if v != "*" {
+			return common.NewErr(&common.ConditionFailedErrNo, fmt.Errorf("unsupported If-None-Match: %s", v))
+		}
+		r.Condition = params.ConditionAbsent
+	}
+	if v := request.Header.Get("If-Match"); v != "" {
+		if v == "*" {
+			r.Condition = params.ConditionExists
+			return nil
+		}
+		version, e := strconv.ParseUint(strings.Trim(v, `"`), 10, 64)
+		if e != nil {
+			return common.NewErr(&common.ConditionFailedErrNo, fmt.Errorf("invalid If-Match: %s", v))
+		}
+		r.Condition = params.ConditionVersion
+		r.Version = version
+	}
+	return nil
+}
+
+func formatETag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// incr 处理 incr 请求，delta 为整数时按整数计算，否则按浮点数计算，返回新的值
+func incr(op kvOperator, key []byte, delta string) ([]byte, error) {
+	if delta == "" {
+		delta = "1"
+	}
+	if d, e := strconv.ParseInt(delta, 10, 64); e == nil {
+		val, e := op.IncrBy(key, d)
+		return []byte(strconv.FormatInt(val, 10)), e
+	}
+	d, e := strconv.ParseFloat(delta, 64)
+	if e != nil {
+		return nil, common.NewErr(&common.NotFloatErrNo, common.ErrNotFloat)
+	}
+	val, e := op.IncrByFloat(key, d)
+	return []byte(strconv.FormatFloat(val, 'f', -1, 64)), e
+}
+
+// bucketOf 返回请求中指定的 bucket，为空时使用默认 bucket 并返回 nil。
+// create 为 false 时不会创建 bucket，bucket 不存在时返回错误
+func (s *httpServer) bucketOf(name string, create bool) (*core.Bucket, error) {
+	if name == "" {
+		return nil, nil
+	}
+	if create {
+		return s.db.Bucket(name)
+	}
+	if bucket, ok := s.db.LookupBucket(name); ok {
+		return bucket, nil
+	}
+	return nil, common.NewErr(&common.BucketNotFoundErrNo, common.ErrBucketNotFound)
+}
+
+// createsBucket 只有写入数据的操作才会创建不存在的 bucket，
+// 创建 bucket 需要写入一条元数据记录，读取时写错 bucket 名称不应该留下一个新的 bucket
+func createsBucket(action string) bool {
+	return action == params.PutAction || action == params.IncrAction
+}
+
+// handleVerifyRequest GET 返回最近一次校验的结果，POST 立即校验数据文件与索引并返回结果
+func (s *httpServer) handleVerifyRequest(writer http.ResponseWriter, request *http.Request) {
+	if request.Method == http.MethodPost {
+		if _, e := s.db.Verify(); e != nil {
+			encodeReply(writer, params.MakeErrReply(e))
+			return
+		}
+	}
+
+	stats := s.db.VerifyStats()
+	reply := &params.VerifyReply{Runs: stats.Runs, Running: stats.Running}
+	if last := stats.Last; last != nil {
+		reply.StartedAt = last.StartedAt.Format(time.RFC3339)
+		reply.DurationMs = last.Duration.Milliseconds()
+		reply.Segments, reply.Records, reply.Bytes = last.Segments, last.Records, last.Bytes
+		for _, r := range last.Corrupt {
+			reply.Corrupt = append(reply.Corrupt, params.CorruptRange{
+				Segment: r.SegmentId, Offset: r.Offset, Size: r.Size, Error: r.Error})
+		}
+		for _, e := range last.IndexErrors {
+			reply.IndexErrors = append(reply.IndexErrors, params.IndexError{Bucket: e.Bucket, Key: string(e.Key),
+				Segment: e.Position.SegmentId, Offset: e.Position.FileOffset(), Error: e.Error})
+		}
+	}
+	encodeReply(writer, params.FastDbReply{Status: true, Verify: reply})
+}
+
+func handleHealthRequest(writer http.ResponseWriter, _ *http.Request) {
+	encodeReply(writer, params.MakeSuccessReply(nil))
 }
 
 func decodeRequest(request *http.Request) (params.FastDbRequest, error) {
 	var r params.FastDbRequest
-	decoder := json.NewDecoder(request.Body)
-	e := decoder.Decode(&r)
-	if e != nil {
+	if e := decodeBody(request, &r); e != nil {
 		return params.FastDbRequest{}, e
 	}
 	return r, nil
 }
 
+func decodeBody(request *http.Request, v any) error {
+	decoder := json.NewDecoder(request.Body)
+	return decoder.Decode(v)
+}
+
 func encodeReply(writer http.ResponseWriter, reply params.FastDbReply) {
 	e := json.NewEncoder(writer).Encode(reply)
 	if e != nil {
@@ -77,6 +353,9 @@ func encodeReply(writer http.ResponseWriter, reply params.FastDbReply) {
 
 func (s *httpServer) Close() {
 	print("正在关闭连接")
+	if s.server != nil {
+		_ = s.server.Close()
+	}
 	err := s.db.Close()
 	if err != nil {
 		print(err)
@@ -90,13 +369,19 @@ func (s *httpServer) Run() error {
 	}
 	s.db = db
 
-	http.HandleFunc("/single", s.handleSingleRequest)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/single", s.handleSingleRequest)
+	mux.HandleFunc("/batch", s.handleBatchRequest)
+	mux.HandleFunc("/scan", s.handleScanRequest)
+	mux.HandleFunc("/health", handleHealthRequest)
+	mux.HandleFunc("/admin/verify", s.handleVerifyRequest)
 
 	addr := fmt.Sprintf(":%d", s.options.Port)
 	fmt.Println("Running at " + addr)
 
-	err = http.ListenAndServe(addr, nil)
-	if err != nil {
+	s.server = &http.Server{Addr: addr, Handler: mux}
+	err = s.server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatal("ListenAndServe: ", err.Error())
 	}
 
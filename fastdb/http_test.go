@@ -3,14 +3,71 @@ package fastdb
 import (
 	"bytes"
 	"encoding/json"
+	"fastdb/common"
+	"fastdb/config"
 	"fastdb/fastdb/params"
+	"fastdb/interface/server"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"testing"
+	"time"
 )
 
+func TestMain(m *testing.M) {
+	options := config.ServerOptions{
+		DbOptions:    config.DefaultOptions,
+		BatchOptions: config.DefaultBatchOptions,
+		Port:         6666,
+	}
+	s := startTestServer(options)
+	code := m.Run()
+	s.Close()
+	_ = os.RemoveAll(options.DbOptions.DirPath)
+	os.Exit(code)
+}
+
+// startTestServer 在后台启动服务，并等待其可以处理请求
+func startTestServer(options config.ServerOptions) server.Server {
+	var s server.Server
+	var err error
+	if len(options.ProxyOptions.Nodes) > 0 {
+		s, err = MakeProxyServer(options)
+	} else {
+		s, err = MakeServer(options)
+	}
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		_ = s.Run()
+	}()
+
+	addr := fmt.Sprintf("localhost:%d", options.Port)
+	for i := 0; i < 100; i++ {
+		if r := doPost(addr, "/health", nil); r.Status {
+			return s
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	panic("server " + addr + " is not ready")
+}
+
+func doPost(addr string, path string, body any) params.FastDbReply {
+	pjson, _ := json.Marshal(body)
+	response, err := http.Post("http://"+addr+path, "application/json", bytes.NewReader(pjson))
+	if err != nil {
+		return params.MakeErrReply(err)
+	}
+	defer response.Body.Close()
+
+	r := params.FastDbReply{}
+	_ = json.NewDecoder(response.Body).Decode(&r)
+	return r
+}
+
 func TestHTTP_Server_Run(t *testing.T) {
 	testKey := "key"
 	testVal := "val"
@@ -25,6 +82,150 @@ func TestHTTP_Server_Run(t *testing.T) {
 	assert.Equal(t, r.Status, false)
 }
 
+func TestHTTP_Server_Batch(t *testing.T) {
+	r := doPost("localhost:6666", "/batch", params.FastDbBatchRequest{Requests: []params.FastDbRequest{
+		{Key: "batch-1", Value: "v1", Action: params.PutAction},
+		{Key: "batch-2", Value: "v2", Action: params.PutAction},
+		{Key: "batch-1", Action: params.GetAction},
+	}})
+	assert.True(t, r.Status)
+	assert.Equal(t, []params.KeyValue{{Key: "batch-1", Value: "v1"}}, r.Items)
+
+	r = doPost("localhost:6666", "/scan", params.FastDbScanRequest{Prefix: "batch-"})
+	assert.True(t, r.Status)
+	assert.Equal(t, []params.KeyValue{{Key: "batch-1", Value: "v1"}, {Key: "batch-2", Value: "v2"}}, r.Items)
+}
+
+func TestHTTP_Server_Bucket(t *testing.T) {
+	r := doPost("localhost:6666", "/batch", params.FastDbBatchRequest{Requests: []params.FastDbRequest{
+		{Key: "bucket-key", Value: "default", Action: params.PutAction},
+		{Key: "bucket-key", Value: "b1", Action: params.PutAction, Bucket: "b1"},
+	}})
+	assert.True(t, r.Status)
+
+	r = doPost("localhost:6666", "/single", params.FastDbRequest{Key: "bucket-key", Action: params.GetAction, Bucket: "b1"})
+	assert.Equal(t, "b1", r.Data)
+	r = doPost("localhost:6666", "/single", params.FastDbRequest{Key: "bucket-key", Action: params.GetAction})
+	assert.Equal(t, "default", r.Data)
+	r = doPost("localhost:6666", "/scan", params.FastDbScanRequest{Prefix: "bucket-", Bucket: "b1"})
+	assert.Equal(t, []params.KeyValue{{Key: "bucket-key", Value: "b1"}}, r.Items)
+
+	// 读取不存在的 bucket 不会创建它
+	for i := 0; i < 2; i++ {
+		r = doPost("localhost:6666", "/single", params.FastDbRequest{Key: "bucket-key", Action: params.GetAction, Bucket: "typo"})
+		assert.Equal(t, common.BucketNotFoundErrNo.Code, r.Code)
+		r = doPost("localhost:6666", "/scan", params.FastDbScanRequest{Prefix: "bucket-", Bucket: "typo"})
+		assert.Equal(t, common.BucketNotFoundErrNo.Code, r.Code)
+		r = doPost("localhost:6666", "/batch", params.FastDbBatchRequest{Requests: []params.FastDbRequest{
+			{Key: "bucket-key", Action: params.GetAction, Bucket: "typo"},
+		}})
+		assert.Equal(t, common.BucketNotFoundErrNo.Code, r.Code)
+	}
+
+	r = doPost("localhost:6666", "/single", params.FastDbRequest{Key: "incr", Action: params.IncrAction, Bucket: "b2"})
+	assert.True(t, r.Status)
+	r = doPost("localhost:6666", "/batch", params.FastDbBatchRequest{Requests: []params.FastDbRequest{
+		{Key: "bucket-key", Action: params.GetAction, Bucket: "b3"},
+		{Key: "bucket-key", Value: "b3", Action: params.PutAction, Bucket: "b3"},
+	}})
+	assert.Equal(t, common.KeyNotFoundErrNo.Code, r.Code)
+	r = doPost("localhost:6666", "/scan", params.FastDbScanRequest{Prefix: "incr", Bucket: "b2"})
+	assert.Equal(t, []params.KeyValue{{Key: "incr", Value: "1"}}, r.Items)
+}
+
+func TestHTTP_Server_MayContain(t *testing.T) {
+	r := doPost("localhost:6666", "/single", params.FastDbRequest{Key: "may-1", Value: "v", Action: params.PutAction})
+	assert.True(t, r.Status)
+	r = doPost("localhost:6666", "/single", params.FastDbRequest{Key: "may-1", Action: params.MayContainAction})
+	assert.True(t, r.Status)
+	assert.Equal(t, "true", r.Data)
+	r = doPost("localhost:6666", "/single", params.FastDbRequest{Key: "may-2", Action: params.MayContainAction})
+	assert.True(t, r.Status)
+	assert.Equal(t, "false", r.Data)
+	r = doPost("localhost:6666", "/single", params.FastDbRequest{Key: "may-1", Action: params.MayContainAction, Bucket: "missing"})
+	assert.Equal(t, common.BucketNotFoundErrNo.Code, r.Code)
+}
+
+func TestHTTP_Server_Incr(t *testing.T) {
+	r := doPost("localhost:6666", "/single", params.FastDbRequest{Key: "incr-1", Action: params.IncrAction})
+	assert.True(t, r.Status)
+	assert.Equal(t, "1", r.Data)
+	r = doPost("localhost:6666", "/single", params.FastDbRequest{Key: "incr-1", Value: "-5", Action: params.IncrAction})
+	assert.Equal(t, "-4", r.Data)
+	r = doPost("localhost:6666", "/single", params.FastDbRequest{Key: "incr-1", Value: "0.5", Action: params.IncrAction})
+	assert.Equal(t, "-3.5", r.Data)
+
+	r = doPost("localhost:6666", "/batch", params.FastDbBatchRequest{Requests: []params.FastDbRequest{
+		{Key: "incr-2", Value: "abc", Action: params.PutAction},
+		{Key: "incr-2", Action: params.IncrAction},
+	}})
+	assert.False(t, r.Status)
+	assert.Equal(t, common.NotIntegerErrNo.Code, r.Code)
+}
+
+func TestHTTP_Server_Condition(t *testing.T) {
+	r := doPost("localhost:6666", "/single", params.FastDbRequest{Key: "cond-1", Value: "v1", Action: params.PutAction, Condition: params.ConditionAbsent})
+	assert.True(t, r.Status)
+	r = doPost("localhost:6666", "/single", params.FastDbRequest{Key: "cond-1", Value: "v2", Action: params.PutAction, Condition: params.ConditionAbsent})
+	assert.Equal(t, common.ConditionFailedErrNo.Code, r.Code)
+	r = doPost("localhost:6666", "/single", params.FastDbRequest{Key: "cond-1", Value: "v2", Action: params.PutAction, Condition: params.ConditionEquals, Expected: "v1"})
+	assert.True(t, r.Status)
+
+	// 通过 ETag 与 If-Match 进行乐观并发控制
+	response := doPostWithHeader("/single", params.FastDbRequest{Key: "cond-1", Action: params.GetAction}, nil)
+	etag := response.Header.Get("ETag")
+	_ = response.Body.Close()
+	assert.NotEmpty(t, etag)
+	response = doPostWithHeader("/single", params.FastDbRequest{Key: "cond-1", Value: "v3", Action: params.PutAction}, map[string]string{"If-Match": etag})
+	r = decodeReply(response)
+	assert.True(t, r.Status)
+	response = doPostWithHeader("/single", params.FastDbRequest{Key: "cond-1", Value: "v4", Action: params.PutAction}, map[string]string{"If-Match": etag})
+	r = decodeReply(response)
+	assert.Equal(t, common.ConditionFailedErrNo.Code, r.Code)
+	response = doPostWithHeader("/single", params.FastDbRequest{Key: "cond-1", Value: "v4", Action: params.PutAction}, map[string]string{"If-None-Match": "*"})
+	r = decodeReply(response)
+	assert.Equal(t, common.ConditionFailedErrNo.Code, r.Code)
+
+	r = doGet("cond-1")
+	assert.Equal(t, "v3", r.Data)
+}
+
+func TestHTTP_Server_Verify(t *testing.T) {
+	r := doPost("localhost:6666", "/single", params.FastDbRequest{Key: "verify-1", Value: "v1", Action: params.PutAction})
+	assert.True(t, r.Status)
+
+	r = doPost("localhost:6666", "/admin/verify", nil)
+	assert.True(t, r.Status)
+	assert.NotNil(t, r.Verify)
+	assert.Greater(t, r.Verify.Runs, uint64(0))
+	assert.Greater(t, r.Verify.Records, 0)
+	assert.Empty(t, r.Verify.Corrupt)
+	assert.Empty(t, r.Verify.IndexErrors)
+
+	response, err := http.Get("http://localhost:6666/admin/verify")
+	assert.Nil(t, err)
+	stats := decodeReply(response)
+	assert.Equal(t, r.Verify.Runs, stats.Verify.Runs)
+}
+
+func doPostWithHeader(path string, body any, header map[string]string) *http.Response {
+	data, _ := json.Marshal(body)
+	request, _ := http.NewRequest("POST", "http://localhost:6666"+path, bytes.NewReader(data))
+	request.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		request.Header.Set(k, v)
+	}
+	response, _ := http.DefaultClient.Do(request)
+	return response
+}
+
+func decodeReply(response *http.Response) params.FastDbReply {
+	defer response.Body.Close()
+	r := params.FastDbReply{}
+	_ = json.NewDecoder(response.Body).Decode(&r)
+	return r
+}
+
 func doGet(key string) params.FastDbReply {
 	p := params.FastDbRequest{
 		Key:    key,
@@ -36,7 +237,10 @@ func doGet(key string) params.FastDbReply {
 	request.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{}
-	response, _ := client.Do(request)
+	response, err := client.Do(request)
+	if err != nil {
+		return params.MakeErrReply(err)
+	}
 	defer response.Body.Close()
 	body, _ := ioutil.ReadAll(response.Body)
 	fmt.Printf(string(body))
@@ -58,7 +262,10 @@ func doPut(key string, val string) params.FastDbReply {
 	request.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{}
-	response, _ := client.Do(request)
+	response, err := client.Do(request)
+	if err != nil {
+		return params.MakeErrReply(err)
+	}
 	defer response.Body.Close()
 	body, _ := ioutil.ReadAll(response.Body)
 	fmt.Printf("doPut:" + string(body))
@@ -79,7 +286,10 @@ func doDelete(key string) params.FastDbReply {
 	request.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{}
-	response, _ := client.Do(request)
+	response, err := client.Do(request)
+	if err != nil {
+		return params.MakeErrReply(err)
+	}
 	defer response.Body.Close()
 	body, _ := ioutil.ReadAll(response.Body)
 	fmt.Printf("doDelete:" + string(body))
@@ -2,11 +2,51 @@ package params
 
 import "fastdb/common"
 
+type KeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
 type FastDbReply struct {
-	Status bool   `json:"status"`
-	Code   int    `json:"code"`
-	Msg    string `json:"msg"`
-	Data   string `json:"data"`
+	Status bool       `json:"status"`
+	Code   int        `json:"code"`
+	Msg    string     `json:"msg"`
+	Data   string     `json:"data"`
+	Items  []KeyValue `json:"items,omitempty"`
+	// Version get 返回的 key 的版本号，同时通过 ETag 头返回
+	Version uint64 `json:"version,omitempty"`
+	// Verify /admin/verify 返回的校验状态
+	Verify *VerifyReply `json:"verify,omitempty"`
+}
+
+// CorruptRange 数据文件中无法通过校验的区间
+type CorruptRange struct {
+	Segment uint32 `json:"segment"`
+	Offset  int64  `json:"offset"`
+	Size    int64  `json:"size"`
+	Error   string `json:"error"`
+}
+
+// IndexError 索引中无法读取或者与 key 不一致的位置
+type IndexError struct {
+	Bucket  string `json:"bucket,omitempty"`
+	Key     string `json:"key"`
+	Segment uint32 `json:"segment"`
+	Offset  int64  `json:"offset"`
+	Error   string `json:"error"`
+}
+
+type VerifyReply struct {
+	Runs    uint64 `json:"runs"`
+	Running bool   `json:"running"`
+	// 以下为最近一次完成的校验结果，从未校验时为空
+	StartedAt   string         `json:"started_at,omitempty"`
+	DurationMs  int64          `json:"duration_ms,omitempty"`
+	Segments    int            `json:"segments,omitempty"`
+	Records     int            `json:"records,omitempty"`
+	Bytes       int64          `json:"bytes,omitempty"`
+	Corrupt     []CorruptRange `json:"corrupt,omitempty"`
+	IndexErrors []IndexError   `json:"index_errors,omitempty"`
 }
 
 func MakeErrReply(err error) FastDbReply {
@@ -23,3 +63,10 @@ func MakeSuccessReply(val []byte) FastDbReply {
 		Data:   string(val),
 	}
 }
+
+func MakeItemsReply(items []KeyValue) FastDbReply {
+	return FastDbReply{
+		Status: true,
+		Items:  items,
+	}
+}
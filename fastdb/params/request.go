@@ -4,10 +4,44 @@ const (
 	GetAction    = "get"
 	PutAction    = "put"
 	DeleteAction = "delete"
+	// IncrAction 将 key 的值加上 Value，Value 为空时加 1，Value 为小数时按浮点数计算
+	IncrAction = "incr"
+	// MayContainAction 查询 bucket 的布隆过滤器，Data 为 "false" 时 key 一定不存在，为 "true" 时 key 可能存在
+	MayContainAction = "maycontain"
+)
+
+// put 与 delete 的写入条件，条件不满足时返回 ConditionFailedErrNo
+const (
+	// ConditionAbsent 只有 key 不存在时才写入
+	ConditionAbsent = "absent"
+	// ConditionExists 只有 key 存在时才写入
+	ConditionExists = "exists"
+	// ConditionEquals 只有 key 的值等于 Expected 时才写入
+	ConditionEquals = "equals"
+	// ConditionVersion 只有 key 的版本号等于 Version 时才写入，Version 为 0 代表 key 不存在
+	ConditionVersion = "version"
 )
 
 type FastDbRequest struct {
 	Key    string `json:"key"`
 	Value  string `json:"value"`
 	Action string `json:"action"`
+	// Bucket 为空时使用默认 bucket
+	Bucket string `json:"bucket,omitempty"`
+	// Condition 为空时无条件写入
+	Condition string `json:"condition,omitempty"`
+	Expected  string `json:"expected,omitempty"`
+	Version   uint64 `json:"version,omitempty"`
+}
+
+// FastDbBatchRequest 在一个 batch 中原子地执行多个操作
+type FastDbBatchRequest struct {
+	Requests []FastDbRequest `json:"requests"`
+}
+
+// FastDbScanRequest 按 key 的升序返回以 Prefix 为前缀的数据，Limit <= 0 时不限制数量
+type FastDbScanRequest struct {
+	Prefix string `json:"prefix"`
+	Limit  int    `json:"limit"`
+	Bucket string `json:"bucket,omitempty"`
 }
@@ -0,0 +1,246 @@
+package fastdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fastdb/common"
+	"fastdb/config"
+	"fastdb/fastdb/params"
+	"fastdb/interface/server"
+	"fastdb/lib/consistenthash"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// proxyServer 对外提供与 httpServer 相同的协议，
+// 通过一致性哈希把 key 路由到后端的 fastdb 节点
+type proxyServer struct {
+	options config.ServerOptions
+	ring    *consistenthash.Ring
+	client  *http.Client
+	server  *http.Server
+	closeCh chan struct{}
+}
+
+func MakeProxyServer(options config.ServerOptions) (server.Server, error) {
+	if len(options.ProxyOptions.Nodes) == 0 {
+		return nil, errors.New("proxy backend nodes is empty")
+	}
+	ring := consistenthash.New(options.ProxyOptions.VirtualNodes, nil)
+	ring.Add(options.ProxyOptions.Nodes...)
+	return &proxyServer{
+		options: options,
+		ring:    ring,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		closeCh: make(chan struct{}),
+	}, nil
+}
+
+func (p *proxyServer) handleSingleRequest(writer http.ResponseWriter, request *http.Request) {
+	r, e := decodeRequest(request)
+	if e == nil {
+		// 条件头转换为请求中的条件后再转发
+		e = conditionFromHeader(request, &r)
+	}
+	if e != nil {
+		encodeReply(writer, params.MakeErrReply(e))
+		return
+	}
+	node := p.ring.Get([]byte(r.Key))
+	if node == "" {
+		encodeReply(writer, params.MakeErrReply(common.NewErr(&common.NoAvailableNodeErrNo, common.ErrNoAvailableNode)))
+		return
+	}
+
+	var reply params.FastDbReply
+	if e = p.forward(node, "/single", r, &reply); e != nil {
+		encodeReply(writer, params.MakeErrReply(e))
+		return
+	}
+	if reply.Version != 0 {
+		writer.Header().Set("ETag", formatETag(reply.Version))
+	}
+	encodeReply(writer, reply)
+}
+
+// handleBatchRequest 按节点拆分 batch，每个节点上的操作是原子的，
+// 但跨节点的 batch 不具备原子性，某个节点失败时其他节点可能已经提交
+func (p *proxyServer) handleBatchRequest(writer http.ResponseWriter, request *http.Request) {
+	var r params.FastDbBatchRequest
+	if e := decodeBody(request, &r); e != nil {
+		encodeReply(writer, params.MakeErrReply(e))
+		return
+	}
+
+	groups := make(map[string]*params.FastDbBatchRequest)
+	// getIndexes 记录每个节点上的 get 操作在原始请求中的位置，用于按原顺序返回结果
+	getIndexes := make(map[string][]int)
+	getCount := 0
+	for _, req := range r.Requests {
+		node := p.ring.Get([]byte(req.Key))
+		if node == "" {
+			encodeReply(writer, params.MakeErrReply(common.NewErr(&common.NoAvailableNodeErrNo, common.ErrNoAvailableNode)))
+			return
+		}
+		if groups[node] == nil {
+			groups[node] = &params.FastDbBatchRequest{}
+		}
+		groups[node].Requests = append(groups[node].Requests, req)
+		// get 与 incr 会在结果中返回一项
+		if req.Action == params.GetAction || req.Action == params.IncrAction {
+			getIndexes[node] = append(getIndexes[node], getCount)
+			getCount++
+		}
+	}
+
+	items := make([]params.KeyValue, getCount)
+	for node, group := range groups {
+		var reply params.FastDbReply
+		if e := p.forward(node, "/batch", group, &reply); e != nil {
+			encodeReply(writer, params.MakeErrReply(e))
+			return
+		}
+		if !reply.Status {
+			encodeReply(writer, reply)
+			return
+		}
+		for i, item := range reply.Items {
+			items[getIndexes[node][i]] = item
+		}
+	}
+	encodeReply(writer, params.MakeItemsReply(items))
+}
+
+// handleScanRequest 向所有节点并发发送 scan 请求，再按 key 归并排序
+func (p *proxyServer) handleScanRequest(writer http.ResponseWriter, request *http.Request) {
+	var r params.FastDbScanRequest
+	if e := decodeBody(request, &r); e != nil {
+		encodeReply(writer, params.MakeErrReply(e))
+		return
+	}
+
+	nodes := p.ring.Nodes()
+	if len(nodes) == 0 {
+		encodeReply(writer, params.MakeErrReply(common.NewErr(&common.NoAvailableNodeErrNo, common.ErrNoAvailableNode)))
+		return
+	}
+	replies := make([]params.FastDbReply, len(nodes))
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node string) {
+			defer wg.Done()
+			errs[i] = p.forward(node, "/scan", r, &replies[i])
+		}(i, node)
+	}
+	wg.Wait()
+
+	var items []params.KeyValue
+	for i := range nodes {
+		if errs[i] != nil {
+			encodeReply(writer, params.MakeErrReply(errs[i]))
+			return
+		}
+		if !replies[i].Status {
+			encodeReply(writer, replies[i])
+			return
+		}
+		items = append(items, replies[i].Items...)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Key < items[j].Key
+	})
+	if r.Limit > 0 && len(items) > r.Limit {
+		items = items[:r.Limit]
+	}
+	encodeReply(writer, params.MakeItemsReply(items))
+}
+
+func (p *proxyServer) forward(node string, path string, body any, reply *params.FastDbReply) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	response, err := p.client.Post("http://"+node+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return common.NewErr(&common.NoAvailableNodeErrNo, err)
+	}
+	defer response.Body.Close()
+	return json.NewDecoder(response.Body).Decode(reply)
+}
+
+// healthCheck 定期探测所有配置的后端节点，不健康的节点从哈希环上摘除，恢复后重新加入
+func (p *proxyServer) healthCheck() {
+	interval := p.options.ProxyOptions.HealthCheckInterval
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.checkNodes()
+		case <-p.closeCh:
+			return
+		}
+	}
+}
+
+func (p *proxyServer) checkNodes() {
+	for _, node := range p.options.ProxyOptions.Nodes {
+		healthy := p.isHealthy(node)
+		if healthy && !p.ring.Has(node) {
+			p.ring.Add(node)
+		} else if !healthy && p.ring.Has(node) {
+			p.ring.Remove(node)
+		}
+	}
+}
+
+func (p *proxyServer) isHealthy(node string) bool {
+	response, err := p.client.Get("http://" + node + "/health")
+	if err != nil {
+		return false
+	}
+	defer response.Body.Close()
+	var reply params.FastDbReply
+	if err = json.NewDecoder(response.Body).Decode(&reply); err != nil {
+		return false
+	}
+	return reply.Status
+}
+
+func (p *proxyServer) Close() {
+	print("正在关闭代理")
+	close(p.closeCh)
+	if p.server != nil {
+		_ = p.server.Close()
+	}
+}
+
+func (p *proxyServer) Run() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/single", p.handleSingleRequest)
+	mux.HandleFunc("/batch", p.handleBatchRequest)
+	mux.HandleFunc("/scan", p.handleScanRequest)
+	mux.HandleFunc("/health", handleHealthRequest)
+
+	go p.healthCheck()
+
+	addr := fmt.Sprintf(":%d", p.options.Port)
+	fmt.Println("Proxy running at " + addr)
+
+	p.server = &http.Server{Addr: addr, Handler: mux}
+	err := p.server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatal("ListenAndServe: ", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package fastdb
+
+import (
+	"fastdb/config"
+	"fastdb/fastdb/params"
+	"fastdb/interface/server"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+const proxyAddr = "localhost:6700"
+
+func startTestBackends(t *testing.T, ports ...uint16) ([]server.Server, []string) {
+	var servers []server.Server
+	var addrs []string
+	for _, port := range ports {
+		dir, err := os.MkdirTemp("", "fastdb-proxy")
+		assert.Nil(t, err)
+		options := config.ServerOptions{
+			DbOptions:    config.DefaultOptions,
+			BatchOptions: config.DefaultBatchOptions,
+			Port:         port,
+		}
+		options.DbOptions.DirPath = dir
+		servers = append(servers, startTestServer(options))
+		addrs = append(addrs, fmt.Sprintf("localhost:%d", port))
+		t.Cleanup(func() {
+			_ = os.RemoveAll(dir)
+		})
+	}
+	return servers, addrs
+}
+
+func TestProxy_Server(t *testing.T) {
+	backends, addrs := startTestBackends(t, 6701, 6702, 6703)
+	options := config.ServerOptions{
+		ProxyOptions: config.DefaultProxyOptions,
+		Port:         6700,
+	}
+	options.ProxyOptions.Nodes = addrs
+	options.ProxyOptions.HealthCheckInterval = 20 * time.Millisecond
+	proxy := startTestServer(options)
+	defer proxy.Close()
+
+	// 数据通过代理写入，并分散在各个后端节点上
+	for i := 0; i < 60; i++ {
+		key := fmt.Sprintf("proxy-key-%03d", i)
+		r := doPost(proxyAddr, "/single", params.FastDbRequest{Key: key, Value: key, Action: params.PutAction})
+		assert.True(t, r.Status)
+	}
+	total := 0
+	for _, addr := range addrs {
+		r := doPost(addr, "/scan", params.FastDbScanRequest{Prefix: "proxy-key-"})
+		assert.True(t, r.Status)
+		assert.NotEmpty(t, r.Items)
+		total += len(r.Items)
+	}
+	assert.Equal(t, 60, total)
+
+	r := doPost(proxyAddr, "/single", params.FastDbRequest{Key: "proxy-key-007", Action: params.GetAction})
+	assert.True(t, r.Status)
+	assert.Equal(t, "proxy-key-007", r.Data)
+
+	// maycontain 被路由到 key 所在的节点
+	r = doPost(proxyAddr, "/single", params.FastDbRequest{Key: "proxy-key-007", Action: params.MayContainAction})
+	assert.True(t, r.Status)
+	assert.Equal(t, "true", r.Data)
+	r = doPost(proxyAddr, "/single", params.FastDbRequest{Key: "proxy-missing", Action: params.MayContainAction})
+	assert.True(t, r.Status)
+	assert.Equal(t, "false", r.Data)
+
+	// scan 从所有节点收集后按 key 排序
+	r = doPost(proxyAddr, "/scan", params.FastDbScanRequest{Prefix: "proxy-key-", Limit: 5})
+	assert.True(t, r.Status)
+	assert.Equal(t, 5, len(r.Items))
+	for i, item := range r.Items {
+		assert.Equal(t, fmt.Sprintf("proxy-key-%03d", i), item.Key)
+	}
+
+	// 跨节点的 batch 按原始顺序返回 get 的结果
+	r = doPost(proxyAddr, "/batch", params.FastDbBatchRequest{Requests: []params.FastDbRequest{
+		{Key: "proxy-key-001", Action: params.GetAction},
+		{Key: "proxy-key-100", Value: "v100", Action: params.PutAction},
+		{Key: "proxy-key-002", Action: params.GetAction},
+		{Key: "proxy-key-003", Action: params.DeleteAction},
+		{Key: "proxy-key-100", Action: params.GetAction},
+	}})
+	assert.True(t, r.Status)
+	assert.Equal(t, []params.KeyValue{
+		{Key: "proxy-key-001", Value: "proxy-key-001"},
+		{Key: "proxy-key-002", Value: "proxy-key-002"},
+		{Key: "proxy-key-100", Value: "v100"},
+	}, r.Items)
+	r = doPost(proxyAddr, "/single", params.FastDbRequest{Key: "proxy-key-003", Action: params.GetAction})
+	assert.False(t, r.Status)
+
+	// 后端节点下线后，健康检查将其从哈希环上摘除，剩余的 key 仍然可以访问
+	backends[2].Close()
+	ring := proxy.(*proxyServer).ring
+	deadline := time.Now().Add(5 * time.Second)
+	for ring.Has(addrs[2]) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, ring.Has(addrs[2]))
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("proxy-new-key-%03d", i)
+		r = doPost(proxyAddr, "/single", params.FastDbRequest{Key: key, Value: key, Action: params.PutAction})
+		assert.True(t, r.Status)
+		r = doPost(proxyAddr, "/single", params.FastDbRequest{Key: key, Action: params.GetAction})
+		assert.Equal(t, key, r.Data)
+	}
+	backends[0].Close()
+	backends[1].Close()
+}
@@ -0,0 +1,104 @@
+package index
+
+import (
+	"bytes"
+	"fastdb/wal"
+	"github.com/google/btree"
+	"sync"
+)
+
+// btreeDegree B 树每个节点的度
+const btreeDegree = 32
+
+func lessItem(a, b indexItem) bool {
+	return bytes.Compare(a.key, b.key) < 0
+}
+
+// BTreeIndexer 有序的 B 树索引，写入时原地修改节点。
+// 迭代器持有写时复制的克隆，克隆之后的修改只会复制被修改的节点
+type BTreeIndexer struct {
+	tree *btree.BTreeG[indexItem]
+	lock sync.RWMutex
+}
+
+func newBTree() *BTreeIndexer {
+	return &BTreeIndexer{tree: btree.NewG[indexItem](btreeDegree, lessItem)}
+}
+
+func (bt *BTreeIndexer) Put(key []byte, position *wal.ChunkPosition) *wal.ChunkPosition {
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	old, ok := bt.tree.ReplaceOrInsert(indexItem{key: key, position: position})
+	if !ok {
+		return nil
+	}
+	return old.position
+}
+
+func (bt *BTreeIndexer) Get(key []byte) *wal.ChunkPosition {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
+	item, ok := bt.tree.Get(indexItem{key: key})
+	if !ok {
+		return nil
+	}
+	return item.position
+}
+
+func (bt *BTreeIndexer) Delete(key []byte) (*wal.ChunkPosition, bool) {
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	item, ok := bt.tree.Delete(indexItem{key: key})
+	return item.position, ok
+}
+
+// Apply 在一次写锁中应用所有修改
+func (bt *BTreeIndexer) Apply(mutations []Mutation) []*wal.ChunkPosition {
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	olds := make([]*wal.ChunkPosition, len(mutations))
+	for i, mutation := range mutations {
+		var old indexItem
+		if mutation.Delete {
+			old, _ = bt.tree.Delete(indexItem{key: mutation.Key})
+		} else {
+			old, _ = bt.tree.ReplaceOrInsert(indexItem{key: mutation.Key, position: mutation.Position})
+		}
+		olds[i] = old.position
+	}
+	return olds
+}
+
+func (bt *BTreeIndexer) Size() int {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
+	return bt.tree.Len()
+}
+
+func (bt *BTreeIndexer) Iterator(options IteratorOptions) Iterator {
+	bt.lock.Lock()
+	tree := bt.tree.Clone()
+	bt.lock.Unlock()
+
+	return newOrderedIterator(options, func(start []byte, inclusive bool, reverse bool, n int) []indexItem {
+		items := make([]indexItem, 0, n)
+		visit := func(item indexItem) bool {
+			if !inclusive && bytes.Equal(item.key, start) {
+				return true
+			}
+			items = append(items, item)
+			return len(items) < n
+		}
+		switch {
+		case start == nil && !reverse:
+			tree.Ascend(visit)
+		case start == nil:
+			tree.Descend(visit)
+		case !reverse:
+			tree.AscendGreaterOrEqual(indexItem{key: start}, visit)
+		default:
+			tree.DescendLessOrEqual(indexItem{key: start}, visit)
+		}
+		return items
+	})
+}
@@ -0,0 +1,358 @@
+package index
+
+import (
+	"bytes"
+	"fastdb/wal"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// indexerTypes 所有 Indexer 的实现都必须通过这里的一致性测试
+var indexerTypes = map[string]IndexerType{
+	"radix":   RadixTree,
+	"btree":   BTree,
+	"hashmap": HashMap,
+}
+
+func forEachIndexer(t *testing.T, fn func(t *testing.T, newIndexer func() Indexer)) {
+	for name, typ := range indexerTypes {
+		typ := typ
+		t.Run(name, func(t *testing.T) {
+			fn(t, func() Indexer {
+				return NewIndexer(typ)
+			})
+		})
+	}
+	t.Run("disk", func(t *testing.T) {
+		store := openTestDiskStore(t)
+		var id uint32
+		fn(t, func() Indexer {
+			id++
+			return store.Indexer(id)
+		})
+	})
+}
+
+func openTestDiskStore(t testing.TB) *DiskStore {
+	store, err := OpenDiskStore(filepath.Join(t.TempDir(), "index.db"))
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+	return store
+}
+
+func testPosition(i int) *wal.ChunkPosition {
+	return &wal.ChunkPosition{SegmentId: 1, BlockNumber: uint32(i), ChunkOffset: int64(i), ChunkSize: uint32(i)}
+}
+
+func collect(iter Iterator) []string {
+	var keys []string
+	for ; iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	return keys
+}
+
+// expectedKeys 按照 Iterator 的约定计算期望的结果: 只包含以 prefix 为前缀的 key，
+// 升序时从第一个大于等于 seek 的 key 开始，降序时从最后一个小于等于 seek 的 key 开始
+func expectedKeys(keys []string, prefix string, reverse bool, seek *string) []string {
+	var result []string
+	for _, key := range keys {
+		if !bytes.HasPrefix([]byte(key), []byte(prefix)) {
+			continue
+		}
+		if seek != nil && !reverse && key < *seek {
+			continue
+		}
+		if seek != nil && reverse && key > *seek {
+			continue
+		}
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	if reverse {
+		for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+			result[i], result[j] = result[j], result[i]
+		}
+	}
+	return result
+}
+
+func TestIndexer_PutGetDelete(t *testing.T) {
+	forEachIndexer(t, func(t *testing.T, newIndexer func() Indexer) {
+		idx := newIndexer()
+		assert.Nil(t, idx.Get([]byte("a")))
+		assert.Nil(t, idx.Put([]byte("a"), testPosition(1)))
+		assert.Equal(t, testPosition(1), idx.Put([]byte("a"), testPosition(2)))
+		assert.Equal(t, testPosition(2), idx.Get([]byte("a")))
+		assert.Nil(t, idx.Put([]byte("b"), testPosition(3)))
+		assert.Equal(t, 2, idx.Size())
+
+		old, ok := idx.Delete([]byte("a"))
+		assert.True(t, ok)
+		assert.Equal(t, testPosition(2), old)
+		old, ok = idx.Delete([]byte("a"))
+		assert.False(t, ok)
+		assert.Nil(t, old)
+		assert.Nil(t, idx.Get([]byte("a")))
+		assert.Equal(t, 1, idx.Size())
+	})
+}
+
+func TestIndexer_Iterator(t *testing.T) {
+	// 包含空前缀、互为前缀以及 0xff 结尾的 key，覆盖前缀边界
+	var keys []string
+	for i := 0; i < 300; i++ {
+		keys = append(keys, fmt.Sprintf("key-%03d", i))
+	}
+	keys = append(keys, "k", "key", "key-", "key-1", "key-1\xff", "key-1\xff\xff", "kez", "a", "\xff", "\xff\xff")
+
+	prefixes := []string{"", "key-", "key-1", "key-1\xff", "key-2", "kez", "\xff", "missing"}
+	seeks := []string{"", "a", "key-", "key-150", "key-1\xff", "key-2", "key-299", "kez", "zzz", "\xff\xff\xff"}
+
+	forEachIndexer(t, func(t *testing.T, newIndexer func() Indexer) {
+		idx := newIndexer()
+		for i, key := range keys {
+			idx.Put([]byte(key), testPosition(i))
+		}
+		assert.Equal(t, len(keys), idx.Size())
+
+		for _, prefix := range prefixes {
+			for _, reverse := range []bool{false, true} {
+				options := IteratorOptions{Prefix: []byte(prefix), Reverse: reverse}
+				iter := idx.Iterator(options)
+				assert.Equal(t, expectedKeys(keys, prefix, reverse, nil), collect(iter),
+					"prefix=%q reverse=%v", prefix, reverse)
+
+				iter.Rewind()
+				assert.Equal(t, expectedKeys(keys, prefix, reverse, nil), collect(iter),
+					"rewind prefix=%q reverse=%v", prefix, reverse)
+
+				for _, seek := range seeks {
+					seek := seek
+					iter.Seek([]byte(seek))
+					assert.Equal(t, expectedKeys(keys, prefix, reverse, &seek), collect(iter),
+						"prefix=%q reverse=%v seek=%q", prefix, reverse, seek)
+				}
+				iter.Close()
+				assert.False(t, iter.Valid())
+			}
+		}
+
+		// Value 与 Key 一一对应
+		iter := idx.Iterator(IteratorOptions{Prefix: []byte("key-00")})
+		for ; iter.Valid(); iter.Next() {
+			var i int
+			_, _ = fmt.Sscanf(string(iter.Key()), "key-%03d", &i)
+			assert.Equal(t, testPosition(i), iter.Value())
+		}
+		iter.Close()
+	})
+}
+
+func TestIndexer_IteratorSnapshot(t *testing.T) {
+	forEachIndexer(t, func(t *testing.T, newIndexer func() Indexer) {
+		idx := newIndexer()
+		if _, ok := idx.(*DiskBTreeIndexer); ok {
+			t.Skip("DiskBTreeIndexer 的迭代器每一页使用单独的只读事务，不是快照")
+		}
+		for i := 0; i < 1000; i++ {
+			idx.Put([]byte(fmt.Sprintf("key-%04d", i)), testPosition(i))
+		}
+		iter := idx.Iterator(IteratorOptions{})
+		defer iter.Close()
+
+		// 创建迭代器之后的修改对迭代器不可见
+		for i := 0; i < 1000; i += 2 {
+			idx.Delete([]byte(fmt.Sprintf("key-%04d", i)))
+		}
+		idx.Put([]byte("key-0001"), testPosition(5000))
+		idx.Put([]byte("new"), testPosition(5001))
+
+		var count int
+		for ; iter.Valid(); iter.Next() {
+			assert.Equal(t, fmt.Sprintf("key-%04d", count), string(iter.Key()))
+			assert.Equal(t, testPosition(count), iter.Value())
+			count++
+		}
+		assert.Equal(t, 1000, count)
+		assert.Equal(t, 501, idx.Size())
+	})
+}
+
+func TestIndexer_Concurrent(t *testing.T) {
+	forEachIndexer(t, func(t *testing.T, newIndexer func() Indexer) {
+		idx := newIndexer()
+		var wg sync.WaitGroup
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < 500; i++ {
+					key := []byte(fmt.Sprintf("key-%d-%d", w, i))
+					idx.Put(key, testPosition(i))
+					assert.Equal(t, testPosition(i), idx.Get(key))
+					if i%10 == 0 {
+						iter := idx.Iterator(IteratorOptions{Prefix: []byte(fmt.Sprintf("key-%d-", w))})
+						assert.Len(t, collect(iter), i+1)
+						iter.Close()
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+		assert.Equal(t, 2000, idx.Size())
+	})
+}
+
+func TestIndexer_Apply(t *testing.T) {
+	forEachIndexer(t, func(t *testing.T, newIndexer func() Indexer) {
+		idx := newIndexer()
+		idx.Put([]byte("a"), testPosition(1))
+		idx.Put([]byte("b"), testPosition(2))
+
+		olds := idx.Apply([]Mutation{
+			{Key: []byte("a"), Position: testPosition(3)},
+			{Key: []byte("b"), Delete: true},
+			{Key: []byte("c"), Position: testPosition(4)},
+			{Key: []byte("c"), Position: testPosition(5)},
+			{Key: []byte("missing"), Delete: true},
+		})
+		assert.Equal(t, []*wal.ChunkPosition{testPosition(1), testPosition(2), nil, testPosition(4), nil}, olds)
+		assert.Equal(t, testPosition(3), idx.Get([]byte("a")))
+		assert.Nil(t, idx.Get([]byte("b")))
+		assert.Equal(t, testPosition(5), idx.Get([]byte("c")))
+		assert.Equal(t, 2, idx.Size())
+		assert.Empty(t, idx.Apply(nil))
+
+		// 读取方只能看到完整的 batch
+		var wg sync.WaitGroup
+		var stopped atomic.Bool
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stopped.Load() {
+				iter := idx.Iterator(IteratorOptions{Prefix: []byte("batch-")})
+				values := make(map[uint32]int)
+				for ; iter.Valid(); iter.Next() {
+					values[iter.Value().BlockNumber]++
+				}
+				iter.Close()
+				assert.LessOrEqual(t, len(values), 1)
+			}
+		}()
+		for round := 1; round <= 100; round++ {
+			mutations := make([]Mutation, 20)
+			for i := range mutations {
+				mutations[i] = Mutation{Key: []byte(fmt.Sprintf("batch-%02d", i)), Position: testPosition(round)}
+			}
+			idx.Apply(mutations)
+		}
+		stopped.Store(true)
+		wg.Wait()
+		assert.Equal(t, 22, idx.Size())
+	})
+}
+
+func benchmarkKeys(n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("bench-key-%09d", rand.Intn(n*10)))
+	}
+	return keys
+}
+
+func forEachIndexerBench(b *testing.B, fn func(b *testing.B, idx Indexer)) {
+	for _, name := range []string{"radix", "btree", "hashmap"} {
+		typ := indexerTypes[name]
+		b.Run(name, func(b *testing.B) {
+			fn(b, NewIndexer(typ))
+		})
+	}
+}
+
+func BenchmarkIndexer_Put(b *testing.B) {
+	keys := benchmarkKeys(100000)
+	position := testPosition(1)
+	forEachIndexerBench(b, func(b *testing.B, idx Indexer) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			idx.Put(keys[i%len(keys)], position)
+		}
+	})
+}
+
+func BenchmarkIndexer_Get(b *testing.B) {
+	keys := benchmarkKeys(100000)
+	position := testPosition(1)
+	forEachIndexerBench(b, func(b *testing.B, idx Indexer) {
+		for _, key := range keys {
+			idx.Put(key, position)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			idx.Get(keys[i%len(keys)])
+		}
+	})
+}
+
+func BenchmarkIndexer_ParallelGet(b *testing.B) {
+	keys := benchmarkKeys(100000)
+	position := testPosition(1)
+	forEachIndexerBench(b, func(b *testing.B, idx Indexer) {
+		for _, key := range keys {
+			idx.Put(key, position)
+		}
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			i := rand.Intn(len(keys))
+			for pb.Next() {
+				idx.Get(keys[i%len(keys)])
+				i++
+			}
+		})
+	})
+}
+
+func BenchmarkIndexer_Iterate(b *testing.B) {
+	keys := benchmarkKeys(100000)
+	position := testPosition(1)
+	forEachIndexerBench(b, func(b *testing.B, idx Indexer) {
+		for _, key := range keys {
+			idx.Put(key, position)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			iter := idx.Iterator(IteratorOptions{Prefix: []byte("bench-key-00001")})
+			for ; iter.Valid(); iter.Next() {
+			}
+			iter.Close()
+		}
+	})
+}
+
+func BenchmarkIndexer_Apply(b *testing.B) {
+	keys := benchmarkKeys(100000)
+	position := testPosition(1)
+	forEachIndexerBench(b, func(b *testing.B, idx Indexer) {
+		mutations := make([]Mutation, 1000)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for j := range mutations {
+				mutations[j] = Mutation{Key: keys[(i*len(mutations)+j)%len(keys)], Position: position}
+			}
+			idx.Apply(mutations)
+		}
+	})
+}
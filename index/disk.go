@@ -0,0 +1,366 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fastdb/wal"
+	bolt "go.etcd.io/bbolt"
+	"sync"
+	"time"
+)
+
+var (
+	// diskMetaBucket 保存每个索引的 key 数量以及调用方通过 Commit 提交的状态
+	diskMetaBucket = []byte("meta")
+	diskStateKey   = []byte("state")
+	// diskExtraPrefix 调用方通过 PutExtra 保存的附加数据的 key 前缀
+	diskExtraPrefix = "extra-"
+	// diskIndexPrefix 每个索引对应的 bolt bucket 名称的前缀，之后是 4 个字节的索引 id
+	diskIndexPrefix = []byte("index-")
+
+	ErrDiskIndexTxStarted  = errors.New("disk index transaction already started")
+	ErrDiskIndexTxNotFound = errors.New("disk index transaction not started")
+	ErrDiskKeyTooLarge     = errors.New("the key is too large for the disk index")
+)
+
+// MaxDiskKeySize DiskBTreeIndexer 中 key 的最大长度
+const MaxDiskKeySize = bolt.MaxKeySize
+
+// DiskStore 保存在磁盘上的 B+ 树索引文件，底层是 bbolt：
+// 页面通过 mmap 由操作系统的页缓存缓存，修改时复制页面，事务提交是原子的，
+// 进程或者机器崩溃之后文件依然处于最后一次提交的状态。
+// 一个文件中可以保存多个 DiskBTreeIndexer，每个对应文件中的一个 bolt bucket
+type DiskStore struct {
+	db *bolt.DB
+	// mu 保护 tx 与 err，bolt 的事务不能被多个 goroutine 同时使用
+	mu sync.Mutex
+	// tx Begin 开始的写事务，为 nil 时每次修改都在单独的事务中提交
+	tx *bolt.Tx
+	// err 第一次写入失败的错误，之后索引中的数据可能不完整，所有的提交都会返回该错误
+	err error
+}
+
+// OpenDiskStore 打开 path 处的索引文件，不存在时创建
+func OpenDiskStore(path string) (*DiskStore, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(diskMetaBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &DiskStore{db: db}, nil
+}
+
+// Indexer 返回 id 对应的索引，索引中的数据在第一次写入时创建
+func (s *DiskStore) Indexer(id uint32) *DiskBTreeIndexer {
+	name := make([]byte, len(diskIndexPrefix)+4)
+	copy(name, diskIndexPrefix)
+	binary.BigEndian.PutUint32(name[len(diskIndexPrefix):], id)
+	return &DiskBTreeIndexer{store: s, name: name}
+}
+
+// Begin 开始一个写事务，之后所有索引的修改都在这个事务中进行，直到 Commit 或者 Rollback。
+// 事务期间的读取也使用这个事务，可以读到尚未提交的修改
+func (s *DiskStore) Begin() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if s.tx != nil {
+		return ErrDiskIndexTxStarted
+	}
+	tx, err := s.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	s.tx = tx
+	return nil
+}
+
+// Commit 将 state 与事务中的修改一起原子地提交，state 之后可以通过 State 读取
+func (s *DiskStore) Commit(state []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx := s.tx
+	if tx == nil {
+		return ErrDiskIndexTxNotFound
+	}
+	s.tx = nil
+	if s.err != nil {
+		_ = tx.Rollback()
+		return s.err
+	}
+
+	err := tx.Bucket(diskMetaBucket).Put(diskStateKey, state)
+	if err != nil {
+		_ = tx.Rollback()
+	} else {
+		err = tx.Commit()
+	}
+	s.err = err
+	return err
+}
+
+// Rollback 放弃事务中的所有修改
+func (s *DiskStore) Rollback() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tx != nil {
+		_ = s.tx.Rollback()
+		s.tx = nil
+	}
+}
+
+// State 返回最后一次 Commit 提交的状态，从未提交过时返回 nil
+func (s *DiskStore) State() ([]byte, error) {
+	var state []byte
+	err := s.view(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(diskMetaBucket).Get(diskStateKey); value != nil {
+			state = append([]byte(nil), value...)
+		}
+		return nil
+	})
+	return state, err
+}
+
+// PutExtra 保存调用方的附加数据，存在事务时在 Commit 时一起提交
+func (s *DiskStore) PutExtra(name string, value []byte) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(diskMetaBucket).Put([]byte(diskExtraPrefix+name), value)
+	})
+}
+
+// Extra 返回 PutExtra 保存的数据，不存在时返回 nil
+func (s *DiskStore) Extra(name string) ([]byte, error) {
+	var value []byte
+	err := s.view(func(tx *bolt.Tx) error {
+		if data := tx.Bucket(diskMetaBucket).Get([]byte(diskExtraPrefix + name)); data != nil {
+			value = append([]byte(nil), data...)
+		}
+		return nil
+	})
+	return value, err
+}
+
+// Drop 删除 id 对应的索引中的所有数据
+func (s *DiskStore) Drop(id uint32) error {
+	name := s.Indexer(id).name
+	return s.update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(name); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+		return tx.Bucket(diskMetaBucket).Delete(name)
+	})
+}
+
+// Reset 删除所有索引以及提交的状态，不能在事务中调用
+func (s *DiskStore) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tx != nil {
+		return ErrDiskIndexTxStarted
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var names [][]byte
+		err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			names = append(names, append([]byte(nil), name...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		_, err = tx.CreateBucket(diskMetaBucket)
+		return err
+	})
+	// 重置之后索引重新变为一致的状态
+	s.err = err
+	return err
+}
+
+// Close 放弃尚未提交的事务并关闭文件
+func (s *DiskStore) Close() error {
+	s.Rollback()
+	return s.db.Close()
+}
+
+// update 在当前事务中执行 fn，没有事务时在单独的事务中执行并提交
+func (s *DiskStore) update(fn func(tx *bolt.Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if s.tx != nil {
+		s.err = fn(s.tx)
+	} else {
+		s.err = s.db.Update(fn)
+	}
+	return s.err
+}
+
+// view 在当前事务中执行 fn，没有事务时在单独的只读事务中执行，只读事务之间不会互相阻塞
+func (s *DiskStore) view(fn func(tx *bolt.Tx) error) error {
+	s.mu.Lock()
+	if s.tx != nil {
+		defer s.mu.Unlock()
+		return fn(s.tx)
+	}
+	s.mu.Unlock()
+	return s.db.View(fn)
+}
+
+// DiskBTreeIndexer 保存在 DiskStore 中的索引，key 按照字节序保存在 B+ 树中，value 是编码后的位置。
+// 只有访问到的页面才会被读入内存，适合无法全部放入内存的 keyspace。
+// Indexer 的方法无法返回错误，写入失败的错误会在 DiskStore.Commit 时返回，读取失败时当作 key 不存在。
+// 迭代器每次读取一页数据，每一页使用单独的只读事务，因此创建迭代器之后的修改可能出现在尚未读取的页中
+type DiskBTreeIndexer struct {
+	store *DiskStore
+	name  []byte
+}
+
+func (dt *DiskBTreeIndexer) Put(key []byte, position *wal.ChunkPosition) *wal.ChunkPosition {
+	return dt.Apply([]Mutation{{Key: key, Position: position}})[0]
+}
+
+func (dt *DiskBTreeIndexer) Get(key []byte) *wal.ChunkPosition {
+	var position *wal.ChunkPosition
+	_ = dt.store.view(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket(dt.name); bucket != nil {
+			position = decodeDiskPosition(bucket.Get(key))
+		}
+		return nil
+	})
+	return position
+}
+
+func (dt *DiskBTreeIndexer) Delete(key []byte) (*wal.ChunkPosition, bool) {
+	old := dt.Apply([]Mutation{{Key: key, Delete: true}})[0]
+	return old, old != nil
+}
+
+// Apply 在同一个 bolt 事务中应用所有修改，调用了 DiskStore.Begin 时使用当前的事务
+func (dt *DiskBTreeIndexer) Apply(mutations []Mutation) []*wal.ChunkPosition {
+	olds := make([]*wal.ChunkPosition, len(mutations))
+	_ = dt.store.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(dt.name)
+		if err != nil {
+			return err
+		}
+		var delta int64
+		for i, mutation := range mutations {
+			olds[i] = decodeDiskPosition(bucket.Get(mutation.Key))
+			switch {
+			case mutation.Delete && olds[i] != nil:
+				if err := bucket.Delete(mutation.Key); err != nil {
+					return err
+				}
+				delta--
+			case !mutation.Delete:
+				if err := bucket.Put(mutation.Key, mutation.Position.Encode()); err != nil {
+					return err
+				}
+				if olds[i] == nil {
+					delta++
+				}
+			}
+		}
+		if delta == 0 {
+			return nil
+		}
+		return dt.addSize(tx, delta)
+	})
+	return olds
+}
+
+func (dt *DiskBTreeIndexer) Size() int {
+	var size uint64
+	_ = dt.store.view(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(diskMetaBucket).Get(dt.name); len(value) == 8 {
+			size = binary.BigEndian.Uint64(value)
+		}
+		return nil
+	})
+	return int(size)
+}
+
+// addSize key 的数量保存在 meta 中，与 key 的修改在同一个事务中提交
+func (dt *DiskBTreeIndexer) addSize(tx *bolt.Tx, delta int64) error {
+	meta := tx.Bucket(diskMetaBucket)
+	var size uint64
+	if value := meta.Get(dt.name); len(value) == 8 {
+		size = binary.BigEndian.Uint64(value)
+	}
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(int64(size)+delta))
+	return meta.Put(dt.name, value)
+}
+
+func (dt *DiskBTreeIndexer) Iterator(options IteratorOptions) Iterator {
+	return newOrderedIterator(options, func(start []byte, inclusive bool, reverse bool, n int) []indexItem {
+		items := make([]indexItem, 0, n)
+		_ = dt.store.view(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(dt.name)
+			if bucket == nil {
+				return nil
+			}
+			cursor := bucket.Cursor()
+			var key, value []byte
+			switch {
+			case start == nil && !reverse:
+				key, value = cursor.First()
+			case start == nil:
+				key, value = cursor.Last()
+			case !reverse:
+				key, value = cursor.Seek(start)
+			default:
+				// 降序时从最后一个小于等于 start 的 key 开始
+				key, value = cursor.Seek(start)
+				if key == nil {
+					key, value = cursor.Last()
+				} else if !bytes.Equal(key, start) {
+					key, value = cursor.Prev()
+				}
+			}
+			for key != nil && len(items) < n {
+				if inclusive || !bytes.Equal(key, start) {
+					// 事务结束之后 bolt 返回的 key 不再有效，需要复制
+					items = append(items, indexItem{
+						key:      append([]byte(nil), key...),
+						position: decodeDiskPosition(value),
+					})
+				}
+				if reverse {
+					key, value = cursor.Prev()
+				} else {
+					key, value = cursor.Next()
+				}
+			}
+			return nil
+		})
+		return items
+	})
+}
+
+func decodeDiskPosition(value []byte) *wal.ChunkPosition {
+	if value == nil {
+		return nil
+	}
+	position, err := wal.DecodeChunkPosition(value)
+	if err != nil {
+		return nil
+	}
+	return position
+}
@@ -0,0 +1,63 @@
+package index
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiskStore_Transaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.db")
+	store, err := OpenDiskStore(path)
+	assert.Nil(t, err)
+	state, err := store.State()
+	assert.Nil(t, err)
+	assert.Nil(t, state)
+
+	idx := store.Indexer(1)
+	assert.Nil(t, store.Begin())
+	assert.Equal(t, ErrDiskIndexTxStarted, store.Begin())
+	idx.Put([]byte("a"), testPosition(1))
+	idx.Put([]byte("b"), testPosition(2))
+	// 事务中可以读到尚未提交的修改
+	assert.Equal(t, testPosition(1), idx.Get([]byte("a")))
+	assert.Equal(t, 2, idx.Size())
+	assert.Nil(t, store.Commit([]byte("state-1")))
+	assert.Equal(t, ErrDiskIndexTxNotFound, store.Commit(nil))
+
+	assert.Nil(t, store.Begin())
+	idx.Delete([]byte("a"))
+	idx.Put([]byte("c"), testPosition(3))
+	store.Rollback()
+	assert.Equal(t, testPosition(1), idx.Get([]byte("a")))
+	assert.Nil(t, idx.Get([]byte("c")))
+	assert.Equal(t, 2, idx.Size())
+
+	other := store.Indexer(2)
+	other.Put([]byte("a"), testPosition(4))
+	assert.Nil(t, store.Close())
+
+	// 重新打开之后数据与状态都依然存在
+	store, err = OpenDiskStore(path)
+	assert.Nil(t, err)
+	defer store.Close()
+	state, err = store.State()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("state-1"), state)
+	idx = store.Indexer(1)
+	assert.Equal(t, 2, idx.Size())
+	assert.Equal(t, testPosition(2), idx.Get([]byte("b")))
+	assert.Equal(t, testPosition(4), store.Indexer(2).Get([]byte("a")))
+
+	assert.Nil(t, store.Drop(2))
+	assert.Nil(t, store.Indexer(2).Get([]byte("a")))
+	assert.Equal(t, 0, store.Indexer(2).Size())
+	assert.Equal(t, 2, idx.Size())
+
+	assert.Nil(t, store.Reset())
+	assert.Equal(t, 0, idx.Size())
+	assert.Nil(t, idx.Get([]byte("b")))
+	state, err = store.State()
+	assert.Nil(t, err)
+	assert.Nil(t, state)
+}
@@ -0,0 +1,168 @@
+package index
+
+import (
+	"bytes"
+	"fastdb/wal"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// hashMapShards 哈希表的分片数量，不同分片的读写互不阻塞
+const hashMapShards = 64
+
+type hashMapShard struct {
+	items map[string]*wal.ChunkPosition
+	lock  sync.RWMutex
+}
+
+// HashMapIndexer 分片的哈希表索引，适合以点查询为主的负载。
+// 创建迭代器时需要复制所有的 key 并排序，代价与 key 的数量成正比
+type HashMapIndexer struct {
+	shards [hashMapShards]hashMapShard
+	size   atomic.Int64
+}
+
+func newHashMap() *HashMapIndexer {
+	hm := &HashMapIndexer{}
+	for i := range hm.shards {
+		hm.shards[i].items = make(map[string]*wal.ChunkPosition)
+	}
+	return hm
+}
+
+func (hm *HashMapIndexer) shard(key []byte) *hashMapShard {
+	return &hm.shards[shardIndex(key)]
+}
+
+func shardIndex(key []byte) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	return h.Sum32() % hashMapShards
+}
+
+func (hm *HashMapIndexer) Put(key []byte, position *wal.ChunkPosition) *wal.ChunkPosition {
+	shard := hm.shard(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	old, ok := shard.items[string(key)]
+	shard.items[string(key)] = position
+	if !ok {
+		hm.size.Add(1)
+	}
+	return old
+}
+
+func (hm *HashMapIndexer) Get(key []byte) *wal.ChunkPosition {
+	shard := hm.shard(key)
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+	return shard.items[string(key)]
+}
+
+func (hm *HashMapIndexer) Delete(key []byte) (*wal.ChunkPosition, bool) {
+	shard := hm.shard(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	old, ok := shard.items[string(key)]
+	if ok {
+		delete(shard.items, string(key))
+		hm.size.Add(-1)
+	}
+	return old, ok
+}
+
+// Apply 按照分片的顺序获取所有涉及到的分片的写锁，持有写锁期间应用全部修改，
+// 迭代器需要获取所有分片的读锁，因此看不到只应用了一部分的修改
+func (hm *HashMapIndexer) Apply(mutations []Mutation) []*wal.ChunkPosition {
+	var touched [hashMapShards]bool
+	indexes := make([]uint32, len(mutations))
+	for i, mutation := range mutations {
+		indexes[i] = shardIndex(mutation.Key)
+		touched[indexes[i]] = true
+	}
+	for i := range hm.shards {
+		if touched[i] {
+			hm.shards[i].lock.Lock()
+		}
+	}
+	defer func() {
+		for i := range hm.shards {
+			if touched[i] {
+				hm.shards[i].lock.Unlock()
+			}
+		}
+	}()
+
+	olds := make([]*wal.ChunkPosition, len(mutations))
+	for i, mutation := range mutations {
+		items := hm.shards[indexes[i]].items
+		old, ok := items[string(mutation.Key)]
+		olds[i] = old
+		if mutation.Delete {
+			if ok {
+				delete(items, string(mutation.Key))
+				hm.size.Add(-1)
+			}
+			continue
+		}
+		items[string(mutation.Key)] = mutation.Position
+		if !ok {
+			hm.size.Add(1)
+		}
+	}
+	return olds
+}
+
+func (hm *HashMapIndexer) Size() int {
+	return int(hm.size.Load())
+}
+
+// Iterator 同时持有所有分片的读锁复制出一致的快照，只复制以 Prefix 为前缀的 key
+func (hm *HashMapIndexer) Iterator(options IteratorOptions) Iterator {
+	for i := range hm.shards {
+		hm.shards[i].lock.RLock()
+	}
+	items := make([]indexItem, 0, hm.Size())
+	for i := range hm.shards {
+		for key, position := range hm.shards[i].items {
+			if bytes.HasPrefix([]byte(key), options.Prefix) {
+				items = append(items, indexItem{key: []byte(key), position: position})
+			}
+		}
+	}
+	for i := range hm.shards {
+		hm.shards[i].lock.RUnlock()
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return bytes.Compare(items[i].key, items[j].key) < 0
+	})
+
+	return newOrderedIterator(options, func(start []byte, inclusive bool, reverse bool, n int) []indexItem {
+		if !reverse {
+			i := 0
+			if start != nil {
+				i = sort.Search(len(items), func(i int) bool {
+					c := bytes.Compare(items[i].key, start)
+					return c > 0 || (inclusive && c == 0)
+				})
+			}
+			return items[i:min(i+n, len(items))]
+		}
+
+		// 降序时返回 [i-n, i) 的逆序
+		i := len(items)
+		if start != nil {
+			i = sort.Search(len(items), func(i int) bool {
+				c := bytes.Compare(items[i].key, start)
+				return c > 0 || (!inclusive && c == 0)
+			})
+		}
+		page := make([]indexItem, 0, min(n, i))
+		for j := i - 1; j >= 0 && len(page) < n; j-- {
+			page = append(page, items[j])
+		}
+		return page
+	})
+}
@@ -1,6 +1,9 @@
 package index
 
-import "fastdb/wal"
+import (
+	"fastdb/config"
+	"fastdb/wal"
+)
 
 type IteratorOptions struct {
 	// 根据前缀来过滤key
@@ -12,10 +15,11 @@ type IteratorOptions struct {
 type Iterator interface {
 	// Rewind 设置迭代器指向第一个key
 	Rewind()
-	// Seek 将迭代器指向 传入的 key
+	// Seek 将迭代器指向 第一个大于等于 key 的位置 (Reverse 时为最后一个小于等于 key 的位置)
 	Seek(key []byte)
 
-	Next(key []byte)
+	// Next 将迭代器指向下一个 key
+	Next()
 
 	Key() []byte
 
@@ -26,6 +30,13 @@ type Iterator interface {
 	Close()
 }
 
+// Mutation 批量修改索引时的一个操作，Delete 为 true 时删除 Key，否则将 Key 指向 Position
+type Mutation struct {
+	Key      []byte
+	Position *wal.ChunkPosition
+	Delete   bool
+}
+
 type Indexer interface {
 	Put(k []byte, position *wal.ChunkPosition) *wal.ChunkPosition
 
@@ -33,21 +44,35 @@ type Indexer interface {
 
 	Get(k []byte) *wal.ChunkPosition
 
+	// Apply 按顺序应用一组修改，返回每个 key 修改之前的位置。
+	// 所有修改作为一个整体对读取方可见，读取方不会看到只应用了一部分的修改
+	Apply(mutations []Mutation) []*wal.ChunkPosition
+
 	Size() int
+
+	// Iterator 返回索引当前状态的迭代器，之后的修改对迭代器不可见，DiskBTreeIndexer 除外
+	Iterator(options IteratorOptions) Iterator
 }
 
-type IndexerType = byte
+type IndexerType = config.IndexType
 
 const (
-	RadixTree IndexerType = iota
+	RadixTree = config.RadixTreeIndex
+	BTree     = config.BTreeIndex
+	HashMap   = config.HashMapIndex
+	DiskBTree = config.DiskBTreeIndex
 )
 
-var indexType = RadixTree
-
-func NewIndexer() Indexer {
+func NewIndexer(indexType IndexerType) Indexer {
 	switch indexType {
 	case RadixTree:
 		return newRadixTree()
+	case BTree:
+		return newBTree()
+	case HashMap:
+		return newHashMap()
+	case DiskBTree:
+		panic("disk index must be created by DiskStore.Indexer")
 	default:
 		panic("unexpected index type")
 	}
@@ -1,61 +1,159 @@
 package index
 
 import (
+	"bytes"
 	"fastdb/lib/iradix"
 	"fastdb/wal"
 	"sync"
+	"sync/atomic"
 )
 
+// IRadixTree 基于不可变基数树的索引。
+// 树在发布之后不会再被修改，读取时原子地加载当前的根，不需要加锁；
+// 写入在一个 iradix.Txn 中复制修改路径上的节点，完成后原子地发布新的树，写入之间通过 lock 串行
 type IRadixTree struct {
-	tree *iradix.Tree[*wal.ChunkPosition]
+	tree atomic.Pointer[iradix.Tree[*wal.ChunkPosition]]
 	lock sync.Mutex
 }
 
 func newRadixTree() *IRadixTree {
-	return &IRadixTree{
-		tree: iradix.NewTree[*wal.ChunkPosition](),
-	}
+	irx := &IRadixTree{}
+	irx.tree.Store(iradix.NewTree[*wal.ChunkPosition]())
+	return irx
 }
 
 func (irx *IRadixTree) Put(key []byte, position *wal.ChunkPosition) *wal.ChunkPosition {
 	irx.lock.Lock()
 	defer irx.lock.Unlock()
-	var oldPos *wal.ChunkPosition
-	irx.tree, oldPos, _ = irx.tree.Insert(key, position)
+	tree, oldPos, _ := irx.tree.Load().Insert(key, position)
+	irx.tree.Store(tree)
 	return oldPos
 }
 
 func (irx *IRadixTree) Get(key []byte) *wal.ChunkPosition {
-	pos, _ := irx.tree.Get(key)
+	pos, _ := irx.tree.Load().Get(key)
 	return pos
 }
 
 func (irx *IRadixTree) Delete(key []byte) (*wal.ChunkPosition, bool) {
 	irx.lock.Lock()
 	defer irx.lock.Unlock()
-	var oldPos *wal.ChunkPosition
-	var ok bool
-	irx.tree, oldPos, ok = irx.tree.Delete(key)
+	tree, oldPos, ok := irx.tree.Load().Delete(key)
+	irx.tree.Store(tree)
 	return oldPos, ok
 }
 
+// Apply 在一个 iradix.Txn 中按顺序应用所有修改，只发布一次新的树，
+// 同一个事务中新建的节点会被直接修改而不是再次复制，读取方看到的要么是全部修改，要么都没有。
+// 返回每个 key 修改之前的位置
+func (irx *IRadixTree) Apply(mutations []Mutation) []*wal.ChunkPosition {
+	irx.lock.Lock()
+	defer irx.lock.Unlock()
+	txn := irx.tree.Load().Txn()
+	olds := make([]*wal.ChunkPosition, len(mutations))
+	for i, mutation := range mutations {
+		if mutation.Delete {
+			olds[i], _ = txn.Delete(mutation.Key)
+		} else {
+			olds[i], _ = txn.Insert(mutation.Key, mutation.Position)
+		}
+	}
+	irx.tree.Store(txn.Commit())
+	return olds
+}
+
 func (irx *IRadixTree) Size() int {
-	return irx.tree.Len()
+	return irx.tree.Load().Len()
 }
 
 type IRadixTreeIterator struct {
 	options      IteratorOptions
-	min          []byte
-	max          []byte
 	currentKey   []byte
 	currentValue *wal.ChunkPosition
+	valid        bool
 	tree         *iradix.Tree[*wal.ChunkPosition]
 	iter         *iradix.Iterator[*wal.ChunkPosition]
+	reverseIter  *iradix.ReverseIterator[*wal.ChunkPosition]
 }
 
-/**
 func (irx *IRadixTree) Iterator(options IteratorOptions) Iterator {
-	minKey,_,_ := irx.tree.Minimum()
-	maxKey,_,_ := irx.tree.Maximum()
+	iter := &IRadixTreeIterator{
+		options: options,
+		tree:    irx.tree.Load(),
+	}
+	iter.Rewind()
+	return iter
+}
+
+func (it *IRadixTreeIterator) Rewind() {
+	if it.options.Reverse {
+		it.iter = nil
+		it.reverseIter = it.tree.Root().ReverseIterator()
+		if len(it.options.Prefix) > 0 {
+			it.reverseIter.SeekPrefix(it.options.Prefix)
+		}
+	} else {
+		it.reverseIter = nil
+		it.iter = it.tree.Root().Iterator()
+		if len(it.options.Prefix) > 0 {
+			it.iter.SeekPrefix(it.options.Prefix)
+		}
+	}
+	it.Next()
+}
+
+func (it *IRadixTreeIterator) Seek(key []byte) {
+	prefix := it.options.Prefix
+	if it.options.Reverse {
+		it.iter = nil
+		it.reverseIter = it.tree.Root().ReverseIterator()
+		it.reverseIter.SeekReverseLowerBound(key)
+		// 跳过大于前缀范围的 key
+		for {
+			k, pos, ok := it.reverseIter.Previous()
+			if !ok || bytes.HasPrefix(k, prefix) || bytes.Compare(k, prefix) < 0 {
+				it.currentKey, it.currentValue = k, pos
+				it.valid = ok && bytes.HasPrefix(k, prefix)
+				return
+			}
+		}
+	}
 
-} **/
+	if bytes.Compare(key, prefix) < 0 {
+		key = prefix
+	}
+	it.reverseIter = nil
+	it.iter = it.tree.Root().Iterator()
+	it.iter.SeekLowerBound(key)
+	it.Next()
+}
+
+func (it *IRadixTreeIterator) Next() {
+	var ok bool
+	if it.options.Reverse {
+		it.currentKey, it.currentValue, ok = it.reverseIter.Previous()
+	} else {
+		it.currentKey, it.currentValue, ok = it.iter.Next()
+	}
+	it.valid = ok && bytes.HasPrefix(it.currentKey, it.options.Prefix)
+}
+
+func (it *IRadixTreeIterator) Key() []byte {
+	return it.currentKey
+}
+
+func (it *IRadixTreeIterator) Value() *wal.ChunkPosition {
+	return it.currentValue
+}
+
+func (it *IRadixTreeIterator) Valid() bool {
+	return it.valid
+}
+
+func (it *IRadixTreeIterator) Close() {
+	it.iter = nil
+	it.reverseIter = nil
+	it.currentKey = nil
+	it.currentValue = nil
+	it.valid = false
+}
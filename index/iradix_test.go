@@ -3,7 +3,10 @@ package index
 import (
 	"fastdb/common"
 	"fastdb/wal"
+	"fmt"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -105,3 +108,164 @@ func TestIRadixTree_Delete(t *testing.T) {
 		})
 	}
 }
+
+func TestIRadixTree_Iterator(t *testing.T) {
+	tree := newRadixTree()
+	keys := []string{"a", "ab", "abc", "abd", "ac", "b", "ba", "bab", "c"}
+	for i, key := range keys {
+		tree.Put([]byte(key), &wal.ChunkPosition{ChunkOffset: int64(i)})
+	}
+
+	collect := func(iter Iterator) []string {
+		var res []string
+		for ; iter.Valid(); iter.Next() {
+			res = append(res, string(iter.Key()))
+		}
+		return res
+	}
+	reversed := func(s []string) []string {
+		res := make([]string, len(s))
+		for i := range s {
+			res[len(s)-1-i] = s[i]
+		}
+		return res
+	}
+
+	tests := []struct {
+		name    string
+		options IteratorOptions
+		seek    []byte
+		want    []string
+	}{
+		{"all", IteratorOptions{}, nil, keys},
+		{"all-reverse", IteratorOptions{Reverse: true}, nil, reversed(keys)},
+		{"prefix", IteratorOptions{Prefix: []byte("ab")}, nil, []string{"ab", "abc", "abd"}},
+		{"prefix-reverse", IteratorOptions{Prefix: []byte("ab"), Reverse: true}, nil, []string{"abd", "abc", "ab"}},
+		{"prefix-missing", IteratorOptions{Prefix: []byte("abz")}, nil, nil},
+		{"seek", IteratorOptions{}, []byte("abca"), []string{"abd", "ac", "b", "ba", "bab", "c"}},
+		{"seek-exist", IteratorOptions{}, []byte("ba"), []string{"ba", "bab", "c"}},
+		{"seek-reverse", IteratorOptions{Reverse: true}, []byte("abca"), []string{"abc", "ab", "a"}},
+		{"seek-reverse-exist", IteratorOptions{Reverse: true}, []byte("ba"), []string{"ba", "b", "ac", "abd", "abc", "ab", "a"}},
+		{"seek-prefix", IteratorOptions{Prefix: []byte("b")}, []byte("a"), []string{"b", "ba", "bab"}},
+		{"seek-prefix-reverse", IteratorOptions{Prefix: []byte("ab"), Reverse: true}, []byte("z"), []string{"abd", "abc", "ab"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iter := tree.Iterator(tt.options)
+			defer iter.Close()
+			if tt.seek != nil {
+				iter.Seek(tt.seek)
+			}
+			if got := collect(iter); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Iterator() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIRadixTree_Apply(t *testing.T) {
+	tree := newRadixTree()
+	tree.Put([]byte("a"), testPosition(1))
+	tree.Put([]byte("b"), testPosition(2))
+
+	iter := tree.Iterator(IteratorOptions{})
+	defer iter.Close()
+	olds := tree.Apply([]Mutation{
+		{Key: []byte("a"), Position: testPosition(3)},
+		{Key: []byte("b"), Delete: true},
+		{Key: []byte("c"), Position: testPosition(4)},
+		{Key: []byte("c"), Position: testPosition(5)},
+		{Key: []byte("missing"), Delete: true},
+	})
+	want := []*wal.ChunkPosition{testPosition(1), testPosition(2), nil, testPosition(4), nil}
+	if !reflect.DeepEqual(olds, want) {
+		t.Errorf("Apply() = %v, want %v", olds, want)
+	}
+	if got := tree.Get([]byte("c")); !reflect.DeepEqual(got, testPosition(5)) {
+		t.Errorf("Get() = %v, want %v", got, testPosition(5))
+	}
+	if got := tree.Size(); got != 2 {
+		t.Errorf("Size() = %v, want 2", got)
+	}
+	// 之前创建的迭代器看不到这次的修改
+	if got := collect(iter); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Iterator() = %v, want [a b]", got)
+	}
+}
+
+// TestIRadixTree_ConcurrentReadWrite 读取不加锁，与写入并发时需要通过 -race 检查。
+// 每个 batch 将同一组 key 指向同一个位置，读取方在任何时刻都只能看到完整的 batch
+func TestIRadixTree_ConcurrentReadWrite(t *testing.T) {
+	const (
+		writers   = 4
+		readers   = 8
+		rounds    = 300
+		batchKeys = 16
+	)
+	tree := newRadixTree()
+	batchKey := func(w, i int) []byte {
+		return []byte(fmt.Sprintf("batch-%d-%02d", w, i))
+	}
+
+	var wg sync.WaitGroup
+	var stopped atomic.Bool
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for round := 1; round <= rounds; round++ {
+				mutations := make([]Mutation, batchKeys)
+				for i := range mutations {
+					mutations[i] = Mutation{Key: batchKey(w, i), Position: testPosition(round)}
+				}
+				tree.Apply(mutations)
+				key := []byte(fmt.Sprintf("single-%d-%d", w, round))
+				tree.Put(key, testPosition(round))
+				if round%2 == 0 {
+					tree.Delete(key)
+				}
+			}
+		}(w)
+	}
+
+	var readerWg sync.WaitGroup
+	for r := 0; r < readers; r++ {
+		readerWg.Add(1)
+		go func(r int) {
+			defer readerWg.Done()
+			for !stopped.Load() {
+				w := r % writers
+				iter := tree.Iterator(IteratorOptions{Prefix: []byte(fmt.Sprintf("batch-%d-", w))})
+				var first *wal.ChunkPosition
+				var count int
+				for ; iter.Valid(); iter.Next() {
+					if first == nil {
+						first = iter.Value()
+					} else if !reflect.DeepEqual(first, iter.Value()) {
+						t.Errorf("iterator saw a partial batch: %v and %v", first, iter.Value())
+					}
+					count++
+				}
+				iter.Close()
+				if count != 0 && count != batchKeys {
+					t.Errorf("iterator saw %d keys of a batch, want %d", count, batchKeys)
+				}
+				tree.Get(batchKey(w, 0))
+				tree.Size()
+			}
+		}(r)
+	}
+
+	wg.Wait()
+	stopped.Store(true)
+	readerWg.Wait()
+
+	if got, want := tree.Size(), writers*batchKeys+writers*rounds/2; got != want {
+		t.Errorf("Size() = %v, want %v", got, want)
+	}
+	for w := 0; w < writers; w++ {
+		if got := tree.Get(batchKey(w, batchKeys-1)); !reflect.DeepEqual(got, testPosition(rounds)) {
+			t.Errorf("Get() = %v, want %v", got, testPosition(rounds))
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package index
+
+import (
+	"bytes"
+	"fastdb/wal"
+)
+
+// orderedPageSize 有序迭代器每次从索引中取出的数量
+const orderedPageSize = 64
+
+type indexItem struct {
+	key      []byte
+	position *wal.ChunkPosition
+}
+
+// pageFunc 从 start 开始按顺序返回最多 n 个元素，reverse 为 true 时按降序返回。
+// start 为 nil 时从最小(降序时为最大)的 key 开始，inclusive 为 false 时不包括 start 本身
+type pageFunc func(start []byte, inclusive bool, reverse bool, n int) []indexItem
+
+// orderedIterator 在一个有序的索引快照上分页迭代，B 树与哈希表的迭代器共用它，
+// Prefix 与 Seek 的语义与 IRadixTreeIterator 一致
+type orderedIterator struct {
+	options IteratorOptions
+	fetch   pageFunc
+	items   []indexItem
+	pos     int
+	valid   bool
+}
+
+func newOrderedIterator(options IteratorOptions, fetch pageFunc) *orderedIterator {
+	iter := &orderedIterator{options: options, fetch: fetch}
+	iter.Rewind()
+	return iter
+}
+
+// prefixEnd 返回大于所有以 prefix 为前缀的 key 的最小值，不存在时返回 nil
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+func (it *orderedIterator) load(start []byte, inclusive bool) {
+	it.items = it.fetch(start, inclusive, it.options.Reverse, orderedPageSize)
+	it.pos = 0
+	it.check()
+}
+
+func (it *orderedIterator) check() {
+	it.valid = it.pos < len(it.items) && bytes.HasPrefix(it.items[it.pos].key, it.options.Prefix)
+}
+
+func (it *orderedIterator) Rewind() {
+	prefix := it.options.Prefix
+	if !it.options.Reverse {
+		if len(prefix) == 0 {
+			it.load(nil, true)
+		} else {
+			it.load(prefix, true)
+		}
+		return
+	}
+	// 降序时从前缀范围内最大的 key 开始
+	if end := prefixEnd(prefix); len(prefix) > 0 && end != nil {
+		it.load(end, false)
+	} else {
+		it.load(nil, true)
+	}
+}
+
+func (it *orderedIterator) Seek(key []byte) {
+	prefix := it.options.Prefix
+	if !it.options.Reverse {
+		if bytes.Compare(key, prefix) < 0 {
+			key = prefix
+		}
+		it.load(key, true)
+		return
+	}
+	// 跳过大于前缀范围的 key
+	if end := prefixEnd(prefix); len(prefix) > 0 && end != nil && bytes.Compare(key, end) >= 0 {
+		it.load(end, false)
+		return
+	}
+	it.load(key, true)
+}
+
+func (it *orderedIterator) Next() {
+	if !it.valid {
+		return
+	}
+	it.pos++
+	if it.pos == len(it.items) {
+		if len(it.items) < orderedPageSize {
+			it.valid = false
+			return
+		}
+		it.load(it.items[len(it.items)-1].key, false)
+		return
+	}
+	it.check()
+}
+
+func (it *orderedIterator) Key() []byte {
+	if !it.valid {
+		return nil
+	}
+	return it.items[it.pos].key
+}
+
+func (it *orderedIterator) Value() *wal.ChunkPosition {
+	if !it.valid {
+		return nil
+	}
+	return it.items[it.pos].position
+}
+
+func (it *orderedIterator) Valid() bool {
+	return it.valid
+}
+
+func (it *orderedIterator) Close() {
+	it.items = nil
+	it.fetch = nil
+	it.valid = false
+}
@@ -0,0 +1,141 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// Filter 布隆过滤器，MayContain 返回 false 时 key 一定没有被加入过，返回 true 时 key 可能存在。
+// Filter 不是并发安全的，Add 与 MayContain 之间需要调用方加锁
+type Filter struct {
+	bits []uint64
+	// m 位数组的长度
+	m uint64
+	// k 每个 key 设置的位数
+	k uint64
+	// capacity 按照目标误判率创建时预期的 key 数量
+	capacity int
+	// count 已经加入的 key 数量
+	count int
+}
+
+// New 创建一个在加入 capacity 个 key 时误判率约为 rate 的布隆过滤器
+func New(capacity int, rate float64) *Filter {
+	if capacity < 1 {
+		capacity = 1
+	}
+	if rate <= 0 || rate >= 1 {
+		rate = 0.01
+	}
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(rate) / (math.Ln2 * math.Ln2)))
+	// 按 64 位对齐
+	m = (m + 63) / 64 * 64
+	k := uint64(math.Round(float64(m) / float64(capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Filter{
+		bits:     make([]uint64, m/64),
+		m:        m,
+		k:        k,
+		capacity: capacity,
+	}
+}
+
+// locations 使用两个哈希值模拟 k 个哈希函数
+func (f *Filter) locations(key []byte, fn func(bit uint64) bool) bool {
+	h := fnv.New64a()
+	_, _ = h.Write(key)
+	sum := h.Sum64()
+	h1, h2 := sum&math.MaxUint32, sum>>32|1
+	for i := uint64(0); i < f.k; i++ {
+		if !fn((h1 + i*h2) % f.m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *Filter) Add(key []byte) {
+	f.locations(key, func(bit uint64) bool {
+		f.bits[bit/64] |= 1 << (bit % 64)
+		return true
+	})
+	f.count++
+}
+
+func (f *Filter) MayContain(key []byte) bool {
+	return f.locations(key, func(bit uint64) bool {
+		return f.bits[bit/64]&(1<<(bit%64)) != 0
+	})
+}
+
+// Count 已经加入的 key 数量，重复加入的 key 会被重复计算
+func (f *Filter) Count() int {
+	return f.count
+}
+
+// Capacity 创建时预期的 key 数量，超过之后误判率会明显上升
+func (f *Filter) Capacity() int {
+	return f.capacity
+}
+
+// Bits 位数组的长度
+func (f *Filter) Bits() uint64 {
+	return f.m
+}
+
+// HashFunctions 每个 key 设置的位数
+func (f *Filter) HashFunctions() uint64 {
+	return f.k
+}
+
+// FalsePositiveRate 根据位数组中已经设置的位估算当前的误判率
+func (f *Filter) FalsePositiveRate() float64 {
+	var set int
+	for _, word := range f.bits {
+		set += bits.OnesCount64(word)
+	}
+	return math.Pow(float64(set)/float64(f.m), float64(f.k))
+}
+
+var ErrInvalidEncoding = errors.New("invalid bloom filter encoding")
+
+// MarshalBinary 编码过滤器: m k capacity count bits...
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, 4*binary.MaxVarintLen64+len(f.bits)*8)
+	buf = binary.AppendUvarint(buf, f.m)
+	buf = binary.AppendUvarint(buf, f.k)
+	buf = binary.AppendUvarint(buf, uint64(f.capacity))
+	buf = binary.AppendUvarint(buf, uint64(f.count))
+	for _, word := range f.bits {
+		buf = binary.LittleEndian.AppendUint64(buf, word)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary 解码 MarshalBinary 编码的过滤器
+func (f *Filter) UnmarshalBinary(data []byte) error {
+	var fields [4]uint64
+	for i := range fields {
+		value, n := binary.Uvarint(data)
+		if n <= 0 {
+			return ErrInvalidEncoding
+		}
+		fields[i] = value
+		data = data[n:]
+	}
+	m, k := fields[0], fields[1]
+	if m == 0 || m%64 != 0 || k == 0 || uint64(len(data)) != m/8 {
+		return ErrInvalidEncoding
+	}
+	words := make([]uint64, m/64)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint64(data[i*8:])
+	}
+	*f = Filter{bits: words, m: m, k: k, capacity: int(fields[2]), count: int(fields[3])}
+	return nil
+}
@@ -0,0 +1,60 @@
+package bloom
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	f := New(10000, 0.01)
+	assert.Equal(t, float64(0), f.FalsePositiveRate())
+	for i := 0; i < 10000; i++ {
+		f.Add([]byte(fmt.Sprintf("key-%d", i)))
+	}
+	assert.Equal(t, 10000, f.Count())
+	assert.Equal(t, 10000, f.Capacity())
+	assert.Equal(t, uint64(7), f.HashFunctions())
+
+	// 加入过的 key 一定返回 true
+	for i := 0; i < 10000; i++ {
+		assert.True(t, f.MayContain([]byte(fmt.Sprintf("key-%d", i))))
+	}
+
+	var positives int
+	for i := 0; i < 100000; i++ {
+		if f.MayContain([]byte(fmt.Sprintf("missing-%d", i))) {
+			positives++
+		}
+	}
+	rate := float64(positives) / 100000
+	assert.Less(t, rate, 0.02)
+	assert.InDelta(t, 0.01, f.FalsePositiveRate(), 0.005)
+}
+
+func TestFilter_Small(t *testing.T) {
+	f := New(0, 0)
+	assert.Equal(t, 1, f.Capacity())
+	assert.False(t, f.MayContain([]byte("a")))
+	f.Add([]byte("a"))
+	assert.True(t, f.MayContain([]byte("a")))
+}
+
+func TestFilter_Marshal(t *testing.T) {
+	f := New(1000, 0.01)
+	for i := 0; i < 500; i++ {
+		f.Add([]byte(fmt.Sprintf("key-%d", i)))
+	}
+	data, err := f.MarshalBinary()
+	assert.Nil(t, err)
+
+	decoded := &Filter{}
+	assert.Nil(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, f, decoded)
+	for i := 0; i < 500; i++ {
+		assert.True(t, decoded.MayContain([]byte(fmt.Sprintf("key-%d", i))))
+	}
+
+	assert.ErrorIs(t, decoded.UnmarshalBinary(data[:len(data)-1]), ErrInvalidEncoding)
+	assert.ErrorIs(t, decoded.UnmarshalBinary(nil), ErrInvalidEncoding)
+}
@@ -0,0 +1,120 @@
+package consistenthash
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+type Hash func(data []byte) uint32
+
+// Ring 使用虚拟节点的一致性哈希环，增删节点时只有相邻区间的 key 会被迁移
+type Ring struct {
+	hash Hash
+	// replicas 每个节点在环上的虚拟节点数量
+	replicas int
+	// keys 排好序的虚拟节点哈希值
+	keys []uint32
+	// owners 虚拟节点哈希值到真实节点的映射
+	owners map[uint32]string
+	nodes  map[string]struct{}
+	mu     sync.RWMutex
+}
+
+func New(replicas int, fn Hash) *Ring {
+	if replicas <= 0 {
+		replicas = 1
+	}
+	if fn == nil {
+		fn = crc32.ChecksumIEEE
+	}
+	return &Ring{
+		hash:     fn,
+		replicas: replicas,
+		owners:   make(map[uint32]string),
+		nodes:    make(map[string]struct{}),
+	}
+}
+
+func (r *Ring) Add(nodes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, node := range nodes {
+		r.nodes[node] = struct{}{}
+	}
+	r.rebuild()
+}
+
+func (r *Ring) Remove(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.nodes[node]; !ok {
+		return
+	}
+	delete(r.nodes, node)
+	r.rebuild()
+}
+
+// rebuild 按节点名称顺序重新生成哈希环，哈希冲突时由名称较小的节点占有该位置，
+// 保证环的结构只取决于节点集合，而与增删的顺序无关
+func (r *Ring) rebuild() {
+	names := make([]string, 0, len(r.nodes))
+	for node := range r.nodes {
+		names = append(names, node)
+	}
+	sort.Strings(names)
+
+	r.keys = r.keys[:0]
+	r.owners = make(map[uint32]string, len(names)*r.replicas)
+	for _, node := range names {
+		for i := 0; i < r.replicas; i++ {
+			h := r.hash([]byte(strconv.Itoa(i) + "#" + node))
+			if _, ok := r.owners[h]; ok {
+				continue
+			}
+			r.owners[h] = node
+			r.keys = append(r.keys, h)
+		}
+	}
+	sort.Slice(r.keys, func(i, j int) bool {
+		return r.keys[i] < r.keys[j]
+	})
+}
+
+func (r *Ring) Has(node string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.nodes[node]
+	return ok
+}
+
+// Nodes 返回环上所有真实节点，按名称排序
+func (r *Ring) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.nodes))
+	for node := range r.nodes {
+		names = append(names, node)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get 返回负责 key 的节点，环为空时返回空字符串
+func (r *Ring) Get(key []byte) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.keys) == 0 {
+		return ""
+	}
+
+	h := r.hash(key)
+	idx := sort.Search(len(r.keys), func(i int) bool {
+		return r.keys[i] >= h
+	})
+	if idx == len(r.keys) {
+		idx = 0
+	}
+	return r.owners[r.keys[idx]]
+}
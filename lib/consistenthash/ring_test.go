@@ -0,0 +1,68 @@
+package consistenthash
+
+import (
+	"fastdb/common"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func assignments(r *Ring, n int) map[string]string {
+	res := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		key := common.GetTestKey(i)
+		res[string(key)] = r.Get(key)
+	}
+	return res
+}
+
+func TestRing_Get(t *testing.T) {
+	r := New(160, nil)
+	assert.Equal(t, "", r.Get([]byte("key")))
+
+	r.Add("node-1", "node-2", "node-3")
+	counts := make(map[string]int)
+	for _, node := range assignments(r, 3000) {
+		counts[node]++
+	}
+	assert.Equal(t, 3, len(counts))
+	for _, count := range counts {
+		assert.Greater(t, count, 500)
+	}
+}
+
+func TestRing_AddOnlyMovesToNewNode(t *testing.T) {
+	r := New(160, nil)
+	r.Add("node-1", "node-2", "node-3")
+	before := assignments(r, 3000)
+
+	r.Add("node-4")
+	after := assignments(r, 3000)
+	moved := 0
+	for key, node := range after {
+		if node != before[key] {
+			assert.Equal(t, "node-4", node)
+			moved++
+		}
+	}
+	assert.Greater(t, moved, 0)
+}
+
+func TestRing_RemoveOnlyMovesRemovedKeys(t *testing.T) {
+	r := New(160, nil)
+	r.Add("node-1", "node-2", "node-3")
+	before := assignments(r, 3000)
+
+	r.Remove("node-2")
+	assert.False(t, r.Has("node-2"))
+	after := assignments(r, 3000)
+	for key, node := range after {
+		assert.NotEqual(t, "node-2", node)
+		if before[key] != "node-2" {
+			assert.Equal(t, before[key], node)
+		}
+	}
+
+	// 重新加入后恢复到原来的分布
+	r.Add("node-2")
+	assert.Equal(t, before, assignments(r, 3000))
+}
@@ -0,0 +1,108 @@
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNoKey = errors.New("the key provider returns no encryption key")
+	// ErrUnknownKey 数据使用的密钥不在 KeyProvider 中，通常是打开数据库时使用了错误的密钥
+	ErrUnknownKey = errors.New("the data is encrypted with an unknown key, the encryption key may be wrong")
+	// ErrDecryptFailed 密钥 id 匹配但无法通过 GCM 校验，数据被篡改或者密钥错误
+	ErrDecryptFailed = errors.New("failed to decrypt the data, the encryption key may be wrong")
+)
+
+const (
+	keyIdSize = 4
+	nonceSize = 12
+	tagSize   = 16
+
+	// PrefixSize 密文中位于加密数据之前的字节数
+	// KeyId Nonce
+	//   4    12
+	PrefixSize = keyIdSize + nonceSize
+
+	// Overhead 加密后的数据比明文多出的字节数
+	Overhead = PrefixSize + tagSize
+)
+
+// Cipher 使用 AES-GCM 加密数据，每段密文都带有所用密钥的 id，
+// 轮换密钥之后旧密钥加密的数据仍然可以被读取
+type Cipher struct {
+	currentId uint32
+	current   cipher.AEAD
+	aeads     map[uint32]cipher.AEAD
+}
+
+func NewCipher(provider KeyProvider) (*Cipher, error) {
+	keys, err := provider.Keys()
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, ErrNoKey
+	}
+
+	c := &Cipher{aeads: make(map[uint32]cipher.AEAD, len(keys))}
+	for i, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key: %v", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		id := KeyId(key)
+		c.aeads[id] = aead
+		if i == 0 {
+			c.currentId, c.current = id, aead
+		}
+	}
+	return c, nil
+}
+
+// KeyId 返回密钥的 id，即密钥 SHA-256 摘要的前 4 个字节，不会泄露密钥本身
+func KeyId(key []byte) uint32 {
+	sum := sha256.Sum256(key)
+	return binary.LittleEndian.Uint32(sum[:keyIdSize])
+}
+
+// CurrentKeyId 返回用于加密新数据的密钥 id
+func (c *Cipher) CurrentKeyId() uint32 {
+	return c.currentId
+}
+
+// Seal 使用当前密钥加密 plaintext
+// 返回的格式为 KeyId(4) Nonce(12) Ciphertext(N) Tag(16)
+func (c *Cipher) Seal(plaintext []byte) []byte {
+	buf := make([]byte, PrefixSize, len(plaintext)+Overhead)
+	binary.LittleEndian.PutUint32(buf[:keyIdSize], c.currentId)
+	if _, err := rand.Read(buf[keyIdSize:PrefixSize]); err != nil {
+		panic(fmt.Errorf("generate nonce failed: %v", err))
+	}
+	return c.current.Seal(buf, buf[keyIdSize:PrefixSize], plaintext, nil)
+}
+
+// Open 在 data 原来的位置解密，返回的明文位于 data[PrefixSize:] 中
+func (c *Cipher) Open(data []byte) ([]byte, error) {
+	if len(data) < Overhead {
+		return nil, ErrDecryptFailed
+	}
+	aead := c.aeads[binary.LittleEndian.Uint32(data[:keyIdSize])]
+	if aead == nil {
+		return nil, ErrUnknownKey
+	}
+	ciphertext := data[PrefixSize:]
+	plaintext, err := aead.Open(ciphertext[:0], data[keyIdSize:PrefixSize], ciphertext, nil)
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	return plaintext, nil
+}
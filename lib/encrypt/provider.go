@@ -0,0 +1,87 @@
+package encrypt
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeyProvider 提供数据加密所用的 AES 密钥(16、24 或 32 字节)。
+// 第一个密钥用于加密新写入的数据，其余的是轮换前的旧密钥，只用于解密，
+// 轮换密钥时将新密钥放在最前面，并保留旧密钥直到旧数据被 core.Reencrypt 重新加密
+type KeyProvider interface {
+	Keys() ([][]byte, error)
+}
+
+type staticKeyProvider struct {
+	keys [][]byte
+}
+
+// NewStaticKeyProvider 返回直接使用 keys 的 KeyProvider
+func NewStaticKeyProvider(keys ...[]byte) KeyProvider {
+	return &staticKeyProvider{keys: keys}
+}
+
+func (p *staticKeyProvider) Keys() ([][]byte, error) {
+	return p.keys, nil
+}
+
+type fileKeyProvider struct {
+	path string
+}
+
+// NewFileKeyProvider 从文件中读取密钥，每行一个十六进制编码的密钥，空行与 # 开头的行会被忽略
+func NewFileKeyProvider(path string) KeyProvider {
+	return &fileKeyProvider{path: path}
+}
+
+func (p *fileKeyProvider) Keys() ([][]byte, error) {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return decodeKeys(lines, p.path)
+}
+
+type envKeyProvider struct {
+	name string
+}
+
+// NewEnvKeyProvider 从环境变量 name 中读取密钥，多个十六进制编码的密钥之间用逗号分隔
+func NewEnvKeyProvider(name string) KeyProvider {
+	return &envKeyProvider{name: name}
+}
+
+func (p *envKeyProvider) Keys() ([][]byte, error) {
+	value, ok := os.LookupEnv(p.name)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", p.name)
+	}
+	var fields []string
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return decodeKeys(fields, "$"+p.name)
+}
+
+func decodeKeys(encoded []string, source string) ([][]byte, error) {
+	keys := make([][]byte, 0, len(encoded))
+	for i, s := range encoded {
+		key, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("decode key %d from %s failed: %v", i, source, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,59 @@
+package encrypt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyProvider(t *testing.T) {
+	key1 := bytes.Repeat([]byte{1}, 32)
+	key2 := bytes.Repeat([]byte{2}, 16)
+
+	path := filepath.Join(t.TempDir(), "keys")
+	content := "# current key\n" + hex.EncodeToString(key1) + "\n\n" + hex.EncodeToString(key2) + "\n"
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0600))
+	t.Setenv("FASTDB_TEST_KEYS", hex.EncodeToString(key1)+", "+hex.EncodeToString(key2))
+
+	for _, provider := range []KeyProvider{
+		NewStaticKeyProvider(key1, key2),
+		NewFileKeyProvider(path),
+		NewEnvKeyProvider("FASTDB_TEST_KEYS"),
+	} {
+		keys, err := provider.Keys()
+		assert.Nil(t, err)
+		assert.Equal(t, [][]byte{key1, key2}, keys)
+	}
+
+	_, err := NewEnvKeyProvider("FASTDB_TEST_MISSING_KEYS").Keys()
+	assert.NotNil(t, err)
+}
+
+func TestCipher(t *testing.T) {
+	oldCipher, err := NewCipher(NewStaticKeyProvider(bytes.Repeat([]byte{1}, 32)))
+	assert.Nil(t, err)
+	sealed := oldCipher.Seal([]byte("fastdb"))
+	assert.Equal(t, len("fastdb")+Overhead, len(sealed))
+
+	// 轮换之后仍然可以解密旧密钥加密的数据
+	rotated, err := NewCipher(NewStaticKeyProvider(bytes.Repeat([]byte{2}, 32), bytes.Repeat([]byte{1}, 32)))
+	assert.Nil(t, err)
+	plaintext, err := rotated.Open(append([]byte(nil), sealed...))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("fastdb"), plaintext)
+
+	other, err := NewCipher(NewStaticKeyProvider(bytes.Repeat([]byte{2}, 32)))
+	assert.Nil(t, err)
+	_, err = other.Open(append([]byte(nil), sealed...))
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	sealed[len(sealed)-1] ^= 0xff
+	_, err = oldCipher.Open(sealed)
+	assert.ErrorIs(t, err, ErrDecryptFailed)
+
+	_, err = NewCipher(NewStaticKeyProvider([]byte("short")))
+	assert.NotNil(t, err)
+}
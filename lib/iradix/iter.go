@@ -1,6 +1,216 @@
 package iradix
 
+import (
+	"bytes"
+)
+
+// Iterator 按照 key 的升序遍历树
 type Iterator[T any] struct {
 	node  *Node[T]
 	stack []edges[T]
 }
+
+func (n *Node[T]) Iterator() *Iterator[T] {
+	return &Iterator[T]{node: n}
+}
+
+// SeekPrefix 将迭代器限定在以 prefix 为前缀的 key 上
+func (i *Iterator[T]) SeekPrefix(prefix []byte) {
+	i.stack = nil
+	now := i.node
+	search := prefix
+	for {
+		if len(search) == 0 {
+			i.node = now
+			return
+		}
+
+		_, now = now.getEdge(search[0])
+		if now == nil {
+			i.node = nil
+			return
+		}
+
+		if bytes.HasPrefix(search, now.prefix) {
+			search = search[len(now.prefix):]
+		} else if bytes.HasPrefix(now.prefix, search) {
+			i.node = now
+			return
+		} else {
+			i.node = nil
+			return
+		}
+	}
+}
+
+// SeekLowerBound 将迭代器指向第一个大于等于 key 的位置
+func (i *Iterator[T]) SeekLowerBound(key []byte) {
+	i.stack = []edges[T]{}
+	now := i.node
+	i.node = nil
+	search := key
+
+	found := func(n *Node[T]) {
+		i.stack = append(i.stack, edges[T]{edge[T]{node: n}})
+	}
+
+	for {
+		var prefixCmp int
+		if len(now.prefix) < len(search) {
+			prefixCmp = bytes.Compare(now.prefix, search[:len(now.prefix)])
+		} else {
+			prefixCmp = bytes.Compare(now.prefix, search)
+		}
+
+		// 整棵子树都大于 key
+		if prefixCmp > 0 {
+			found(now)
+			return
+		}
+		// 整棵子树都小于 key
+		if prefixCmp < 0 {
+			return
+		}
+
+		search = search[len(now.prefix):]
+		if len(search) == 0 {
+			found(now)
+			return
+		}
+
+		// 当前节点的值小于 key，只需要考虑 label 不小于 search[0] 的子节点
+		idx, lowerBound := now.getLowerBoundEdge(search[0])
+		if lowerBound == nil {
+			return
+		}
+		if idx+1 < len(now.edges) {
+			i.stack = append(i.stack, now.edges[idx+1:])
+		}
+		now = lowerBound
+	}
+}
+
+// Next 返回下一个 key 与 value，遍历结束时返回 false
+func (i *Iterator[T]) Next() ([]byte, T, bool) {
+	var zero T
+	if i.stack == nil && i.node != nil {
+		i.stack = []edges[T]{{edge[T]{node: i.node}}}
+	}
+
+	for len(i.stack) > 0 {
+		num := len(i.stack)
+		last := i.stack[num-1]
+		now := last[0].node
+		if len(last) > 1 {
+			i.stack[num-1] = last[1:]
+		} else {
+			i.stack = i.stack[:num-1]
+		}
+
+		if len(now.edges) > 0 {
+			i.stack = append(i.stack, now.edges)
+		}
+		if now.isLeaf() {
+			return now.leaf.key, now.leaf.val, true
+		}
+	}
+	return nil, zero, false
+}
+
+type reverseFrame[T any] struct {
+	node *Node[T]
+	// expanded 代表子节点已经入栈，再次弹出时只需要返回该节点自身的值
+	expanded bool
+}
+
+// ReverseIterator 按照 key 的降序遍历树
+type ReverseIterator[T any] struct {
+	node  *Node[T]
+	stack []reverseFrame[T]
+}
+
+func (n *Node[T]) ReverseIterator() *ReverseIterator[T] {
+	return &ReverseIterator[T]{node: n}
+}
+
+// SeekPrefix 将迭代器限定在以 prefix 为前缀的 key 上
+func (ri *ReverseIterator[T]) SeekPrefix(prefix []byte) {
+	it := ri.node.Iterator()
+	it.SeekPrefix(prefix)
+	ri.node = it.node
+	ri.stack = nil
+}
+
+// SeekReverseLowerBound 将迭代器指向最后一个小于等于 key 的位置
+func (ri *ReverseIterator[T]) SeekReverseLowerBound(key []byte) {
+	ri.stack = []reverseFrame[T]{}
+	now := ri.node
+	ri.node = nil
+	search := key
+
+	for {
+		var prefixCmp int
+		if len(now.prefix) < len(search) {
+			prefixCmp = bytes.Compare(now.prefix, search[:len(now.prefix)])
+		} else {
+			prefixCmp = bytes.Compare(now.prefix, search)
+		}
+
+		// 整棵子树都小于 key
+		if prefixCmp < 0 {
+			ri.stack = append(ri.stack, reverseFrame[T]{node: now})
+			return
+		}
+		// 整棵子树都大于 key
+		if prefixCmp > 0 {
+			return
+		}
+
+		search = search[len(now.prefix):]
+		// 当前节点的 key 比子节点都小，最后返回
+		ri.stack = append(ri.stack, reverseFrame[T]{node: now, expanded: true})
+		if len(search) == 0 {
+			return
+		}
+
+		var next *Node[T]
+		for _, e := range now.edges {
+			if e.label < search[0] {
+				ri.stack = append(ri.stack, reverseFrame[T]{node: e.node})
+			} else if e.label == search[0] {
+				next = e.node
+			}
+		}
+		if next == nil {
+			return
+		}
+		now = next
+	}
+}
+
+// Previous 返回上一个 key 与 value，遍历结束时返回 false
+func (ri *ReverseIterator[T]) Previous() ([]byte, T, bool) {
+	var zero T
+	if ri.stack == nil && ri.node != nil {
+		ri.stack = []reverseFrame[T]{{node: ri.node}}
+	}
+
+	for len(ri.stack) > 0 {
+		num := len(ri.stack)
+		frame := ri.stack[num-1]
+		ri.stack = ri.stack[:num-1]
+
+		if frame.expanded {
+			if frame.node.isLeaf() {
+				return frame.node.leaf.key, frame.node.leaf.val, true
+			}
+			continue
+		}
+
+		ri.stack = append(ri.stack, reverseFrame[T]{node: frame.node, expanded: true})
+		for _, e := range frame.node.edges {
+			ri.stack = append(ri.stack, reverseFrame[T]{node: e.node})
+		}
+	}
+	return nil, zero, false
+}
@@ -37,6 +37,18 @@ func (n *Node[T]) getEdge(label byte) (int, *Node[T]) {
 	return -1, nil
 }
 
+// getLowerBoundEdge 返回第一个 label 不小于给定 label 的边
+func (n *Node[T]) getLowerBoundEdge(label byte) (int, *Node[T]) {
+	num := len(n.edges)
+	idx := sort.Search(num, func(i int) bool {
+		return n.edges[i].label >= label
+	})
+	if idx < num {
+		return idx, n.edges[idx].node
+	}
+	return -1, nil
+}
+
 func (n *Node[T]) addEdge(e edge[T]) {
 	num := len(n.edges)
 	idx := sort.Search(num, func(i int) bool {
@@ -32,6 +32,10 @@ func (t *Tree[T]) Get(k []byte) (T, bool) {
 	return t.root.get(k)
 }
 
+func (t *Tree[T]) Root() *Node[T] {
+	return t.root
+}
+
 func (t *Tree[T]) Len() int {
 	return t.size
 }
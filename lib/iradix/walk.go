@@ -0,0 +1,166 @@
+package iradix
+
+import (
+	"bytes"
+)
+
+// WalkFn 遍历时对每个 key 调用的函数，返回 true 时停止遍历
+type WalkFn[T any] func(k []byte, v T) bool
+
+// Walk 按照 key 的升序遍历以 n 为根的子树
+func (n *Node[T]) Walk(fn WalkFn[T]) {
+	recursiveWalk(n, fn)
+}
+
+// WalkPrefix 按照 key 的升序遍历所有以 prefix 为前缀的 key
+func (n *Node[T]) WalkPrefix(prefix []byte, fn WalkFn[T]) {
+	now := n
+	search := prefix
+	for {
+		if len(search) == 0 {
+			recursiveWalk(now, fn)
+			return
+		}
+
+		_, now = now.getEdge(search[0])
+		if now == nil {
+			return
+		}
+
+		if bytes.HasPrefix(search, now.prefix) {
+			search = search[len(now.prefix):]
+			continue
+		}
+		// 节点的前缀比剩余的 search 长，子树中所有的 key 都以 prefix 为前缀
+		if bytes.HasPrefix(now.prefix, search) {
+			recursiveWalk(now, fn)
+		}
+		return
+	}
+}
+
+// WalkPath 从根开始依次遍历 path 的所有前缀(包括 path 本身)中存在于树中的 key
+func (n *Node[T]) WalkPath(path []byte, fn WalkFn[T]) {
+	now := n
+	search := path
+	for {
+		if now.isLeaf() && fn(now.leaf.key, now.leaf.val) {
+			return
+		}
+		if len(search) == 0 {
+			return
+		}
+
+		_, now = now.getEdge(search[0])
+		if now == nil || !bytes.HasPrefix(search, now.prefix) {
+			return
+		}
+		search = search[len(now.prefix):]
+	}
+}
+
+// LongestPrefix 返回 k 的所有前缀(包括 k 本身)中存在于树中的最长的一个
+func (n *Node[T]) LongestPrefix(k []byte) ([]byte, T, bool) {
+	var (
+		key   []byte
+		val   T
+		found bool
+	)
+	// WalkPath 按照从短到长的顺序访问，最后一个就是最长的
+	n.WalkPath(k, func(k []byte, v T) bool {
+		key, val, found = k, v, true
+		return false
+	})
+	return key, val, found
+}
+
+func recursiveWalk[T any](n *Node[T], fn WalkFn[T]) bool {
+	if n.isLeaf() && fn(n.leaf.key, n.leaf.val) {
+		return true
+	}
+	for _, e := range n.edges {
+		if recursiveWalk(e.node, fn) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Tree[T]) Walk(fn WalkFn[T]) {
+	t.root.Walk(fn)
+}
+
+func (t *Tree[T]) WalkPrefix(prefix []byte, fn WalkFn[T]) {
+	t.root.WalkPrefix(prefix, fn)
+}
+
+func (t *Tree[T]) WalkPath(path []byte, fn WalkFn[T]) {
+	t.root.WalkPath(path, fn)
+}
+
+func (t *Tree[T]) LongestPrefix(k []byte) ([]byte, T, bool) {
+	return t.root.LongestPrefix(k)
+}
+
+// DeletePrefix 删除所有以 prefix 为前缀的 key，返回新的树以及删除的数量
+func (t *Tree[T]) DeletePrefix(prefix []byte) (*Tree[T], int) {
+	txn := t.Txn()
+	n := txn.DeletePrefix(prefix)
+	return txn.Commit(), n
+}
+
+// DeletePrefix 在事务中删除所有以 prefix 为前缀的 key，返回删除的数量。
+// 只需要复制到达 prefix 的路径上的节点，被删除的子树整体从父节点上摘除
+func (t *Txn[T]) DeletePrefix(prefix []byte) int {
+	newRoot, n := t.deletePrefix(t.root, prefix)
+	if newRoot != nil {
+		t.root = newRoot
+		t.size -= n
+	}
+	return n
+}
+
+func (t *Txn[T]) deletePrefix(n *Node[T], search []byte) (*Node[T], int) {
+	// 以 n 为根的子树中所有的 key 都以 prefix 为前缀
+	if len(search) == 0 {
+		var count int
+		recursiveWalk(n, func([]byte, T) bool {
+			count++
+			return false
+		})
+		if count == 0 {
+			return nil, 0
+		}
+		nc := t.writeNode(n, true)
+		nc.leaf = nil
+		nc.edges = nil
+		return nc, count
+	}
+
+	label := search[0]
+	idx, child := n.getEdge(label)
+	if child == nil || (!bytes.HasPrefix(child.prefix, search) && !bytes.HasPrefix(search, child.prefix)) {
+		return nil, 0
+	}
+	if len(child.prefix) > len(search) {
+		search = nil
+	} else {
+		search = search[len(child.prefix):]
+	}
+
+	newChild, count := t.deletePrefix(child, search)
+	if newChild == nil {
+		return nil, 0
+	}
+
+	nc := t.writeNode(n, false)
+	if newChild.leaf == nil && len(newChild.edges) == 0 {
+		nc.delEdge(label)
+		if n != t.root && len(nc.edges) == 1 && !nc.isLeaf() {
+			t.mergeChild(nc)
+		}
+	} else {
+		nc.edges[idx].node = newChild
+	}
+	return nc, count
+}
@@ -0,0 +1,185 @@
+package iradix
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newTestTree(keys ...string) *Tree[int] {
+	txn := NewTree[int]().Txn()
+	for i, k := range keys {
+		txn.Insert([]byte(k), i)
+	}
+	return txn.Commit()
+}
+
+func collectKeys(walk func(fn WalkFn[int])) []string {
+	var keys []string
+	walk(func(k []byte, _ int) bool {
+		keys = append(keys, string(k))
+		return false
+	})
+	return keys
+}
+
+func TestTree_WalkPrefix(t *testing.T) {
+	tree := newTestTree("", "a", "ab", "abc", "abd", "b", "foo", "foobar", "foobaz", "zip")
+
+	walkPrefix := func(prefix string) []string {
+		return collectKeys(func(fn WalkFn[int]) {
+			tree.WalkPrefix([]byte(prefix), fn)
+		})
+	}
+	assert.Equal(t, []string{"", "a", "ab", "abc", "abd", "b", "foo", "foobar", "foobaz", "zip"}, walkPrefix(""))
+	assert.Equal(t, []string{"ab", "abc", "abd"}, walkPrefix("ab"))
+	assert.Equal(t, []string{"abc"}, walkPrefix("abc"))
+	// 节点的前缀 "ba" 比剩余的 "b" 长
+	assert.Equal(t, []string{"foobar", "foobaz"}, walkPrefix("foob"))
+	assert.Nil(t, walkPrefix("fooc"))
+	assert.Nil(t, walkPrefix("x"))
+	assert.Nil(t, walkPrefix("zipper"))
+
+	// 返回 true 时停止遍历
+	var keys []string
+	tree.WalkPrefix([]byte("a"), func(k []byte, _ int) bool {
+		keys = append(keys, string(k))
+		return len(keys) == 2
+	})
+	assert.Equal(t, []string{"a", "ab"}, keys)
+}
+
+func TestTree_WalkPath(t *testing.T) {
+	tree := newTestTree("", "f", "foo", "foobar", "foobaz", "fox")
+
+	walkPath := func(path string) []string {
+		return collectKeys(func(fn WalkFn[int]) {
+			tree.WalkPath([]byte(path), fn)
+		})
+	}
+	assert.Equal(t, []string{"", "f", "foo", "foobar"}, walkPath("foobar"))
+	assert.Equal(t, []string{"", "f", "foo", "foobar"}, walkPath("foobarqux"))
+	assert.Equal(t, []string{"", "f", "foo"}, walkPath("foob"))
+	assert.Equal(t, []string{""}, walkPath(""))
+	assert.Equal(t, []string{""}, walkPath("x"))
+
+	var keys []string
+	tree.WalkPath([]byte("foobar"), func(k []byte, _ int) bool {
+		keys = append(keys, string(k))
+		return string(k) == "f"
+	})
+	assert.Equal(t, []string{"", "f"}, keys)
+}
+
+func TestTree_LongestPrefix(t *testing.T) {
+	tree := newTestTree("10.0", "10.0.1", "10.0.1.5", "192.168")
+
+	longest := func(k string) (string, int, bool) {
+		key, val, ok := tree.LongestPrefix([]byte(k))
+		return string(key), val, ok
+	}
+	key, val, ok := longest("10.0.1.7")
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.1", key)
+	assert.Equal(t, 1, val)
+	key, _, ok = longest("10.0.1.5")
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.1.5", key)
+	key, _, ok = longest("10.0.2")
+	assert.True(t, ok)
+	assert.Equal(t, "10.0", key)
+	_, _, ok = longest("10")
+	assert.False(t, ok)
+	_, _, ok = longest("172.16")
+	assert.False(t, ok)
+
+	tree, _, _ = tree.Insert([]byte(""), 9)
+	key, val, ok = longest("172.16")
+	assert.True(t, ok)
+	assert.Equal(t, "", key)
+	assert.Equal(t, 9, val)
+}
+
+func TestTree_DeletePrefix(t *testing.T) {
+	all := []string{"", "a", "ab", "abc", "abd", "b", "foo", "foobar", "foobaz", "xa", "xyz1", "xyz2"}
+	tree := newTestTree(all...)
+
+	tests := []struct {
+		name   string
+		prefix string
+		left   []string
+	}{
+		{"all keys from root", "", nil},
+		{"subtree with leaf", "ab", []string{"", "a", "b", "foo", "foobar", "foobaz", "xa", "xyz1", "xyz2"}},
+		{"single key", "abc", []string{"", "a", "ab", "abd", "b", "foo", "foobar", "foobaz", "xa", "xyz1", "xyz2"}},
+		{"node prefix longer than search", "foob", []string{"", "a", "ab", "abc", "abd", "b", "foo", "xa", "xyz1", "xyz2"}},
+		{"merge single child", "xyz1", []string{"", "a", "ab", "abc", "abd", "b", "foo", "foobar", "foobaz", "xa", "xyz2"}},
+		{"remove edge", "x", []string{"", "a", "ab", "abc", "abd", "b", "foo", "foobar", "foobaz"}},
+		{"no match", "q", all},
+		{"no match below node prefix", "foobaq", all},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTree, n := tree.DeletePrefix([]byte(tt.prefix))
+			assert.Equal(t, len(all)-len(tt.left), n)
+			assert.Equal(t, len(tt.left), newTree.Len())
+			assert.Equal(t, tt.left, collectKeys(newTree.Walk))
+			for i, k := range all {
+				val, ok := newTree.Get([]byte(k))
+				if ok {
+					assert.Equal(t, i, val)
+				}
+			}
+
+			// 原来的树保持不变
+			assert.Equal(t, len(all), tree.Len())
+			assert.Equal(t, all, collectKeys(tree.Walk))
+		})
+	}
+}
+
+func TestTree_DeletePrefix_MergeChild(t *testing.T) {
+	tree := newTestTree("xyz1", "xyz2", "xa")
+	newTree, n := tree.DeletePrefix([]byte("xyz1"))
+	assert.Equal(t, 1, n)
+
+	// "yz" 只剩下一条边且不是叶子节点，与子节点合并
+	_, x := newTree.Root().getEdge('x')
+	_, yz := x.getEdge('y')
+	assert.Equal(t, []byte("yz2"), yz.prefix)
+	assert.True(t, yz.isLeaf())
+	assert.Empty(t, yz.edges)
+
+	iter := newTree.Root().Iterator()
+	iter.SeekPrefix([]byte("xy"))
+	key, _, ok := iter.Next()
+	assert.True(t, ok)
+	assert.Equal(t, []byte("xyz2"), key)
+
+	_, oldYz := tree.Root().edges[0].node.getEdge('y')
+	assert.Equal(t, []byte("yz"), oldYz.prefix)
+	assert.Len(t, oldYz.edges, 2)
+}
+
+func TestTxn_DeletePrefix(t *testing.T) {
+	tree := newTestTree("user:1", "user:2", "order:1")
+
+	txn := tree.Txn()
+	txn.Insert([]byte("user:3"), 3)
+	txn.Insert([]byte("session:1"), 4)
+	assert.Equal(t, 3, txn.DeletePrefix([]byte("user:")))
+	assert.Equal(t, 0, txn.DeletePrefix([]byte("user:")))
+	_, ok := txn.Get([]byte("user:3"))
+	assert.False(t, ok)
+	txn.Insert([]byte("user:4"), 5)
+
+	newTree := txn.Commit()
+	assert.Equal(t, 3, newTree.Len())
+	assert.Equal(t, []string{"order:1", "session:1", "user:4"}, collectKeys(newTree.Walk))
+	assert.Equal(t, 3, tree.Len())
+	assert.Equal(t, []string{"order:1", "user:1", "user:2"}, collectKeys(tree.Walk))
+
+	txn = newTree.Txn()
+	assert.Equal(t, 3, txn.DeletePrefix(nil))
+	assert.Equal(t, 0, txn.Commit().Len())
+	assert.Equal(t, 3, newTree.Len())
+}
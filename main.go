@@ -3,6 +3,9 @@ package main
 import (
 	"fastdb/config"
 	"fastdb/fastdb"
+	"fastdb/interface/server"
+	"flag"
+	"strings"
 )
 
 var banner = `
@@ -15,15 +18,32 @@ ___________                __ ________ __________
 `
 
 func main() {
+	mode := flag.String("mode", "server", "运行模式: server 或 proxy")
+	port := flag.Uint("port", 6666, "监听端口")
+	nodes := flag.String("nodes", "", "proxy 模式下后端节点的地址，使用逗号分隔")
+	flag.Parse()
+
 	print(banner)
 	options := config.ServerOptions{
 		DbOptions:    config.DefaultOptions,
 		BatchOptions: config.DefaultBatchOptions,
-		Port:         6666,
+		ProxyOptions: config.DefaultProxyOptions,
+		Port:         uint16(*port),
+	}
+
+	var fastDB server.Server
+	var err error
+	if *mode == "proxy" {
+		if *nodes != "" {
+			options.ProxyOptions.Nodes = strings.Split(*nodes, ",")
+		}
+		fastDB, err = fastdb.MakeProxyServer(options)
+	} else {
+		fastDB, err = fastdb.MakeServer(options)
 	}
-	fastDB, err := fastdb.MakeServer(options)
 	if err != nil {
 		print(err)
+		return
 	}
 
 	err = fastDB.Run()
@@ -0,0 +1,223 @@
+// Package rdbimport 将 redis 的 RDB 文件导入 fastdb，用于从 redis 迁移数据。
+// 支持 string、hash、list、set 与 zset，其他类型(例如 stream 与 module)会被跳过并计入 Report。
+// fastdb 不支持过期时间，导入时已经过期的 key 会被跳过，其余设置了过期时间的 key 导入后不会过期。
+// 目标数据库中已经存在的 hash、list、set 与 zset 会与导入的元素合并，list 的元素会被追加到尾部
+package rdbimport
+
+import (
+	"errors"
+	"fastdb/config"
+	"fastdb/core"
+	"github.com/hdt3213/rdb/parser"
+	"io"
+	"os"
+	"time"
+)
+
+type Options struct {
+	// BatchSize 每个 batch 大约包含的元素数量，同一个 key 的所有元素总是在同一个 batch 中提交
+	BatchSize int
+	// Databases 需要导入的 redis 数据库编号，为空时导入所有数据库，
+	// fastdb 只有一个 keyspace，不同数据库中同名的 key 会互相覆盖
+	Databases []int
+	// SkipVolatile 为 true 时跳过所有设置了过期时间的 key
+	SkipVolatile bool
+}
+
+var DefaultOptions = Options{
+	BatchSize: 1000,
+}
+
+// Report 一次导入的统计
+type Report struct {
+	Strings int
+	Hashes  int
+	Lists   int
+	Sets    int
+	ZSets   int
+	// Elements 写入的元素总数，string 计为 1 个元素
+	Elements int
+	// Expired 导入时已经过期而被跳过的 key 数量
+	Expired int
+	// Volatile 设置了过期时间但依然被导入的 key 数量，这些 key 在 fastdb 中不会过期
+	Volatile int
+	// SkippedVolatile 因为 SkipVolatile 被跳过的 key 数量
+	SkippedVolatile int
+	// Unsupported 不支持的类型被跳过的 key 数量，以 RDB 中的类型名称为 key
+	Unsupported map[string]int
+}
+
+// Keys 导入的 key 总数
+func (r *Report) Keys() int {
+	return r.Strings + r.Hashes + r.Lists + r.Sets + r.ZSets
+}
+
+// ImportFile 导入路径为 path 的 RDB 文件
+func ImportFile(db *core.DB, path string, options Options) (*Report, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	return Import(db, fd, options)
+}
+
+// Import 解析 r 中的 RDB 数据并写入 db。
+// 多个 key 被攒成一个 batch 提交，每个 batch 是原子的，但整个导入不是，出错时已经提交的 batch 会保留
+func Import(db *core.DB, r io.Reader, options Options) (*Report, error) {
+	if options.BatchSize <= 0 {
+		return nil, errors.New("batch size must be greater than 0")
+	}
+	im := &importer{
+		db:      db,
+		options: options,
+		report:  &Report{Unsupported: make(map[string]int)},
+		now:     time.Now(),
+	}
+	if len(options.Databases) > 0 {
+		im.databases = make(map[int]bool, len(options.Databases))
+		for _, id := range options.Databases {
+			im.databases[id] = true
+		}
+	}
+
+	err := parser.NewDecoder(r).Parse(func(object parser.RedisObject) bool {
+		im.err = im.add(object)
+		return im.err == nil
+	})
+	if err != nil {
+		return im.report, err
+	}
+	if im.err != nil {
+		return im.report, im.err
+	}
+	if err = im.flush(); err != nil {
+		return im.report, err
+	}
+	return im.report, nil
+}
+
+type importer struct {
+	db        *core.DB
+	options   Options
+	report    *Report
+	now       time.Time
+	databases map[int]bool
+	// pending 等待写入的 key，以及它们包含的元素数量
+	pending  []parser.RedisObject
+	elements int
+	err      error
+}
+
+// add 记录一个需要导入的 key，攒够 BatchSize 个元素后提交
+func (im *importer) add(object parser.RedisObject) error {
+	switch object.GetType() {
+	case parser.AuxType, parser.DBSizeType:
+		return nil
+	}
+	if im.databases != nil && !im.databases[object.GetDBIndex()] {
+		return nil
+	}
+	switch object.GetType() {
+	case parser.StringType, parser.HashType, parser.ListType, parser.SetType, parser.ZSetType:
+	default:
+		im.report.Unsupported[object.GetType()]++
+		return nil
+	}
+	if expiration := object.GetExpiration(); expiration != nil {
+		if !expiration.After(im.now) {
+			im.report.Expired++
+			return nil
+		}
+		if im.options.SkipVolatile {
+			im.report.SkippedVolatile++
+			return nil
+		}
+		im.report.Volatile++
+	}
+
+	im.pending = append(im.pending, object)
+	im.elements += elementCount(object)
+	if im.elements >= im.options.BatchSize {
+		return im.flush()
+	}
+	return nil
+}
+
+func elementCount(object parser.RedisObject) int {
+	if object.GetType() == parser.StringType {
+		return 1
+	}
+	return object.GetElemCount()
+}
+
+// flush 在一个 batch 中写入所有等待中的 key
+func (im *importer) flush() error {
+	if len(im.pending) == 0 {
+		return nil
+	}
+	options := config.DefaultBatchOptions
+	options.Sync = false
+	batch := im.db.NewBatch(options)
+	for _, object := range im.pending {
+		if err := im.write(batch, object); err != nil {
+			batch.Close()
+			return err
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+	for _, object := range im.pending {
+		im.count(object)
+	}
+	im.pending = im.pending[:0]
+	im.elements = 0
+	return nil
+}
+
+func (im *importer) write(batch *core.Batch, object parser.RedisObject) error {
+	key := []byte(object.GetKey())
+	switch object := object.(type) {
+	case *parser.StringObject:
+		return batch.Put(key, object.Value)
+	case *parser.HashObject:
+		for field, value := range object.Hash {
+			if _, err := batch.HSet(key, []byte(field), value); err != nil {
+				return err
+			}
+		}
+	case *parser.ListObject:
+		if _, err := batch.RPush(key, object.Values...); err != nil {
+			return err
+		}
+	case *parser.SetObject:
+		if _, err := batch.SAdd(key, object.Members...); err != nil {
+			return err
+		}
+	case *parser.ZSetObject:
+		for _, entry := range object.Entries {
+			if _, err := batch.ZAdd(key, entry.Score, []byte(entry.Member)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// count 在 batch 提交之后统计写入的 key
+func (im *importer) count(object parser.RedisObject) {
+	switch object.GetType() {
+	case parser.StringType:
+		im.report.Strings++
+	case parser.HashType:
+		im.report.Hashes++
+	case parser.ListType:
+		im.report.Lists++
+	case parser.SetType:
+		im.report.Sets++
+	case parser.ZSetType:
+		im.report.ZSets++
+	}
+	im.report.Elements += elementCount(object)
+}
@@ -0,0 +1,181 @@
+package rdbimport
+
+import (
+	"fastdb/common"
+	"fastdb/config"
+	"fastdb/core"
+	"fmt"
+	"github.com/hdt3213/rdb/encoder"
+	"github.com/hdt3213/rdb/model"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// writeFixture 使用 encoder 在本地生成 RDB 文件，小的数据结构会被编码为 ziplist 或 intset，
+// 大的数据结构会被编码为 quicklist 与哈希表，字符串开启了 LZF 压缩
+func writeFixture(t *testing.T, fn func(enc *encoder.Encoder)) string {
+	path := filepath.Join(t.TempDir(), "dump.rdb")
+	fd, err := os.Create(path)
+	assert.Nil(t, err)
+	defer fd.Close()
+
+	enc := encoder.NewEncoder(fd).EnableCompress()
+	assert.Nil(t, enc.WriteHeader())
+	assert.Nil(t, enc.WriteAux("redis-ver", "7.0.0"))
+	fn(enc)
+	assert.Nil(t, enc.WriteEnd())
+	return path
+}
+
+func openDB(t *testing.T) *core.DB {
+	options := config.DefaultOptions
+	options.DirPath = t.TempDir()
+	db, err := core.Open(options)
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db
+}
+
+func TestImport(t *testing.T) {
+	future := uint64(time.Now().Add(time.Hour).UnixMilli())
+	past := uint64(time.Now().Add(-time.Hour).UnixMilli())
+
+	bigList := make([][]byte, 600)
+	bigHash := make(map[string][]byte)
+	bigZSet := make([]*model.ZSetEntry, 300)
+	for i := range bigList {
+		bigList[i] = common.RandomValue(100)
+		bigHash[fmt.Sprintf("field-%d", i)] = []byte(strconv.Itoa(i))
+	}
+	for i := range bigZSet {
+		bigZSet[i] = &model.ZSetEntry{Member: fmt.Sprintf("member-%d", i), Score: float64(i) / 2}
+	}
+
+	path := writeFixture(t, func(enc *encoder.Encoder) {
+		assert.Nil(t, enc.WriteDBHeader(0, 12, 2))
+		assert.Nil(t, enc.WriteStringObject("string", []byte("value")))
+		assert.Nil(t, enc.WriteStringObject("number", []byte("12345")))
+		assert.Nil(t, enc.WriteStringObject("compressible", make([]byte, 4096)))
+		assert.Nil(t, enc.WriteStringObject("volatile", []byte("ttl"), encoder.WithTTL(future)))
+		assert.Nil(t, enc.WriteStringObject("expired", []byte("ttl"), encoder.WithTTL(past)))
+		assert.Nil(t, enc.WriteHashMapObject("hash", map[string][]byte{"a": []byte("1"), "b": []byte("2")}))
+		assert.Nil(t, enc.WriteHashMapObject("big-hash", bigHash))
+		assert.Nil(t, enc.WriteListObject("list", [][]byte{[]byte("x"), []byte("y"), []byte("z")}))
+		assert.Nil(t, enc.WriteListObject("big-list", bigList))
+		assert.Nil(t, enc.WriteSetObject("intset", [][]byte{[]byte("1"), []byte("2"), []byte("3")}))
+		assert.Nil(t, enc.WriteSetObject("set", [][]byte{[]byte("a"), []byte("b")}))
+		assert.Nil(t, enc.WriteZSetObject("zset", []*model.ZSetEntry{{Member: "a", Score: 1.5}, {Member: "b", Score: -1}}))
+		assert.Nil(t, enc.WriteZSetObject("big-zset", bigZSet))
+	})
+
+	db := openDB(t)
+	options := DefaultOptions
+	options.BatchSize = 100
+	report, err := ImportFile(db, path, options)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, report.Strings)
+	assert.Equal(t, 2, report.Hashes)
+	assert.Equal(t, 2, report.Lists)
+	assert.Equal(t, 2, report.Sets)
+	assert.Equal(t, 2, report.ZSets)
+	assert.Equal(t, 12, report.Keys())
+	assert.Equal(t, 1, report.Expired)
+	assert.Equal(t, 1, report.Volatile)
+	assert.Equal(t, 4+2+600+3+600+3+2+2+300, report.Elements)
+
+	value, err := db.Get([]byte("string"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	value, err = db.Get([]byte("number"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("12345"), value)
+	value, err = db.Get([]byte("compressible"))
+	assert.Nil(t, err)
+	assert.Equal(t, make([]byte, 4096), value)
+	_, err = db.Get([]byte("volatile"))
+	assert.Nil(t, err)
+	_, err = db.Get([]byte("expired"))
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+
+	value, err = db.HGet([]byte("hash"), []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), value)
+	size, err := db.HLen([]byte("big-hash"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(600), size)
+
+	values, err := db.LRange([]byte("list"), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("x"), []byte("y"), []byte("z")}, values)
+	values, err = db.LRange([]byte("big-list"), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, bigList, values)
+
+	members, err := db.SMembers([]byte("intset"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("1"), []byte("2"), []byte("3")}, members)
+	ok, err := db.SIsMember([]byte("set"), []byte("b"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	score, err := db.ZScore([]byte("zset"), []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(-1), score)
+	rank, err := db.ZRank([]byte("big-zset"), []byte("member-299"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(299), rank)
+}
+
+func TestImport_Options(t *testing.T) {
+	future := uint64(time.Now().Add(time.Hour).UnixMilli())
+	path := writeFixture(t, func(enc *encoder.Encoder) {
+		assert.Nil(t, enc.WriteDBHeader(0, 2, 1))
+		assert.Nil(t, enc.WriteStringObject("db0", []byte("0")))
+		assert.Nil(t, enc.WriteStringObject("volatile", []byte("ttl"), encoder.WithTTL(future)))
+		assert.Nil(t, enc.WriteDBHeader(3, 1, 0))
+		assert.Nil(t, enc.WriteStringObject("db3", []byte("3")))
+	})
+
+	db := openDB(t)
+	options := DefaultOptions
+	options.Databases = []int{3}
+	report, err := ImportFile(db, path, options)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Keys())
+	_, err = db.Get([]byte("db0"))
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+	value, err := db.Get([]byte("db3"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("3"), value)
+
+	options = DefaultOptions
+	options.SkipVolatile = true
+	report, err = ImportFile(db, path, options)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, report.Keys())
+	assert.Equal(t, 1, report.SkippedVolatile)
+	_, err = db.Get([]byte("volatile"))
+	assert.ErrorIs(t, err, common.ErrKeyNotFound)
+
+	// 类型冲突时返回错误
+	path = writeFixture(t, func(enc *encoder.Encoder) {
+		assert.Nil(t, enc.WriteDBHeader(0, 1, 0))
+		assert.Nil(t, enc.WriteListObject("conflict", [][]byte{[]byte("x")}))
+	})
+	_, err = db.SAdd([]byte("conflict"), []byte("member"))
+	assert.Nil(t, err)
+	_, err = ImportFile(db, path, DefaultOptions)
+	assert.ErrorIs(t, err, common.ErrWrongType)
+
+	_, err = ImportFile(db, filepath.Join(t.TempDir(), "missing.rdb"), DefaultOptions)
+	assert.NotNil(t, err)
+	options.BatchSize = 0
+	_, err = ImportFile(db, path, options)
+	assert.NotNil(t, err)
+}
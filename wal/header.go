@@ -0,0 +1,101 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+var (
+	ErrInvalidHeader      = errors.New("invalid segment header, the file may be corrupted or not a fastdb segment")
+	ErrUnsupportedVersion = errors.New("unsupported segment format version")
+	ErrNoKeyProvider      = errors.New("the segment is encrypted but no key provider is configured")
+)
+
+const (
+	// FormatVersion 当前 segment 文件的格式版本，LogRecord 的编码发生不兼容的变化时需要增加
+	FormatVersion uint16 = 1
+
+	// 24 Bytes
+	// Magic Version Flags SegmentId CreatedAt Checksum
+	//   4      2      2       4         8        4
+	segmentHeaderSize = 24
+)
+
+const (
+	// SegmentFlagCompressed segment 中的记录可能经过压缩
+	SegmentFlagCompressed uint16 = 1 << iota
+	// SegmentFlagEncrypted segment 中的 chunk 经过加密
+	SegmentFlagEncrypted
+)
+
+var segmentMagic = []byte("FSEG")
+
+// SegmentHeader 位于 segment 文件第一个 block 的开头，没有头部的旧版本文件从偏移量 0 开始就是 chunk
+type SegmentHeader struct {
+	Version   uint16
+	Flags     uint16
+	SegmentId SegmentID
+	CreatedAt time.Time
+}
+
+func (h *SegmentHeader) Encrypted() bool {
+	return h.Flags&SegmentFlagEncrypted != 0
+}
+
+func (h *SegmentHeader) encode() []byte {
+	buf := make([]byte, segmentHeaderSize)
+	copy(buf[:4], segmentMagic)
+	binary.LittleEndian.PutUint16(buf[4:6], h.Version)
+	binary.LittleEndian.PutUint16(buf[6:8], h.Flags)
+	binary.LittleEndian.PutUint32(buf[8:12], h.SegmentId)
+	binary.LittleEndian.PutUint64(buf[12:20], uint64(h.CreatedAt.UnixMilli()))
+	binary.LittleEndian.PutUint32(buf[20:24], crc32.ChecksumIEEE(buf[:20]))
+	return buf
+}
+
+// decodeSegmentHeader 解析文件开头的字节，不以 magic 开头时代表没有头部的旧版本文件，返回 nil
+func decodeSegmentHeader(buf []byte) (*SegmentHeader, error) {
+	if len(buf) < len(segmentMagic) || !bytes.Equal(buf[:4], segmentMagic) {
+		return nil, nil
+	}
+	if len(buf) < segmentHeaderSize ||
+		binary.LittleEndian.Uint32(buf[20:24]) != crc32.ChecksumIEEE(buf[:20]) {
+		return nil, ErrInvalidHeader
+	}
+	header := &SegmentHeader{
+		Version:   binary.LittleEndian.Uint16(buf[4:6]),
+		Flags:     binary.LittleEndian.Uint16(buf[6:8]),
+		SegmentId: binary.LittleEndian.Uint32(buf[8:12]),
+		CreatedAt: time.UnixMilli(int64(binary.LittleEndian.Uint64(buf[12:20]))),
+	}
+	if header.Version == 0 || header.Version > FormatVersion {
+		return nil, fmt.Errorf("%w %d, the newest supported version is %d",
+			ErrUnsupportedVersion, header.Version, FormatVersion)
+	}
+	return header, nil
+}
+
+// ReadSegmentHeader 读取 segment 文件的头部，没有头部的旧版本文件返回 nil
+func ReadSegmentHeader(path string) (*SegmentHeader, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	return readSegmentHeader(fd)
+}
+
+func readSegmentHeader(r io.ReaderAt) (*SegmentHeader, error) {
+	buf := make([]byte, segmentHeaderSize)
+	n, err := r.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return decodeSegmentHeader(buf[:n])
+}
@@ -0,0 +1,36 @@
+package wal
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSegmentHeader_Decode(t *testing.T) {
+	header := &SegmentHeader{
+		Version:   FormatVersion,
+		Flags:     SegmentFlagEncrypted,
+		SegmentId: 3,
+		CreatedAt: time.UnixMilli(time.Now().UnixMilli()),
+	}
+	decoded, err := decodeSegmentHeader(header.encode())
+	assert.Nil(t, err)
+	assert.Equal(t, header, decoded)
+
+	// 不以 magic 开头的是旧版本的文件
+	decoded, err = decodeSegmentHeader([]byte{1, 2, 3, 4, 5, 6, 7})
+	assert.Nil(t, err)
+	assert.Nil(t, decoded)
+
+	buf := header.encode()
+	buf[10] ^= 0xff
+	_, err = decodeSegmentHeader(buf)
+	assert.ErrorIs(t, err, ErrInvalidHeader)
+
+	_, err = decodeSegmentHeader(header.encode()[:10])
+	assert.ErrorIs(t, err, ErrInvalidHeader)
+
+	header.Version = FormatVersion + 1
+	_, err = decodeSegmentHeader(header.encode())
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+}
@@ -2,6 +2,7 @@ package wal
 
 import (
 	"fastdb/config"
+	"fastdb/lib/encrypt"
 	"os"
 )
 
@@ -22,6 +23,15 @@ type Options struct {
 
 	// BytesPerSync 指定 在调用 fsync函数之前，应写入的 字节数
 	BytesPerSync uint32
+
+	// ReadOnly 以只读方式打开，不会创建或写入 segment 文件，用于离线检查数据文件
+	ReadOnly bool
+
+	// Compressed 记录中的数据可能经过压缩，会被记录在 segment 的头部
+	Compressed bool
+
+	// KeyProvider 不为 nil 时使用 AES-GCM 加密写入的每个 chunk
+	KeyProvider encrypt.KeyProvider
 }
 
 var DefaultOptions = Options{
@@ -4,6 +4,8 @@ import (
 	"encoding/binary"
 	"errors"
 	"fastdb/config"
+	"fastdb/lib/encrypt"
+	"fmt"
 	lru "github.com/hashicorp/golang-lru/v2"
 	"hash/crc32"
 	"io"
@@ -11,8 +13,9 @@ import (
 )
 
 var (
-	ErrClosed     = errors.New("the segment file is closed")
-	ErrInvalidCRC = errors.New("invalid crc, the data may be corrupted")
+	ErrClosed          = errors.New("the segment file is closed")
+	ErrInvalidCRC      = errors.New("invalid crc, the data may be corrupted")
+	ErrInvalidPosition = errors.New("invalid encoded chunk position")
 )
 
 type ChunkType = byte
@@ -50,27 +53,96 @@ type ChunkPosition struct {
 	ChunkSize uint32
 }
 
+// FileOffset 返回 chunk 在 segment 文件中的偏移量
+func (cp *ChunkPosition) FileOffset() int64 {
+	return int64(cp.BlockNumber)*blockSize + cp.ChunkOffset
+}
+
+// Encode 将位置编码为变长的字节序列，用于持久化保存位置
+func (cp *ChunkPosition) Encode() []byte {
+	buf := make([]byte, binary.MaxVarintLen32*3+binary.MaxVarintLen64)
+	index := binary.PutUvarint(buf, uint64(cp.SegmentId))
+	index += binary.PutUvarint(buf[index:], uint64(cp.BlockNumber))
+	index += binary.PutUvarint(buf[index:], uint64(cp.ChunkOffset))
+	index += binary.PutUvarint(buf[index:], uint64(cp.ChunkSize))
+	return buf[:index]
+}
+
+// DecodeChunkPosition 解码 Encode 的结果
+func DecodeChunkPosition(buf []byte) (*ChunkPosition, error) {
+	var fields [4]uint64
+	for i := range fields {
+		value, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return nil, ErrInvalidPosition
+		}
+		fields[i] = value
+		buf = buf[n:]
+	}
+	return &ChunkPosition{
+		SegmentId:   SegmentID(fields[0]),
+		BlockNumber: uint32(fields[1]),
+		ChunkOffset: int64(fields[2]),
+		ChunkSize:   uint32(fields[3]),
+	}, nil
+}
+
+// ChunkInfo 描述一个物理 chunk，用于离线检查数据文件
+type ChunkInfo struct {
+	BlockNumber uint32
+	ChunkOffset int64
+	Type        ChunkType
+	// Length chunk 中数据的字节数，加密时为密文的字节数
+	Length uint16
+	// Valid crc 校验是否通过
+	Valid bool
+}
+
 type segment struct {
 	id                 SegmentID
 	fd                 *os.File
 	currentBlockNumber uint32
 	currentBlockSize   uint32
 	closed             bool
-	cache              *lru.Cache[uint64, []byte]
+	// cache 中缓存的是解密之后的 block
+	cache *lru.Cache[uint64, []byte]
+	// cipher 不为 nil 时每个 chunk 的数据都单独加密
+	cipher *encrypt.Cipher
+	// header 为 nil 时代表没有头部的旧版本文件
+	header *SegmentHeader
 }
 
 type segmentReader struct {
 	segment     *segment
 	blockNumber uint32
 	chunkOffset int64
+	uncached    bool
+	last        readBlock
 }
 
 func (seg *segment) Read(blockNumber uint32, chunkOffset int64) ([]byte, error) {
-	value, _, err := seg.readInternal(blockNumber, chunkOffset)
+	value, _, err := seg.readInternal(blockNumber, chunkOffset, nil)
 	return value, err
 }
 
-func (seg *segment) readInternal(blockNumber uint32, chunkOffset int64) ([]byte, *ChunkPosition, error) {
+// readOptions 顺序读取整个 segment 时使用的选项，读取单条数据时为 nil
+type readOptions struct {
+	// chunks 不为 nil 时记录读取过的每个物理 chunk
+	chunks *[]ChunkInfo
+	// uncached 为 true 时不使用 block cache，总是从文件中读取，用于校验磁盘上的数据
+	uncached bool
+	// last 最近一次从文件中读取的 block，顺序读取时避免重复读取同一个 block
+	last *readBlock
+}
+
+type readBlock struct {
+	number    uint32
+	data      []byte
+	validSize int64
+}
+
+// readInternal 读取从 chunkOffset 开始的一条完整数据
+func (seg *segment) readInternal(blockNumber uint32, chunkOffset int64, opts *readOptions) ([]byte, *ChunkPosition, error) {
 	if seg.closed {
 		return nil, nil, ErrClosed
 	}
@@ -91,23 +163,12 @@ func (seg *segment) readInternal(blockNumber uint32, chunkOffset int64) ([]byte,
 			return nil, nil, io.EOF
 		}
 
-		var block []byte
-		var ok bool
-		// 先尝试从缓存中读
-		if seg.cache != nil {
-			block, ok = seg.cache.Get(seg.getCacheKey(blockNumber))
+		block, validSize, err := seg.readBlock(blockNumber, size, opts)
+		if err != nil {
+			return nil, nil, err
 		}
-
-		if !ok || len(block) == 0 {
-			block = make([]byte, size)
-			_, err := seg.fd.ReadAt(block, offset)
-			if err != nil {
-				return nil, nil, err
-			}
-			// 将读出来的block放入到缓存中
-			if seg.cache != nil && size == blockSize {
-				seg.cache.Add(seg.getCacheKey(blockNumber), block)
-			}
+		if chunkOffset >= validSize || chunkOffset+chunkHeaderSize > size {
+			return nil, nil, ErrInvalidCRC
 		}
 
 		header := make([]byte, chunkHeaderSize)
@@ -117,22 +178,37 @@ func (seg *segment) readInternal(blockNumber uint32, chunkOffset int64) ([]byte,
 		length := binary.LittleEndian.Uint16(header[4:6])
 
 		start := chunkOffset + chunkHeaderSize
-		result = append(result, block[start:start+int64(length)]...)
-
-		// 校验和
 		checksumEnd := chunkOffset + chunkHeaderSize + int64(length)
-		checksum := crc32.ChecksumIEEE(block[chunkOffset+4 : checksumEnd])
-		savedSum := binary.LittleEndian.Uint32(header[:4])
-		if savedSum != checksum {
+		info := ChunkInfo{BlockNumber: blockNumber, ChunkOffset: chunkOffset, Type: header[6], Length: length, Valid: true}
+		if checksumEnd > size {
+			info.Valid = false
+			opts.appendChunk(info)
 			return nil, nil, ErrInvalidCRC
 		}
+		if seg.cipher != nil {
+			// 解密时已经校验过，明文位于 key id 与 nonce 之后
+			plainStart := start + encrypt.PrefixSize
+			result = append(result, block[plainStart:plainStart+int64(length)-encrypt.Overhead]...)
+		} else {
+			result = append(result, block[start:checksumEnd]...)
+
+			// 校验和
+			checksum := crc32.ChecksumIEEE(block[chunkOffset+4 : checksumEnd])
+			savedSum := binary.LittleEndian.Uint32(header[:4])
+			if savedSum != checksum {
+				info.Valid = false
+				opts.appendChunk(info)
+				return nil, nil, ErrInvalidCRC
+			}
+		}
+		opts.appendChunk(info)
 
 		// type
 		chunkType := header[6]
 		if chunkType == ChunkTypeFull || chunkType == ChunkTypeLast {
 			nextChunk.BlockNumber = blockNumber
 			nextChunk.ChunkOffset = checksumEnd
-			if checksumEnd+chunkHeaderSize >= blockSize {
+			if checksumEnd+int64(seg.chunkOverhead()) >= blockSize {
 				nextChunk.BlockNumber += 1
 				nextChunk.ChunkOffset = 0
 			}
@@ -146,6 +222,95 @@ func (seg *segment) readInternal(blockNumber uint32, chunkOffset int64) ([]byte,
 	return result, nextChunk, nil
 }
 
+// readBlock 读取一个 block，返回 block 中通过校验的字节数
+func (seg *segment) readBlock(blockNumber uint32, size int64, opts *readOptions) ([]byte, int64, error) {
+	uncached := opts != nil && opts.uncached
+	if uncached && opts.last != nil && opts.last.number == blockNumber && int64(len(opts.last.data)) == size {
+		return opts.last.data, opts.last.validSize, nil
+	}
+	// 先尝试从缓存中读
+	if seg.cache != nil && !uncached {
+		if block, ok := seg.cache.Get(seg.getCacheKey(blockNumber)); ok && len(block) > 0 {
+			return block, size, nil
+		}
+	}
+
+	block := make([]byte, size)
+	if _, err := seg.fd.ReadAt(block, int64(blockNumber*blockSize)); err != nil {
+		return nil, 0, err
+	}
+	// validSize block 中通过校验的字节数
+	validSize := size
+	if seg.cipher != nil {
+		var err error
+		if validSize, err = seg.decryptBlock(block, seg.blockStart(blockNumber)); err != nil {
+			return nil, 0, err
+		}
+	}
+	if uncached {
+		if opts.last != nil {
+			*opts.last = readBlock{number: blockNumber, data: block, validSize: validSize}
+		}
+		return block, validSize, nil
+	}
+	// 将读出来的block放入到缓存中
+	if seg.cache != nil && size == blockSize && validSize == size {
+		seg.cache.Add(seg.getCacheKey(blockNumber), block)
+	}
+	return block, validSize, nil
+}
+
+func (opts *readOptions) appendChunk(info ChunkInfo) {
+	if opts != nil && opts.chunks != nil {
+		*opts.chunks = append(*opts.chunks, info)
+	}
+}
+
+// decryptBlock 在原来的位置解密 block 中从 start 开始的所有 chunk，返回通过校验的字节数。
+// 解密前先检查 crc，crc 正确但无法解密说明使用了错误的密钥
+func (seg *segment) decryptBlock(block []byte, start int64) (int64, error) {
+	size := int64(len(block))
+	offset := start
+	for offset < size {
+		// 剩余的空间放不下一个 chunk 时写入时会填充到 block 结尾
+		if offset+int64(seg.chunkOverhead()) >= blockSize {
+			break
+		}
+		if offset+chunkHeaderSize > size {
+			return offset, nil
+		}
+		length := binary.LittleEndian.Uint16(block[offset+4 : offset+6])
+		end := offset + chunkHeaderSize + int64(length)
+		if end > size {
+			return offset, nil
+		}
+		if crc32.ChecksumIEEE(block[offset+4:end]) != binary.LittleEndian.Uint32(block[offset:offset+4]) {
+			return offset, nil
+		}
+		if _, err := seg.cipher.Open(block[offset+chunkHeaderSize : end]); err != nil {
+			return offset, fmt.Errorf("segment %d: %w", seg.id, err)
+		}
+		offset = end
+	}
+	return size, nil
+}
+
+// blockStart 返回 block 中第一个 chunk 的偏移量，第一个 block 的开头是 segment 的头部
+func (seg *segment) blockStart(blockNumber uint32) int64 {
+	if blockNumber == 0 && seg.header != nil {
+		return segmentHeaderSize
+	}
+	return 0
+}
+
+// chunkOverhead 返回每个 chunk 中除数据之外占用的字节数
+func (seg *segment) chunkOverhead() uint32 {
+	if seg.cipher != nil {
+		return chunkHeaderSize + encrypt.Overhead
+	}
+	return chunkHeaderSize
+}
+
 func (seg *segment) Size() int64 {
 	return int64(seg.currentBlockNumber*blockSize + seg.currentBlockSize)
 }
@@ -166,7 +331,8 @@ func (seg *segment) Write(data []byte) (*ChunkPosition, error) {
 		return nil, ErrClosed
 	}
 
-	if seg.currentBlockSize+chunkHeaderSize >= blockSize {
+	overhead := seg.chunkOverhead()
+	if seg.currentBlockSize+overhead >= blockSize {
 		if seg.currentBlockSize < blockSize {
 			padding := make([]byte, blockSize-seg.currentBlockSize)
 			if _, err := seg.fd.Write(padding); err != nil {
@@ -187,19 +353,19 @@ func (seg *segment) Write(data []byte) (*ChunkPosition, error) {
 	}
 	dataSize := uint32(len(data))
 
-	if seg.currentBlockSize+dataSize+chunkHeaderSize <= blockSize {
+	if seg.currentBlockSize+dataSize+overhead <= blockSize {
 		err := seg.writeInternal(data, ChunkTypeFull)
 		if err != nil {
 			return nil, err
 		}
-		position.ChunkSize = dataSize + chunkHeaderSize
+		position.ChunkSize = dataSize + overhead
 		return position, nil
 	}
 
 	var leftSize = dataSize
 	var blockCount uint32 = 0
 	for leftSize > 0 {
-		chunkSize := blockSize - seg.currentBlockSize - chunkHeaderSize
+		chunkSize := blockSize - seg.currentBlockSize - overhead
 		if chunkSize > leftSize {
 			chunkSize = leftSize
 		}
@@ -230,12 +396,15 @@ func (seg *segment) Write(data []byte) (*ChunkPosition, error) {
 		blockCount += 1
 	}
 
-	position.ChunkSize = blockCount*chunkHeaderSize + dataSize
+	position.ChunkSize = blockCount*overhead + dataSize
 	return position, nil
 
 }
 
 func (seg *segment) writeInternal(data []byte, chunkType ChunkType) error {
+	if seg.cipher != nil {
+		data = seg.cipher.Seal(data)
+	}
 	dataSize := uint32(len(data))
 	buf := make([]byte, dataSize+chunkHeaderSize)
 
@@ -271,11 +440,15 @@ func (seg *segment) NewReader() *segmentReader {
 	return &segmentReader{
 		segment:     seg,
 		blockNumber: 0,
-		chunkOffset: 0,
+		chunkOffset: seg.blockStart(0),
 	}
 }
 
 func (segReader *segmentReader) Next() ([]byte, *ChunkPosition, error) {
+	return segReader.next(nil)
+}
+
+func (segReader *segmentReader) next(chunks *[]ChunkInfo) ([]byte, *ChunkPosition, error) {
 	// The segment file is closed
 	if segReader.segment.closed {
 		return nil, nil, ErrClosed
@@ -289,9 +462,10 @@ func (segReader *segmentReader) Next() ([]byte, *ChunkPosition, error) {
 	value, nextChunk, err := segReader.segment.readInternal(
 		segReader.blockNumber,
 		segReader.chunkOffset,
+		&readOptions{chunks: chunks, uncached: segReader.uncached, last: &segReader.last},
 	)
 	if err != nil {
-		return nil, nil, err
+		return nil, chunkPosition, err
 	}
 
 	chunkPosition.ChunkSize =
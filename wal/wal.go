@@ -2,6 +2,7 @@ package wal
 
 import (
 	"errors"
+	"fastdb/lib/encrypt"
 	"fmt"
 	lru "github.com/hashicorp/golang-lru/v2"
 	"io"
@@ -10,6 +11,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -18,6 +20,8 @@ const (
 
 var (
 	ErrValueTooLarge = errors.New("the data size can't larger than segment size")
+	ErrReadOnly      = errors.New("the wal is opened in read only mode")
+	ErrNoSegment     = errors.New("no segment file found")
 )
 
 type WAL struct {
@@ -28,6 +32,7 @@ type WAL struct {
 	options       Options
 	mu            sync.RWMutex
 	blockCache    *lru.Cache[uint64, []byte]
+	cipher        *encrypt.Cipher
 	bytesWrite    uint32
 }
 
@@ -57,7 +62,10 @@ func (wal *WAL) Read(pos *ChunkPosition) ([]byte, error) {
 func (wal *WAL) Write(data []byte) (*ChunkPosition, error) {
 	wal.mu.Lock()
 	defer wal.mu.Unlock()
-	if int64(len(data))+chunkHeaderSize > wal.options.SegmentSize {
+	if wal.options.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if wal.maxWriteSize(int64(len(data))) > wal.options.SegmentSize {
 		return nil, ErrValueTooLarge
 	}
 
@@ -67,7 +75,7 @@ func (wal *WAL) Write(data []byte) (*ChunkPosition, error) {
 			return nil, err
 		}
 		wal.bytesWrite = 0
-		segment, err := openSegmentFile(wal.options.DirPath, wal.options.SegmentFileExt, wal.activeSegment.id+1, wal.blockCache)
+		segment, err := wal.openSegmentFile(wal.activeSegment.id + 1)
 		if err != nil {
 			return nil, err
 		}
@@ -98,15 +106,27 @@ func (wal *WAL) Write(data []byte) (*ChunkPosition, error) {
 }
 
 func (wal *WAL) isFull(delta int64) bool {
-	return wal.activeSegment.Size()+delta+chunkHeaderSize > wal.options.SegmentSize
+	return wal.activeSegment.Size()+wal.maxWriteSize(delta) > wal.options.SegmentSize
+}
+
+// maxWriteSize 返回写入 size 字节的数据最多占用的空间。
+// 数据跨 block 时被分成多个 chunk，每个 chunk 都有头部，加密时还要加上 encrypt.Overhead，
+// block 末尾放不下 chunk 头部的空间会被填充
+func (wal *WAL) maxWriteSize(size int64) int64 {
+	overhead := int64(wal.activeSegment.chunkOverhead())
+	chunks := size/(blockSize-overhead) + 2
+	return size + chunks*overhead
 }
 
-func openSegmentFile(dirPath, extName string, id uint32, cache *lru.Cache[uint64, []byte]) (*segment, error) {
-	fd, err := os.OpenFile(
-		SegmentFileName(dirPath, extName, id),
-		os.O_CREATE|os.O_RDWR|os.O_APPEND,
-		fileModePerm,
-	)
+// openSegmentFile 打开 id 对应的 segment 文件，新创建的文件会先写入头部，
+// 已有的文件会校验头部，没有头部的旧版本文件只用于读取
+func (wal *WAL) openSegmentFile(id SegmentID) (*segment, error) {
+	extName := wal.options.SegmentFileExt
+	flag := os.O_CREATE | os.O_RDWR | os.O_APPEND
+	if wal.options.ReadOnly {
+		flag = os.O_RDONLY
+	}
+	fd, err := os.OpenFile(SegmentFileName(wal.options.DirPath, extName, id), flag, fileModePerm)
 	if err != nil {
 		return nil, err
 	}
@@ -116,15 +136,59 @@ func openSegmentFile(dirPath, extName string, id uint32, cache *lru.Cache[uint64
 		panic(fmt.Errorf("seek to the end of segment file %d%s failed: %v", id, extName, err))
 	}
 
+	var header *SegmentHeader
+	if offset == 0 && !wal.options.ReadOnly {
+		header = &SegmentHeader{Version: FormatVersion, SegmentId: id, CreatedAt: time.Now()}
+		if wal.options.Compressed {
+			header.Flags |= SegmentFlagCompressed
+		}
+		if wal.cipher != nil {
+			header.Flags |= SegmentFlagEncrypted
+		}
+		if _, err = fd.Write(header.encode()); err != nil {
+			_ = fd.Close()
+			return nil, err
+		}
+		offset = segmentHeaderSize
+	} else if header, err = readSegmentHeader(fd); err != nil {
+		_ = fd.Close()
+		return nil, fmt.Errorf("segment file %d%s: %w", id, extName, err)
+	}
+
+	// 没有头部的只能是加密功能出现之前的旧版本文件，一定没有加密
+	var cipher *encrypt.Cipher
+	if header != nil {
+		cipher = wal.cipher
+		if header.SegmentId != id {
+			_ = fd.Close()
+			return nil, fmt.Errorf("segment file %d%s: %w, the header has segment id %d",
+				id, extName, ErrInvalidHeader, header.SegmentId)
+		}
+		if !header.Encrypted() {
+			cipher = nil
+		} else if cipher == nil {
+			_ = fd.Close()
+			return nil, fmt.Errorf("segment file %d%s: %w", id, extName, ErrNoKeyProvider)
+		}
+	}
+
 	return &segment{
 		id:                 id,
 		fd:                 fd,
-		cache:              cache,
+		cache:              wal.blockCache,
+		cipher:             cipher,
+		header:             header,
 		currentBlockNumber: uint32(offset / blockSize),
 		currentBlockSize:   uint32(offset % blockSize),
 	}, nil
 }
 
+// writable 判断新的数据是否可以追加到 segment 中，
+// 没有头部的旧版本文件以及加密配置与当前不同的文件不再写入，而是创建新的 segment
+func (wal *WAL) writable(seg *segment) bool {
+	return seg.header != nil && seg.header.Encrypted() == (wal.cipher != nil)
+}
+
 // SegmentFileName returns the file name of a segment file.
 func SegmentFileName(dirPath string, extName string, id SegmentID) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d"+extName, id))
@@ -146,8 +210,10 @@ func Open(options Options) (*WAL, error) {
 		olderSegments: make(map[SegmentID]*segment),
 	}
 	// create the directory if not exists.
-	if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
-		return nil, err
+	if !options.ReadOnly {
+		if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
+			return nil, err
+		}
 	}
 
 	if options.BlockCache > 0 {
@@ -162,6 +228,14 @@ func Open(options Options) (*WAL, error) {
 		wal.blockCache = cache
 	}
 
+	if options.KeyProvider != nil {
+		c, err := encrypt.NewCipher(options.KeyProvider)
+		if err != nil {
+			return nil, err
+		}
+		wal.cipher = c
+	}
+
 	entries, err := os.ReadDir(options.DirPath)
 	if err != nil {
 		return nil, err
@@ -180,9 +254,11 @@ func Open(options Options) (*WAL, error) {
 		segmentIDs = append(segmentIDs, id)
 	}
 
+	if len(segmentIDs) == 0 && options.ReadOnly {
+		return nil, fmt.Errorf("%w in %s", ErrNoSegment, options.DirPath)
+	}
 	if len(segmentIDs) == 0 {
-		segment, err := openSegmentFile(options.DirPath, options.SegmentFileExt,
-			initialSegmentFileID, wal.blockCache)
+		segment, err := wal.openSegmentFile(initialSegmentFileID)
 		if err != nil {
 			return nil, err
 		}
@@ -190,9 +266,9 @@ func Open(options Options) (*WAL, error) {
 	} else {
 		sort.Ints(segmentIDs)
 		for i, segId := range segmentIDs {
-			segment, err := openSegmentFile(options.DirPath, options.SegmentFileExt,
-				uint32(segId), wal.blockCache)
+			segment, err := wal.openSegmentFile(uint32(segId))
 			if err != nil {
+				_ = wal.closeSegments()
 				return nil, err
 			}
 			if i == len(segmentIDs)-1 {
@@ -201,11 +277,49 @@ func Open(options Options) (*WAL, error) {
 				wal.olderSegments[segment.id] = segment
 			}
 		}
+		if !options.ReadOnly && !wal.writable(wal.activeSegment) {
+			segment, err := wal.openSegmentFile(wal.activeSegment.id + 1)
+			if err != nil {
+				_ = wal.closeSegments()
+				return nil, err
+			}
+			wal.olderSegments[wal.activeSegment.id] = wal.activeSegment
+			wal.activeSegment = segment
+		}
+	}
+	if err := wal.checkKey(); err != nil {
+		_ = wal.Close()
+		return nil, err
 	}
 	return wal, nil
 
 }
 
+// checkKey 解密最早的一个 chunk，使用错误的密钥打开时直接返回错误，而不是在读取数据时才失败
+func (wal *WAL) checkKey() error {
+	if wal.cipher == nil {
+		return nil
+	}
+	_, _, err := wal.NewReader().Next()
+	if errors.Is(err, encrypt.ErrUnknownKey) || errors.Is(err, encrypt.ErrDecryptFailed) {
+		return err
+	}
+	return nil
+}
+
+// SegmentSizes 返回所有 segment 文件的 id 以及当前已写入的字节数
+func (wal *WAL) SegmentSizes() map[SegmentID]int64 {
+	wal.mu.RLock()
+	defer wal.mu.RUnlock()
+
+	sizes := make(map[SegmentID]int64, len(wal.olderSegments)+1)
+	for id, segment := range wal.olderSegments {
+		sizes[id] = segment.Size()
+	}
+	sizes[wal.activeSegment.id] = wal.activeSegment.Size()
+	return sizes
+}
+
 func (wal *WAL) NewReader() *Reader {
 	return wal.NewReaderWithMax(0)
 }
@@ -236,22 +350,95 @@ func (wal *WAL) NewReaderWithMax(segId SegmentID) *Reader {
 	}
 }
 
+// NewUncachedReader 返回不经过 block cache 的 Reader，总是从文件中读取，用于校验磁盘上的数据
+func (wal *WAL) NewUncachedReader() *Reader {
+	reader := wal.NewReader()
+	for _, segReader := range reader.segmentReaders {
+		segReader.uncached = true
+	}
+	return reader
+}
+
+// NewReaderAfter 返回从 pos 处的数据之后开始读取的 Reader，同时返回 pos 处的数据，
+// 调用方可以据此确认 pos 处依然是之前写入的数据。pos 所在的 segment 不存在或者无法读取时返回错误
+func (wal *WAL) NewReaderAfter(pos *ChunkPosition) (*Reader, []byte, error) {
+	reader := wal.NewReader()
+	for len(reader.segmentReaders) > 0 && reader.segmentReaders[0].segment.id < pos.SegmentId {
+		reader.segmentReaders = reader.segmentReaders[1:]
+	}
+	if len(reader.segmentReaders) == 0 || reader.segmentReaders[0].segment.id != pos.SegmentId {
+		return nil, nil, fmt.Errorf("segment file %d%s not found", pos.SegmentId, wal.options.SegmentFileExt)
+	}
+
+	segReader := reader.segmentReaders[0]
+	data, nextChunk, err := segReader.segment.readInternal(pos.BlockNumber, pos.ChunkOffset, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	segReader.blockNumber = nextChunk.BlockNumber
+	segReader.chunkOffset = nextChunk.ChunkOffset
+	return reader, data, nil
+}
+
 func (r *Reader) CurrentSegmentId() SegmentID {
 	return r.segmentReaders[r.currentReader].segment.id
 }
 
 func (r *Reader) Next() ([]byte, *ChunkPosition, error) {
+	return r.next(nil)
+}
+
+// NextChunks 与 Next 相同，同时返回组成这条数据的每个物理 chunk，
+// 校验失败时 chunks 的最后一个元素是出错的 chunk
+func (r *Reader) NextChunks() ([]byte, *ChunkPosition, []ChunkInfo, error) {
+	var chunks []ChunkInfo
+	data, position, err := r.next(&chunks)
+	return data, position, chunks, err
+}
+
+func (r *Reader) next(chunks *[]ChunkInfo) ([]byte, *ChunkPosition, error) {
 	if r.currentReader >= len(r.segmentReaders) {
 		return nil, nil, io.EOF
 	}
-	data, position, err := r.segmentReaders[r.currentReader].Next()
+	data, position, err := r.segmentReaders[r.currentReader].next(chunks)
 	if err == io.EOF {
 		r.currentReader++
-		return r.Next()
+		return r.next(chunks)
 	}
 	return data, position, err
 }
 
+// SkipSegment 跳过当前 segment 中剩余的数据，读取出错后可以继续读取之后的 segment
+func (r *Reader) SkipSegment() {
+	if r.currentReader < len(r.segmentReaders) {
+		r.currentReader++
+	}
+}
+
+// SkipBlock 从当前 segment 的下一个 block 开始继续读取，用于跳过损坏的 block。
+// 下一个 block 可能以跨 block 记录的 Middle 或 Last chunk 开始，调用方可以通过 NextChunks 识别并丢弃
+func (r *Reader) SkipBlock() {
+	if r.currentReader < len(r.segmentReaders) {
+		segReader := r.segmentReaders[r.currentReader]
+		segReader.blockNumber++
+		segReader.chunkOffset = 0
+	}
+}
+
+// SkipChunk 从 chunk 之后继续读取，用于跳过 crc 校验失败但头部完好的 chunk，
+// chunk 是 NextChunks 返回的当前 segment 中的 chunk
+func (r *Reader) SkipChunk(chunk ChunkInfo) {
+	if r.currentReader < len(r.segmentReaders) {
+		segReader := r.segmentReaders[r.currentReader]
+		segReader.blockNumber = chunk.BlockNumber
+		segReader.chunkOffset = chunk.ChunkOffset + chunkHeaderSize + int64(chunk.Length)
+		if segReader.chunkOffset+int64(segReader.segment.chunkOverhead()) >= blockSize {
+			segReader.blockNumber++
+			segReader.chunkOffset = 0
+		}
+	}
+}
+
 func (wal *WAL) Close() error {
 	wal.mu.Lock()
 	defer wal.mu.Unlock()
@@ -260,6 +447,10 @@ func (wal *WAL) Close() error {
 		wal.blockCache.Purge()
 	}
 
+	return wal.closeSegments()
+}
+
+func (wal *WAL) closeSegments() error {
 	// close all segment files.
 	for _, segment := range wal.olderSegments {
 		if err := segment.Close(); err != nil {
@@ -269,5 +460,8 @@ func (wal *WAL) Close() error {
 	wal.olderSegments = nil
 
 	// close the active segment file.
+	if wal.activeSegment == nil {
+		return nil
+	}
 	return wal.activeSegment.Close()
 }
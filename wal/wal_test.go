@@ -0,0 +1,56 @@
+package wal
+
+import (
+	"bytes"
+	"fastdb/config"
+	"fastdb/lib/encrypt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWAL_WriteEncryptedSegmentSize(t *testing.T) {
+	dir, err := os.MkdirTemp("", "wal-encrypt")
+	assert.Nil(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	options := DefaultOptions
+	options.DirPath = dir
+	options.SegmentSize = 64 * config.KB
+	options.KeyProvider = encrypt.NewStaticKeyProvider(bytes.Repeat([]byte{1}, 32))
+	wal, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		_ = wal.Close()
+	}()
+
+	// 加上每个 chunk 的加密开销之后超过 segment 的大小
+	_, err = wal.Write(make([]byte, options.SegmentSize-chunkHeaderSize-encrypt.Overhead))
+	assert.ErrorIs(t, err, ErrValueTooLarge)
+
+	data := bytes.Repeat([]byte("fastdb"), 500)
+	var positions []*ChunkPosition
+	for i := 0; i < 100; i++ {
+		pos, err := wal.Write(data)
+		assert.Nil(t, err)
+		positions = append(positions, pos)
+	}
+	assert.Greater(t, wal.activeSegment.id, SegmentID(initialSegmentFileID))
+	for _, pos := range positions {
+		got, err := wal.Read(pos)
+		assert.Nil(t, err)
+		assert.Equal(t, data, got)
+	}
+
+	// 加密后的 segment 文件不会超过 SegmentSize
+	files, err := filepath.Glob(filepath.Join(dir, "*"+options.SegmentFileExt))
+	assert.Nil(t, err)
+	for _, file := range files {
+		info, err := os.Stat(file)
+		assert.Nil(t, err)
+		assert.LessOrEqual(t, info.Size(), options.SegmentSize, file)
+	}
+}